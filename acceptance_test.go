@@ -0,0 +1,111 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+func TestAcceptancePolicy(t *testing.T) {
+	a := assert.New(t)
+
+	// Reject uploads during the current hour, e.g. a backup window
+	window := time.Now().Truncate(time.Hour)
+	handler, _ := NewHandler(Config{
+		DataStore: zeroStore{},
+		AcceptancePolicy: func(t time.Time) (bool, time.Time) {
+			if t.Before(window) || !t.Before(window.Add(time.Hour)) {
+				return true, time.Time{}
+			}
+			return false, window.Add(time.Hour)
+		},
+	})
+
+	w := (&httpTest{
+		Name:   "Upload during maintenance window",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusServiceUnavailable,
+	}).Run(handler, t)
+
+	retryAfter, err := strconv.Atoi(w.HeaderMap.Get("Retry-After"))
+	a.NoError(err)
+	a.True(retryAfter > 0 && retryAfter <= 3600, "retry after the window has ended")
+
+	// Existing uploads continue
+	(&httpTest{
+		Name:   "HEAD during maintenance window",
+		Method: "HEAD",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	// The header is sent even if the window is just about to end
+	handler, _ = NewHandler(Config{
+		DataStore: zeroStore{},
+		AcceptancePolicy: func(t time.Time) (bool, time.Time) {
+			return false, t
+		},
+	})
+
+	(&httpTest{
+		Name:   "Window ending now",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusServiceUnavailable,
+		ResHeader: map[string]string{
+			"Retry-After": "1",
+		},
+	}).Run(handler, t)
+
+	// No Retry-After header is sent if the end of the window is unknown
+	handler, _ = NewHandler(Config{
+		DataStore: zeroStore{},
+		AcceptancePolicy: func(t time.Time) (bool, time.Time) {
+			return false, time.Time{}
+		},
+	})
+
+	w = (&httpTest{
+		Name:   "Window without known end",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusServiceUnavailable,
+	}).Run(handler, t)
+	a.Equal("", w.HeaderMap.Get("Retry-After"))
+
+	handler, _ = NewHandler(Config{
+		BasePath:  "files",
+		DataStore: zeroStore{},
+		AcceptancePolicy: func(t time.Time) (bool, time.Time) {
+			return true, time.Time{}
+		},
+	})
+
+	(&httpTest{
+		Name:   "Uploads accepted",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+}
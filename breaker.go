@@ -0,0 +1,153 @@
+package tusd
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// BreakerState describes whether a CircuitBreaker lets write operations pass.
+type BreakerState int
+
+const (
+	// BreakerClosed is the normal state in which all operations are allowed.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all operations until the cooldown has elapsed.
+	BreakerOpen
+	// BreakerHalfOpen allows a single trial operation whose outcome decides
+	// whether the breaker is closed or opened again.
+	BreakerHalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker protects a failing data store from further write operations,
+// see Config.StoreBreaker. It opens after Threshold consecutive failures,
+// causing new uploads and chunks to be rejected with 503 Service Unavailable
+// while reads are still served. Once Cooldown has elapsed, a single operation
+// is let through to test whether the store has recovered. If this trial has
+// not been reported within another Cooldown, e.g. since it hangs, the next
+// operation is let through instead.
+type CircuitBreaker struct {
+	// Threshold is the number of consecutive failures opening the breaker.
+	Threshold int
+	// Cooldown is the time the breaker stays open before trying again.
+	Cooldown time.Duration
+
+	mutex    sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+	probedAt time.Time
+}
+
+// NewCircuitBreaker creates a new, closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Allow reports whether an operation may be performed. If true is returned,
+// its outcome must be passed to Report afterwards.
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state == BreakerOpen && time.Since(breaker.openedAt) >= breaker.Cooldown {
+		breaker.state = BreakerHalfOpen
+	}
+
+	switch breaker.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		// Only a single trial operation is allowed at the same time
+		if breaker.probing && time.Since(breaker.probedAt) < breaker.Cooldown {
+			return false
+		}
+		breaker.probing = true
+		breaker.probedAt = time.Now()
+	}
+
+	return true
+}
+
+// Report records the outcome of an operation which has been allowed. Errors
+// defined by tusd (see ErrStatusCodes), errors concerning only the single
+// upload and interrupted request bodies do not indicate a problem of the store
+// and therefore count as success.
+func (breaker *CircuitBreaker) Report(err error) {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state == BreakerHalfOpen {
+		breaker.probing = false
+	}
+
+	if !isStoreFailure(err) {
+		breaker.state = BreakerClosed
+		breaker.failures = 0
+		return
+	}
+
+	breaker.failures++
+	if breaker.state == BreakerHalfOpen || breaker.failures >= breaker.Threshold {
+		breaker.state = BreakerOpen
+		breaker.openedAt = time.Now()
+	}
+}
+
+// release ends an allowed operation without recording its outcome, since it
+// failed due to the request, e.g. as the client disconnected, and therefore
+// tells nothing about the store's health.
+func (breaker *CircuitBreaker) release() {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	breaker.probing = false
+}
+
+// State returns the current state of the breaker, e.g. for health checks.
+func (breaker *CircuitBreaker) State() BreakerState {
+	breaker.mutex.Lock()
+	defer breaker.mutex.Unlock()
+
+	if breaker.state == BreakerOpen && time.Since(breaker.openedAt) >= breaker.Cooldown {
+		return BreakerHalfOpen
+	}
+
+	return breaker.state
+}
+
+// isStoreFailure reports whether the error indicates a problem of the data
+// store rather than of the request.
+func isStoreFailure(err error) bool {
+	if err == nil || err == io.ErrUnexpectedEOF || err == context.Canceled {
+		return false
+	}
+
+	if _, ok := ErrStatusCodes[err]; ok {
+		return false
+	}
+
+	// These errors concern a single upload or request, not the store's health
+	switch err.(type) {
+	case InsufficientStorageError, UnrecoverableError:
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,203 @@
+package tusd_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/tus/tusd"
+)
+
+type breakerStore struct {
+	zeroStore
+	failing *bool
+}
+
+func (s breakerStore) NewUpload(info FileInfo) (string, error) {
+	if *s.failing {
+		return "", errors.New("disk unavailable")
+	}
+	return "foo", nil
+}
+
+func TestStoreBreaker(t *testing.T) {
+	failing := true
+	breaker := NewCircuitBreaker(2, 20*time.Millisecond)
+	handler, _ := NewHandler(Config{
+		DataStore:    breakerStore{failing: &failing},
+		StoreBreaker: breaker,
+	})
+
+	post := func(name string, code int) {
+		(&httpTest{
+			Name:   name,
+			Method: "POST",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Upload-Length": "300",
+			},
+			Code: code,
+		}).Run(handler, t)
+	}
+
+	expectState := func(expected BreakerState) {
+		if state := breaker.State(); state != expected {
+			t.Errorf("Expected breaker to be %s but got %s", expected, state)
+		}
+	}
+
+	post("First failure", http.StatusInternalServerError)
+	expectState(BreakerClosed)
+	post("Second failure", http.StatusInternalServerError)
+	expectState(BreakerOpen)
+
+	post("Rejected while open", http.StatusServiceUnavailable)
+
+	(&httpTest{
+		Name:   "Reads are still served",
+		Method: "HEAD",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	// A failing trial opens the breaker again
+	time.Sleep(30 * time.Millisecond)
+	expectState(BreakerHalfOpen)
+	post("Failing trial", http.StatusInternalServerError)
+	expectState(BreakerOpen)
+	post("Rejected after failing trial", http.StatusServiceUnavailable)
+
+	// A successful trial closes the breaker
+	time.Sleep(30 * time.Millisecond)
+	failing = false
+	post("Successful trial", http.StatusCreated)
+	expectState(BreakerClosed)
+	post("Accepted after recovery", http.StatusCreated)
+}
+
+type panicBreakerStore struct {
+	zeroStore
+	panicking *bool
+}
+
+func (s panicBreakerStore) NewUpload(info FileInfo) (string, error) {
+	if *s.panicking {
+		panic("disk unavailable")
+	}
+	return "foo", nil
+}
+
+func TestStoreBreakerPanic(t *testing.T) {
+	panicking := true
+	breaker := NewCircuitBreaker(1, 20*time.Millisecond)
+	handler, _ := NewHandler(Config{
+		DataStore:     panicBreakerStore{panicking: &panicking},
+		StoreBreaker:  breaker,
+		RecoverPanics: true,
+		Logger:        log.New(ioutil.Discard, "", 0),
+	})
+
+	post := func(name string, code int) {
+		(&httpTest{
+			Name:   name,
+			Method: "POST",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Upload-Length": "300",
+			},
+			Code: code,
+		}).Run(handler, t)
+	}
+
+	post("Panic", http.StatusInternalServerError)
+	if state := breaker.State(); state != BreakerOpen {
+		t.Errorf("Expected breaker to be open but got %s", state)
+	}
+
+	// A panicking trial counts as failure instead of blocking further trials
+	time.Sleep(30 * time.Millisecond)
+	post("Panicking trial", http.StatusInternalServerError)
+	time.Sleep(30 * time.Millisecond)
+	panicking = false
+	post("Successful trial", http.StatusCreated)
+	if state := breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected breaker to be closed but got %s", state)
+	}
+}
+
+func TestStoreBreakerHangingTrial(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 20*time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("Expected closed breaker to allow operations")
+	}
+	breaker.Report(errors.New("disk unavailable"))
+
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Fatal("Expected trial to be allowed")
+	}
+	if breaker.Allow() {
+		t.Error("Expected only a single trial to be allowed")
+	}
+
+	// The trial is abandoned if it is not reported within the cooldown
+	time.Sleep(30 * time.Millisecond)
+	if !breaker.Allow() {
+		t.Error("Expected another trial to be allowed")
+	}
+}
+
+type readFailingStore struct {
+	zeroStore
+}
+
+func (s readFailingStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:   id,
+		Size: 10,
+	}, nil
+}
+
+func (s readFailingStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	_, err := ioutil.ReadAll(src)
+	return 0, fmt.Errorf("reading chunk failed: %s", err)
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
+
+func TestStoreBreakerRequestFailures(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Minute)
+	handler, _ := NewHandler(Config{
+		DataStore:    readFailingStore{},
+		StoreBreaker: breaker,
+	})
+
+	(&httpTest{
+		Name:   "Failing request body",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: failingReader{},
+		Code:    http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	if state := breaker.State(); state != BreakerClosed {
+		t.Errorf("Expected breaker to be closed but got %s", state)
+	}
+}
@@ -0,0 +1,154 @@
+package tusd_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type cancelStore struct {
+	zeroStore
+	mutex   *sync.Mutex
+	offset  *int64
+	started chan struct{}
+}
+
+func (s cancelStore) GetInfo(id string) (FileInfo, error) {
+	if id != "yes" {
+		return FileInfo{}, ErrNotFound
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return FileInfo{
+		ID:     id,
+		Offset: *s.offset,
+		Size:   20,
+	}, nil
+}
+
+func (s cancelStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	buf := make([]byte, 5)
+	var written int64
+	for {
+		n, err := src.Read(buf)
+
+		s.mutex.Lock()
+		*s.offset += int64(n)
+		if n > 0 && *s.offset == 5 {
+			close(s.started)
+		}
+		s.mutex.Unlock()
+		written += int64(n)
+
+		if err == io.EOF {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+}
+
+func (s cancelStore) Terminate(id string) error {
+	panic("upload must not be terminated")
+}
+
+func TestCancelWrite(t *testing.T) {
+	a := assert.New(t)
+
+	offset := int64(0)
+	started := make(chan struct{})
+	handler, _ := NewHandler(Config{
+		DataStore: cancelStore{
+			mutex:   &sync.Mutex{},
+			offset:  &offset,
+			started: started,
+		},
+	})
+
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		(&httpTest{
+			Name:   "Canceled PATCH request",
+			Method: "PATCH",
+			URL:    "yes",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: reader,
+			Code:    http.StatusBadRequest,
+		}).Run(handler, t)
+	}()
+
+	writer.Write([]byte("hello"))
+	<-started
+
+	(&httpTest{
+		Name:   "Cancel active write",
+		Method: "DELETE",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Cancel-Write": "true",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	// Unblock the pending read. Although the body has been read entirely, the
+	// request must fail since the write has been canceled.
+	writer.Close()
+	<-done
+
+	a.EqualValues(5, offset)
+
+	(&httpTest{
+		Name:   "Cancel without active write",
+		Method: "DELETE",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Cancel-Write": "true",
+		},
+		Code: http.StatusConflict,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Cancel write to unknown upload",
+		Method: "DELETE",
+		URL:    "no",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Cancel-Write": "true",
+		},
+		Code: http.StatusNotFound,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Resume upload",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader("world"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+}
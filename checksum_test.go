@@ -0,0 +1,300 @@
+package tusd_test
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+type checksumStore struct {
+	zeroStore
+	written *[]string
+}
+
+func (s checksumStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		Offset: 5,
+		Size:   20,
+	}, nil
+}
+
+func (s checksumStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+
+	*s.written = append(*s.written, string(data))
+	return int64(len(data)), nil
+}
+
+func TestChecksum(t *testing.T) {
+	var written []string
+	handler, _ := NewHandler(Config{
+		DataStore: checksumStore{
+			written: &written,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Advertise algorithms",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Tus-Checksum-Algorithm": "sha1,md5,crc32",
+		},
+	}).Run(handler, t)
+
+	for _, checksum := range []string{
+		"sha1 qvTGHdzF6KLavt4PO0gs2a6pQ00=",
+		"md5 XUFAKrxLKna5cZ2REBfFkg==",
+		"crc32 NhCmhg==",
+	} {
+		(&httpTest{
+			Name:   "Matching checksum",
+			Method: "PATCH",
+			URL:    "yes",
+			ReqHeader: map[string]string{
+				"Tus-Resumable":   "1.0.0",
+				"Content-Type":    "application/offset+octet-stream",
+				"Upload-Offset":   "5",
+				"Upload-Checksum": checksum,
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Upload-Offset": "10",
+			},
+		}).Run(handler, t)
+	}
+
+	if len(written) != 3 || written[0] != "hello" {
+		t.Fatalf("Expected three verified chunks to be written but got %v", written)
+	}
+
+	(&httpTest{
+		Name:   "Mismatching checksum",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": "sha1 qvTGHdzF6KLavt4PO0gs2a6pQ00=",
+		},
+		ReqBody: strings.NewReader("hallo"),
+		Code:    460,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Unsupported algorithm",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": "sha512 qvTGHdzF6KLavt4PO0gs2a6pQ00=",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Malformed header",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": "sha1",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusBadRequest,
+	}).Run(handler, t)
+
+	if len(written) != 3 {
+		t.Errorf("Expected rejected chunks not to be written but got %v", written)
+	}
+}
+
+func TestChecksumLastChunk(t *testing.T) {
+	var written []string
+	handler, _ := NewHandler(Config{
+		DataStore: checksumStore{
+			written: &written,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Advertise scopes",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Tus-Checksum-Scope": "chunk,last-chunk",
+		},
+	}).Run(handler, t)
+
+	checksum := func(data string) string {
+		sum := sha1.Sum([]byte(data))
+		return "sha1 " + base64.StdEncoding.EncodeToString(sum[:]) + " last-chunk"
+	}
+
+	(&httpTest{
+		Name:   "Chunk does not complete the upload",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": checksum("hello"),
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusBadRequest,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Truncated last chunk",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": checksum("hello world, bye"),
+		},
+		ReqBody: strings.NewReader("hello world, by"),
+		Code:    460,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Verified last chunk",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": checksum("hello world, by"),
+		},
+		ReqBody: strings.NewReader("hello world, by"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "20",
+		},
+	}).Run(handler, t)
+
+	if len(written) != 1 || written[0] != "hello world, by" {
+		t.Errorf("Expected only the verified last chunk to be written but got %v", written)
+	}
+}
+
+func TestChecksumMaxVerifiedChunkSize(t *testing.T) {
+	var written []string
+	handler, _ := NewHandler(Config{
+		DataStore: checksumStore{
+			written: &written,
+		},
+		MaxVerifiedChunkSize: 4,
+	})
+
+	(&httpTest{
+		Name:   "Declared length exceeding limit",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": "sha1 qvTGHdzF6KLavt4PO0gs2a6pQ00=",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+
+	// The length of a chunked body is only known once it has been read
+	(&httpTest{
+		Name:   "Received length exceeding limit",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "5",
+			"Upload-Checksum": "sha1 qvTGHdzF6KLavt4PO0gs2a6pQ00=",
+		},
+		ReqBody: &noEOFReader{closed: true, buffer: []byte("hello")},
+		Code:    http.StatusRequestEntityTooLarge,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	if len(written) != 0 {
+		t.Errorf("Expected rejected chunks not to be written but got %v", written)
+	}
+}
+
+func TestChecksumOffsetSkew(t *testing.T) {
+	var written []string
+	handler, _ := NewHandler(Config{
+		DataStore: checksumStore{
+			written: &written,
+		},
+		OffsetSkewTolerance: 3,
+	})
+
+	// The checksum covers the entire body including the bytes which have
+	// already been received
+	sum := sha1.Sum([]byte("XXhello"))
+	(&httpTest{
+		Name:   "Client behind with checksum",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "3",
+			"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+		},
+		ReqBody: strings.NewReader("XXhello"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	if len(written) != 1 || written[0] != "hello" {
+		t.Fatalf("Expected only the new bytes to be written but got %v", written)
+	}
+
+	// A checksum of the new bytes alone does not match
+	sum = sha1.Sum([]byte("hello"))
+	(&httpTest{
+		Name:   "Checksum without skipped bytes",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Content-Type":    "application/offset+octet-stream",
+			"Upload-Offset":   "3",
+			"Upload-Checksum": "sha1 " + base64.StdEncoding.EncodeToString(sum[:]),
+		},
+		ReqBody: strings.NewReader("XXhello"),
+		Code:    460,
+	}).Run(handler, t)
+}
@@ -0,0 +1,283 @@
+package tusd_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+// manifestInfoStore keeps the info of a single upload in memory
+type manifestInfoStore struct {
+	zeroStore
+	info *FileInfo
+	data *[]byte
+}
+
+func (s manifestInfoStore) NewUpload(info FileInfo) (string, error) {
+	*s.info = info
+	return "foo", nil
+}
+
+func (s manifestInfoStore) GetInfo(id string) (FileInfo, error) {
+	return *s.info, nil
+}
+
+func (s manifestInfoStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(src)
+	*s.data = append(*s.data, data...)
+	s.info.Offset += int64(len(data))
+	return int64(len(data)), err
+}
+
+func sha256Hex(data string) string {
+	digest := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestChunkManifest(t *testing.T) {
+	a := assert.New(t)
+
+	info := FileInfo{}
+	data := []byte{}
+	handler, _ := NewHandler(Config{
+		BasePath: "files",
+		DataStore: manifestInfoStore{
+			info: &info,
+			data: &data,
+		},
+		MaxManifestChunkSize: 10,
+	})
+
+	manifest := "5 " + sha256Hex("hello") + ", 6 " + sha256Hex(" world")
+
+	(&httpTest{
+		Name:   "Sizes not matching Upload-Length",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":         "1.0.0",
+			"Upload-Length":         "12",
+			"Upload-Chunk-Manifest": manifest,
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Chunk exceeding maximum size",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":         "1.0.0",
+			"Upload-Length":         "11",
+			"Upload-Chunk-Manifest": "11 " + sha256Hex("hello world"),
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Creating upload with manifest",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":         "1.0.0",
+			"Upload-Length":         "11",
+			"Upload-Chunk-Manifest": manifest,
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	a.Equal([]ChunkHash{
+		{Size: 5, SHA256: sha256Hex("hello")},
+		{Size: 6, SHA256: sha256Hex(" world")},
+	}, info.ChunkManifest)
+
+	patch := func(name string, offset string, body string, code int, newOffset string) {
+		(&httpTest{
+			Name:   name,
+			Method: "PATCH",
+			URL:    "foo",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": offset,
+			},
+			ReqBody: strings.NewReader(body),
+			Code:    code,
+			ResHeader: map[string]string{
+				"Upload-Offset": newOffset,
+			},
+		}).Run(handler, t)
+	}
+
+	// Mismatches are reported with the offset of the rejected chunk
+	patch("Tampered chunk", "0", "hellO", 460, "0")
+	patch("Incomplete chunk", "0", "hell", 460, "0")
+	a.Len(data, 0)
+
+	patch("Correct first chunk", "0", "hello", http.StatusNoContent, "5")
+	patch("Tampered second chunk", "5", " World", 460, "5")
+	patch("Correct second chunk", "5", " world", http.StatusNoContent, "11")
+
+	a.Equal("hello world", string(data))
+	a.EqualValues(11, info.Offset)
+}
+
+func TestChunkManifestMaxVerifiedChunkSize(t *testing.T) {
+	a := assert.New(t)
+
+	info := FileInfo{}
+	data := []byte{}
+	handler, _ := NewHandler(Config{
+		DataStore: manifestInfoStore{
+			info: &info,
+			data: &data,
+		},
+		MaxManifestChunkSize: 10,
+		MaxVerifiedChunkSize: 5,
+	})
+
+	// Chunks are buffered, so the smaller limit applies when registering them
+	(&httpTest{
+		Name:   "Chunk exceeding verified size",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":         "1.0.0",
+			"Upload-Length":         "11",
+			"Upload-Chunk-Manifest": "5 " + sha256Hex("hello") + ", 6 " + sha256Hex(" world"),
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	// Chunks registered before the limit has been lowered are not read
+	info = FileInfo{
+		Size: 11,
+		ChunkManifest: []ChunkHash{
+			{Size: 11, SHA256: sha256Hex("hello world")},
+		},
+	}
+	(&httpTest{
+		Name:   "Registered chunk exceeding verified size",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: &noEOFReader{closed: true, buffer: []byte("hello world")},
+		Code:    http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+
+	a.Len(data, 0)
+}
+
+type verifiedStore struct {
+	manifestInfoStore
+}
+
+func (s verifiedStore) GetReader(id string) (io.Reader, error) {
+	return bytes.NewReader(*s.data), nil
+}
+
+func TestVerifiedDownload(t *testing.T) {
+	a := assert.New(t)
+
+	info := FileInfo{
+		Size:   11,
+		Offset: 11,
+		ChunkManifest: []ChunkHash{
+			{Size: 5, SHA256: sha256Hex("hello")},
+			{Size: 6, SHA256: sha256Hex(" world")},
+		},
+	}
+	data := []byte("hello world")
+	handler, _ := NewHandler(Config{
+		BasePath: "files",
+		DataStore: verifiedStore{manifestInfoStore{
+			info: &info,
+			data: &data,
+		}},
+		VerifyDownloads: true,
+	})
+
+	w := (&httpTest{
+		Name:    "Intact upload",
+		Method:  "GET",
+		URL:     "foo",
+		Code:    http.StatusOK,
+		ResBody: "hello world",
+	}).Run(handler, t)
+	a.Equal("verified", w.Result().Trailer.Get("Upload-Integrity"))
+	a.Empty(w.HeaderMap.Get("Content-Length"))
+
+	// Flip a bit in the second chunk at rest
+	data[7] ^= 1
+	w = (&httpTest{
+		Name:    "Corrupted upload",
+		Method:  "GET",
+		URL:     "foo",
+		Code:    http.StatusOK,
+		ResBody: "hello",
+	}).Run(handler, t)
+	a.Equal("corrupted", w.Result().Trailer.Get("Upload-Integrity"))
+
+	// Truncated data is detected, too
+	data = data[:8]
+	w = (&httpTest{
+		Name:    "Truncated upload",
+		Method:  "GET",
+		URL:     "foo",
+		Code:    http.StatusOK,
+		ResBody: "hello",
+	}).Run(handler, t)
+	a.Equal("corrupted", w.Result().Trailer.Get("Upload-Integrity"))
+}
+
+func TestChunkManifestOffsetSkew(t *testing.T) {
+	a := assert.New(t)
+
+	info := FileInfo{
+		ID:     "foo",
+		Size:   11,
+		Offset: 5,
+		ChunkManifest: []ChunkHash{
+			{Size: 5, SHA256: sha256Hex("hello")},
+			{Size: 6, SHA256: sha256Hex(" world")},
+		},
+	}
+	data := []byte("hello")
+	handler, _ := NewHandler(Config{
+		BasePath: "files",
+		DataStore: manifestInfoStore{
+			info: &info,
+			data: &data,
+		},
+		MaxManifestChunkSize: 10,
+		OffsetSkewTolerance:  5,
+	})
+
+	// The retried chunk is verified as sent before its bytes are skipped
+	(&httpTest{
+		Name:   "Retried chunk",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	a.Equal("hello", string(data))
+}
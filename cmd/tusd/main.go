@@ -37,6 +37,8 @@ var storeSize int64
 var basepath string
 var timeout int64
 var s3Bucket string
+var s3MaxIdleConns int
+var s3IdleTimeout int64
 var hooksDir string
 var version bool
 
@@ -56,6 +58,8 @@ func init() {
 	flag.StringVar(&basepath, "base-path", "/files/", "Basepath of the HTTP server")
 	flag.Int64Var(&timeout, "timeout", 30*1000, "Read timeout for connections in milliseconds")
 	flag.StringVar(&s3Bucket, "s3-bucket", "", "Use AWS S3 with this bucket as storage backend (requires the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION environment variables to be set)")
+	flag.IntVar(&s3MaxIdleConns, "s3-max-idle-conns", 0, "Maximum number of idle connections kept open to S3 (0 uses Go's defaults)")
+	flag.Int64Var(&s3IdleTimeout, "s3-idle-timeout", 0, "Time in milliseconds after which idle connections to S3 are closed (0 uses Go's defaults)")
 	flag.StringVar(&hooksDir, "hooks-dir", "", "")
 	flag.BoolVar(&version, "version", false, "Print tusd version information")
 
@@ -107,12 +111,21 @@ func main() {
 
 		// Derive credentials from AWS_SECRET_ACCESS_KEY, AWS_ACCESS_KEY_ID and
 		// AWS_REGION environment variables.
-		credentials := aws.NewConfig().WithCredentials(credentials.NewEnvCredentials())
-		store = s3store.New(s3Bucket, s3.New(session.New(), credentials))
+		config := aws.NewConfig().WithCredentials(credentials.NewEnvCredentials())
+		config = config.WithHTTPClient(s3store.ClientOptions{
+			MaxIdleConns:        s3MaxIdleConns,
+			MaxIdleConnsPerHost: s3MaxIdleConns,
+			IdleConnTimeout:     time.Duration(s3IdleTimeout) * time.Millisecond,
+		}.HTTPClient())
+		store = s3store.New(s3Bucket, s3.New(session.New(), config))
 	}
 
 	if storeSize > 0 {
-		store = limitedstore.New(storeSize, store)
+		limitedStore := limitedstore.New(storeSize, store)
+		if err := limitedStore.Restore(); err != nil {
+			stderr.Fatalf("Unable to restore existing uploads: %s", err)
+		}
+		store = limitedStore
 		stdout.Printf("Using %.2fMB as storage size.\n", float64(storeSize)/1024/1024)
 
 		// We need to ensure that a single upload can fit into the storage size
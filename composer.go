@@ -0,0 +1,59 @@
+package tusd
+
+// StoreComposer represents a data store consisting of a core data store and
+// the optional interfaces it supports. The handler only uses the optional
+// interfaces through the composer, so a store wrapping another one can
+// forward exactly the interfaces which the wrapped store implements by
+// replacing the corresponding fields, see metricsstore. Otherwise a type
+// would have to be declared for every combination of interfaces since Go does
+// not allow adding methods at runtime.
+// A field is nil if the interface is not supported.
+type StoreComposer struct {
+	Core DataStore
+
+	Terminater       TerminaterDataStore
+	Finisher         FinisherDataStore
+	Locker           LockerDataStore
+	LockInspector    LockInspector
+	GetReader        GetReaderDataStore
+	ContextGetReader ContextGetReaderDataStore
+	ReaderAt         ReaderAtDataStore
+	Context          ContextDataStore
+	Concater         ConcaterDataStore
+	Describer        DescriberDataStore
+	Buffered         BufferedDataStore
+	Failer           FailerDataStore
+	Truncater        TruncaterDataStore
+	CapacityChecker  CapacityCheckerDataStore
+	Sealer           SealerDataStore
+	Expirer          ExpirerDataStore
+	LengthDeferrer   LengthDeferrerDataStore
+}
+
+// NewStoreComposer creates a new composer using the data store as core and
+// for every optional interface it implements.
+func NewStoreComposer(store DataStore) *StoreComposer {
+	composer := &StoreComposer{
+		Core: store,
+	}
+
+	composer.Terminater, _ = store.(TerminaterDataStore)
+	composer.Finisher, _ = store.(FinisherDataStore)
+	composer.Locker, _ = store.(LockerDataStore)
+	composer.LockInspector, _ = store.(LockInspector)
+	composer.GetReader, _ = store.(GetReaderDataStore)
+	composer.ContextGetReader, _ = store.(ContextGetReaderDataStore)
+	composer.ReaderAt, _ = store.(ReaderAtDataStore)
+	composer.Context, _ = store.(ContextDataStore)
+	composer.Concater, _ = store.(ConcaterDataStore)
+	composer.Describer, _ = store.(DescriberDataStore)
+	composer.Buffered, _ = store.(BufferedDataStore)
+	composer.Failer, _ = store.(FailerDataStore)
+	composer.Truncater, _ = store.(TruncaterDataStore)
+	composer.CapacityChecker, _ = store.(CapacityCheckerDataStore)
+	composer.Sealer, _ = store.(SealerDataStore)
+	composer.Expirer, _ = store.(ExpirerDataStore)
+	composer.LengthDeferrer, _ = store.(LengthDeferrerDataStore)
+
+	return composer
+}
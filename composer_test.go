@@ -0,0 +1,41 @@
+package tusd_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+func TestStoreComposer(t *testing.T) {
+	composer := NewStoreComposer(terminateStore{t: t})
+	if composer.Terminater == nil || composer.Concater != nil {
+		t.Fatalf("Expected only the termination interface to be used but got %+v", composer)
+	}
+
+	// Interfaces removed from the composer are not used even though the core
+	// implements them
+	composer.Terminater = nil
+	handler, _ := NewHandler(Config{
+		StoreComposer: composer,
+	})
+
+	(&httpTest{
+		Name:   "Termination not advertised",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Tus-Extension": "creation,creation-with-upload,checksum",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Termination not routed",
+		Method: "DELETE",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusMethodNotAllowed,
+	}).Run(handler, t)
+}
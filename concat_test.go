@@ -1,7 +1,11 @@
 package tusd_test
 
 import (
+	"io"
+	"io/ioutil"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -46,7 +50,7 @@ func TestConcatPartial(t *testing.T) {
 		Method: "OPTIONS",
 		URL:    "",
 		ResHeader: map[string]string{
-			"Tus-Extension": "creation,concatenation",
+			"Tus-Extension": "creation,creation-with-upload,concatenation,checksum",
 		},
 		Code: http.StatusNoContent,
 	}).Run(handler, t)
@@ -169,6 +173,18 @@ func TestConcatFinal(t *testing.T) {
 		Code: http.StatusBadRequest,
 	}).Run(handler, t)
 
+	(&httpTest{
+		Name:   "Final upload with data",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; http://tus.io/files/a /files/b/",
+			"Content-Type":  "application/offset+octet-stream",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusBadRequest,
+	}).Run(handler, t)
+
 	handler, _ = NewHandler(Config{
 		MaxSize:  9,
 		BasePath: "files",
@@ -187,3 +203,215 @@ func TestConcatFinal(t *testing.T) {
 		Code: http.StatusRequestEntityTooLarge,
 	}).Run(handler, t)
 }
+
+type concatMetaStore struct {
+	zeroStore
+}
+
+func (s concatMetaStore) NewUpload(info FileInfo) (string, error) {
+	return "foo", nil
+}
+
+func (s concatMetaStore) GetInfo(id string) (FileInfo, error) {
+	filetype := "image/png"
+	if id == "c" {
+		filetype = "video/mp4"
+	}
+
+	return FileInfo{
+		IsPartial: true,
+		Size:      5,
+		Offset:    5,
+		MetaData: MetaData{
+			"filetype": filetype,
+			"filename": id,
+		},
+	}, nil
+}
+
+func (s concatMetaStore) ConcatUploads(id string, uploads []string) error {
+	return nil
+}
+
+func TestConcatMetaData(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		BasePath:           "files",
+		DataStore:          concatMetaStore{},
+		ConcatMetaDataKeys: []string{"filetype"},
+	})
+
+	(&httpTest{
+		Name:   "Matching metadata",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a /files/b",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Mismatching metadata",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a /files/c",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	handler, _ = NewHandler(Config{
+		BasePath:  "files",
+		DataStore: concatMetaStore{},
+	})
+
+	(&httpTest{
+		Name:   "Metadata not compared by default",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a /files/c",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+}
+
+func TestConcatDuplicateParts(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		BasePath:  "files",
+		DataStore: concatMetaStore{},
+	})
+
+	(&httpTest{
+		Name:   "Duplicate parts rejected by default",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a /files/b /files/a",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	handler, _ = NewHandler(Config{
+		BasePath:                  "files",
+		DataStore:                 concatDuplicateStore{t: assert.New(t)},
+		AllowDuplicateConcatParts: true,
+	})
+
+	(&httpTest{
+		Name:   "Duplicate parts allowed",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a /files/b /files/a",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+}
+
+type concatDuplicateStore struct {
+	concatMetaStore
+	t *assert.Assertions
+}
+
+func (s concatDuplicateStore) NewUpload(info FileInfo) (string, error) {
+	s.t.EqualValues(15, info.Size)
+	return "foo", nil
+}
+
+func (s concatDuplicateStore) ConcatUploads(id string, uploads []string) error {
+	s.t.Equal([]string{"a", "b", "a"}, uploads)
+	return nil
+}
+
+// concatWritingStore reports part "a" as complete as soon as its data has been
+// read, while the write only returns once release is closed.
+type concatWritingStore struct {
+	concatMetaStore
+	mutex    *sync.Mutex
+	offset   *int64
+	received chan struct{}
+	release  chan struct{}
+}
+
+func (s concatWritingStore) GetInfo(id string) (FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return FileInfo{
+		IsPartial: true,
+		Size:      5,
+		Offset:    *s.offset,
+	}, nil
+}
+
+func (s concatWritingStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	*s.offset += int64(len(data))
+	s.mutex.Unlock()
+
+	close(s.received)
+	<-s.release
+	return int64(len(data)), nil
+}
+
+func TestConcatPartBeingWritten(t *testing.T) {
+	offset := int64(0)
+	store := concatWritingStore{
+		mutex:    &sync.Mutex{},
+		offset:   &offset,
+		received: make(chan struct{}),
+		release:  make(chan struct{}),
+	}
+	handler, _ := NewHandler(Config{
+		BasePath:  "files",
+		DataStore: store,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&httpTest{
+			Name:   "Writing part",
+			Method: "PATCH",
+			URL:    "a",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+		}).Run(handler, t)
+	}()
+	<-store.received
+
+	(&httpTest{
+		Name:   "Concatenating part being written",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a",
+		},
+		Code:    http.StatusBadRequest,
+		ResBody: "one of the partial uploads is not finished\n",
+	}).Run(handler, t)
+
+	close(store.release)
+	<-done
+
+	(&httpTest{
+		Name:   "Concatenating written part",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Concat": "final; /files/a",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+}
@@ -13,6 +13,8 @@
 package consullocker
 
 import (
+	"errors"
+	"regexp"
 	"sync"
 	"time"
 
@@ -20,6 +22,12 @@ import (
 	"github.com/tus/tusd"
 )
 
+var (
+	reKeyPrefix = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+	ErrInvalidKeyPrefix = errors.New("consullocker: key prefix may only contain ASCII letters, digits, dashes and underscores")
+)
+
 type ConsulLocker struct {
 	// Client used to connect to the Consul server
 	Client *consul.Client
@@ -29,6 +37,11 @@ type ConsulLocker struct {
 	// messages and can be used to match them to a specific Consul instance.
 	ConnectionName string
 
+	// KeyPrefix is an optional namespace for the keys used for locking. If
+	// multiple services share a Consul server, distinct prefixes prevent their
+	// locks from colliding. If empty, the upload IDs are used as keys directly.
+	KeyPrefix string
+
 	// locks is used for storing consul.Lock structs before they are unlocked.
 	// If you want to release a lock, you need the same consul.Lock instance
 	// and therefore we need to save them temporarily.
@@ -47,8 +60,17 @@ func New(client *consul.Client) *ConsulLocker {
 
 // LockUpload tries to obtain the exclusive lock.
 func (locker *ConsulLocker) LockUpload(id string) error {
+	if locker.KeyPrefix != "" && !reKeyPrefix.MatchString(locker.KeyPrefix) {
+		return ErrInvalidKeyPrefix
+	}
+
+	key := id + "/" + consul.DefaultSemaphoreKey
+	if locker.KeyPrefix != "" {
+		key = locker.KeyPrefix + "/" + key
+	}
+
 	lock, err := locker.Client.LockOpts(&consul.LockOptions{
-		Key:          id + "/" + consul.DefaultSemaphoreKey,
+		Key:          key,
 		LockTryOnce:  true,
 		LockWaitTime: time.Second,
 	})
@@ -30,6 +30,36 @@ func TestConsulLocker(t *testing.T) {
 	a.Equal(consul.ErrLockNotHeld, locker.UnlockUpload("one"))
 }
 
+func TestKeyPrefix(t *testing.T) {
+	a := assert.New(t)
+
+	server := consultestutil.NewTestServer(t)
+	defer server.Stop()
+
+	client, err := consul.NewClient(&consul.Config{
+		Address: server.HTTPAddr,
+	})
+	a.NoError(err)
+
+	lockerA := New(client)
+	lockerA.KeyPrefix = "service-a"
+	lockerB := New(client)
+	lockerB.KeyPrefix = "service-b"
+	lockerC := New(client)
+	lockerC.KeyPrefix = "service-a"
+
+	// Locks of different namespaces do not exclude each other
+	a.NoError(lockerA.LockUpload("one"))
+	a.NoError(lockerB.LockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, lockerC.LockUpload("one"))
+	a.NoError(lockerA.UnlockUpload("one"))
+	a.NoError(lockerB.UnlockUpload("one"))
+
+	invalid := New(client)
+	invalid.KeyPrefix = "../other"
+	a.Equal(ErrInvalidKeyPrefix, invalid.LockUpload("one"))
+}
+
 func TestLockLost(t *testing.T) {
 	// This test will panic because the connection to Consul will be cut, which
 	// is indented.
@@ -0,0 +1,88 @@
+package tusd_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type contextKey struct{}
+
+// contextStore records the values of the contexts passed to its methods.
+type contextStore struct {
+	*expirationStore
+	values []string
+}
+
+func (s *contextStore) NewUploadWithContext(ctx context.Context, info FileInfo) (string, error) {
+	s.values = append(s.values, "NewUpload="+ctx.Value(contextKey{}).(string))
+	return s.NewUpload(info)
+}
+
+func (s *contextStore) WriteChunkWithContext(ctx context.Context, id string, offset int64, src io.Reader) (int64, error) {
+	s.values = append(s.values, "WriteChunk="+ctx.Value(contextKey{}).(string))
+	return s.WriteChunk(id, offset, src)
+}
+
+func (s *contextStore) GetReader(id string) (io.Reader, error) {
+	return strings.NewReader("hello"), nil
+}
+
+func (s *contextStore) GetReaderWithContext(ctx context.Context, id string) (io.Reader, error) {
+	s.values = append(s.values, "GetReader="+ctx.Value(contextKey{}).(string))
+	return s.GetReader(id)
+}
+
+func TestContextDataStore(t *testing.T) {
+	store := &contextStore{
+		expirationStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+	})
+	withContext := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextKey{}, r.Method)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	(&httpTest{
+		Name:   "Create upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusCreated,
+	}).Run(withContext, t)
+
+	(&httpTest{
+		Name:   "Write chunk",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(withContext, t)
+
+	(&httpTest{
+		Name:    "Download upload",
+		Method:  "GET",
+		URL:     "new",
+		Code:    http.StatusOK,
+		ResBody: "hello",
+	}).Run(withContext, t)
+
+	assert.Equal(t, []string{"NewUpload=POST", "WriteChunk=PATCH", "GetReader=GET"}, store.values)
+}
@@ -0,0 +1,106 @@
+package tusd
+
+import (
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers used by the protocol, which
+// browsers are allowed to send.
+var corsAllowedHeaders = []string{
+	"Origin",
+	"X-Requested-With",
+	"Content-Type",
+	"Upload-Length",
+	"Upload-Offset",
+	"Tus-Resumable",
+	"Upload-Metadata",
+	"Upload-Defer-Length",
+	"Tus-Dry-Run",
+	"Upload-Cancel-Write",
+	"Upload-Chunk-Manifest",
+	"Upload-Checksum",
+}
+
+// corsExposedHeaders are the response headers used by the protocol, which
+// browsers expose to clients.
+var corsExposedHeaders = []string{
+	"Upload-Offset",
+	"Location",
+	"Upload-Length",
+	"Upload-Defer-Length",
+	"Tus-Version",
+	"Tus-Resumable",
+	"Tus-Max-Size",
+	"Tus-Extension",
+	"Tus-Checksum-Algorithm",
+	"Tus-Checksum-Scope",
+	"Upload-Metadata",
+	"Upload-Expires",
+	"Upload-Finish-Pending",
+	"Upload-Tree-Hash",
+	"Upload-Error",
+	"Upload-Quota-Used",
+	"Upload-Quota-Total",
+	"Upload-Integrity",
+	"Upload-Resumptions",
+}
+
+// CorsConfig controls the CORS headers sent by the handler, see Config.Cors.
+// The zero value allows requests from any origin.
+type CorsConfig struct {
+	// AllowedOrigins lists the origins, e.g. "https://example.com", which
+	// may access the server from a browser. Requests from other origins are
+	// answered without any CORS headers, so browsers refuse them. If empty or
+	// if it contains "*", any origin is allowed.
+	AllowedOrigins []string
+	// AllowCredentials allows browsers to include credentials, such as
+	// cookies, in the requests. Since this allows the origins to act on behalf
+	// of the users, it only applies to the origins listed explicitly in
+	// AllowedOrigins. Requests from origins which are only allowed by "*" or
+	// an empty list never receive credentials.
+	AllowCredentials bool
+	// AllowedHeaders lists request headers which may be sent in addition to
+	// the ones used by the protocol, e.g. "Authorization".
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers which are exposed to clients in
+	// addition to the ones used by the protocol.
+	ExposedHeaders []string
+}
+
+// allowsOrigin reports whether requests from the origin may receive CORS
+// headers.
+func (cors CorsConfig) allowsOrigin(origin string) bool {
+	if len(cors.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, allowed := range cors.AllowedOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsCredentials reports whether requests from the origin may include
+// credentials, see AllowCredentials.
+func (cors CorsConfig) allowsCredentials(origin string) bool {
+	if !cors.AllowCredentials {
+		return false
+	}
+	for _, allowed := range cors.AllowedOrigins {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedHeaders returns the value of the Access-Control-Allow-Headers header.
+func (cors CorsConfig) allowedHeaders() string {
+	return strings.Join(append(append([]string{}, corsAllowedHeaders...), cors.AllowedHeaders...), ", ")
+}
+
+// exposedHeaders returns the value of the Access-Control-Expose-Headers header.
+func (cors CorsConfig) exposedHeaders() string {
+	return strings.Join(append(append([]string{}, corsExposedHeaders...), cors.ExposedHeaders...), ", ")
+}
@@ -2,8 +2,11 @@ package tusd_test
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+
 	. "github.com/tus/tusd"
 )
 
@@ -38,3 +41,141 @@ func TestCORS(t *testing.T) {
 		},
 	}).Run(handler, t)
 }
+
+func TestCORSConfig(t *testing.T) {
+	a := assert.New(t)
+	handler, _ := NewHandler(Config{
+		Cors: CorsConfig{
+			AllowedOrigins:   []string{"https://tus.io"},
+			AllowCredentials: true,
+			AllowedHeaders:   []string{"Authorization"},
+			ExposedHeaders:   []string{"X-Request-ID"},
+		},
+	})
+
+	w := (&httpTest{
+		Name:   "Preflight request from allowed origin",
+		Method: "OPTIONS",
+		ReqHeader: map[string]string{
+			"Origin": "https://tus.io",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Access-Control-Allow-Origin":      "https://tus.io",
+			"Access-Control-Allow-Credentials": "true",
+			"Vary":                             "Origin",
+		},
+	}).Run(handler, t)
+
+	allowed := strings.Split(w.HeaderMap.Get("Access-Control-Allow-Headers"), ", ")
+	a.Contains(allowed, "Upload-Offset")
+	a.Contains(allowed, "Tus-Resumable")
+	a.Contains(allowed, "Authorization")
+
+	w = (&httpTest{
+		Name:   "Actual request from allowed origin",
+		Method: "GET",
+		ReqHeader: map[string]string{
+			"Origin": "https://tus.io",
+		},
+		Code: http.StatusMethodNotAllowed,
+		ResHeader: map[string]string{
+			"Access-Control-Allow-Origin":      "https://tus.io",
+			"Access-Control-Allow-Credentials": "true",
+		},
+	}).Run(handler, t)
+
+	exposed := strings.Split(w.HeaderMap.Get("Access-Control-Expose-Headers"), ", ")
+	for _, name := range []string{"Upload-Offset", "Location", "Upload-Length", "Tus-Version", "Tus-Resumable", "X-Request-ID"} {
+		a.Contains(exposed, name)
+	}
+
+	for _, method := range []string{"OPTIONS", "GET"} {
+		w = (&httpTest{
+			Name:   "Request from other origin",
+			Method: method,
+			ReqHeader: map[string]string{
+				"Origin": "https://example.com",
+			},
+			Code: map[string]int{
+				"OPTIONS": http.StatusNoContent,
+				"GET":     http.StatusMethodNotAllowed,
+			}[method],
+			ResHeader: map[string]string{
+				"Vary": "Origin",
+			},
+		}).Run(handler, t)
+
+		a.Empty(w.HeaderMap.Get("Access-Control-Allow-Origin"))
+		a.Empty(w.HeaderMap.Get("Access-Control-Allow-Credentials"))
+		a.Empty(w.HeaderMap.Get("Access-Control-Allow-Headers"))
+		a.Empty(w.HeaderMap.Get("Access-Control-Expose-Headers"))
+	}
+}
+
+func TestCORSDefault(t *testing.T) {
+	a := assert.New(t)
+	handler, _ := NewHandler(Config{})
+
+	w := (&httpTest{
+		Name:   "Any origin is allowed without credentials",
+		Method: "GET",
+		ReqHeader: map[string]string{
+			"Origin": "https://example.com",
+		},
+		Code: http.StatusMethodNotAllowed,
+		ResHeader: map[string]string{
+			"Access-Control-Allow-Origin": "https://example.com",
+		},
+	}).Run(handler, t)
+
+	a.Empty(w.HeaderMap.Get("Access-Control-Allow-Credentials"))
+	a.Equal("Upload-Offset, Location, Upload-Length, Upload-Defer-Length, Tus-Version, Tus-Resumable, Tus-Max-Size, Tus-Extension, Tus-Checksum-Algorithm, Tus-Checksum-Scope, Upload-Metadata, Upload-Expires, Upload-Finish-Pending, Upload-Tree-Hash, Upload-Error, Upload-Quota-Used, Upload-Quota-Total, Upload-Integrity, Upload-Resumptions", w.HeaderMap.Get("Access-Control-Expose-Headers"))
+}
+
+func TestCORSCredentialsWildcard(t *testing.T) {
+	a := assert.New(t)
+
+	for _, origins := range [][]string{nil, {"*"}, {"*", "https://tus.io"}} {
+		handler, _ := NewHandler(Config{
+			Cors: CorsConfig{
+				AllowedOrigins:   origins,
+				AllowCredentials: true,
+			},
+		})
+
+		w := (&httpTest{
+			Name:   "Origin allowed by wildcard",
+			Method: "GET",
+			ReqHeader: map[string]string{
+				"Origin": "https://example.com",
+			},
+			Code: http.StatusMethodNotAllowed,
+			ResHeader: map[string]string{
+				"Access-Control-Allow-Origin": "https://example.com",
+			},
+		}).Run(handler, t)
+
+		a.Empty(w.HeaderMap.Get("Access-Control-Allow-Credentials"), "%v", origins)
+	}
+
+	// Explicitly listed origins still receive credentials next to a wildcard
+	handler, _ := NewHandler(Config{
+		Cors: CorsConfig{
+			AllowedOrigins:   []string{"*", "https://tus.io"},
+			AllowCredentials: true,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Origin listed explicitly",
+		Method: "GET",
+		ReqHeader: map[string]string{
+			"Origin": "https://tus.io",
+		},
+		Code: http.StatusMethodNotAllowed,
+		ResHeader: map[string]string{
+			"Access-Control-Allow-Credentials": "true",
+		},
+	}).Run(handler, t)
+}
@@ -0,0 +1,96 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+func TestCreationWithUpload(t *testing.T) {
+	store := deferStore{&expirationStore{
+		uploads: make(map[string]FileInfo),
+	}}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+		MaxSize:   8,
+	})
+
+	(&httpTest{
+		Name:   "Create upload with first chunk",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "8",
+			"Content-Type":  "application/offset+octet-stream",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusCreated,
+		ResHeader: map[string]string{
+			"Location":      "http://tus.io/files/new",
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	if offset := store.uploads["new"].Offset; offset != 5 {
+		t.Errorf("Expected 5 bytes to be written but got %d", offset)
+	}
+
+	delete(store.uploads, "new")
+
+	w := (&httpTest{
+		Name:   "Maximum size applies to deferred length",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Defer-Length": "1",
+			"Content-Type":        "application/offset+octet-stream",
+		},
+		ReqBody: strings.NewReader("hello world"),
+		Code:    http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+
+	// The upload is not created if the first chunk cannot be stored
+	if location := w.Header().Get("Location"); location != "" {
+		t.Errorf("Expected no Location header but got %s", location)
+	}
+	if _, ok := store.uploads["new"]; ok {
+		t.Error("Expected no upload to be created")
+	}
+
+	(&httpTest{
+		Name:   "First chunk exceeding the length",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "4",
+			"Content-Type":  "application/offset+octet-stream",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+
+	if _, ok := store.uploads["new"]; ok {
+		t.Error("Expected no upload to be created")
+	}
+
+	handler, _ = NewHandler(Config{
+		DataStore:  store,
+		BasePath:   "/files/",
+		Extensions: []string{"creation"},
+	})
+
+	(&httpTest{
+		Name:   "Disabled extension",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "8",
+			"Content-Type":  "application/offset+octet-stream",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusPreconditionFailed,
+	}).Run(handler, t)
+}
@@ -1,7 +1,11 @@
 package tusd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"time"
 )
 
 type MetaData map[string]string
@@ -10,6 +14,10 @@ type FileInfo struct {
 	ID string
 	// Total file size in bytes specified in the NewUpload call
 	Size int64
+	// SizeIsDeferred indicates that the upload has been created using the
+	// Upload-Defer-Length header and its size has not been declared yet. Size
+	// is zero in the meantime.
+	SizeIsDeferred bool `json:",omitempty"`
 	// Offset in bytes (zero-based)
 	Offset   int64
 	MetaData MetaData
@@ -23,13 +31,111 @@ type FileInfo struct {
 	// ordered slice containing the ids of the uploads of which the final upload
 	// will consist after concatenation.
 	PartialUploads []string
+	// Error describes why the upload has failed permanently, e.g. because its
+	// data has been corrupted. If it is not empty, the upload cannot be
+	// continued and clients have to start over using a new one.
+	Error string `json:",omitempty"`
+	// ChunkManifest lists the chunks the upload's data is expected to consist
+	// of, if the client has registered them when creating the upload. Each
+	// PATCH request must then contain exactly the next chunk.
+	ChunkManifest []ChunkHash `json:",omitempty"`
+	// Sealed indicates that the finished upload has been made immutable using
+	// the SealerDataStore interface. It may neither be modified nor, unless
+	// Config.TerminateSealedUploads is set, terminated.
+	Sealed bool `json:",omitempty"`
+	// Expires is the time after which the unfinished upload may be removed, if
+	// the expiration extension is enabled. It is nil for uploads which do not
+	// expire.
+	Expires *time.Time `json:",omitempty"`
+	// CreatedAt is the time at which the upload has been created. It is only
+	// recorded if Config.MaxUploadDuration is set.
+	CreatedAt *time.Time `json:",omitempty"`
+
+	// The following properties are computed by the handler once the upload
+	// has been finished. They are only set in the info passed to the
+	// CompleteUploads channel, callback and webhook, so consumers do not
+	// have to look them up on their own.
+
+	// URL is the absolute URL of the upload, as sent in the Location header.
+	URL string `json:",omitempty"`
+	// Hash is the hex-encoded tree hash of the upload's content if
+	// Config.ComputeTreeHash is enabled and it has been received entirely by
+	// this handler.
+	Hash string `json:",omitempty"`
+	// CompletedAt is the time at which the upload has been finished.
+	CompletedAt *time.Time `json:",omitempty"`
+	// Resumptions is the number of PATCH requests which have resumed the
+	// upload after an interruption if Config.ResumptionGap is set and all
+	// data has been received by this handler.
+	Resumptions int `json:",omitempty"`
+}
+
+// MarshalJSON encodes the info in the canonical form used wherever uploads are
+// represented as JSON, e.g. by data stores persisting them and by webhooks.
+// The fields keep their names, so the result can be decoded into a FileInfo
+// again, and IsComplete is added, telling whether all data has been received.
+// It is computed from the other fields and ignored when decoding.
+func (info FileInfo) MarshalJSON() ([]byte, error) {
+	// fileInfo has the same fields but not this method, so encoding it does
+	// not recurse.
+	type fileInfo FileInfo
+	return json.Marshal(struct {
+		fileInfo
+		IsComplete bool
+	}{
+		fileInfo:   fileInfo(info),
+		IsComplete: !info.SizeIsDeferred && info.Offset == info.Size,
+	})
+}
+
+// ChunkHash describes the expected size and content of a single chunk.
+type ChunkHash struct {
+	Size int64
+	// SHA256 is the hex-encoded SHA-256 digest of the chunk's data.
+	SHA256 string
+}
+
+// UnrecoverableError is returned by DataStores if an upload cannot be
+// continued, for example because its data has been corrupted. In contrast to
+// other errors, retrying the request will not succeed. The handler records the
+// reason using the FailerDataStore interface, if implemented, and responds
+// with ErrUploadFailed.
+type UnrecoverableError struct {
+	Reason string
+}
+
+func (err UnrecoverableError) Error() string {
+	return err.Reason
+}
+
+// InsufficientStorageError is returned by DataStores if an upload cannot be
+// created because not enough space is available. The handler responds with
+// 507 Insufficient Storage and includes the numbers in the response, allowing
+// clients to display a meaningful message.
+type InsufficientStorageError struct {
+	// Used is the number of bytes currently occupied in the store.
+	Used int64
+	// Total is the capacity of the store in bytes.
+	Total int64
+	// Requested is the size of the upload which could not be created.
+	Requested int64
+}
+
+func (err InsufficientStorageError) Error() string {
+	return fmt.Sprintf("insufficient storage: %d bytes requested but only %d of %d bytes available", err.Requested, err.Total-err.Used, err.Total)
 }
 
 type DataStore interface {
 	// Create a new upload using the size as the file's length. The method must
 	// return an unique id which is used to identify the upload. If no backend
 	// (e.g. Riak) specifes the id you may want to use the uid package to
-	// generate one. The properties Size and MetaData will be filled.
+	// generate one. The properties Size and MetaData will be filled. If the
+	// ID property is set, e.g. by Config.IDGenerator, it must be used as the
+	// upload's id instead of generating one.
+	// If the generated id is already taken, e.g. by an upload created by
+	// another node sharing the storage, the existing upload must not be
+	// overwritten. Instead, tusd.ErrUploadIDCollision should be returned in
+	// order to let the handler retry with a new id.
 	NewUpload(info FileInfo) (id string, err error)
 	// Write the chunk read from src into the file specified by the id at the
 	// given offset. The handler will take care of validating the offset and
@@ -90,6 +196,39 @@ type LockerDataStore interface {
 	UnlockUpload(id string) error
 }
 
+// LockInfo describes a lock which is currently held for an upload.
+type LockInfo struct {
+	// ID of the locked upload
+	ID string
+	// Holder optionally identifies who acquired the lock, e.g. a process ID.
+	Holder string
+	// Since is the time at which the lock has been acquired. It is the zero
+	// value if the locker does not keep track of this information.
+	Since time.Time
+}
+
+// LockInspector is the interface which can be implemented by LockerDataStores
+// which are able to report the locks they are currently holding. This is
+// useful for debugging stuck uploads, see UnroutedHandler.ListLocks.
+type LockInspector interface {
+	LockerDataStore
+
+	// ActiveLocks returns a list of all locks which are currently held.
+	ActiveLocks() []LockInfo
+}
+
+// ExpiringLocker is the interface which can be implemented by LockerDataStores
+// whose locks expire if they are not released in time. The handler uses the
+// TTL for telling clients when to retry a request for a locked upload, see
+// Config.LockedRetryAfter.
+type ExpiringLocker interface {
+	LockerDataStore
+
+	// LockTTL returns the duration after which a lock expires. Zero means
+	// that locks do not expire.
+	LockTTL() time.Duration
+}
+
 // GetReaderDataStore is the interface which must be implemented if handler should
 // expose and support the GET route. It will allow clients to download the
 // content of an upload regardless whether it's finished or not.
@@ -100,14 +239,38 @@ type GetReaderDataStore interface {
 
 	// GetReader returns a reader which allows iterating of the content of an
 	// upload specified by its ID. It should attempt to provide a reader even if
-	// the upload has not been finished yet but it's not required.
+	// the upload has not been finished yet but it's not required. Such a
+	// reader should serve a snapshot of the content at the time of the call,
+	// so chunks which are appended while reading are not returned.
 	// If the returned reader also implements the io.Closer interface, the
-	// Close() method will be invoked once everything has been read.
+	// Close() method will be invoked once everything has been read. See
+	// RangeReader for streaming a range more efficiently.
 	// If the given upload could not be found, the error tusd.ErrNotFound should
 	// be returned.
 	GetReader(id string) (io.Reader, error)
 }
 
+// ContextDataStore is the interface which can be implemented by DataStores
+// communicating with remote backends. The handler passes the request's context
+// to these methods instead of calling NewUpload and WriteChunk, so the backend
+// requests can be aborted once the client disconnects. The methods are
+// expected to behave like their counterparts without context otherwise.
+type ContextDataStore interface {
+	DataStore
+
+	NewUploadWithContext(ctx context.Context, info FileInfo) (id string, err error)
+	WriteChunkWithContext(ctx context.Context, id string, offset int64, src io.Reader) (int64, error)
+}
+
+// ContextGetReaderDataStore is the interface which can be implemented by
+// GetReaderDataStores in order to receive the request's context when a
+// download is started, see ContextDataStore.
+type ContextGetReaderDataStore interface {
+	GetReaderDataStore
+
+	GetReaderWithContext(ctx context.Context, id string) (io.Reader, error)
+}
+
 // ConcaterDataStore is the interface required to be implemented if the
 // Concatenation extension should be enabled. Only in this case, the handler
 // will parse and respect the Upload-Concat header.
@@ -122,3 +285,150 @@ type ConcaterDataStore interface {
 	// must be respected during concatenation.
 	ConcatUploads(destination string, partialUploads []string) error
 }
+
+// ReaderAtDataStore is the interface which can be implemented by DataStores
+// which allow random access to the content of an upload, in contrast to the
+// sequential reading offered by GetReaderDataStore. If implemented, the
+// handler will use it for responding to GET requests containing a Range
+// header. Similar to the GET route, this is not part of the official tus
+// specification.
+type ReaderAtDataStore interface {
+	DataStore
+
+	// GetReaderAt returns a reader which allows reading the content of an
+	// upload specified by its ID at arbitrary offsets in addition to the number
+	// of bytes which can be read from it.
+	// If the returned reader also implements the io.Closer interface, the
+	// Close() method will be invoked once everything has been read.
+	// If the given upload could not be found, the error tusd.ErrNotFound should
+	// be returned. Stores which only support random access in some cases, e.g.
+	// because they wrap another store, may return tusd.ErrNotImplemented in
+	// order to have the handler send the entire upload using GetReader.
+	GetReaderAt(id string) (io.ReaderAt, int64, error)
+}
+
+// RangeReader is the interface which can be implemented by the readers returned
+// from ReaderAtDataStore.GetReaderAt if reading a range sequentially is cheaper
+// than reading it using multiple ReadAt calls, e.g. because every call causes a
+// request to a remote service. If implemented, the handler uses it to stream the
+// range requested by a GET request in a single pass.
+type RangeReader interface {
+	// ReadRange returns a reader for the length bytes starting at offset. The
+	// returned reader will be closed once everything has been read.
+	ReadRange(offset, length int64) (io.ReadCloser, error)
+}
+
+// DescriberDataStore is the interface which can be implemented by DataStores
+// in order to identify themselves to clients. If implemented, the description
+// is sent in the X-Tusd-Store header in responses to OPTIONS requests, helping
+// to find out which storage backend a deployment uses when debugging.
+type DescriberDataStore interface {
+	DataStore
+
+	// Describe returns the type and version of the storage backend, e.g.
+	// "s3store/1". An empty string causes the header to be omitted.
+	Describe() string
+}
+
+// BufferedDataStore is the interface which can be implemented by DataStores
+// which do not make every received byte durable immediately. For these, the
+// Offset property of FileInfo only covers the bytes which have been flushed,
+// so HEAD requests never report data which may be lost in a crash. However,
+// PATCH requests are also accepted if they continue after any byte which has
+// been written already, allowing clients to proceed without waiting for the
+// next flush.
+type BufferedDataStore interface {
+	DataStore
+
+	// GetWrittenOffset returns the number of bytes which have been written for
+	// the upload, including those which have not been flushed yet. WriteChunk
+	// must accept every offset between the flushed and the written one and
+	// discard the bytes following it.
+	GetWrittenOffset(id string) (int64, error)
+}
+
+// FailerDataStore is the interface which can be implemented by DataStores in
+// order to persist that an upload has failed permanently. Afterwards, the
+// reason must be returned in the Error property of the upload's FileInfo,
+// causing HEAD and PATCH requests to be rejected with ErrUploadFailed.
+type FailerDataStore interface {
+	DataStore
+
+	// FailUpload marks the upload specified by its ID as failed. The handler
+	// only invokes it while holding the upload's lock, so it does not race
+	// with chunk writes.
+	FailUpload(id string, reason string) error
+}
+
+// TruncaterDataStore is the interface which can be implemented by DataStores
+// in order to discard data which has been written partially. The handler uses
+// it for rolling back a chunk whose write has been interrupted by a panic, see
+// Config.RecoverPanics.
+type TruncaterDataStore interface {
+	DataStore
+
+	// Truncate discards all bytes of the upload following the offset. The
+	// handler only invokes it while holding the upload's lock.
+	Truncate(id string, offset int64) error
+}
+
+// CapacityCheckerDataStore is the interface which can be implemented by
+// DataStores which limit the space available to uploads, e.g. LimitedStore.
+// The handler uses it to answer requests with the Tus-Dry-Run header.
+type CapacityCheckerDataStore interface {
+	DataStore
+
+	// CheckCapacity returns the error NewUpload would return if the store
+	// could not hold the upload. It must neither create the upload nor free
+	// or reserve any space.
+	CheckCapacity(info FileInfo) error
+}
+
+// SealerDataStore is the interface which can be implemented by DataStores in
+// order to seal finished uploads, see UnroutedHandler.SealFile. Afterwards,
+// the Sealed property of the upload's FileInfo must be true.
+type SealerDataStore interface {
+	DataStore
+
+	// Seal marks the upload specified by its ID as immutable. The handler
+	// only invokes it for finished uploads while holding the upload's lock.
+	Seal(id string) error
+}
+
+// ExpirerDataStore is the interface required for the expiration extension.
+// The expiration time of a new upload is passed to NewUpload in the Expires
+// property of its FileInfo and must be returned by GetInfo afterwards.
+type ExpirerDataStore interface {
+	TerminaterDataStore
+
+	// SetExpiration updates the time after which the upload expires. It is
+	// invoked after data has been written to the upload, while its lock is
+	// held.
+	SetExpiration(id string, expires time.Time) error
+	// ListUploads returns the info of all uploads in the store, allowing the
+	// expired ones to be found, see UploadLister.
+	ListUploads() ([]FileInfo, error)
+}
+
+// UploadLister is the interface for data stores which are able to enumerate
+// the uploads they contain. It allows state which is only kept in memory, such
+// as the index of a LimitedStore, to be rebuilt after a restart.
+type UploadLister interface {
+	DataStore
+
+	// ListUploads returns the info of all uploads in the store.
+	ListUploads() ([]FileInfo, error)
+}
+
+// LengthDeferrerDataStore is the interface required for the
+// creation-defer-length extension, which allows creating uploads whose size is
+// not known yet.
+type LengthDeferrerDataStore interface {
+	DataStore
+
+	// DeclareLength sets the size of an upload which has been created with
+	// SizeIsDeferred set. Afterwards, GetInfo must return the new size with
+	// SizeIsDeferred unset. It is invoked at most once per upload, while its
+	// lock is held.
+	DeclareLength(id string, length int64) error
+}
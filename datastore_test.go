@@ -0,0 +1,72 @@
+package tusd_test
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+func TestFileInfoJSON(t *testing.T) {
+	a := assert.New(t)
+
+	expires := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	info := FileInfo{
+		ID:     "foo",
+		Size:   10,
+		Offset: 10,
+		MetaData: MetaData{
+			"filename": "hello.txt",
+		},
+		Expires: &expires,
+	}
+
+	data, err := json.Marshal(info)
+	a.NoError(err)
+
+	var fields map[string]interface{}
+	a.NoError(json.Unmarshal(data, &fields))
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	a.Equal([]string{
+		"Expires",
+		"ID",
+		"IsComplete",
+		"IsFinal",
+		"IsPartial",
+		"MetaData",
+		"Offset",
+		"PartialUploads",
+		"Size",
+	}, keys)
+	a.Equal(true, fields["IsComplete"])
+	a.Equal("2017-01-02T03:04:05Z", fields["Expires"])
+
+	// The canonical form can be decoded again
+	var decoded FileInfo
+	a.NoError(json.Unmarshal(data, &decoded))
+	a.Equal(info, decoded)
+
+	// Pointers are encoded identically
+	pointerData, err := json.Marshal(&info)
+	a.NoError(err)
+	a.Equal(string(data), string(pointerData))
+
+	for _, incomplete := range []FileInfo{
+		{Size: 10, Offset: 5},
+		{SizeIsDeferred: true},
+	} {
+		data, err := json.Marshal(incomplete)
+		a.NoError(err)
+		a.NoError(json.Unmarshal(data, &fields))
+		a.Equal(false, fields["IsComplete"])
+	}
+}
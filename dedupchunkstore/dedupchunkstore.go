@@ -0,0 +1,469 @@
+// Package dedupchunkstore provides a storage backend which stores identical
+// chunks of data only once.
+//
+// The uploaded data is split into chunks of a fixed size, based on their
+// offset within the upload. Every chunk is identified by the SHA-256 digest of
+// its content and stored in a `[hash].chunk` file, which is shared by all
+// uploads containing the same chunk. The number of references to a chunk is
+// kept in the `[hash].refs` file and the chunk is removed once the last upload
+// referencing it has been terminated. This saves space for data sets with
+// repeated content, e.g. the same file being uploaded multiple times.
+//
+// The `[id].info` files contain the fileinfo in addition to the list of chunk
+// hashes of each upload. Since a chunk can only be hashed once it is complete,
+// the bytes of an incomplete trailing chunk are kept in the `[id].tail` file
+// until it is filled up or the upload is finished.
+//
+// No cleanup is performed so you may want to run a cronjob to ensure your disks
+// are not filled up with old and finished uploads. In addition, no locking
+// mechanism is provided for uploads, so it is recommended to wrap the store
+// using the memorylocker package.
+package dedupchunkstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/tus/tusd"
+	"github.com/tus/tusd/uid"
+)
+
+var defaultFilePerm = os.FileMode(0775)
+
+// See the tusd.DataStore interface for documentation about the different
+// methods.
+type DedupChunkStore struct {
+	// Relative or absolute path to store the chunks and info files in.
+	// DedupChunkStore does not check whether the path exists, use os.MkdirAll
+	// in this case on your own.
+	Path string
+	// ChunkSize defines the size of the chunks the data is split into. Smaller
+	// chunks find more duplicates but require more files and hashes.
+	ChunkSize int64
+	// FileMode defines the permission bits of the files created for uploads
+	// and chunks. It is applied explicitly after creating a file, so the umask
+	// of the process does not affect it. If zero, the files are created using
+	// 0775 restricted by the umask.
+	FileMode os.FileMode
+
+	// mutex serializes the updates of the reference counts.
+	mutex *sync.Mutex
+}
+
+// New creates a new deduplicating storage backend using the provided directory
+// and chunk size. This method does not check whether the path exists, use
+// os.MkdirAll to ensure.
+func New(path string, chunkSize int64) DedupChunkStore {
+	return DedupChunkStore{
+		Path:      path,
+		ChunkSize: chunkSize,
+		mutex:     new(sync.Mutex),
+	}
+}
+
+// dedupInfo is the content of the .info files. In addition to the fileinfo,
+// it contains the hashes of the upload's complete chunks in order.
+type dedupInfo struct {
+	Info      tusd.FileInfo
+	ChunkSize int64
+	Chunks    []string
+}
+
+func (store DedupChunkStore) NewUpload(info tusd.FileInfo) (id string, err error) {
+	if store.ChunkSize <= 0 || store.mutex == nil {
+		return "", errors.New("dedupchunkstore: store must be created using New with a positive chunk size")
+	}
+
+	if id = info.ID; id == "" {
+		id = uid.Uid()
+	}
+	info.ID = id
+
+	// Create .tail file with no content. It is created exclusively, so an
+	// existing upload with the same ID is never overwritten.
+	file, err := os.OpenFile(store.tailPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+	if os.IsExist(err) {
+		return "", tusd.ErrUploadIDCollision
+	}
+	if err != nil {
+		return "", err
+	}
+	err = store.chmod(file.Name())
+	file.Close()
+	if err != nil {
+		os.Remove(store.tailPath(id))
+		return "", err
+	}
+
+	err = store.writeInfo(id, dedupInfo{
+		Info:      info,
+		ChunkSize: store.ChunkSize,
+	})
+	return
+}
+
+func (store DedupChunkStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return 0, err
+	}
+
+	tail, err := ioutil.ReadFile(store.tailPath(id))
+	if err != nil {
+		return 0, err
+	}
+
+	if offset != layout.Info.Offset {
+		return 0, tusd.ErrMismatchOffset
+	}
+
+	bytesWritten := int64(0)
+	buf := make([]byte, layout.ChunkSize)
+	copy(buf, tail)
+	filled := int64(len(tail))
+
+	// The references taken by this call are released again if the new layout
+	// cannot be stored, since no upload would refer to these chunks otherwise
+	var added []string
+	rollback := func() {
+		for _, hash := range added {
+			store.releaseChunk(hash)
+		}
+	}
+
+	for {
+		n, readErr := io.ReadFull(src, buf[filled:])
+		filled += int64(n)
+		bytesWritten += int64(n)
+		layout.Info.Offset += int64(n)
+
+		// Hash the chunk once it is complete or the upload has been finished
+		complete := filled == layout.ChunkSize || layout.Info.Offset == layout.Info.Size
+		if complete && filled > 0 {
+			hash, err := store.addChunk(buf[:filled])
+			if err != nil {
+				rollback()
+				return 0, err
+			}
+
+			added = append(added, hash)
+			layout.Chunks = append(layout.Chunks, hash)
+			filled = 0
+		}
+
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			err = readErr
+			break
+		}
+	}
+
+	// Keep the incomplete trailing chunk and update the info even if reading
+	// failed, so the received bytes are not lost.
+	if writeErr := store.writeFile(store.tailPath(id), buf[:filled]); writeErr != nil {
+		rollback()
+		return 0, writeErr
+	}
+	if writeErr := store.writeInfo(id, layout); writeErr != nil {
+		rollback()
+		return 0, writeErr
+	}
+
+	return bytesWritten, err
+}
+
+func (store DedupChunkStore) GetInfo(id string) (tusd.FileInfo, error) {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return tusd.FileInfo{}, err
+	}
+
+	return layout.Info, nil
+}
+
+func (store DedupChunkStore) Describe() string {
+	return "dedupchunkstore/1"
+}
+
+func (store DedupChunkStore) GetReader(id string) (io.Reader, error) {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunkReader{
+		paths: append(store.chunkPaths(layout.Chunks), store.tailPath(id)),
+	}, nil
+}
+
+func (store DedupChunkStore) Terminate(id string) error {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(store.infoPath(id)); err != nil {
+		return err
+	}
+	if err := os.Remove(store.tailPath(id)); err != nil {
+		return err
+	}
+
+	for _, hash := range layout.Chunks {
+		if err := store.releaseChunk(hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PoolSize returns the number of bytes occupied by the shared chunks. Data
+// which is contained in multiple uploads is only counted once.
+func (store DedupChunkStore) PoolSize() (int64, error) {
+	paths, err := filepath.Glob(filepath.Join(store.Path, "*.chunk"))
+	if err != nil {
+		return 0, err
+	}
+
+	size := int64(0)
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		size += stat.Size()
+	}
+
+	return size, nil
+}
+
+// addChunk stores the chunk in the pool unless it is already present and
+// increments its reference count. The hash of the chunk is returned.
+func (store DedupChunkStore) addChunk(data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	refs, err := store.readRefs(hash)
+	if err != nil {
+		return "", err
+	}
+
+	if refs == 0 {
+		if err := store.writeFile(store.chunkPath(hash), data); err != nil {
+			os.Remove(store.chunkPath(hash))
+			return "", err
+		}
+	}
+
+	if err := store.writeRefs(hash, refs+1); err != nil {
+		// A new chunk must not remain in the pool without being referenced
+		if refs == 0 {
+			os.Remove(store.chunkPath(hash))
+		}
+		return "", err
+	}
+
+	return hash, nil
+}
+
+// releaseChunk decrements the reference count of the chunk and removes it
+// from the pool once it is not referenced anymore.
+func (store DedupChunkStore) releaseChunk(hash string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	refs, err := store.readRefs(hash)
+	if err != nil {
+		return err
+	}
+
+	if refs > 1 {
+		return store.writeRefs(hash, refs-1)
+	}
+
+	if err := os.Remove(store.chunkPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(store.refsPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// readRefs returns the reference count of the chunk, which is zero if the
+// chunk is not stored.
+func (store DedupChunkStore) readRefs(hash string) (int64, error) {
+	data, err := ioutil.ReadFile(store.refsPath(hash))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// writeRefs stores the reference count of the chunk.
+func (store DedupChunkStore) writeRefs(hash string, refs int64) error {
+	return store.writeFile(store.refsPath(hash), []byte(strconv.FormatInt(refs, 10)))
+}
+
+// chunkPaths returns the paths to the .chunk files of the given hashes.
+func (store DedupChunkStore) chunkPaths(hashes []string) []string {
+	paths := make([]string, len(hashes))
+	for i, hash := range hashes {
+		paths[i] = store.chunkPath(hash)
+	}
+	return paths
+}
+
+// chunkPath returns the path to the .chunk file storing the chunk's content.
+func (store DedupChunkStore) chunkPath(hash string) string {
+	return filepath.Join(store.Path, hash+".chunk")
+}
+
+// refsPath returns the path to the .refs file storing the chunk's reference
+// count.
+func (store DedupChunkStore) refsPath(hash string) string {
+	return filepath.Join(store.Path, hash+".refs")
+}
+
+// infoPath returns the path to the .info file storing the file's info.
+func (store DedupChunkStore) infoPath(id string) string {
+	return filepath.Join(store.Path, id+".info")
+}
+
+// tailPath returns the path to the .tail file storing the incomplete trailing
+// chunk.
+func (store DedupChunkStore) tailPath(id string) string {
+	return filepath.Join(store.Path, id+".tail")
+}
+
+// writeInfo updates the entire information. Everything will be overwritten.
+func (store DedupChunkStore) writeInfo(id string, layout dedupInfo) error {
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+	return store.writeFile(store.infoPath(id), data)
+}
+
+// perm returns the mode used for creating files, see FileMode.
+func (store DedupChunkStore) perm() os.FileMode {
+	if store.FileMode == 0 {
+		return defaultFilePerm
+	}
+	return store.FileMode
+}
+
+// chmod applies the configured FileMode to a newly created file, if set.
+func (store DedupChunkStore) chmod(path string) error {
+	if store.FileMode == 0 {
+		return nil
+	}
+	return os.Chmod(path, store.FileMode)
+}
+
+// writeFile writes the data to a temporary file in the same directory and
+// renames it to the specified path afterwards. Since renaming is atomic, the
+// file contains either the old or the new data at any time, even if the
+// process crashes while writing.
+func (store DedupChunkStore) writeFile(path string, data []byte) error {
+	// The temporary file is created exclusively using a random name, so
+	// concurrent writers do not interfere
+	file, err := os.OpenFile(path+".tmp"+uid.Uid(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+	if err != nil {
+		return err
+	}
+
+	err = store.chmod(file.Name())
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// readInfo reads the fileinfo and chunk list from the .info file.
+func (store DedupChunkStore) readInfo(id string) (dedupInfo, error) {
+	layout := dedupInfo{}
+	data, err := ioutil.ReadFile(store.infoPath(id))
+	if err != nil {
+		return layout, err
+	}
+
+	err = json.Unmarshal(data, &layout)
+	return layout, err
+}
+
+// chunkReader reassembles the content of an upload by reading the referenced
+// chunks, followed by the incomplete trailing chunk, one after another.
+type chunkReader struct {
+	paths []string
+	file  *os.File
+}
+
+func (reader *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if reader.file == nil {
+			if len(reader.paths) == 0 {
+				return 0, io.EOF
+			}
+
+			file, err := os.Open(reader.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			reader.file = file
+			reader.paths = reader.paths[1:]
+		}
+
+		n, err := reader.file.Read(p)
+		if err == io.EOF {
+			// Continue with the next chunk
+			reader.file.Close()
+			reader.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (reader *chunkReader) Close() error {
+	if reader.file == nil {
+		return nil
+	}
+
+	err := reader.file.Close()
+	reader.file = nil
+	return err
+}
@@ -0,0 +1,232 @@
+package dedupchunkstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+// Test interface implementation of DedupChunkStore
+var _ tusd.DataStore = DedupChunkStore{}
+var _ tusd.GetReaderDataStore = DedupChunkStore{}
+var _ tusd.TerminaterDataStore = DedupChunkStore{}
+var _ tusd.DescriberDataStore = DedupChunkStore{}
+
+func TestDedupChunkStore(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-dedupchunkstore-")
+	a.NoError(err)
+
+	store := New(tmp, 4)
+	content := "abcdabcdxyz"
+
+	// Create and write two uploads with the same content, the second one using
+	// chunks which are not aligned to the chunk size
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 11})
+	a.NoError(err)
+	n, err := store.WriteChunk(idA, 0, strings.NewReader(content))
+	a.NoError(err)
+	a.EqualValues(11, n)
+
+	idB, err := store.NewUpload(tusd.FileInfo{Size: 11})
+	a.NoError(err)
+	offset := int64(0)
+	for _, chunk := range []string{"abc", "dabcdx", "yz"} {
+		n, err := store.WriteChunk(idB, offset, strings.NewReader(chunk))
+		a.NoError(err)
+		a.EqualValues(len(chunk), n)
+		offset += n
+	}
+
+	info, err := store.GetInfo(idB)
+	a.NoError(err)
+	a.EqualValues(11, info.Offset)
+
+	// The chunk "abcd" occurs twice in both uploads and "xyz" once, but each
+	// is only stored once
+	size, err := store.PoolSize()
+	a.NoError(err)
+	a.EqualValues(7, size)
+
+	for _, id := range []string{idA, idB} {
+		reader, err := store.GetReader(id)
+		a.NoError(err)
+
+		data, err := ioutil.ReadAll(reader)
+		a.NoError(err)
+		a.Equal(content, string(data))
+		a.NoError(reader.(io.Closer).Close())
+	}
+
+	// Shared chunks are kept until the last reference is removed
+	a.NoError(store.Terminate(idA))
+	size, err = store.PoolSize()
+	a.NoError(err)
+	a.EqualValues(7, size)
+
+	reader, err := store.GetReader(idB)
+	a.NoError(err)
+	data, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal(content, string(data))
+
+	a.NoError(store.Terminate(idB))
+	size, err = store.PoolSize()
+	a.NoError(err)
+	a.EqualValues(0, size)
+
+	paths, err := filepath.Glob(filepath.Join(tmp, "*"))
+	a.NoError(err)
+	a.Empty(paths)
+}
+
+func TestIncompleteChunk(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-dedupchunkstore-")
+	a.NoError(err)
+
+	store := New(tmp, 4)
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	// The incomplete trailing chunk is not part of the pool yet but can be read
+	size, err := store.PoolSize()
+	a.NoError(err)
+	a.EqualValues(4, size)
+
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+	data, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal("hello", string(data))
+
+	_, err = store.WriteChunk(id, 4, strings.NewReader("world"))
+	a.Equal(tusd.ErrMismatchOffset, err)
+}
+
+func TestWriteChunkRollback(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-dedupchunkstore-")
+	a.NoError(err)
+
+	store := New(tmp, 5)
+
+	// The chunk "abcde" is shared with another upload
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 5})
+	a.NoError(err)
+	_, err = store.WriteChunk(idA, 0, strings.NewReader("abcde"))
+	a.NoError(err)
+
+	// Storing the last chunk fails since its reference count cannot be read
+	sum := sha256.Sum256([]byte("world"))
+	a.NoError(os.Mkdir(store.refsPath(hex.EncodeToString(sum[:])), 0775))
+
+	idB, err := store.NewUpload(tusd.FileInfo{Size: 15})
+	a.NoError(err)
+	_, err = store.WriteChunk(idB, 0, strings.NewReader("abcdehelloworld"))
+	a.Error(err)
+
+	info, err := store.GetInfo(idB)
+	a.NoError(err)
+	a.EqualValues(0, info.Offset)
+
+	// The references taken before the failure have been released
+	sum = sha256.Sum256([]byte("hello"))
+	_, err = os.Stat(store.chunkPath(hex.EncodeToString(sum[:])))
+	a.True(os.IsNotExist(err))
+
+	sum = sha256.Sum256([]byte("abcde"))
+	refs, err := store.readRefs(hex.EncodeToString(sum[:]))
+	a.NoError(err)
+	a.EqualValues(1, refs)
+}
+
+func TestNewUploadCollision(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-dedupchunkstore-collision-")
+	a.NoError(err)
+
+	store := New(tmp, 4)
+
+	id, err := store.NewUpload(tusd.FileInfo{ID: "taken", Size: 5})
+	a.NoError(err)
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hel"))
+	a.NoError(err)
+
+	// A second upload with the same ID must not overwrite the first one
+	_, err = store.NewUpload(tusd.FileInfo{ID: "taken", Size: 100})
+	a.Equal(tusd.ErrUploadIDCollision, err)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(5, info.Size)
+	a.EqualValues(3, info.Offset)
+}
+
+func TestFileMode(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-dedupchunkstore-mode-")
+	a.NoError(err)
+
+	// The mode is writable by everyone, so it is only kept if the umask is
+	// not applied
+	store := New(tmp, 4)
+	store.FileMode = 0666
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	sum := sha256.Sum256([]byte("hell"))
+	hash := hex.EncodeToString(sum[:])
+	for _, path := range []string{store.tailPath(id), store.infoPath(id), store.chunkPath(hash), store.refsPath(hash)} {
+		stat, err := os.Stat(path)
+		a.NoError(err)
+		a.Equal(os.FileMode(0666), stat.Mode().Perm(), path)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-dedupchunkstore-atomic-")
+	a.NoError(err)
+
+	store := New(tmp, 4)
+
+	path := filepath.Join(tmp, "file")
+	a.NoError(store.writeFile(path, []byte("old")))
+	a.NoError(store.writeFile(path, []byte("new")))
+	data, err := ioutil.ReadFile(path)
+	a.NoError(err)
+	a.Equal("new", string(data))
+
+	// The temporary file is removed if it cannot replace the target
+	dir := filepath.Join(tmp, "dir")
+	a.NoError(os.Mkdir(dir, 0775))
+	a.NoError(ioutil.WriteFile(filepath.Join(dir, "child"), nil, 0664))
+	a.Error(store.writeFile(dir, []byte("new")))
+
+	paths, err := filepath.Glob(filepath.Join(tmp, "*"))
+	a.NoError(err)
+	a.Equal([]string{dir, path}, paths)
+}
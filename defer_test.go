@@ -0,0 +1,250 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type deferStore struct {
+	*expirationStore
+}
+
+func (s deferStore) DeclareLength(id string, length int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := s.uploads[id]
+	info.Size = length
+	info.SizeIsDeferred = false
+	s.uploads[id] = info
+	return nil
+}
+
+func TestDeferLength(t *testing.T) {
+	store := deferStore{&expirationStore{
+		uploads: make(map[string]FileInfo),
+	}}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+	})
+
+	(&httpTest{
+		Name:   "Advertise extension",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Tus-Extension": "creation,creation-defer-length,creation-with-upload,termination,checksum",
+		},
+	}).Run(handler, t)
+
+	for _, header := range []map[string]string{
+		{"Upload-Defer-Length": "2"},
+		{"Upload-Defer-Length": "1", "Upload-Length": "10"},
+	} {
+		header["Tus-Resumable"] = "1.0.0"
+		(&httpTest{
+			Name:      "Invalid Upload-Defer-Length header",
+			Method:    "POST",
+			ReqHeader: header,
+			Code:      http.StatusBadRequest,
+		}).Run(handler, t)
+	}
+
+	(&httpTest{
+		Name:   "Create upload with deferred length",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Defer-Length": "1",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	if !store.uploads["new"].SizeIsDeferred {
+		t.Fatal("Expected upload to be created with deferred length")
+	}
+
+	w := (&httpTest{
+		Name:   "Deferred length",
+		Method: "HEAD",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Defer-Length": "1",
+			"Upload-Offset":       "0",
+		},
+	}).Run(handler, t)
+
+	if header := w.HeaderMap.Get("Upload-Length"); header != "" {
+		t.Errorf("Expected no Upload-Length header but got '%s'", header)
+	}
+
+	(&httpTest{
+		Name:   "Write without length",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Length smaller than offset",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+			"Upload-Length": "3",
+		},
+		ReqBody: strings.NewReader("world"),
+		Code:    http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Declare length",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+			"Upload-Length": "10",
+		},
+		ReqBody: strings.NewReader("world"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Declared length",
+		Method: "HEAD",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Length": "10",
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Length can only be declared once",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "10",
+			"Upload-Length": "12",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+}
+
+func TestDeferLengthUnsupported(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: zeroStore{},
+	})
+
+	(&httpTest{
+		Name:   "Store cannot declare length",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Defer-Length": "1",
+		},
+		Code: http.StatusPreconditionFailed,
+	}).Run(handler, t)
+}
+
+func TestDeferLengthAfterLastChunk(t *testing.T) {
+	a := assert.New(t)
+	store := deferStore{&expirationStore{
+		uploads: make(map[string]FileInfo),
+	}}
+	completed := make(chan FileInfo, 1)
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+		CompleteUploadsCallback: func(info FileInfo) {
+			completed <- info
+		},
+	})
+
+	(&httpTest{
+		Name:   "Create upload with deferred length",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":       "1.0.0",
+			"Upload-Defer-Length": "1",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Upload all data",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	select {
+	case <-completed:
+		t.Fatal("Upload must not be finished before its length is known")
+	default:
+	}
+
+	(&httpTest{
+		Name:   "Declare length without body",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	select {
+	case info := <-completed:
+		a.Equal("new", info.ID)
+		a.EqualValues(5, info.Size)
+		a.EqualValues(5, info.Offset)
+	case <-time.After(time.Second):
+		t.Fatal("Upload has not been finished")
+	}
+}
@@ -0,0 +1,73 @@
+package tusd_test
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+func TestExpectContinue(t *testing.T) {
+	a := assert.New(t)
+	store := &expirationStore{
+		uploads: map[string]FileInfo{
+			"foo": {ID: "foo", Size: 10, Offset: 5},
+		},
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+	})
+	server := httptest.NewServer(http.StripPrefix("/files/", handler))
+	defer server.Close()
+
+	patch := func(offset string) (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = conn.Write([]byte("PATCH /files/foo HTTP/1.1\r\n" +
+			"Host: tus.io\r\n" +
+			"Tus-Resumable: 1.0.0\r\n" +
+			"Content-Type: application/offset+octet-stream\r\n" +
+			"Upload-Offset: " + offset + "\r\n" +
+			"Content-Length: 5\r\n" +
+			"Expect: 100-continue\r\n\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return conn, bufio.NewReader(conn)
+	}
+
+	// A rejected request is answered without waiting for the body
+	conn, reader := patch("0")
+	res, err := http.ReadResponse(reader, nil)
+	a.NoError(err)
+	a.Equal(http.StatusConflict, res.StatusCode)
+	conn.Close()
+
+	// An accepted request receives 100 Continue before the body is sent
+	conn, reader = patch("5")
+	defer conn.Close()
+	status, err := reader.ReadString('\n')
+	a.NoError(err)
+	a.True(strings.HasPrefix(status, "HTTP/1.1 100 Continue"), status)
+	line, err := reader.ReadString('\n')
+	a.NoError(err)
+	a.Equal("\r\n", line)
+
+	_, err = conn.Write([]byte("world"))
+	a.NoError(err)
+	res, err = http.ReadResponse(reader, nil)
+	a.NoError(err)
+	a.Equal(http.StatusNoContent, res.StatusCode)
+	a.Equal("10", res.Header.Get("Upload-Offset"))
+}
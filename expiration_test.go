@@ -0,0 +1,255 @@
+package tusd_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type expirationStore struct {
+	mutex      sync.Mutex
+	uploads    map[string]FileInfo
+	terminated []string
+}
+
+func (s *expirationStore) NewUpload(info FileInfo) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info.ID = "new"
+	s.uploads[info.ID] = info
+	return info.ID, nil
+}
+
+func (s *expirationStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := s.uploads[id]
+	info.Offset += int64(len(data))
+	s.uploads[id] = info
+	return int64(len(data)), nil
+}
+
+func (s *expirationStore) GetInfo(id string) (FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.uploads[id], nil
+}
+
+func (s *expirationStore) Terminate(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.uploads, id)
+	s.terminated = append(s.terminated, id)
+	return nil
+}
+
+func (s *expirationStore) SetExpiration(id string, expires time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := s.uploads[id]
+	info.Expires = &expires
+	s.uploads[id] = info
+	return nil
+}
+
+func (s *expirationStore) ListUploads() ([]FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var infos []FileInfo
+	for _, info := range s.uploads {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func TestExpiration(t *testing.T) {
+	a := assert.New(t)
+	store := &expirationStore{
+		uploads: make(map[string]FileInfo),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore:        store,
+		BasePath:         "/files/",
+		UploadExpiration: time.Hour,
+	})
+
+	(&httpTest{
+		Name:   "Advertise extension",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Tus-Extension": "creation,creation-with-upload,termination,expiration,checksum",
+		},
+	}).Run(handler, t)
+
+	w := (&httpTest{
+		Name:   "Create expiring upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "10",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	expires, err := time.Parse(http.TimeFormat, w.HeaderMap.Get("Upload-Expires"))
+	a.NoError(err)
+	a.WithinDuration(time.Now().Add(time.Hour), expires, time.Minute)
+	a.NotNil(store.uploads["new"].Expires)
+
+	(&httpTest{
+		Name:   "Announce expiration",
+		Method: "HEAD",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Expires": w.HeaderMap.Get("Upload-Expires"),
+		},
+	}).Run(handler, t)
+
+	// Writing data postpones the expiration
+	store.uploads["new"] = FileInfo{
+		ID:      "new",
+		Size:    10,
+		Expires: &time.Time{},
+	}
+	w = (&httpTest{
+		Name:   "Postpone expiration",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	a.NotEmpty(w.HeaderMap.Get("Upload-Expires"))
+	a.WithinDuration(time.Now().Add(time.Hour), *store.uploads["new"].Expires, time.Minute)
+
+	// Only unfinished uploads whose expiration time has passed are removed
+	past := time.Now().Add(-time.Minute)
+	store.uploads["expired"] = FileInfo{ID: "expired", Size: 10, Offset: 5, Expires: &past}
+	store.uploads["finished"] = FileInfo{ID: "finished", Size: 10, Offset: 10, Expires: &past}
+
+	a.NoError(handler.Unrouted.CleanupExpiredUploads())
+	a.Equal([]string{"expired"}, store.terminated)
+	a.Contains(store.uploads, "new")
+	a.Contains(store.uploads, "finished")
+}
+
+func TestExpirationDisabled(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+		BasePath: "/files/",
+	})
+
+	w := (&httpTest{
+		Name:   "Create upload without expiration",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "10",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	if header := w.HeaderMap.Get("Upload-Expires"); header != "" {
+		t.Errorf("Expected no Upload-Expires header but got '%s'", header)
+	}
+
+	if err := handler.Unrouted.CleanupExpiredUploads(); err != ErrNotImplemented {
+		t.Errorf("Expected ErrNotImplemented but got %v", err)
+	}
+}
+
+func TestMaxUploadDuration(t *testing.T) {
+	a := assert.New(t)
+	store := &expirationStore{
+		uploads: make(map[string]FileInfo),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore:         store,
+		BasePath:          "/files/",
+		UploadExpiration:  time.Hour,
+		MaxUploadDuration: 2 * time.Hour,
+	})
+
+	(&httpTest{
+		Name:   "Create upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "10",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	created := store.uploads["new"].CreatedAt
+	if a.NotNil(created) {
+		a.WithinDuration(time.Now(), *created, time.Minute)
+	}
+
+	// Writing data does not postpone the expiration beyond the limit
+	longAgo := time.Now().Add(-90 * time.Minute)
+	store.uploads["new"] = FileInfo{
+		ID:        "new",
+		Size:      10,
+		CreatedAt: &longAgo,
+	}
+	w := (&httpTest{
+		Name:   "Postpone expiration until limit",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	a.NotEmpty(w.HeaderMap.Get("Upload-Expires"))
+	a.WithinDuration(longAgo.Add(2*time.Hour), *store.uploads["new"].Expires, time.Second)
+
+	// Uploads exceeding the limit are removed even if they have been active
+	// recently
+	tooLongAgo := time.Now().Add(-3 * time.Hour)
+	later := time.Now().Add(time.Hour)
+	store.uploads["slow"] = FileInfo{ID: "slow", Size: 10, Offset: 5, CreatedAt: &tooLongAgo, Expires: &later}
+	store.uploads["finished"] = FileInfo{ID: "finished", Size: 10, Offset: 10, CreatedAt: &tooLongAgo}
+	store.uploads["unlimited"] = FileInfo{ID: "unlimited", Size: 10, Offset: 5, Expires: &later}
+
+	a.NoError(handler.Unrouted.CleanupExpiredUploads())
+	a.Equal([]string{"slow"}, store.terminated)
+	a.Contains(store.uploads, "new")
+	a.Contains(store.uploads, "finished")
+	a.Contains(store.uploads, "unlimited")
+}
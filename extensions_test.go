@@ -0,0 +1,106 @@
+package tusd_test
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+type extensionStore struct {
+	zeroStore
+}
+
+func (s extensionStore) Terminate(id string) error {
+	return nil
+}
+
+func (s extensionStore) ConcatUploads(id string, uploads []string) error {
+	return nil
+}
+
+func TestExtensions(t *testing.T) {
+	tests := []struct {
+		Extension  string
+		Advertised string
+		Test       httpTest
+	}{
+		{
+			Extension:  "creation",
+			Advertised: "termination,concatenation",
+			Test: httpTest{
+				Method: "POST",
+				ReqHeader: map[string]string{
+					"Tus-Resumable": "1.0.0",
+					"Upload-Length": "300",
+				},
+				Code: http.StatusPreconditionFailed,
+			},
+		},
+		{
+			Extension:  "termination",
+			Advertised: "creation,concatenation",
+			Test: httpTest{
+				Method: "DELETE",
+				URL:    "foo",
+				ReqHeader: map[string]string{
+					"Tus-Resumable": "1.0.0",
+				},
+				Code: http.StatusMethodNotAllowed,
+			},
+		},
+		{
+			Extension:  "concatenation",
+			Advertised: "creation,termination",
+			Test: httpTest{
+				Method: "POST",
+				ReqHeader: map[string]string{
+					"Tus-Resumable": "1.0.0",
+					"Upload-Length": "300",
+					"Upload-Concat": "partial",
+				},
+				Code: http.StatusPreconditionFailed,
+			},
+		},
+	}
+
+	all := []string{"creation", "termination", "concatenation"}
+
+	for _, test := range tests {
+		var enabled []string
+		for _, name := range all {
+			if name != test.Extension {
+				enabled = append(enabled, name)
+			}
+		}
+
+		handler, _ := NewHandler(Config{
+			DataStore:  extensionStore{},
+			Extensions: enabled,
+		})
+
+		(&httpTest{
+			Name:   "Advertise without " + test.Extension,
+			Method: "OPTIONS",
+			Code:   http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Tus-Extension": test.Advertised,
+			},
+		}).Run(handler, t)
+
+		test.Test.Name = "Request with disabled " + test.Extension
+		test.Test.Run(handler, t)
+
+		// The same request is accepted if all extensions are enabled
+		handler, _ = NewHandler(Config{
+			DataStore: extensionStore{},
+		})
+
+		test.Test.Name = "Request with enabled " + test.Extension
+		test.Test.Code = map[string]int{
+			"POST":   http.StatusCreated,
+			"DELETE": http.StatusNoContent,
+		}[test.Test.Method]
+		test.Test.Run(handler, t)
+	}
+}
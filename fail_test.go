@@ -0,0 +1,102 @@
+package tusd_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type failStore struct {
+	zeroStore
+	reasons map[string]string
+}
+
+func (s failStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:     id,
+		Offset: 0,
+		Size:   10,
+		Error:  s.reasons[id],
+	}, nil
+}
+
+func (s failStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return 0, UnrecoverableError{"checksum of stored data does not match"}
+}
+
+func (s failStore) FailUpload(id string, reason string) error {
+	s.reasons[id] = reason
+	return nil
+}
+
+func TestFailedUpload(t *testing.T) {
+	a := assert.New(t)
+
+	store := failStore{
+		reasons: make(map[string]string),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+	})
+
+	(&httpTest{
+		Name:   "Writing corrupted upload",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusGone,
+	}).Run(handler, t)
+
+	a.Equal("checksum of stored data does not match", store.reasons["foo"])
+
+	(&httpTest{
+		Name:   "HEAD request for failed upload",
+		Method: "HEAD",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusGone,
+		ResHeader: map[string]string{
+			"Upload-Error": "checksum of stored data does not match",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "PATCH request for failed upload",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusGone,
+	}).Run(handler, t)
+
+	store.reasons["bar"] = "broken\nreason"
+
+	(&httpTest{
+		Name:   "Control characters are removed from the reason",
+		Method: "HEAD",
+		URL:    "bar",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusGone,
+		ResHeader: map[string]string{
+			"Upload-Error": "broken reason",
+		},
+	}).Run(handler, t)
+}
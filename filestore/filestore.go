@@ -3,7 +3,11 @@
 // FileStore is a storage backend used as a tusd.DataStore in tusd.NewHandler.
 // It stores the uploads in a directory specified in two different files: The
 // `[id].info` files are used to store the fileinfo in JSON format. The
-// `[id].bin` files contain the raw binary data uploaded.
+// `[id].bin` files contain the raw binary data uploaded. If the write-ahead
+// log is enabled, `[id].wal` files temporarily hold chunks which have not been
+// applied to the `[id].bin` files yet. If a flush policy is configured, the
+// `[id].offset` files contain the number of bytes which have been synced to
+// disk.
 // No cleanup is performed so you may want to run a cronjob to ensure your disk
 // is not filled up with old and finished uploads.
 //
@@ -11,16 +15,27 @@
 // which are stored on disk. Each of them stores the PID of the process which
 // aquired the lock. This allows locks to be automatically freed when a process
 // is unable to release it on its own because the process is not alive anymore.
+// If a lock namespace is configured, the lock files are named
+// `[namespace].[id].lock`, so multiple services can share a directory
+// without interfering with each other's locks.
 // For more information, consult the documentation for tusd.LockerDataStore
 // interface, which is implemented by FileStore
 package filestore
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/tus/tusd"
 	"github.com/tus/tusd/uid"
@@ -29,40 +44,152 @@ import (
 )
 
 var defaultFilePerm = os.FileMode(0775)
+var defaultDirPerm = os.FileMode(0775)
+
+// generateID returns the ID for a new upload. It is a variable, so collisions
+// can be simulated in tests.
+var generateID = uid.Uid
+
+// infoFormat identifies the format of compressed info files. It is stored in
+// the comment of their gzip header, so the format can be changed in the
+// future without misinterpreting existing files.
+const infoFormat = "tusd-info/1"
+
+var (
+	reLockNamespace = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+	ErrInvalidLockNamespace = errors.New("filestore: lock namespace may only contain ASCII letters, digits, dashes and underscores")
+	ErrUnknownInfoFormat    = errors.New("filestore: info file has been written using an unknown format version")
+)
 
 // See the tusd.DataStore interface for documentation about the different
 // methods.
 type FileStore struct {
-	// Relative or absolute path to store files in. FileStore does not check
-	// whether the path exists, use os.MkdirAll in this case on your own.
+	// Relative or absolute path to store files in. If the directory does not
+	// exist, it is created, including its parents, once the first upload is
+	// created.
 	Path string
+	// CompressInfo enables gzip compression for the `[id].info` files in order
+	// to reduce the disk space used for storing the information of a high
+	// number of uploads. Compressed and uncompressed info files can be read
+	// regardless of this setting, so it can be enabled for existing stores.
+	// Compressed files carry a format version, and files written by a newer
+	// version of the store are rejected using ErrUnknownInfoFormat.
+	CompressInfo bool
+	// EnableWAL causes every chunk to be written to a write-ahead log, the
+	// `[id].wal` file, and synced to disk before it is applied to the
+	// `[id].bin` file. Therefore, once WriteChunk returns, the chunk is
+	// persisted even if the process crashes while applying it. A log which has
+	// not been applied is replayed by the next write to the upload or by
+	// Recover, both of which run while the upload is locked. Until then,
+	// GetInfo reports the offset at which the logged chunk starts. This
+	// trades throughput for durability since every chunk is written twice.
+	EnableWAL bool
+	// Flush defines how often the received data is synced to disk. Only the
+	// synced bytes are reported as the upload's offset, so clients never
+	// resume after data which may be lost in a crash. If nil, the data is not
+	// synced explicitly and the offset is the size of the `[id].bin` file. The
+	// policy has no effect if EnableWAL is set since every chunk is synced
+	// already in this case.
+	Flush *FlushPolicy
+	// LockNamespace scopes the lock files, allowing multiple services to use
+	// the same directory for locking without seeing each other's locks. Locks
+	// of different namespaces do not exclude each other. If empty, the lock
+	// files are not scoped.
+	LockNamespace string
+	// FileMode defines the permission bits of the files created for uploads,
+	// e.g. 0640 in order to prevent them from being world-readable on shared
+	// hosts. It is applied explicitly after creating a file, so the umask of
+	// the process does not affect it. If zero, the files are created using
+	// 0775 restricted by the umask.
+	FileMode os.FileMode
+	// DirMode defines the permission bits of the directories created by the
+	// store, i.e. the one at Path if it does not exist yet. Similar to
+	// FileMode, it is applied explicitly. If zero, the directories are created
+	// using 0775 restricted by the umask.
+	DirMode os.FileMode
+	// Group optionally defines the ID of the group owning the created files
+	// and directories, e.g. for sharing them with another service without
+	// making them world-readable. The process must be a member of the group.
+	// If zero, the group is not changed. Changing the group is not supported
+	// on Windows, causing the creation of uploads to fail.
+	Group int
+}
+
+// FlushPolicy defines how often FileStore syncs the received data to disk and
+// persists the offset. If both limits are zero, this happens after every
+// chunk. Otherwise, it happens while writing once one of the limits has been
+// reached, but at the latest once the upload has been finished. Larger limits
+// increase the throughput but also the amount of data which must be uploaded
+// again after a crash.
+type FlushPolicy struct {
+	// Bytes is the number of bytes which may be received before flushing.
+	Bytes int64
+	// Interval is the time which may pass before flushing.
+	Interval time.Duration
 }
 
 // New creates a new file based storage backend. The directory specified will
 // be used as the only storage entry. This method does not check
-// whether the path exists, use os.MkdirAll to ensure.
+// whether the path exists, it is created along with the first upload.
 // In addition, a locking mechanism is provided.
 func New(path string) FileStore {
-	return FileStore{path}
+	return FileStore{Path: path}
 }
 
 func (store FileStore) NewUpload(info tusd.FileInfo) (id string, err error) {
-	id = uid.Uid()
+	if id = info.ID; id == "" {
+		id = generateID()
+	}
 	info.ID = id
 
-	// Create .bin file with no content
-	file, err := os.OpenFile(store.binPath(id), os.O_CREATE|os.O_WRONLY, defaultFilePerm)
+	// The lock files of namespaces are told apart by the dot following the
+	// namespace, see ActiveLocks
+	if strings.Contains(id, ".") {
+		return "", tusd.ErrInvalidUploadID
+	}
+
+	// Create .bin file with no content. It is created exclusively, so an
+	// upload whose ID has been generated by another process sharing the
+	// directory is never overwritten.
+	file, err := os.OpenFile(store.binPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+	if os.IsNotExist(err) {
+		if err = store.createDir(); err != nil {
+			return
+		}
+		file, err = os.OpenFile(store.binPath(id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+	}
+	if os.IsExist(err) {
+		return "", tusd.ErrUploadIDCollision
+	}
 	if err != nil {
 		return
 	}
 	defer file.Close()
 
+	if err = store.applyPerm(file); err != nil {
+		return
+	}
+
 	// writeInfo creates the file by itself if necessary
-	err = store.writeInfo(id, info)
+	if err = store.writeInfo(id, info); err != nil {
+		return
+	}
+
+	if store.Flush != nil && !store.EnableWAL {
+		err = store.writeOffset(id, 0)
+	}
 	return
 }
 
 func (store FileStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	if store.EnableWAL {
+		return store.writeChunkWAL(id, offset, src)
+	}
+	if store.Flush != nil {
+		return store.writeChunkFlush(id, offset, src)
+	}
+
 	file, err := os.OpenFile(store.binPath(id), os.O_WRONLY|os.O_APPEND, defaultFilePerm)
 	if err != nil {
 		return 0, err
@@ -75,7 +202,8 @@ func (store FileStore) WriteChunk(id string, offset int64, src io.Reader) (int64
 
 func (store FileStore) GetInfo(id string) (tusd.FileInfo, error) {
 	info := tusd.FileInfo{}
-	data, err := ioutil.ReadFile(store.infoPath(id))
+
+	data, err := store.readInfo(id)
 	if err != nil {
 		return info, err
 	}
@@ -90,11 +218,240 @@ func (store FileStore) GetInfo(id string) (tusd.FileInfo, error) {
 
 	info.Offset = stat.Size()
 
-	return info, nil
+	if store.EnableWAL {
+		// GetInfo is invoked without holding the upload's lock, e.g. for HEAD
+		// requests, so a pending log is not replayed here since it may be
+		// written concurrently. Its chunk has not been acknowledged yet.
+		if logged, ok, err := store.loggedOffset(id); err != nil {
+			return info, err
+		} else if ok && logged < info.Offset {
+			info.Offset = logged
+		}
+	}
+
+	if store.Flush != nil && !store.EnableWAL {
+		info.Offset, err = store.readOffset(id, info.Offset)
+	}
+
+	return info, err
+}
+
+// FailUpload stores the reason in the `[id].info` file, marking the upload as
+// failed permanently.
+func (store FileStore) FailUpload(id string, reason string) error {
+	data, err := store.readInfo(id)
+	if err != nil {
+		return err
+	}
+
+	info := tusd.FileInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return err
+	}
+
+	info.Error = reason
+	return store.writeInfo(id, info)
+}
+
+// Seal stores the sealed flag in the `[id].info` file.
+func (store FileStore) Seal(id string) error {
+	data, err := store.readInfo(id)
+	if err != nil {
+		return err
+	}
+
+	info := tusd.FileInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return err
+	}
+
+	info.Sealed = true
+	return store.writeInfo(id, info)
+}
+
+// SetExpiration stores the expiration time in the `[id].info` file.
+func (store FileStore) SetExpiration(id string, expires time.Time) error {
+	data, err := store.readInfo(id)
+	if err != nil {
+		return err
+	}
+
+	info := tusd.FileInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return err
+	}
+
+	info.Expires = &expires
+	return store.writeInfo(id, info)
+}
+
+// DeclareLength stores the size of an upload created with a deferred length in
+// the `[id].info` file.
+func (store FileStore) DeclareLength(id string, length int64) error {
+	data, err := store.readInfo(id)
+	if err != nil {
+		return err
+	}
+
+	info := tusd.FileInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return err
+	}
+
+	info.Size = length
+	info.SizeIsDeferred = false
+	return store.writeInfo(id, info)
+}
+
+// ListUploads returns the info of all uploads which have an `[id].info` file.
+// Uploads which are terminated while listing them are skipped.
+func (store FileStore) ListUploads() ([]tusd.FileInfo, error) {
+	paths, err := filepath.Glob(filepath.Join(store.Path, "*.info"))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]tusd.FileInfo, 0, len(paths))
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".info")
+		info, err := store.GetInfo(id)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		info.ID = id
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// Recover rebuilds the `[id].info` file of an upload whose data survived while
+// its info has been lost, so the upload can be finished or downloaded again.
+// Since the original size and metadata are unknown, the reconstructed info
+// uses the amount of stored data as both size and offset, marking the upload
+// as finished, and contains no metadata. If the info file exists, it is left
+// untouched and the current info is returned. A pending write-ahead log is
+// applied in either case, so the upload's lock must be held. If the `[id].bin`
+// file is missing, the upload cannot be recovered and an error satisfying
+// os.IsNotExist is returned.
+func (store FileStore) Recover(id string) (tusd.FileInfo, error) {
+	if store.EnableWAL {
+		if err := store.replayWAL(id); err != nil && !os.IsNotExist(err) {
+			return tusd.FileInfo{}, err
+		}
+	}
+
+	if _, err := store.readInfo(id); err == nil {
+		return store.GetInfo(id)
+	} else if !os.IsNotExist(err) {
+		return tusd.FileInfo{}, err
+	}
+
+	stat, err := os.Stat(store.binPath(id))
+	if err != nil {
+		return tusd.FileInfo{}, err
+	}
+
+	size := stat.Size()
+	if store.Flush != nil && !store.EnableWAL {
+		// Only the data which has been synced to disk is reliable
+		if size, err = store.readOffset(id, size); err != nil {
+			return tusd.FileInfo{}, err
+		}
+	}
+
+	info := tusd.FileInfo{
+		ID:   id,
+		Size: size,
+	}
+	if err := store.writeInfo(id, info); err != nil {
+		return tusd.FileInfo{}, err
+	}
+
+	return store.GetInfo(id)
 }
 
+// Truncate discards the bytes following the offset, including those which have
+// only been written to the write-ahead log. The upload must be locked.
+func (store FileStore) Truncate(id string, offset int64) error {
+	if store.EnableWAL {
+		if err := os.Remove(store.walPath(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Truncate(store.binPath(id), offset); err != nil {
+		return err
+	}
+
+	if store.Flush != nil && !store.EnableWAL {
+		flushed, err := store.readOffset(id, offset)
+		if err != nil {
+			return err
+		}
+		if flushed > offset {
+			return store.writeOffset(id, offset)
+		}
+	}
+
+	return nil
+}
+
+// GetWrittenOffset returns the size of the `[id].bin` file which includes the
+// bytes which have not been flushed yet.
+func (store FileStore) GetWrittenOffset(id string) (int64, error) {
+	stat, err := os.Stat(store.binPath(id))
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
+
+func (store FileStore) Describe() string {
+	return "filestore/1"
+}
+
+// GetReader returns a reader for the `[id].bin` file which is limited to the
+// upload's current offset. For uploads which are still being written, only the
+// durable bytes are returned, followed by io.EOF, even if the file already
+// contains data which has not been flushed yet. Since the offset is captured
+// once, the reader serves a stable snapshot and ignores chunks appended while
+// reading.
 func (store FileStore) GetReader(id string) (io.Reader, error) {
-	return os.Open(store.binPath(id))
+	info, err := store.GetInfo(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(store.binPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return limitedFile{
+		Reader: io.LimitReader(file, info.Offset),
+		file:   file,
+	}, nil
+}
+
+func (store FileStore) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	file, err := os.Open(store.binPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, stat.Size(), nil
 }
 
 func (store FileStore) Terminate(id string) error {
@@ -104,6 +461,12 @@ func (store FileStore) Terminate(id string) error {
 	if err := os.Remove(store.binPath(id)); err != nil {
 		return err
 	}
+	if err := os.Remove(store.walPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(store.offsetPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
 	return nil
 }
 
@@ -125,6 +488,15 @@ func (store FileStore) ConcatUploads(dest string, uploads []string) (err error)
 		}
 	}
 
+	if store.Flush != nil && !store.EnableWAL {
+		stat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+
+		return store.flush(dest, file, stat.Size())
+	}
+
 	return
 }
 
@@ -160,9 +532,62 @@ func (store FileStore) UnlockUpload(id string) error {
 	return nil
 }
 
+// ActiveLocks returns the locks held by any process using the same directory
+// and lock namespace. The holder of a lock is the PID stored in the lock file.
+func (store FileStore) ActiveLocks() []tusd.LockInfo {
+	prefix := store.lockPrefix()
+	paths, err := filepath.Glob(filepath.Join(store.Path, prefix+"*.lock"))
+	if err != nil {
+		return nil
+	}
+
+	locks := make([]tusd.LockInfo, 0, len(paths))
+	for _, path := range paths {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), prefix), ".lock")
+
+		// NewUpload rejects IDs containing dots, so these are locks of other
+		// namespaces, whose names end with a dot
+		if strings.Contains(id, ".") {
+			continue
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			// The lock may have been released in the meantime
+			continue
+		}
+
+		pid, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		locks = append(locks, tusd.LockInfo{
+			ID:     id,
+			Holder: strings.TrimSpace(string(pid)),
+			Since:  stat.ModTime(),
+		})
+	}
+
+	return locks
+}
+
+// lockPrefix returns the prefix of the lock files' names for the namespace.
+func (store FileStore) lockPrefix() string {
+	if store.LockNamespace == "" {
+		return ""
+	}
+
+	return store.LockNamespace + "."
+}
+
 // newLock contructs a new Lockfile instance.
 func (store FileStore) newLock(id string) (lockfile.Lockfile, error) {
-	path, err := filepath.Abs(store.Path + "/" + id + ".lock")
+	if store.LockNamespace != "" && !reLockNamespace.MatchString(store.LockNamespace) {
+		return lockfile.Lockfile(""), ErrInvalidLockNamespace
+	}
+
+	path, err := filepath.Abs(store.Path + "/" + store.lockPrefix() + id + ".lock")
 	if err != nil {
 		return lockfile.Lockfile(""), err
 	}
@@ -183,11 +608,381 @@ func (store FileStore) infoPath(id string) string {
 	return store.Path + "/" + id + ".info"
 }
 
+// walPath returns the path to the .wal file storing the write-ahead log.
+func (store FileStore) walPath(id string) string {
+	return store.Path + "/" + id + ".wal"
+}
+
+// offsetPath returns the path to the .offset file storing the flushed offset.
+func (store FileStore) offsetPath(id string) string {
+	return store.Path + "/" + id + ".offset"
+}
+
+// writeChunkFlush writes the chunk at the given offset, discarding any bytes
+// following it which have not been flushed, and flushes according to the
+// policy.
+func (store FileStore) writeChunkFlush(id string, offset int64, src io.Reader) (int64, error) {
+	data, err := store.readInfo(id)
+	if err != nil {
+		return 0, err
+	}
+	info := tusd.FileInfo{}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return 0, err
+	}
+
+	file, err := os.OpenFile(store.binPath(id), os.O_WRONLY, defaultFilePerm)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err := file.Seek(offset, 0); err != nil {
+		return 0, err
+	}
+
+	flushed, err := store.readOffset(id, offset)
+	if err != nil {
+		return 0, err
+	}
+	lastFlush := time.Now()
+	if stat, err := os.Stat(store.offsetPath(id)); err == nil {
+		lastFlush = stat.ModTime()
+	}
+
+	policy := store.Flush
+	everyChunk := policy.Bytes <= 0 && policy.Interval <= 0
+
+	buf := make([]byte, 32*1024)
+	bytesWritten := int64(0)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := file.Write(buf[:n]); err != nil {
+				return bytesWritten, err
+			}
+			bytesWritten += int64(n)
+		}
+
+		current := offset + bytesWritten
+		if !everyChunk && current > flushed &&
+			((policy.Bytes > 0 && current-flushed >= policy.Bytes) ||
+				(policy.Interval > 0 && time.Since(lastFlush) >= policy.Interval) ||
+				current == info.Size) {
+			if err := store.flush(id, file, current); err != nil {
+				return bytesWritten, err
+			}
+			flushed = current
+			lastFlush = time.Now()
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return bytesWritten, readErr
+		}
+	}
+
+	if everyChunk {
+		return bytesWritten, store.flush(id, file, offset+bytesWritten)
+	}
+
+	return bytesWritten, nil
+}
+
+// flush syncs the .bin file to disk and persists the offset afterwards.
+func (store FileStore) flush(id string, file *os.File, offset int64) error {
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	return store.writeOffset(id, offset)
+}
+
+// writeOffset stores the flushed offset in the .offset file.
+func (store FileStore) writeOffset(id string, offset int64) error {
+	return store.writeFileAtomic(store.offsetPath(id), []byte(strconv.FormatInt(offset, 10)))
+}
+
+// readOffset returns the flushed offset from the .offset file. If the file
+// does not exist, e.g. because the upload has been created before the flush
+// policy was configured, the fallback value is returned.
+func (store FileStore) readOffset(id string, fallback int64) (int64, error) {
+	data, err := ioutil.ReadFile(store.offsetPath(id))
+	if os.IsNotExist(err) {
+		return fallback, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+// writeChunkWAL writes the chunk to the write-ahead log, syncs it to disk and
+// applies it to the .bin file afterwards.
+func (store FileStore) writeChunkWAL(id string, offset int64, src io.Reader) (int64, error) {
+	// Apply a log which may be left over from a crash before writing a new one
+	if err := store.replayWAL(id); err != nil {
+		return 0, err
+	}
+
+	n, err := store.writeWAL(id, offset, src)
+
+	// Even if reading the chunk failed, the received bytes have been logged and
+	// are applied, similar to WriteChunk without the write-ahead log.
+	if applyErr := store.replayWAL(id); applyErr != nil {
+		return 0, applyErr
+	}
+
+	return n, err
+}
+
+// writeWAL stores the offset followed by the chunk's data in the .wal file and
+// syncs it to disk.
+func (store FileStore) writeWAL(id string, offset int64, src io.Reader) (int64, error) {
+	file, err := os.OpenFile(store.walPath(id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, store.perm())
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	if err := store.applyPerm(file); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, uint64(offset))
+	if _, err := file.Write(header); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(file, src)
+	if syncErr := file.Sync(); syncErr != nil && err == nil {
+		err = syncErr
+	}
+
+	return n, err
+}
+
+// replayWAL applies the chunk stored in the .wal file to the .bin file, if a
+// log exists, and removes the log afterwards. Since the .bin file is truncated
+// to the logged offset before writing, a log can safely be applied multiple
+// times, e.g. if the process crashed while applying it the last time.
+func (store FileStore) replayWAL(id string) error {
+	wal, err := os.Open(store.walPath(id))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := store.applyWAL(id, wal); err != nil {
+		wal.Close()
+		return err
+	}
+
+	// The log must be closed before removing it since this is not possible for
+	// open files on Windows.
+	wal.Close()
+	return os.Remove(store.walPath(id))
+}
+
+// loggedOffset returns the offset of the chunk stored in the .wal file without
+// applying it. ok is false if no log exists or its header is incomplete.
+func (store FileStore) loggedOffset(id string) (offset int64, ok bool, err error) {
+	wal, err := os.Open(store.walPath(id))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer wal.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(wal, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return int64(binary.BigEndian.Uint64(header)), true, nil
+}
+
+// applyWAL copies the chunk from the write-ahead log into the .bin file.
+func (store FileStore) applyWAL(id string, wal io.Reader) error {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(wal, header); err != nil {
+		// An incomplete header means that the process crashed before any data
+		// was logged, so there is nothing to apply.
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	offset := int64(binary.BigEndian.Uint64(header))
+
+	file, err := os.OpenFile(store.binPath(id), os.O_WRONLY, defaultFilePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := file.Seek(offset, 0); err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, wal); err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
 // writeInfo updates the entire information. Everything will be overwritten.
+// The file is replaced atomically, so concurrent readers never observe a
+// partially written file.
 func (store FileStore) writeInfo(id string, info tusd.FileInfo) error {
 	data, err := json.Marshal(info)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(store.infoPath(id), data, defaultFilePerm)
+
+	if store.CompressInfo {
+		buf := new(bytes.Buffer)
+		writer := gzip.NewWriter(buf)
+		writer.Comment = infoFormat
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	return store.writeFileAtomic(store.infoPath(id), data)
+}
+
+// limitedFile reads from a limited part of a file and closes the entire file.
+type limitedFile struct {
+	io.Reader
+	file *os.File
+}
+
+func (f limitedFile) Close() error {
+	return f.file.Close()
+}
+
+// perm returns the mode used for creating files, see FileMode.
+func (store FileStore) perm() os.FileMode {
+	if store.FileMode == 0 {
+		return defaultFilePerm
+	}
+
+	return store.FileMode
+}
+
+// applyPerm applies the configured FileMode and Group to a newly created
+// file, if set.
+func (store FileStore) applyPerm(file *os.File) error {
+	if store.FileMode != 0 {
+		if err := file.Chmod(store.FileMode); err != nil {
+			return err
+		}
+	}
+
+	if store.Group != 0 {
+		return file.Chown(-1, store.Group)
+	}
+
+	return nil
+}
+
+// createDir creates the directory at Path, including its parents, and applies
+// the configured DirMode and Group to it.
+func (store FileStore) createDir() error {
+	mode := store.DirMode
+	if mode == 0 {
+		mode = defaultDirPerm
+	}
+
+	if err := os.MkdirAll(store.Path, mode); err != nil {
+		return err
+	}
+
+	if store.DirMode != 0 {
+		if err := os.Chmod(store.Path, store.DirMode); err != nil {
+			return err
+		}
+	}
+
+	if store.Group != 0 {
+		return os.Chown(store.Path, -1, store.Group)
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory
+// and renames it to the specified path afterwards. Since renaming is atomic,
+// the file contains either the old or the new data at any time.
+func (store FileStore) writeFileAtomic(path string, data []byte) error {
+	// The temporary file is created exclusively using a random name, so
+	// concurrent writers do not interfere
+	file, err := os.OpenFile(path+".tmp"+uid.Uid(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+	if err != nil {
+		return err
+	}
+
+	err = store.applyPerm(file)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// readInfo returns the JSON-encoded content of the .info file. Files
+// compressed using gzip are detected by their magic number and decompressed.
+// Compressed files written before the format version was introduced do not
+// carry one and share the format of the first version.
+func (store FileStore) readInfo(id string) ([]byte, error) {
+	data, err := ioutil.ReadFile(store.infoPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	if reader.Comment != "" && reader.Comment != infoFormat {
+		return nil, ErrUnknownInfoFormat
+	}
+
+	return ioutil.ReadAll(reader)
 }
@@ -1,11 +1,17 @@
 package filestore
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -18,6 +24,16 @@ var _ tusd.GetReaderDataStore = FileStore{}
 var _ tusd.TerminaterDataStore = FileStore{}
 var _ tusd.LockerDataStore = FileStore{}
 var _ tusd.ConcaterDataStore = FileStore{}
+var _ tusd.ReaderAtDataStore = FileStore{}
+var _ tusd.LockInspector = FileStore{}
+var _ tusd.DescriberDataStore = FileStore{}
+var _ tusd.BufferedDataStore = FileStore{}
+var _ tusd.FailerDataStore = FileStore{}
+var _ tusd.SealerDataStore = FileStore{}
+var _ tusd.ExpirerDataStore = FileStore{}
+var _ tusd.LengthDeferrerDataStore = FileStore{}
+var _ tusd.UploadLister = FileStore{}
+var _ tusd.TruncaterDataStore = FileStore{}
 
 func TestFilestore(t *testing.T) {
 	a := assert.New(t)
@@ -25,7 +41,7 @@ func TestFilestore(t *testing.T) {
 	tmp, err := ioutil.TempDir("", "tusd-filestore-")
 	a.NoError(err)
 
-	store := FileStore{tmp}
+	store := FileStore{Path: tmp}
 
 	// Create new upload
 	id, err := store.NewUpload(tusd.FileInfo{
@@ -72,6 +88,503 @@ func TestFilestore(t *testing.T) {
 	a.True(os.IsNotExist(err))
 }
 
+func TestCompressInfo(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-compress-")
+	a.NoError(err)
+
+	store := FileStore{
+		Path:         tmp,
+		CompressInfo: true,
+	}
+
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size: 42,
+		MetaData: map[string]string{
+			"hello": "world",
+		},
+	})
+	a.NoError(err)
+
+	// The info file must be stored compressed
+	data, err := ioutil.ReadFile(tmp + "/" + id + ".info")
+	a.NoError(err)
+	a.Equal([]byte{0x1f, 0x8b}, data[:2])
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	a.NoError(err)
+	a.Equal("tusd-info/1", reader.Comment)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.Equal(id, info.ID)
+	a.EqualValues(42, info.Size)
+	a.Equal(tusd.MetaData{"hello": "world"}, info.MetaData)
+
+	// Uncompressed info files must still be readable
+	store.CompressInfo = false
+	id, err = store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	store.CompressInfo = true
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(10, info.Size)
+
+	// Compressed info files carry their format version, and unknown versions
+	// are rejected instead of being misinterpreted
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	writer.Comment = "tusd-info/2"
+	writer.Write([]byte(`{"Size":10}`))
+	a.NoError(writer.Close())
+	a.NoError(ioutil.WriteFile(store.infoPath(id), buf.Bytes(), 0664))
+
+	_, err = store.GetInfo(id)
+	a.Equal(ErrUnknownInfoFormat, err)
+}
+
+func TestWAL(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-wal-")
+	a.NoError(err)
+
+	store := FileStore{
+		Path:      tmp,
+		EnableWAL: true,
+	}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 11})
+	a.NoError(err)
+
+	n, err := store.WriteChunk(id, 0, strings.NewReader("hello "))
+	a.NoError(err)
+	a.EqualValues(6, n)
+
+	// The log must be removed once it has been applied
+	_, err = os.Stat(tmp + "/" + id + ".wal")
+	a.True(os.IsNotExist(err))
+
+	// Simulate a crash after the chunk has been logged but before it has been
+	// applied to the .bin file.
+	n, err = store.writeWAL(id, 6, strings.NewReader("world"))
+	a.NoError(err)
+	a.EqualValues(5, n)
+
+	content, err := ioutil.ReadFile(tmp + "/" + id + ".bin")
+	a.NoError(err)
+	a.Equal("hello ", string(content))
+
+	// Reading the info does not touch the log but reports the offset before
+	// the unacknowledged chunk
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(6, info.Offset)
+
+	_, err = os.Stat(tmp + "/" + id + ".wal")
+	a.NoError(err)
+
+	// This also applies if the log has been applied partially
+	a.NoError(ioutil.WriteFile(tmp+"/"+id+".bin", []byte("hello wo"), 0644))
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(6, info.Offset)
+
+	// The next write replays the log before resuming at the reported offset
+	n, err = store.WriteChunk(id, 6, strings.NewReader("world"))
+	a.NoError(err)
+	a.EqualValues(5, n)
+
+	content, err = ioutil.ReadFile(tmp + "/" + id + ".bin")
+	a.NoError(err)
+	a.Equal("hello world", string(content))
+
+	_, err = os.Stat(tmp + "/" + id + ".wal")
+	a.True(os.IsNotExist(err))
+
+	// Replaying a log which has already been partially applied must not
+	// duplicate data.
+	_, err = store.writeWAL(id, 6, strings.NewReader("world"))
+	a.NoError(err)
+	a.NoError(store.replayWAL(id))
+
+	content, err = ioutil.ReadFile(tmp + "/" + id + ".bin")
+	a.NoError(err)
+	a.Equal("hello world", string(content))
+}
+
+func TestFailUpload(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-fail-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.Equal("", info.Error)
+
+	a.NoError(store.FailUpload(id, "data corrupted"))
+
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.Equal("data corrupted", info.Error)
+	a.EqualValues(10, info.Size)
+}
+
+func TestAtomicInfo(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-atomic-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 1000})
+	a.NoError(err)
+
+	// Updates of the info file and chunk writes must not cause readers to
+	// observe a partially written info file.
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			a.NoError(store.FailUpload(id, strings.Repeat("x", i*10)))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, err := store.WriteChunk(id, int64(i), strings.NewReader("a"))
+			a.NoError(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			_, err := store.GetInfo(id)
+			a.NoError(err)
+		}
+	}()
+	wg.Wait()
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(100, info.Offset)
+	a.Equal(strings.Repeat("x", 990), info.Error)
+
+	// No temporary files are left behind
+	paths, err := filepath.Glob(filepath.Join(tmp, "*.tmp*"))
+	a.NoError(err)
+	a.Empty(paths)
+}
+
+func TestGetReaderIncomplete(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-incomplete-")
+	a.NoError(err)
+
+	store := FileStore{
+		Path:  tmp,
+		Flush: &FlushPolicy{Bytes: 10},
+	}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello world"))
+	a.NoError(err)
+	_, err = store.WriteChunk(id, 11, strings.NewReader("abc"))
+	a.NoError(err)
+
+	// Only the flushed bytes are returned although the file contains more
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+
+	content, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal("hello world", string(content))
+	a.NoError(reader.(io.Closer).Close())
+}
+
+func TestGetReaderSnapshot(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-snapshot-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(reader, buf)
+	a.NoError(err)
+
+	// Chunks written while reading are not part of the snapshot
+	_, err = store.WriteChunk(id, 5, strings.NewReader(" world"))
+	a.NoError(err)
+
+	rest, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal("hello", string(buf)+string(rest))
+	a.NoError(reader.(io.Closer).Close())
+}
+
+func TestTruncate(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-truncate-")
+	a.NoError(err)
+
+	for _, store := range []FileStore{
+		{Path: tmp},
+		{Path: tmp, Flush: &FlushPolicy{}},
+		{Path: tmp, EnableWAL: true},
+	} {
+		id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+		a.NoError(err)
+
+		_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+		a.NoError(err)
+		_, err = store.WriteChunk(id, 5, strings.NewReader("wor"))
+		a.NoError(err)
+
+		a.NoError(store.Truncate(id, 5))
+
+		info, err := store.GetInfo(id)
+		a.NoError(err)
+		a.EqualValues(5, info.Offset)
+
+		reader, err := store.GetReader(id)
+		a.NoError(err)
+		content, err := ioutil.ReadAll(reader)
+		a.NoError(err)
+		a.Equal("hello", string(content))
+		reader.(io.Closer).Close()
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-mode-")
+	a.NoError(err)
+
+	// The modes are writable by everyone, so they are only kept if the umask
+	// is not applied
+	store := FileStore{
+		Path:      filepath.Join(tmp, "uploads", "files"),
+		FileMode:  0666,
+		DirMode:   0777,
+		EnableWAL: true,
+	}
+	if gid := os.Getgid(); gid > 0 {
+		store.Group = gid
+	}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	// The log is removed after it has been applied, so its mode is checked
+	// while it is being written
+	_, err = store.writeWAL(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	for _, path := range []string{store.binPath(id), store.infoPath(id), store.walPath(id)} {
+		stat, err := os.Stat(path)
+		a.NoError(err)
+		a.Equal(os.FileMode(0666), stat.Mode().Perm(), path)
+	}
+
+	stat, err := os.Stat(store.Path)
+	a.NoError(err)
+	a.True(stat.IsDir())
+	a.Equal(os.FileMode(0777), stat.Mode().Perm())
+}
+
+func TestFlushPolicy(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-flush-")
+	a.NoError(err)
+
+	// assertOffsets checks the offset reported by GetInfo, i.e. in HEAD
+	// requests, and the number of bytes which have been written.
+	assertOffsets := func(store FileStore, id string, flushed, written int64) {
+		info, err := store.GetInfo(id)
+		a.NoError(err)
+		a.EqualValues(flushed, info.Offset)
+
+		n, err := store.GetWrittenOffset(id)
+		a.NoError(err)
+		a.EqualValues(written, n)
+	}
+
+	// Flush after every chunk
+	store := FileStore{
+		Path:  tmp,
+		Flush: &FlushPolicy{},
+	}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+	assertOffsets(store, id, 0, 0)
+
+	n, err := store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+	a.EqualValues(5, n)
+	assertOffsets(store, id, 5, 5)
+
+	// Flush every 10 bytes
+	store.Flush = &FlushPolicy{Bytes: 10}
+
+	id, err = store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+	assertOffsets(store, id, 0, 5)
+
+	_, err = store.WriteChunk(id, 5, strings.NewReader("world"))
+	a.NoError(err)
+	assertOffsets(store, id, 10, 10)
+
+	_, err = store.WriteChunk(id, 10, strings.NewReader("abc"))
+	a.NoError(err)
+	assertOffsets(store, id, 10, 13)
+
+	// Resuming from the flushed offset discards the unflushed bytes
+	_, err = store.WriteChunk(id, 10, strings.NewReader("x"))
+	a.NoError(err)
+	assertOffsets(store, id, 10, 11)
+
+	// Finishing the upload always flushes
+	_, err = store.WriteChunk(id, 11, strings.NewReader("yyyyyyyyy"))
+	a.NoError(err)
+	assertOffsets(store, id, 20, 20)
+
+	content, err := ioutil.ReadFile(tmp + "/" + id + ".bin")
+	a.NoError(err)
+	a.Equal("helloworldxyyyyyyyyy", string(content))
+
+	// Flush every hour
+	store.Flush = &FlushPolicy{Interval: time.Hour}
+
+	id, err = store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello world"))
+	a.NoError(err)
+	assertOffsets(store, id, 0, 11)
+
+	// Simulate the interval having passed since the last flush
+	past := time.Now().Add(-2 * time.Hour)
+	a.NoError(os.Chtimes(tmp+"/"+id+".offset", past, past))
+
+	_, err = store.WriteChunk(id, 11, strings.NewReader("!"))
+	a.NoError(err)
+	assertOffsets(store, id, 12, 12)
+
+	a.NoError(store.Terminate(id))
+	_, err = os.Stat(tmp + "/" + id + ".offset")
+	a.True(os.IsNotExist(err))
+}
+
+func benchmarkInfo(b *testing.B, compress bool) {
+	tmp, err := ioutil.TempDir("", "tusd-filestore-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	store := FileStore{
+		Path:         tmp,
+		CompressInfo: compress,
+	}
+
+	info := tusd.FileInfo{
+		Size: 42,
+		MetaData: map[string]string{
+			"filename": "lunrjs.png",
+			"filetype": "image/png",
+		},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := store.writeInfo("bench", info); err != nil {
+			b.Fatal(err)
+		}
+
+		if _, err := store.readInfo("bench"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInfo(b *testing.B) {
+	benchmarkInfo(b, false)
+}
+
+func BenchmarkInfoCompressed(b *testing.B) {
+	benchmarkInfo(b, true)
+}
+
+func TestGetReaderAt(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-readerat-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 11})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello world"))
+	a.NoError(err)
+
+	reader, size, err := store.GetReaderAt(id)
+	a.NoError(err)
+	a.EqualValues(11, size)
+	defer reader.(io.Closer).Close()
+
+	// Read the content out of order
+	p := make([]byte, 5)
+	n, err := reader.ReadAt(p, 6)
+	a.NoError(err)
+	a.Equal(5, n)
+	a.Equal("world", string(p))
+
+	n, err = reader.ReadAt(p, 0)
+	a.NoError(err)
+	a.Equal(5, n)
+	a.Equal("hello", string(p))
+
+	n, err = reader.ReadAt(p, 9)
+	a.Equal(io.EOF, err)
+	a.Equal("ld", string(p[:n]))
+
+	// Reading non-existing uploads fails
+	_, _, err = store.GetReaderAt("nonexistent")
+	a.True(os.IsNotExist(err))
+}
+
 func TestFileLocker(t *testing.T) {
 	a := assert.New(t)
 
@@ -79,7 +592,7 @@ func TestFileLocker(t *testing.T) {
 	a.NoError(err)
 
 	var locker tusd.LockerDataStore
-	locker = FileStore{dir}
+	locker = FileStore{Path: dir}
 
 	a.NoError(locker.LockUpload("one"))
 	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
@@ -87,13 +600,71 @@ func TestFileLocker(t *testing.T) {
 	a.NoError(locker.UnlockUpload("one"))
 }
 
+func TestActiveLocks(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "tusd-file-locker-active")
+	a.NoError(err)
+
+	store := FileStore{Path: dir}
+	a.Len(store.ActiveLocks(), 0)
+
+	a.NoError(store.LockUpload("one"))
+
+	locks := store.ActiveLocks()
+	a.Len(locks, 1)
+	a.Equal("one", locks[0].ID)
+	a.Equal(strconv.Itoa(os.Getpid()), locks[0].Holder)
+	a.False(locks[0].Since.IsZero())
+
+	a.NoError(store.UnlockUpload("one"))
+	a.Len(store.ActiveLocks(), 0)
+}
+
+func TestLockNamespace(t *testing.T) {
+	a := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "tusd-file-locker-namespace")
+	a.NoError(err)
+
+	storeA := FileStore{Path: dir, LockNamespace: "service-a"}
+	storeB := FileStore{Path: dir, LockNamespace: "service-b"}
+	store := FileStore{Path: dir}
+
+	// Locks of different namespaces do not exclude each other
+	a.NoError(storeA.LockUpload("one"))
+	a.NoError(storeB.LockUpload("one"))
+	a.NoError(storeB.LockUpload("two"))
+
+	locks := storeA.ActiveLocks()
+	a.Len(locks, 1)
+	a.Equal("one", locks[0].ID)
+	a.Len(storeB.ActiveLocks(), 2)
+	a.Len(store.ActiveLocks(), 0)
+
+	a.NoError(storeA.UnlockUpload("one"))
+	a.Len(storeA.ActiveLocks(), 0)
+	a.Len(storeB.ActiveLocks(), 2)
+
+	a.NoError(storeB.UnlockUpload("one"))
+	a.NoError(storeB.UnlockUpload("two"))
+
+	// An upload whose lock would be listed as one of the namespace's locks
+	// cannot be created
+	_, err = store.NewUpload(tusd.FileInfo{ID: "service-a.one", Size: 5})
+	a.Equal(tusd.ErrInvalidUploadID, err)
+
+	invalid := FileStore{Path: dir, LockNamespace: "../other"}
+	a.Equal(ErrInvalidLockNamespace, invalid.LockUpload("one"))
+}
+
 func TestConcatUploads(t *testing.T) {
 	a := assert.New(t)
 
 	tmp, err := ioutil.TempDir("", "tusd-filestore-concat-")
 	a.NoError(err)
 
-	store := FileStore{tmp}
+	store := FileStore{Path: tmp}
 
 	// Create new upload to hold concatenated upload
 	finId, err := store.NewUpload(tusd.FileInfo{Size: 9})
@@ -136,3 +707,187 @@ func TestConcatUploads(t *testing.T) {
 	a.Equal("abcdefghi", string(content))
 	reader.(io.Closer).Close()
 }
+
+func TestExpiration(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-expiration-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	expires := time.Now().Add(time.Hour).Round(time.Second)
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size:    10,
+		Expires: &expires,
+	})
+	a.NoError(err)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.True(expires.Equal(*info.Expires))
+
+	expires = expires.Add(time.Hour)
+	a.NoError(store.SetExpiration(id, expires))
+
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.True(expires.Equal(*info.Expires))
+
+	infos, err := store.ListUploads()
+	a.NoError(err)
+	if a.Len(infos, 1) {
+		a.Equal(id, infos[0].ID)
+		a.EqualValues(10, infos[0].Size)
+	}
+}
+
+func TestDeclareLength(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-defer-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{SizeIsDeferred: true})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	a.NoError(store.DeclareLength(id, 11))
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.False(info.SizeIsDeferred)
+	a.EqualValues(11, info.Size)
+	a.EqualValues(5, info.Offset)
+}
+
+func TestRecover(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-recover-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size:     11,
+		MetaData: map[string]string{"foo": "hello"},
+	})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	// Existing infos are not modified
+	info, err := store.Recover(id)
+	a.NoError(err)
+	a.EqualValues(11, info.Size)
+	a.Equal("hello", info.MetaData["foo"])
+
+	// Lose the info file while the data survives
+	a.NoError(os.Remove(filepath.Join(tmp, id+".info")))
+	_, err = store.GetInfo(id)
+	a.True(os.IsNotExist(err))
+
+	info, err = store.Recover(id)
+	a.NoError(err)
+	a.Equal(id, info.ID)
+	a.EqualValues(5, info.Size)
+	a.EqualValues(5, info.Offset)
+	a.Empty(info.MetaData)
+
+	// The recovered upload is finished and can be downloaded
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+	content, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal("hello", string(content))
+	if closer, ok := reader.(io.Closer); ok {
+		a.NoError(closer.Close())
+	}
+
+	// Without data, nothing can be recovered
+	_, err = store.Recover("nonexisting")
+	a.True(os.IsNotExist(err))
+}
+
+func TestNewUploadCollision(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-collision-")
+	a.NoError(err)
+
+	defer func(original func() string) {
+		generateID = original
+	}(generateID)
+	generateID = func() string {
+		return "taken"
+	}
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size:     5,
+		MetaData: map[string]string{"foo": "hello"},
+	})
+	a.NoError(err)
+	a.Equal("taken", id)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hel"))
+	a.NoError(err)
+
+	// A second upload with the same ID must not overwrite the first one
+	_, err = store.NewUpload(tusd.FileInfo{Size: 100})
+	a.Equal(tusd.ErrUploadIDCollision, err)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(5, info.Size)
+	a.EqualValues(3, info.Offset)
+	a.Equal("hello", info.MetaData["foo"])
+}
+
+func TestNewUploadProvidedID(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-id-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{ID: "acme-123", Size: 5})
+	a.NoError(err)
+	a.Equal("acme-123", id)
+
+	info, err := store.GetInfo("acme-123")
+	a.NoError(err)
+	a.Equal("acme-123", info.ID)
+	a.EqualValues(5, info.Size)
+}
+
+func TestSeal(t *testing.T) {
+	a := assert.New(t)
+
+	tmp, err := ioutil.TempDir("", "tusd-filestore-seal-")
+	a.NoError(err)
+
+	store := FileStore{Path: tmp}
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 5})
+	a.NoError(err)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.False(info.Sealed)
+
+	a.NoError(store.Seal(id))
+
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.True(info.Sealed)
+	a.EqualValues(5, info.Size)
+}
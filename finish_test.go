@@ -0,0 +1,239 @@
+package tusd_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type finishStore struct {
+	zeroStore
+	// Number of calls to FinishUpload which will fail before succeeding
+	failures int
+	calls    int
+	mutex    sync.Mutex
+}
+
+func (s *finishStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:     id,
+		Offset: 0,
+		Size:   5,
+	}, nil
+}
+
+func (s *finishStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return 5, nil
+}
+
+func (s *finishStore) FinishUpload(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.calls += 1
+	if s.calls <= s.failures {
+		return errors.New("throttled")
+	}
+
+	return nil
+}
+
+// waitForCalls waits until FinishUpload has been called the expected number of
+// times, since failed attempts are retried in the background.
+func (s *finishStore) waitForCalls(t *testing.T, calls int) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		s.mutex.Lock()
+		actual := s.calls
+		s.mutex.Unlock()
+
+		if actual == calls {
+			return
+		}
+	}
+
+	t.Errorf("Expected FinishUpload to be called %d times", calls)
+}
+
+var finishPatchTest = httpTest{
+	Name:   "Finishing upload",
+	Method: "PATCH",
+	URL:    "yes",
+	ReqHeader: map[string]string{
+		"Tus-Resumable": "1.0.0",
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": "0",
+	},
+	Code: http.StatusNoContent,
+}
+
+func TestFinishUploadRetry(t *testing.T) {
+	a := assert.New(t)
+
+	store := &finishStore{
+		failures: 2,
+	}
+	completed := make(chan FileInfo, 1)
+	handler, _ := NewHandler(Config{
+		DataStore:           store,
+		FinishUploadRetries: 3,
+		FinishUploadBackoff: time.Millisecond,
+		CompleteUploadsCallback: func(info FileInfo) {
+			completed <- info
+		},
+	})
+
+	// The request does not wait for the retries
+	test := finishPatchTest
+	test.ReqBody = strings.NewReader("hello")
+	test.ResHeader = map[string]string{
+		"Upload-Finish-Pending": "true",
+	}
+	test.Run(handler, t)
+
+	info := <-completed
+	a.Equal("yes", info.ID)
+	store.waitForCalls(t, 3)
+
+	res := (&httpTest{
+		Name:   "Finished upload",
+		Method: "HEAD",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+	a.Equal("", res.Header().Get("Upload-Finish-Pending"))
+}
+
+func TestFinishUploadPending(t *testing.T) {
+	a := assert.New(t)
+
+	store := &finishStore{
+		failures: 3,
+	}
+	completed := make(chan FileInfo, 1)
+	handler, _ := NewHandler(Config{
+		DataStore:           store,
+		FinishUploadRetries: 1,
+		CompleteUploadsCallback: func(info FileInfo) {
+			completed <- info
+		},
+	})
+
+	test := finishPatchTest
+	test.ReqBody = strings.NewReader("hello")
+	test.ResHeader = map[string]string{
+		"Upload-Finish-Pending": "true",
+	}
+	test.Run(handler, t)
+	store.waitForCalls(t, 2)
+
+	(&httpTest{
+		Name:   "Pending upload",
+		Method: "HEAD",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Finish-Pending": "true",
+		},
+	}).Run(handler, t)
+
+	// The first retry by the background worker still fails
+	handler.Unrouted.RetryPendingFinishes()
+	a.Equal(3, store.calls)
+	a.Len(completed, 0)
+
+	handler.Unrouted.RetryPendingFinishes()
+	a.Equal(4, store.calls)
+
+	info := <-completed
+	a.Equal("yes", info.ID)
+	a.EqualValues(5, info.Offset)
+
+	res := (&httpTest{
+		Name:   "Finished upload",
+		Method: "HEAD",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+	a.Equal("", res.Header().Get("Upload-Finish-Pending"))
+
+	// Nothing is left to be finished
+	handler.Unrouted.RetryPendingFinishes()
+	a.Equal(4, store.calls)
+}
+
+type lockingFinishStore struct {
+	finishStore
+	locked bool
+}
+
+func (s *lockingFinishStore) LockUpload(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.locked {
+		return ErrFileLocked
+	}
+	s.locked = true
+	return nil
+}
+
+func (s *lockingFinishStore) UnlockUpload(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.locked = false
+	return nil
+}
+
+func (s *lockingFinishStore) isLocked() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.locked
+}
+
+func TestFinishUploadRetryUnlocked(t *testing.T) {
+	a := assert.New(t)
+
+	store := &lockingFinishStore{
+		finishStore: finishStore{
+			failures: 1,
+		},
+	}
+	completed := make(chan FileInfo, 1)
+	handler, _ := NewHandler(Config{
+		DataStore:           store,
+		FinishUploadRetries: 1,
+		FinishUploadBackoff: 50 * time.Millisecond,
+		CompleteUploadsCallback: func(info FileInfo) {
+			completed <- info
+		},
+	})
+
+	test := finishPatchTest
+	test.ReqBody = strings.NewReader("hello")
+	test.Run(handler, t)
+
+	// The lock is released while waiting for the retry
+	a.False(store.isLocked())
+
+	<-completed
+	store.waitForCalls(t, 2)
+}
@@ -0,0 +1,56 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/tus/tusd"
+)
+
+func TestFirstChunkCallback(t *testing.T) {
+	store := &expirationStore{
+		uploads: map[string]FileInfo{
+			"new": {ID: "new", Size: 10},
+		},
+	}
+
+	started := make(chan FileInfo, 10)
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		FirstChunkCallback: func(info FileInfo) {
+			started <- info
+		},
+	})
+
+	for _, offset := range []string{"0", "5"} {
+		(&httpTest{
+			Name:   "Write chunk at offset " + offset,
+			Method: "PATCH",
+			URL:    "new",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": offset,
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+		}).Run(handler, t)
+	}
+
+	select {
+	case info := <-started:
+		if info.ID != "new" || info.Offset != 5 {
+			t.Errorf("Unexpected info for first chunk: %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected callback to be invoked for first chunk")
+	}
+
+	select {
+	case info := <-started:
+		t.Errorf("Expected callback to be invoked only once but got %+v", info)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,236 @@
+// Package gcsstore provides a storage backend using Google Cloud Storage.
+//
+// For every upload, two objects are created in the bucket: the info object,
+// whose name has the suffix ".info", contains a JSON-encoded blob of general
+// information about the upload including its size and meta data. The data
+// object, whose name is the upload's ID, contains the bytes received so far,
+// so its size is the upload's offset.
+//
+// Objects in GCS cannot be modified once they have been written. Therefore,
+// every chunk received using a PATCH request is first uploaded as a temporary
+// object, which is then appended to the data object using object composition
+// (https://cloud.google.com/storage/docs/composite-objects) and deleted
+// afterwards. Since the data object is complete at any time, no further work
+// is required once the upload is finished.
+//
+// If a chunk is retried after the previous attempt has been appended already,
+// e.g. since the response to the client was lost, the bytes which are already
+// stored are skipped instead of being appended a second time.
+//
+// The package does not depend on a specific version of the GCS client
+// library. Instead, the store communicates with GCS using the GCSAPI
+// interface, which can be implemented by a small adapter around
+// cloud.google.com/go/storage's Client, e.g. using ObjectHandle.NewWriter for
+// WriteObject and ObjectHandle.ComposerFrom for ComposeObjects.
+//
+// Composing objects is not atomic with respect to concurrent writes to the
+// same upload. Therefore, it is required to use a locking mechanism in order
+// to prevent concurrent access to the same upload, see tusd.LockerDataStore
+// for more information.
+package gcsstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/tus/tusd"
+	"github.com/tus/tusd/uid"
+)
+
+// ErrObjectNotExist must be returned by the methods of GCSAPI if the requested
+// object does not exist.
+var ErrObjectNotExist = errors.New("gcsstore: object does not exist")
+
+// GCSAPI is the interface used for communicating with GCS.
+type GCSAPI interface {
+	// ReadObject returns a reader for the object's content.
+	ReadObject(bucket string, name string) (io.ReadCloser, error)
+	// GetObjectSize returns the size of the object in bytes.
+	GetObjectSize(bucket string, name string) (int64, error)
+	// WriteObject creates or replaces the object using the content read from
+	// src until io.EOF and returns the number of bytes written.
+	WriteObject(bucket string, name string, src io.Reader) (int64, error)
+	// ComposeObjects concatenates the sources, in the given order, into the
+	// destination object. The destination may be one of the sources.
+	ComposeObjects(bucket string, sources []string, destination string) error
+	// DeleteObject removes the object.
+	DeleteObject(bucket string, name string) error
+}
+
+// See the tusd.DataStore interface for documentation about the different
+// methods.
+type GCSStore struct {
+	// Bucket used to store the data in, e.g. "tusdstore.example.com"
+	Bucket string
+	// Service specifies an interface used to communicate with GCS.
+	Service GCSAPI
+	// ObjectPrefix is prepended to the names of all objects created by the
+	// store, allowing to keep the uploads in a folder of a shared bucket.
+	ObjectPrefix string
+}
+
+// New constructs a new storage using the supplied bucket and service object.
+func New(bucket string, service GCSAPI) GCSStore {
+	return GCSStore{
+		Bucket:  bucket,
+		Service: service,
+	}
+}
+
+func (store GCSStore) NewUpload(info tusd.FileInfo) (id string, err error) {
+	if id = info.ID; id == "" {
+		id = uid.Uid()
+	}
+	info.ID = id
+
+	// GCS does not offer creating objects exclusively, so the check is only
+	// best effort and may miss concurrently created uploads.
+	if _, err := store.Service.GetObjectSize(store.Bucket, store.infoName(id)); err == nil {
+		return "", tusd.ErrUploadIDCollision
+	} else if err != ErrObjectNotExist {
+		return "", err
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := store.Service.WriteObject(store.Bucket, store.infoName(id), bytes.NewReader(data)); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (store GCSStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	name := store.dataName(id)
+	size, err := store.dataSize(id)
+	if err != nil {
+		return 0, err
+	}
+
+	if offset > size {
+		return 0, fmt.Errorf("gcsstore: offset %d exceeds the %d bytes stored for upload %s", offset, size, id)
+	}
+
+	// Skip the bytes which have been appended by a previous attempt
+	skipped := size - offset
+	if skipped > 0 {
+		n, err := io.CopyN(ioutil.Discard, src, skipped)
+		if err == io.EOF {
+			err = nil
+		}
+		if n < skipped || err != nil {
+			return n, err
+		}
+	}
+
+	// The temporary object's name is derived from the offset, so an object
+	// left behind by a failed attempt is overwritten when the chunk is retried.
+	chunk := name + "_" + strconv.FormatInt(size, 10)
+	reader := &errorCatcher{reader: src}
+	n, err := store.Service.WriteObject(store.Bucket, chunk, reader)
+	defer store.Service.DeleteObject(store.Bucket, chunk)
+	if err != nil {
+		return skipped, err
+	}
+
+	if n > 0 {
+		sources := []string{chunk}
+		if size > 0 {
+			sources = []string{name, chunk}
+		}
+		if err := store.Service.ComposeObjects(store.Bucket, sources, name); err != nil {
+			return skipped, err
+		}
+	}
+
+	// The bytes received before the client's connection has been interrupted
+	// are kept, so the upload can be resumed after them.
+	return skipped + n, reader.err
+}
+
+func (store GCSStore) GetInfo(id string) (tusd.FileInfo, error) {
+	info := tusd.FileInfo{}
+
+	r, err := store.Service.ReadObject(store.Bucket, store.infoName(id))
+	if err == ErrObjectNotExist {
+		return info, tusd.ErrNotFound
+	}
+	if err != nil {
+		return info, err
+	}
+	defer r.Close()
+
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return info, err
+	}
+
+	info.Offset, err = store.dataSize(id)
+	return info, err
+}
+
+func (store GCSStore) GetReader(id string) (io.Reader, error) {
+	if _, err := store.GetInfo(id); err != nil {
+		return nil, err
+	}
+
+	r, err := store.Service.ReadObject(store.Bucket, store.dataName(id))
+	if err == ErrObjectNotExist {
+		// No data has been received yet
+		return bytes.NewReader(nil), nil
+	}
+	return r, err
+}
+
+func (store GCSStore) Terminate(id string) error {
+	if err := store.Service.DeleteObject(store.Bucket, store.infoName(id)); err == ErrObjectNotExist {
+		return tusd.ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := store.Service.DeleteObject(store.Bucket, store.dataName(id)); err != nil && err != ErrObjectNotExist {
+		return err
+	}
+	return nil
+}
+
+// dataSize returns the number of bytes stored for the upload. The data object
+// is only created once the first chunk has been received.
+func (store GCSStore) dataSize(id string) (int64, error) {
+	size, err := store.Service.GetObjectSize(store.Bucket, store.dataName(id))
+	if err == ErrObjectNotExist {
+		return 0, nil
+	}
+	return size, err
+}
+
+func (store GCSStore) dataName(id string) string {
+	return store.ObjectPrefix + id
+}
+
+func (store GCSStore) infoName(id string) string {
+	return store.ObjectPrefix + id + ".info"
+}
+
+// errorCatcher ends the stream with io.EOF if the underlying reader fails and
+// remembers the error, so the data read before is still stored.
+type errorCatcher struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *errorCatcher) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+		err = io.EOF
+	}
+	return n, err
+}
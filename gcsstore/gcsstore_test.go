@@ -0,0 +1,278 @@
+package gcsstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+var _ tusd.DataStore = GCSStore{}
+var _ tusd.GetReaderDataStore = GCSStore{}
+var _ tusd.TerminaterDataStore = GCSStore{}
+
+// fakeGCS implements GCSAPI by keeping the objects in memory.
+type fakeGCS struct {
+	objects map[string][]byte
+	mutex   sync.Mutex
+}
+
+func newFakeGCS() *fakeGCS {
+	return &fakeGCS{
+		objects: make(map[string][]byte),
+	}
+}
+
+func (gcs *fakeGCS) get(bucket string, name string) ([]byte, error) {
+	gcs.mutex.Lock()
+	defer gcs.mutex.Unlock()
+
+	data, ok := gcs.objects[bucket+"/"+name]
+	if !ok {
+		return nil, ErrObjectNotExist
+	}
+	return data, nil
+}
+
+func (gcs *fakeGCS) ReadObject(bucket string, name string) (io.ReadCloser, error) {
+	data, err := gcs.get(bucket, name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (gcs *fakeGCS) GetObjectSize(bucket string, name string) (int64, error) {
+	data, err := gcs.get(bucket, name)
+	return int64(len(data)), err
+}
+
+func (gcs *fakeGCS) WriteObject(bucket string, name string, src io.Reader) (int64, error) {
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+
+	gcs.mutex.Lock()
+	defer gcs.mutex.Unlock()
+
+	gcs.objects[bucket+"/"+name] = data
+	return int64(len(data)), nil
+}
+
+func (gcs *fakeGCS) ComposeObjects(bucket string, sources []string, destination string) error {
+	var composed []byte
+	for _, name := range sources {
+		data, err := gcs.get(bucket, name)
+		if err != nil {
+			return err
+		}
+		composed = append(composed, data...)
+	}
+
+	gcs.mutex.Lock()
+	defer gcs.mutex.Unlock()
+
+	gcs.objects[bucket+"/"+destination] = composed
+	return nil
+}
+
+func (gcs *fakeGCS) DeleteObject(bucket string, name string) error {
+	gcs.mutex.Lock()
+	defer gcs.mutex.Unlock()
+
+	if _, ok := gcs.objects[bucket+"/"+name]; !ok {
+		return ErrObjectNotExist
+	}
+	delete(gcs.objects, bucket+"/"+name)
+	return nil
+}
+
+func (gcs *fakeGCS) names() []string {
+	gcs.mutex.Lock()
+	defer gcs.mutex.Unlock()
+
+	var names []string
+	for name := range gcs.objects {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestGCSStore(t *testing.T) {
+	a := assert.New(t)
+
+	gcs := newFakeGCS()
+	store := New("bucket", gcs)
+	store.ObjectPrefix = "uploads/"
+
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size: 11,
+		MetaData: tusd.MetaData{
+			"hello": "world",
+		},
+	})
+	a.NoError(err)
+	a.NotEqual("", id)
+	a.Equal([]string{"bucket/uploads/" + id + ".info"}, gcs.names())
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.Equal(id, info.ID)
+	a.EqualValues(11, info.Size)
+	a.EqualValues(0, info.Offset)
+	a.Equal(tusd.MetaData{"hello": "world"}, info.MetaData)
+
+	// Each chunk is appended to the data object
+	n, err := store.WriteChunk(id, 0, strings.NewReader("hello "))
+	a.NoError(err)
+	a.EqualValues(6, n)
+
+	n, err = store.WriteChunk(id, 6, strings.NewReader("world"))
+	a.NoError(err)
+	a.EqualValues(5, n)
+
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(11, info.Offset)
+
+	// The temporary objects have been removed
+	a.Len(gcs.names(), 2)
+
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+	content, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal("hello world", string(content))
+
+	a.NoError(store.Terminate(id))
+	a.Empty(gcs.names())
+
+	_, err = store.GetInfo(id)
+	a.Equal(tusd.ErrNotFound, err)
+	a.Equal(tusd.ErrNotFound, store.Terminate(id))
+}
+
+func TestGetReaderEmpty(t *testing.T) {
+	a := assert.New(t)
+
+	store := New("bucket", newFakeGCS())
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+	content, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Empty(content)
+
+	_, err = store.GetReader("unknown")
+	a.Equal(tusd.ErrNotFound, err)
+}
+
+func TestNewUploadCollision(t *testing.T) {
+	a := assert.New(t)
+
+	store := New("bucket", newFakeGCS())
+	_, err := store.NewUpload(tusd.FileInfo{ID: "foo", Size: 10})
+	a.NoError(err)
+
+	_, err = store.NewUpload(tusd.FileInfo{ID: "foo", Size: 10})
+	a.Equal(tusd.ErrUploadIDCollision, err)
+}
+
+func TestWriteChunkRetried(t *testing.T) {
+	a := assert.New(t)
+
+	gcs := newFakeGCS()
+	store := New("bucket", gcs)
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	// The first bytes have already been appended and are skipped
+	n, err := store.WriteChunk(id, 3, strings.NewReader("loworld"))
+	a.NoError(err)
+	a.EqualValues(7, n)
+
+	// A retried chunk which has been appended entirely does not change the data
+	n, err = store.WriteChunk(id, 5, strings.NewReader("world"))
+	a.NoError(err)
+	a.EqualValues(5, n)
+
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+	content, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal("helloworld", string(content))
+
+	_, err = store.WriteChunk(id, 11, strings.NewReader("!"))
+	a.Error(err)
+}
+
+type interruptedReader struct {
+	data string
+}
+
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestWriteChunkInterrupted(t *testing.T) {
+	a := assert.New(t)
+
+	gcs := newFakeGCS()
+	store := New("bucket", gcs)
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	// The bytes received before the interruption are stored
+	n, err := store.WriteChunk(id, 0, &interruptedReader{data: "hel"})
+	a.Equal(io.ErrUnexpectedEOF, err)
+	a.EqualValues(3, n)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(3, info.Offset)
+	a.Len(gcs.names(), 2)
+}
+
+type failingGCS struct {
+	*fakeGCS
+}
+
+func (gcs failingGCS) ComposeObjects(bucket string, sources []string, destination string) error {
+	return errors.New("compose failed")
+}
+
+func TestWriteChunkComposeFailed(t *testing.T) {
+	a := assert.New(t)
+
+	gcs := failingGCS{newFakeGCS()}
+	store := New("bucket", gcs)
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.EqualError(err, "compose failed")
+
+	// Neither data nor a temporary object remains
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(0, info.Offset)
+	a.Len(gcs.names(), 1)
+}
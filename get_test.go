@@ -1,7 +1,9 @@
 package tusd_test
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
@@ -63,3 +65,312 @@ func TestGet(t *testing.T) {
 		t.Error("expected reader to be closed")
 	}
 }
+
+type rangeStore struct {
+	zeroStore
+}
+
+func (s rangeStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		Offset: 11,
+		Size:   20,
+	}, nil
+}
+
+func (s rangeStore) GetReader(id string) (io.Reader, error) {
+	return strings.NewReader("hello world"), nil
+}
+
+func (s rangeStore) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	return strings.NewReader("hello world"), 11, nil
+}
+
+func TestGetRange(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: rangeStore{},
+	})
+
+	(&httpTest{
+		Name:    "Download without range",
+		Method:  "GET",
+		URL:     "yes",
+		Code:    http.StatusOK,
+		ResBody: "hello world",
+		ResHeader: map[string]string{
+			"Content-Length": "11",
+			"Accept-Ranges":  "bytes",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Download range",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=6-9",
+		},
+		Code:    http.StatusPartialContent,
+		ResBody: "worl",
+		ResHeader: map[string]string{
+			"Content-Length": "4",
+			"Content-Range":  "bytes 6-9/11",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Download open-ended range",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=6-",
+		},
+		Code:    http.StatusPartialContent,
+		ResBody: "world",
+		ResHeader: map[string]string{
+			"Content-Range": "bytes 6-10/11",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Download suffix range",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=-3",
+		},
+		Code:    http.StatusPartialContent,
+		ResBody: "rld",
+		ResHeader: map[string]string{
+			"Content-Range": "bytes 8-10/11",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Ignore malformed range",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=a-b",
+		},
+		Code:    http.StatusOK,
+		ResBody: "hello world",
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Unsatisfiable range",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=20-",
+		},
+		Code: http.StatusRequestedRangeNotSatisfiable,
+		ResHeader: map[string]string{
+			"Content-Range": "bytes */11",
+		},
+	}).Run(handler, t)
+}
+
+type sequentialRangeStore struct {
+	rangeStore
+}
+
+func (s sequentialRangeStore) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	return nil, 0, ErrNotImplemented
+}
+
+func TestGetRangeNotImplemented(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: sequentialRangeStore{},
+	})
+
+	w := (&httpTest{
+		Name:   "Download range without random access",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=0-3",
+		},
+		Code:    http.StatusOK,
+		ResBody: "hello world",
+		ResHeader: map[string]string{
+			"Content-Length": "11",
+		},
+	}).Run(handler, t)
+
+	if w.HeaderMap.Get("Accept-Ranges") != "" {
+		t.Error("expected ranges not to be advertised")
+	}
+}
+
+type streamRangeStore struct {
+	rangeStore
+	ranges *[]string
+}
+
+func (s streamRangeStore) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	return streamRangeReader{s.ranges}, 11, nil
+}
+
+type streamRangeReader struct {
+	ranges *[]string
+}
+
+func (r streamRangeReader) ReadAt(p []byte, off int64) (int, error) {
+	panic("range must be streamed using ReadRange")
+}
+
+func (r streamRangeReader) ReadRange(offset, length int64) (io.ReadCloser, error) {
+	*r.ranges = append(*r.ranges, fmt.Sprintf("%d+%d", offset, length))
+	return ioutil.NopCloser(strings.NewReader("hello world"[offset : offset+length])), nil
+}
+
+func TestGetRangeStream(t *testing.T) {
+	var ranges []string
+	handler, _ := NewHandler(Config{
+		DataStore: streamRangeStore{
+			ranges: &ranges,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Stream range",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Range": "bytes=6-9",
+		},
+		Code:    http.StatusPartialContent,
+		ResBody: "worl",
+		ResHeader: map[string]string{
+			"Content-Length": "4",
+			"Content-Range":  "bytes 6-9/11",
+		},
+	}).Run(handler, t)
+
+	if len(ranges) != 1 || ranges[0] != "6+4" {
+		t.Errorf("Expected a single range read but got %v", ranges)
+	}
+}
+
+func TestGetIncomplete(t *testing.T) {
+	tests := []struct {
+		behavior IncompleteDownloadBehavior
+		code     int
+	}{
+		{IncompleteDownloadPartialAllowed, http.StatusOK},
+		{IncompleteDownloadNotFound, http.StatusNotFound},
+		{IncompleteDownloadTooEarly, 425},
+	}
+
+	for _, test := range tests {
+		handler, _ := NewHandler(Config{
+			DataStore:                  rangeStore{},
+			IncompleteDownloadBehavior: test.behavior,
+		})
+
+		(&httpTest{
+			Name:   "Download incomplete upload",
+			Method: "GET",
+			URL:    "yes",
+			Code:   test.code,
+		}).Run(handler, t)
+	}
+}
+
+type etagStore struct {
+	rangeStore
+	offset *int64
+}
+
+func (s etagStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		Offset: *s.offset,
+		Size:   20,
+	}, nil
+}
+
+func TestGetETag(t *testing.T) {
+	offset := int64(11)
+	handler, _ := NewHandler(Config{
+		DataStore: etagStore{offset: &offset},
+	})
+
+	w := (&httpTest{
+		Name:    "Download with entity tag",
+		Method:  "GET",
+		URL:     "yes",
+		Code:    http.StatusOK,
+		ResBody: "hello world",
+		ResHeader: map[string]string{
+			"ETag": "",
+		},
+	}).Run(handler, t)
+	etag := w.HeaderMap.Get("ETag")
+
+	(&httpTest{
+		Name:   "Entity tag is stable",
+		Method: "GET",
+		URL:    "yes",
+		Code:   http.StatusOK,
+		ResHeader: map[string]string{
+			"ETag": etag,
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Matching If-None-Match header",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"If-None-Match": `"other", W/` + etag,
+		},
+		Code: http.StatusNotModified,
+		ResHeader: map[string]string{
+			"ETag": etag,
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Other upload with same content length",
+		Method: "GET",
+		URL:    "no",
+		ReqHeader: map[string]string{
+			"If-None-Match": etag,
+		},
+		Code: http.StatusOK,
+	}).Run(handler, t)
+
+	// Appending data changes the entity tag
+	offset = 15
+	w = (&httpTest{
+		Name:   "Changed content",
+		Method: "GET",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"If-None-Match": etag,
+		},
+		Code: http.StatusOK,
+	}).Run(handler, t)
+
+	if w.HeaderMap.Get("ETag") == etag {
+		t.Error("expected entity tag to change")
+	}
+
+	// Other instances, whether they have computed the tree hash or not, send
+	// the same entity tag
+	offset = 11
+	handler, _ = NewHandler(Config{
+		DataStore:       etagStore{offset: &offset},
+		ComputeTreeHash: true,
+	})
+
+	(&httpTest{
+		Name:   "Entity tag of other instance",
+		Method: "GET",
+		URL:    "yes",
+		Code:   http.StatusOK,
+		ResHeader: map[string]string{
+			"ETag": etag,
+		},
+	}).Run(handler, t)
+}
@@ -3,15 +3,21 @@ package tusd
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/bmizerany/pat"
 )
 
-// Handler is a ready to use handler with routing (using pat)
+// Handler is a ready to use handler with routing (using pat). The underlying
+// UnroutedHandler is accessible using the Unrouted field, e.g. for calling
+// RetryPendingFinishes.
 type Handler struct {
-	unroutedHandler *UnroutedHandler
-	routeHandler    http.Handler
-	CompleteUploads chan FileInfo
+	Unrouted          *UnroutedHandler
+	routeHandler      http.Handler
+	CompleteUploads   chan FileInfo
+	CreatedUploads    chan FileInfo
+	TerminatedUploads chan FileInfo
 }
 
 // NewHandler creates a routed tus protocol handler. This is the simplest
@@ -28,25 +34,35 @@ func NewHandler(config Config) (*Handler, error) {
 	}
 
 	routedHandler := &Handler{
-		unroutedHandler: handler,
-		CompleteUploads: handler.CompleteUploads,
+		Unrouted:          handler,
+		CompleteUploads:   handler.CompleteUploads,
+		CreatedUploads:    handler.CreatedUploads,
+		TerminatedUploads: handler.TerminatedUploads,
 	}
 
 	mux := pat.New()
 
-	routedHandler.routeHandler = handler.Middleware(mux)
+	// The base path and trailing slashes are stripped before routing, so the
+	// handler can be mounted at the base path directly
+	prefix := handler.basePath
+	if uri, err := url.Parse(prefix); err == nil && uri.IsAbs() {
+		prefix = uri.Path
+	}
+	routedHandler.routeHandler = handler.Middleware(normalizePath(prefix, mux))
 
 	mux.Post("", http.HandlerFunc(handler.PostFile))
 	mux.Head(":id", http.HandlerFunc(handler.HeadFile))
 	mux.Add("PATCH", ":id", http.HandlerFunc(handler.PatchFile))
+	mux.Get(":id/manifest", http.HandlerFunc(handler.GetManifest))
 
-	// Only attach the DELETE handler if the Terminate() method is provided
-	if _, ok := config.DataStore.(TerminaterDataStore); ok {
+	// Only attach the DELETE handler if the Terminate() method is provided and
+	// the termination extension is enabled
+	if handler.hasExtension("termination") {
 		mux.Del(":id", http.HandlerFunc(handler.DelFile))
 	}
 
 	// GET handler requires the GetReader() method
-	if _, ok := config.DataStore.(GetReaderDataStore); ok {
+	if handler.composer.GetReader != nil {
 		mux.Get(":id", http.HandlerFunc(handler.GetFile))
 	}
 
@@ -57,3 +73,41 @@ func NewHandler(config Config) (*Handler, error) {
 func (rHandler *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	rHandler.routeHandler.ServeHTTP(w, r)
 }
+
+// normalizePath removes the prefix from the paths of the requests before
+// passing them to the handler. The prefix without its trailing slash is
+// accepted as well, allowing uploads to be created at "/files" in addition to
+// "/files/". Paths not beginning with the prefix are accepted as well, so
+// wrapping the handler using http.StripPrefix continues to work and the
+// handler can be mounted at the root, e.g. behind a reverse proxy which
+// removes the prefix before forwarding the requests. Their leading slash is
+// removed, so "/[id]" is handled the same as "[id]". In addition, trailing
+// slashes are removed since the canonical URL of an upload, as used in the
+// Location header, does not contain one. Therefore "/files/[id]/" is handled
+// the same as "/files/[id]".
+func normalizePath(prefix string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path
+		if prefix != "" && prefix != "/" {
+			if path == strings.TrimSuffix(prefix, "/") {
+				path = ""
+			} else {
+				path = strings.TrimPrefix(path, prefix)
+			}
+		}
+		path = strings.TrimPrefix(path, "/")
+		path = strings.TrimSuffix(path, "/")
+
+		if path == r.URL.Path {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = path
+		h.ServeHTTP(w, r2)
+	})
+}
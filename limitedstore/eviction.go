@@ -0,0 +1,65 @@
+package limitedstore
+
+import (
+	"sort"
+	"time"
+)
+
+// Upload describes an upload known to a LimitedStore which may be terminated
+// in order to free space.
+type Upload struct {
+	ID   string
+	Size int64
+	// LastAccess is the time at which the upload has been created, written to
+	// or read from the last time. It is zero for uploads which have been
+	// restored, see LimitedStore.Restore.
+	LastAccess time.Time
+}
+
+// EvictionPolicy defines the order in which a LimitedStore terminates uploads
+// in order to free space for new ones.
+type EvictionPolicy interface {
+	// Select returns the IDs of the uploads in the order in which they should
+	// be terminated. Uploads which are omitted are not terminated.
+	Select(uploads []Upload) []string
+}
+
+// LargestFirst terminates the biggest uploads first, so as few uploads as
+// possible are lost. It is the default policy.
+type LargestFirst struct{}
+
+func (LargestFirst) Select(uploads []Upload) []string {
+	sorted := bySize(uploads)
+	sort.Sort(sort.Reverse(sorted))
+	return ids(sorted)
+}
+
+// LeastRecentlyUsed terminates the uploads which have not been accessed for
+// the longest time first, regardless of their size.
+type LeastRecentlyUsed struct{}
+
+func (LeastRecentlyUsed) Select(uploads []Upload) []string {
+	sorted := byLastAccess(uploads)
+	sort.Sort(sorted)
+	return ids(sorted)
+}
+
+func ids(uploads []Upload) []string {
+	ids := make([]string, len(uploads))
+	for i, upload := range uploads {
+		ids[i] = upload.ID
+	}
+	return ids
+}
+
+type bySize []Upload
+
+func (p bySize) Len() int           { return len(p) }
+func (p bySize) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p bySize) Less(i, j int) bool { return p[i].Size < p[j].Size }
+
+type byLastAccess []Upload
+
+func (p byLastAccess) Len() int           { return len(p) }
+func (p byLastAccess) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byLastAccess) Less(i, j int) bool { return p[i].LastAccess.Before(p[j].LastAccess) }
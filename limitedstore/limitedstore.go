@@ -4,18 +4,35 @@
 // datastores (tusd.DataStore) while limiting the used storage size.
 // It will start terminating existing uploads if not enough space is left in
 // order to create a new upload.
-// The order in which the uploads will be terminated is defined by their size,
-// whereas the biggest ones are deleted first.
+// The order in which the uploads will be terminated is defined by an
+// EvictionPolicy. By default, the biggest ones are deleted first while the
+// LeastRecentlyUsed policy deletes the ones which have not been written to or
+// read from for the longest time first. If a grace period is configured,
+// uploads which have been idle for longer than this period are terminated
+// before recently active ones. If a reservation TTL is configured, uploads
+// which have not received any data within this time are terminated in order
+// to release the space reserved for them, see LimitedStore.Sweep. If multiple
+// limited stores are used, e.g. one per shard, they can share a QuotaManager
+// which enforces a common limit on their total size in addition to their
+// individual ones.
+// Uploads which are currently locked or written to are pinned and never
+// terminated in order to free space. If enough space cannot be freed without
+// them, tusd.ErrNotEnoughSpace is returned.
+// External services may be informed about evicted uploads using
+// LimitedStore.EvictionWebhook.
 // This package's functionality is very limited and naive. It will terminate
 // uploads whether they are finished yet or not. Only one datastore is allowed to
 // access the underlying storage else the limited store will not function
 // properly. Two tusd.FileStore instances using the same directory, for example.
 // In addition the limited store will keep a list of the uploads' IDs in memory
-// which may create a growing memory leak.
+// which may create a growing memory leak. This list is empty after a restart
+// unless it is rebuilt using LimitedStore.Restore, which requires the
+// underlying data store to implement tusd.UploadLister.
 //
-// While LimitedStore implements the GetReader, LockUpload, UnlockUpload,
-// FinishUpload and ConcatUploads methods, it does not contain proper definitions
-// for them. When invoked, the call will be passed to the underlying
+// While LimitedStore implements the GetReader, GetReaderAt, LockUpload,
+// UnlockUpload, ActiveLocks, FinishUpload, ConcatUploads, Describe,
+// GetWrittenOffset and FailUpload methods, it does not contain proper
+// definitions for them. When invoked, the call will be passed to the underlying
 // data store as long as it provides these methods. If not, either an error
 // is returned or nothing happens (see the specific methods for more
 // detailed information). The motivation behind this decision was, that this
@@ -26,31 +43,73 @@ package limitedstore
 import (
 	"github.com/tus/tusd"
 	"io"
-	"sort"
 	"sync"
+	"time"
 )
 
 type LimitedStore struct {
 	StoreSize int64
 	tusd.TerminaterDataStore
 
+	// GracePeriod defines how long an upload is considered active after it has
+	// been created, written to or read from. When space must be freed,
+	// uploads which have been idle for longer are terminated first while
+	// active ones are only touched as a last resort.
+	GracePeriod time.Duration
+
+	// ReservationTTL defines how long the space reserved for a new upload is
+	// held if no data is written to it. Once expired, an upload without any
+	// progress is terminated by Sweep, which is also run before new uploads
+	// are created. If zero, reservations do not expire.
+	ReservationTTL time.Duration
+
+	// Eviction defines the order in which uploads are terminated in order to
+	// free space. If nil, LargestFirst is used.
+	Eviction EvictionPolicy
+
+	// Quota optionally defines a budget shared with other stores. The space of
+	// every new upload must be reserved in both this store and the quota. If
+	// the quota is exhausted, the store only terminates its own uploads and
+	// rejects the new one if this cannot free enough space.
+	Quota QuotaManager
+
+	// EvictionWebhook, if set, is used for sending an EvictedUpload for every
+	// upload which is terminated in order to free space or because its
+	// reservation has expired, but not for uploads terminated using
+	// Terminate. The notifications are sent in the background, so failed
+	// deliveries have to be handled using the webhook's DeadLetter.
+	EvictionWebhook *tusd.Webhook
+
 	uploads  map[string]int64
+	activity map[string]time.Time
+	created  map[string]time.Time
 	usedSize int64
 
-	mutex *sync.Mutex
-}
+	// pinned counts the locks and writes of each upload which is in use and
+	// must therefore not be terminated.
+	pinned map[string]int
 
-// pair structure to perform map-sorting
-type pair struct {
-	key   string
-	value int64
+	mutex *sync.Mutex
 }
 
-type pairlist []pair
+// The reasons for evicting an upload, as sent in EvictedUpload.Reason.
+const (
+	// ReasonSpace means that the upload has been terminated in order to free
+	// space for a new one.
+	ReasonSpace = "space"
+	// ReasonReservationExpired means that the upload has not received any data
+	// within the ReservationTTL.
+	ReasonReservationExpired = "reservation-expired"
+)
 
-func (p pairlist) Len() int           { return len(p) }
-func (p pairlist) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p pairlist) Less(i, j int) bool { return p[i].value < p[j].value }
+// EvictedUpload is sent to the EvictionWebhook for every evicted upload.
+type EvictedUpload struct {
+	// Upload is the info of the upload as it has been before its
+	// termination. If it could not be retrieved, only the ID is set.
+	Upload tusd.FileInfo
+	// Reason describes why the upload has been evicted, e.g. ReasonSpace.
+	Reason string
+}
 
 // New creates a new limited store with the given size as the maximum storage
 // size. The wrapped data store needs to implement the TerminaterDataStore
@@ -60,6 +119,9 @@ func New(storeSize int64, dataStore tusd.TerminaterDataStore) *LimitedStore {
 		StoreSize:           storeSize,
 		TerminaterDataStore: dataStore,
 		uploads:             make(map[string]int64),
+		activity:            make(map[string]time.Time),
+		created:             make(map[string]time.Time),
+		pinned:              make(map[string]int),
 		mutex:               new(sync.Mutex),
 	}
 }
@@ -68,78 +130,402 @@ func (store *LimitedStore) NewUpload(info tusd.FileInfo) (string, error) {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
+	if err := store.sweep(); err != nil {
+		return "", err
+	}
+
 	if err := store.ensureSpace(info.Size); err != nil {
 		return "", err
 	}
 
 	id, err := store.TerminaterDataStore.NewUpload(info)
 	if err != nil {
+		store.releaseQuota(info.Size)
 		return "", err
 	}
 
 	store.usedSize += info.Size
 	store.uploads[id] = info.Size
+	store.activity[id] = time.Now()
+	store.created[id] = time.Now()
 
 	return id, nil
 }
 
+// WriteChunk passes the call to the underlying data store. The upload is
+// pinned while it is written to, so it is not terminated to free space.
+func (store *LimitedStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	store.touch(id)
+	store.pin(id)
+	defer store.unpin(id)
+
+	return store.TerminaterDataStore.WriteChunk(id, offset, src)
+}
+
+// touch records that the upload has been accessed.
+func (store *LimitedStore) touch(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.uploads[id]; ok {
+		store.activity[id] = time.Now()
+	}
+}
+
+// pin prevents the upload from being terminated to free space until unpin is
+// called as often as pin.
+func (store *LimitedStore) pin(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.pinned[id]++
+}
+
+func (store *LimitedStore) unpin(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.pinned[id] <= 1 {
+		delete(store.pinned, id)
+	} else {
+		store.pinned[id]--
+	}
+}
+
+// Terminate removes the upload from the accounting before passing the call to
+// the underlying data store. The mutex is not held meanwhile, so slow data
+// stores do not block the creation of other uploads. The upload's space is
+// released once it has been terminated.
 func (store *LimitedStore) Terminate(id string) error {
+	store.mutex.Lock()
+	size, ok := store.uploads[id]
+	activity := store.activity[id]
+	created, hasCreated := store.created[id]
+	delete(store.uploads, id)
+	delete(store.activity, id)
+	delete(store.created, id)
+	store.mutex.Unlock()
+
+	err := store.TerminaterDataStore.Terminate(id)
+
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
-	return store.terminate(id)
+	if err != nil {
+		// Restore the accounting since the upload still exists
+		if ok {
+			store.uploads[id] = size
+			store.activity[id] = activity
+		}
+		if hasCreated {
+			store.created[id] = created
+		}
+		return err
+	}
+
+	store.usedSize -= size
+	store.releaseQuota(size)
+	return nil
 }
 
-func (store *LimitedStore) terminate(id string) error {
+// terminate evicts the upload for the given reason and informs the
+// EvictionWebhook, if configured.
+func (store *LimitedStore) terminate(id string, reason string) error {
+	// The info must be fetched beforehand since it is gone afterwards
+	var info tusd.FileInfo
+	if store.EvictionWebhook != nil {
+		info, _ = store.TerminaterDataStore.GetInfo(id)
+		info.ID = id
+	}
+
 	err := store.TerminaterDataStore.Terminate(id)
 	if err != nil {
 		return err
 	}
 
+	if hook := store.EvictionWebhook; hook != nil {
+		go hook.Send(EvictedUpload{
+			Upload: info,
+			Reason: reason,
+		})
+	}
+
 	size := store.uploads[id]
 	delete(store.uploads, id)
+	delete(store.activity, id)
+	delete(store.created, id)
 	store.usedSize -= size
+	store.releaseQuota(size)
+
+	return nil
+}
+
+// Restore rebuilds the list of uploads and the used size from the underlying
+// data store, e.g. after a restart, so existing uploads are taken into account
+// and can be terminated to free space. Since their activity is unknown, they
+// are considered idle. Uploads which have not received any data yet are
+// subject to the ReservationTTL, counted from the restart. Uploads which are
+// already known are skipped. Either all uploads are restored or, if their
+// space cannot be reserved in the shared quota, none of them. If the data
+// store does not implement tusd.UploadLister, nothing happens.
+func (store *LimitedStore) Restore() error {
+	lister, ok := store.TerminaterDataStore.(tusd.UploadLister)
+	if !ok {
+		return nil
+	}
+
+	infos, err := lister.ListUploads()
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	restored := make(map[string]tusd.FileInfo)
+	size := int64(0)
+	for _, info := range infos {
+		if _, ok := store.uploads[info.ID]; ok {
+			continue
+		}
+		if _, ok := restored[info.ID]; ok {
+			continue
+		}
+
+		restored[info.ID] = info
+		size += info.Size
+	}
+
+	// Reserve the space of all uploads at once, so nothing has to be released
+	// if the quota is exceeded
+	if store.Quota != nil && size > 0 {
+		if err := store.Quota.Reserve(size); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for id, info := range restored {
+		store.usedSize += info.Size
+		store.uploads[id] = info.Size
+		store.activity[id] = time.Time{}
+		if info.Offset == 0 {
+			store.created[id] = now
+		}
+	}
+
+	return nil
+}
+
+// Used returns the number of bytes which are currently reserved for uploads.
+func (store *LimitedStore) Used() int64 {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.usedSize
+}
+
+// Sweep terminates all uploads which have been created longer than the
+// reservation TTL ago but have not received any data yet, releasing the space
+// reserved for them. It may be invoked periodically in addition to the
+// automatic sweeping when creating new uploads.
+func (store *LimitedStore) Sweep() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.sweep()
+}
+
+func (store *LimitedStore) sweep() error {
+	if store.ReservationTTL <= 0 {
+		return nil
+	}
+
+	for id, created := range store.created {
+		if time.Since(created) < store.ReservationTTL || store.pinned[id] > 0 {
+			continue
+		}
+
+		info, err := store.TerminaterDataStore.GetInfo(id)
+		if err != nil {
+			return err
+		}
+
+		if info.Offset > 0 {
+			// The upload has made progress, so its reservation is in use and
+			// does not need to be checked again.
+			delete(store.created, id)
+			continue
+		}
+
+		if err := store.terminate(id, ReasonReservationExpired); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
 // Ensure enough space is available to store an upload of the specified size.
-// It will terminate uploads until enough space is freed.
+// It will terminate uploads until enough space is freed and has been reserved
+// in the shared quota, if configured. If the upload is bigger than the entire
+// store, or terminating all of the store's uploads would not free enough space
+// in the quota, tusd.InsufficientStorageError is returned without terminating
+// any upload. Pinned uploads are never terminated and if enough space cannot
+// be freed without them, tusd.ErrNotEnoughSpace is returned.
 func (store *LimitedStore) ensureSpace(size int64) error {
-	if (store.usedSize + size) <= store.StoreSize {
-		// Enough space is available to store the new upload
-		return nil
+	if size > store.StoreSize {
+		// The upload would not fit even if all others were terminated
+		return tusd.InsufficientStorageError{
+			Used:      store.usedSize,
+			Total:     store.StoreSize,
+			Requested: size,
+		}
 	}
 
-	sortedUploads := make(pairlist, len(store.uploads))
-	i := 0
-	for u, h := range store.uploads {
-		sortedUploads[i] = pair{u, h}
-		i++
+	// Check whether terminating the candidates frees enough space before
+	// touching any of them, since the pinned uploads must be kept
+	candidates := store.evictionCandidates()
+	available := store.StoreSize - store.usedSize
+	for _, id := range candidates {
+		available += store.uploads[id]
+	}
+	if available < size {
+		return tusd.ErrNotEnoughSpace
 	}
-	sort.Sort(sort.Reverse(sortedUploads))
 
-	// Forward traversal through the uploads in terms of size, biggest upload first
-	for _, k := range sortedUploads {
-		id := k.key
+	// Reserve the space in the shared quota first, so no upload is terminated
+	// if the quota cannot be satisfied by this store alone.
+	for store.Quota != nil {
+		err := store.Quota.Reserve(size)
+		if err == nil {
+			break
+		}
 
-		if err := store.terminate(id); err != nil {
+		storageErr, ok := err.(tusd.InsufficientStorageError)
+		if !ok || storageErr.Total-storageErr.Used+store.usedSize < size {
 			return err
 		}
+		if len(candidates) == 0 {
+			return tusd.ErrNotEnoughSpace
+		}
 
+		if err := store.terminate(candidates[0], ReasonSpace); err != nil {
+			return err
+		}
+		candidates = candidates[1:]
+	}
+
+	for _, id := range candidates {
 		if (store.usedSize + size) <= store.StoreSize {
 			// Enough space has been freed to store the new upload
 			return nil
 		}
+
+		if err := store.terminate(id, ReasonSpace); err != nil {
+			store.releaseQuota(size)
+			return err
+		}
+	}
+
+	if (store.usedSize + size) > store.StoreSize {
+		// Only pinned uploads are left
+		store.releaseQuota(size)
+		return tusd.ErrNotEnoughSpace
+	}
+
+	return nil
+}
+
+// evictionCandidates returns the uploads which are not pinned in the order in
+// which they are terminated to free space.
+func (store *LimitedStore) evictionCandidates() []string {
+	// Divide the uploads into idle and recently active ones
+	var idleUploads, activeUploads []Upload
+	for id, size := range store.uploads {
+		if store.pinned[id] > 0 {
+			continue
+		}
+
+		upload := Upload{
+			ID:         id,
+			Size:       size,
+			LastAccess: store.activity[id],
+		}
+		if time.Since(upload.LastAccess) >= store.GracePeriod {
+			idleUploads = append(idleUploads, upload)
+		} else {
+			activeUploads = append(activeUploads, upload)
+		}
+	}
+
+	// Forward traversal through the uploads in the order defined by the
+	// eviction policy, while all idle uploads come before the active ones
+	eviction := store.Eviction
+	if eviction == nil {
+		eviction = LargestFirst{}
+	}
+	return append(eviction.Select(idleUploads), eviction.Select(activeUploads)...)
+}
+
+// CheckCapacity returns the error NewUpload would return for an upload of the
+// specified size, without terminating any upload or reserving space in the
+// shared quota. It implements tusd.CapacityCheckerDataStore, so requests with
+// the Tus-Dry-Run header are subject to the store's size.
+func (store *LimitedStore) CheckCapacity(info tusd.FileInfo) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	size := info.Size
+	if size > store.StoreSize {
+		return tusd.InsufficientStorageError{
+			Used:      store.usedSize,
+			Total:     store.StoreSize,
+			Requested: size,
+		}
+	}
+
+	freeable := int64(0)
+	for _, id := range store.evictionCandidates() {
+		freeable += store.uploads[id]
+	}
+	if store.StoreSize-store.usedSize+freeable < size {
+		return tusd.ErrNotEnoughSpace
 	}
 
+	if store.Quota == nil {
+		return nil
+	}
+
+	// Probe the shared quota by reserving the space and releasing it again
+	err := store.Quota.Reserve(size)
+	if err == nil {
+		store.Quota.Release(size)
+		return nil
+	}
+
+	storageErr, ok := err.(tusd.InsufficientStorageError)
+	if !ok || storageErr.Total-storageErr.Used+store.usedSize < size {
+		return err
+	}
+	if storageErr.Total-storageErr.Used+freeable < size {
+		return tusd.ErrNotEnoughSpace
+	}
 	return nil
 }
 
+// releaseQuota returns the space to the shared quota, if configured.
+func (store *LimitedStore) releaseQuota(size int64) {
+	if store.Quota != nil {
+		store.Quota.Release(size)
+	}
+}
+
 // GetReader will pass the call to the underlying data store if it implements
 // the tusd.GetReaderDataStore interface. Else tusd.ErrNotImplemented will be
-// returned.
+// returned. The upload is considered accessed in either case.
 func (store *LimitedStore) GetReader(id string) (io.Reader, error) {
+	store.touch(id)
 	if s, ok := store.TerminaterDataStore.(tusd.GetReaderDataStore); ok {
 		return s.GetReader(id)
 	} else {
@@ -147,19 +533,70 @@ func (store *LimitedStore) GetReader(id string) (io.Reader, error) {
 	}
 }
 
+// GetReaderAt will pass the call to the underlying data store if it implements
+// the tusd.ReaderAtDataStore interface. Else tusd.ErrNotImplemented will be
+// returned. The upload is considered accessed in either case.
+func (store *LimitedStore) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	store.touch(id)
+	if s, ok := store.TerminaterDataStore.(tusd.ReaderAtDataStore); ok {
+		return s.GetReaderAt(id)
+	} else {
+		return nil, 0, tusd.ErrNotImplemented
+	}
+}
+
+// GetWrittenOffset will pass the call to the underlying data store if it
+// implements the tusd.BufferedDataStore interface. Else the offset reported by
+// GetInfo is returned since all written bytes are flushed.
+func (store *LimitedStore) GetWrittenOffset(id string) (int64, error) {
+	if s, ok := store.TerminaterDataStore.(tusd.BufferedDataStore); ok {
+		return s.GetWrittenOffset(id)
+	} else {
+		info, err := store.TerminaterDataStore.GetInfo(id)
+		return info.Offset, err
+	}
+}
+
+// FailUpload will pass the call to the underlying data store if it implements
+// the tusd.FailerDataStore interface. Else tusd.ErrNotImplemented will be
+// returned.
+func (store *LimitedStore) FailUpload(id string, reason string) error {
+	if s, ok := store.TerminaterDataStore.(tusd.FailerDataStore); ok {
+		return s.FailUpload(id, reason)
+	} else {
+		return tusd.ErrNotImplemented
+	}
+}
+
+// Describe will pass the call to the underlying data store if it implements
+// the tusd.DescriberDataStore interface. Else an empty string is returned.
+func (store *LimitedStore) Describe() string {
+	if s, ok := store.TerminaterDataStore.(tusd.DescriberDataStore); ok {
+		return s.Describe()
+	} else {
+		return ""
+	}
+}
+
 // LockUpload will pass the call to the underlying data store if it implements
 // the tusd.LockerDataStore interface. Else this function simply returns nil.
+// Once locked, the upload is pinned until it is unlocked again.
 func (store *LimitedStore) LockUpload(id string) error {
 	if s, ok := store.TerminaterDataStore.(tusd.LockerDataStore); ok {
-		return s.LockUpload(id)
+		if err := s.LockUpload(id); err != nil {
+			return err
+		}
 	}
 
+	store.pin(id)
 	return nil
 }
 
 // UnlockUpload will pass the call to the underlying data store if it implements
 // the tusd.LockerDataStore interface. Else this function simply returns nil.
 func (store *LimitedStore) UnlockUpload(id string) error {
+	store.unpin(id)
+
 	if s, ok := store.TerminaterDataStore.(tusd.LockerDataStore); ok {
 		return s.UnlockUpload(id)
 	}
@@ -167,6 +604,16 @@ func (store *LimitedStore) UnlockUpload(id string) error {
 	return nil
 }
 
+// ActiveLocks will pass the call to the underlying data store if it implements
+// the tusd.LockInspector interface. Else this function simply returns nil.
+func (store *LimitedStore) ActiveLocks() []tusd.LockInfo {
+	if s, ok := store.TerminaterDataStore.(tusd.LockInspector); ok {
+		return s.ActiveLocks()
+	}
+
+	return nil
+}
+
 // FinishUpload will pass the call to the underlying data store if it implements
 // the tusd.FinisherDataStore interface. Else this function simply returns nil.
 func (store *LimitedStore) FinishUpload(id string) error {
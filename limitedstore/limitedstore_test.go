@@ -1,9 +1,14 @@
 package limitedstore
 
 import (
+	"encoding/json"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -16,6 +21,12 @@ var _ tusd.TerminaterDataStore = &LimitedStore{}
 var _ tusd.LockerDataStore = &LimitedStore{}
 var _ tusd.ConcaterDataStore = &LimitedStore{}
 var _ tusd.FinisherDataStore = &LimitedStore{}
+var _ tusd.ReaderAtDataStore = &LimitedStore{}
+var _ tusd.LockInspector = &LimitedStore{}
+var _ tusd.DescriberDataStore = &LimitedStore{}
+var _ tusd.BufferedDataStore = &LimitedStore{}
+var _ tusd.FailerDataStore = &LimitedStore{}
+var _ tusd.CapacityCheckerDataStore = &LimitedStore{}
 
 type dataStore struct {
 	t                    *assert.Assertions
@@ -93,3 +104,425 @@ func TestLimitedStore(t *testing.T) {
 		t.Error("expected two uploads to be terminated")
 	}
 }
+
+type graceDataStore struct {
+	numCreatedUploads int
+	terminatedUploads []string
+}
+
+func (store *graceDataStore) NewUpload(info tusd.FileInfo) (string, error) {
+	id := strconv.Itoa(store.numCreatedUploads)
+	store.numCreatedUploads += 1
+
+	return id, nil
+}
+
+func (store *graceDataStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return 0, nil
+}
+
+func (store *graceDataStore) GetInfo(id string) (tusd.FileInfo, error) {
+	return tusd.FileInfo{}, nil
+}
+
+func (store *graceDataStore) Terminate(id string) error {
+	store.terminatedUploads = append(store.terminatedUploads, id)
+
+	return nil
+}
+
+func TestGracePeriod(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &graceDataStore{}
+	store := New(100, dataStore)
+	store.GracePeriod = time.Hour
+
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 50})
+	a.NoError(err)
+
+	idB, err := store.NewUpload(tusd.FileInfo{Size: 30})
+	a.NoError(err)
+
+	// Upload B has been idle for longer than the grace period while A has
+	// recently received data.
+	store.activity[idB] = time.Now().Add(-2 * time.Hour)
+	_, err = store.WriteChunk(idA, 0, nil)
+	a.NoError(err)
+
+	// The smaller but idle upload is terminated first
+	idC, err := store.NewUpload(tusd.FileInfo{Size: 40})
+	a.NoError(err)
+	a.Equal([]string{idB}, dataStore.terminatedUploads)
+
+	// If no idle uploads are left, active ones are terminated, biggest first
+	_, err = store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+	a.Equal([]string{idB, idA}, dataStore.terminatedUploads)
+	a.NotContains(dataStore.terminatedUploads, idC)
+}
+
+type ttlDataStore struct {
+	graceDataStore
+	offsets map[string]int64
+}
+
+func (store *ttlDataStore) GetInfo(id string) (tusd.FileInfo, error) {
+	return tusd.FileInfo{
+		ID:     id,
+		Offset: store.offsets[id],
+	}, nil
+}
+
+func TestReservationTTL(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &ttlDataStore{
+		offsets: make(map[string]int64),
+	}
+	store := New(100, dataStore)
+	store.ReservationTTL = time.Hour
+
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 30})
+	a.NoError(err)
+
+	idB, err := store.NewUpload(tusd.FileInfo{Size: 30})
+	a.NoError(err)
+
+	idC, err := store.NewUpload(tusd.FileInfo{Size: 30})
+	a.NoError(err)
+
+	// Upload A is stale without progress, B is stale but has received data
+	// and C is still within its TTL.
+	store.created[idA] = time.Now().Add(-2 * time.Hour)
+	store.created[idB] = time.Now().Add(-2 * time.Hour)
+	dataStore.offsets[idB] = 10
+
+	a.NoError(store.Sweep())
+	a.Equal([]string{idA}, dataStore.terminatedUploads)
+	a.EqualValues(60, store.usedSize)
+
+	// The space of the stale reservation is available without terminating
+	// any other upload
+	_, err = store.NewUpload(tusd.FileInfo{Size: 40})
+	a.NoError(err)
+	a.Equal([]string{idA}, dataStore.terminatedUploads)
+	a.NotContains(dataStore.terminatedUploads, idC)
+}
+
+func TestInsufficientStorage(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &graceDataStore{}
+	store := New(100, dataStore)
+
+	_, err := store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+
+	// An upload bigger than the entire store is rejected without terminating
+	// any other upload
+	_, err = store.NewUpload(tusd.FileInfo{Size: 120})
+	a.Equal(tusd.InsufficientStorageError{
+		Used:      60,
+		Total:     100,
+		Requested: 120,
+	}, err)
+	a.Empty(dataStore.terminatedUploads)
+	a.Equal(1, dataStore.numCreatedUploads)
+}
+
+func TestSharedQuota(t *testing.T) {
+	a := assert.New(t)
+	quota := NewMemoryQuota(100)
+
+	dataStoreA := &graceDataStore{}
+	storeA := New(80, dataStoreA)
+	storeA.Quota = quota
+
+	dataStoreB := &graceDataStore{}
+	storeB := New(80, dataStoreB)
+	storeB.Quota = quota
+
+	idA, err := storeA.NewUpload(tusd.FileInfo{Size: 50})
+	a.NoError(err)
+
+	_, err = storeB.NewUpload(tusd.FileInfo{Size: 40})
+	a.NoError(err)
+	a.EqualValues(90, quota.Used())
+
+	// Store B fits the upload on its own but must not exceed the shared quota.
+	// Since terminating its own upload would not free enough space, it is
+	// rejected without terminating anything.
+	_, err = storeB.NewUpload(tusd.FileInfo{Size: 60})
+	a.Equal(tusd.InsufficientStorageError{
+		Used:      90,
+		Total:     100,
+		Requested: 60,
+	}, err)
+	a.Empty(dataStoreB.terminatedUploads)
+	a.Empty(dataStoreA.terminatedUploads)
+	a.EqualValues(90, quota.Used())
+
+	// Store A can free enough space by terminating its own upload
+	_, err = storeA.NewUpload(tusd.FileInfo{Size: 55})
+	a.NoError(err)
+	a.Equal([]string{idA}, dataStoreA.terminatedUploads)
+	a.EqualValues(95, quota.Used())
+
+	// Terminating releases the space in the quota
+	a.NoError(storeB.Terminate("0"))
+	a.EqualValues(55, quota.Used())
+}
+
+type listerDataStore struct {
+	graceDataStore
+	infos []tusd.FileInfo
+}
+
+func (store *listerDataStore) ListUploads() ([]tusd.FileInfo, error) {
+	return store.infos, nil
+}
+
+func TestRestore(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &listerDataStore{
+		infos: []tusd.FileInfo{
+			{ID: "old-a", Size: 30},
+			{ID: "old-b", Size: 50},
+		},
+	}
+	store := New(100, dataStore)
+	store.GracePeriod = time.Hour
+
+	a.NoError(store.Restore())
+	a.EqualValues(80, store.Used())
+
+	// Restoring again does not count the uploads twice
+	a.NoError(store.Restore())
+	a.EqualValues(80, store.Used())
+
+	// The restored uploads are considered idle and terminated to free space
+	_, err := store.NewUpload(tusd.FileInfo{Size: 40})
+	a.NoError(err)
+	a.Equal([]string{"old-b"}, dataStore.terminatedUploads)
+	a.EqualValues(70, store.Used())
+
+	// Stores which cannot list their uploads start empty
+	store = New(100, &graceDataStore{})
+	a.NoError(store.Restore())
+	a.EqualValues(0, store.Used())
+}
+
+func TestRestoreQuota(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &listerDataStore{
+		infos: []tusd.FileInfo{
+			{ID: "old-a", Size: 30},
+			{ID: "old-b", Size: 50},
+		},
+	}
+	store := New(100, dataStore)
+	store.Quota = NewMemoryQuota(60)
+
+	// Nothing is restored if the quota cannot hold all uploads
+	a.IsType(tusd.InsufficientStorageError{}, store.Restore())
+	a.EqualValues(0, store.Used())
+	a.EqualValues(0, store.Quota.(*MemoryQuota).Used())
+
+	store.Quota = NewMemoryQuota(100)
+	a.NoError(store.Restore())
+	a.EqualValues(80, store.Used())
+	a.EqualValues(80, store.Quota.(*MemoryQuota).Used())
+}
+
+func TestRestoreReservationTTL(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &listerDataStore{
+		infos: []tusd.FileInfo{
+			{ID: "empty", Size: 30},
+			{ID: "progress", Size: 50, Offset: 10},
+		},
+	}
+	store := New(100, dataStore)
+	store.ReservationTTL = 10 * time.Millisecond
+
+	a.NoError(store.Restore())
+	time.Sleep(20 * time.Millisecond)
+
+	// The restored upload without any data has its reservation expired
+	a.NoError(store.Sweep())
+	a.Equal([]string{"empty"}, dataStore.terminatedUploads)
+	a.EqualValues(50, store.Used())
+}
+
+func TestLeastRecentlyUsed(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &graceDataStore{}
+	store := New(100, dataStore)
+	store.Eviction = LeastRecentlyUsed{}
+
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+
+	idB, err := store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+
+	idC, err := store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+
+	// The big upload is in use while the small ones are stale, of which B has
+	// been accessed less recently
+	store.activity[idA] = time.Now()
+	store.activity[idB] = time.Now().Add(-2 * time.Hour)
+	store.activity[idC] = time.Now().Add(-time.Hour)
+
+	_, err = store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+	a.Equal([]string{idB}, dataStore.terminatedUploads)
+
+	// Reading counts as access, so C is kept in favor of A
+	store.activity[idA] = time.Now().Add(-3 * time.Hour)
+	_, err = store.GetReader(idC)
+	a.Equal(tusd.ErrNotImplemented, err)
+
+	_, err = store.NewUpload(tusd.FileInfo{Size: 20})
+	a.NoError(err)
+	a.Equal([]string{idB, idA}, dataStore.terminatedUploads)
+}
+
+func TestPinnedUploads(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &graceDataStore{}
+	store := New(100, dataStore)
+
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+	idB, err := store.NewUpload(tusd.FileInfo{Size: 30})
+	a.NoError(err)
+
+	// The locked upload A cannot be terminated and terminating B alone does
+	// not free enough space
+	a.NoError(store.LockUpload(idA))
+	_, err = store.NewUpload(tusd.FileInfo{Size: 50})
+	a.Equal(tusd.ErrNotEnoughSpace, err)
+	a.Empty(dataStore.terminatedUploads)
+
+	// B is terminated instead of the bigger but locked upload A
+	_, err = store.NewUpload(tusd.FileInfo{Size: 30})
+	a.NoError(err)
+	a.Equal([]string{idB}, dataStore.terminatedUploads)
+
+	// Once unlocked, A can be terminated again
+	a.NoError(store.UnlockUpload(idA))
+	_, err = store.NewUpload(tusd.FileInfo{Size: 50})
+	a.NoError(err)
+	a.Equal([]string{idB, idA}, dataStore.terminatedUploads)
+}
+
+// keepAll is an eviction policy which never terminates any upload.
+type keepAll struct{}
+
+func (keepAll) Select(uploads []Upload) []string {
+	return nil
+}
+
+func TestNotEnoughSpace(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &graceDataStore{}
+	store := New(10, dataStore)
+
+	_, err := store.NewUpload(tusd.FileInfo{Size: 8})
+	a.NoError(err)
+
+	// If the space cannot be freed since the eviction policy keeps all
+	// uploads, the new one must not exceed the store's size
+	store.Eviction = keepAll{}
+	_, err = store.NewUpload(tusd.FileInfo{Size: 5})
+	a.Equal(tusd.ErrNotEnoughSpace, err)
+	a.Empty(dataStore.terminatedUploads)
+	a.Equal(1, dataStore.numCreatedUploads)
+	a.Equal(int64(8), store.Used())
+}
+
+func TestCheckCapacity(t *testing.T) {
+	a := assert.New(t)
+	dataStore := &graceDataStore{}
+	store := New(100, dataStore)
+	store.Quota = NewMemoryQuota(150)
+
+	idA, err := store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+
+	a.IsType(tusd.InsufficientStorageError{}, store.CheckCapacity(tusd.FileInfo{Size: 120}))
+
+	// Terminating A would free enough space
+	a.NoError(store.CheckCapacity(tusd.FileInfo{Size: 80}))
+
+	// Unless it is in use
+	a.NoError(store.LockUpload(idA))
+	a.Equal(tusd.ErrNotEnoughSpace, store.CheckCapacity(tusd.FileInfo{Size: 80}))
+	a.NoError(store.UnlockUpload(idA))
+
+	// The space in the shared quota is considered as well
+	a.NoError(store.Quota.Reserve(80))
+	a.NoError(store.CheckCapacity(tusd.FileInfo{Size: 40}))
+	a.IsType(tusd.InsufficientStorageError{}, store.CheckCapacity(tusd.FileInfo{Size: 80}))
+
+	// Nothing has been terminated or reserved
+	a.Empty(dataStore.terminatedUploads)
+	a.Equal(int64(60), store.Used())
+	a.Equal(int64(140), store.Quota.(*MemoryQuota).Used())
+}
+
+func TestEvictionWebhook(t *testing.T) {
+	a := assert.New(t)
+
+	attempts := 0
+	received := make(chan EvictedUpload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		a.Contains(r.Header.Get("X-Tusd-Signature"), "sha256=")
+
+		// The first delivery fails and must be retried
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var evicted EvictedUpload
+		a.NoError(json.Unmarshal(body, &evicted))
+		received <- evicted
+	}))
+	defer server.Close()
+
+	dataStore := &ttlDataStore{
+		offsets: map[string]int64{"0": 10},
+	}
+	store := New(100, dataStore)
+	store.EvictionWebhook = &tusd.Webhook{
+		URL:     server.URL,
+		Secret:  "secret",
+		Retries: 1,
+		Backoff: time.Millisecond,
+	}
+
+	_, err := store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+
+	// Terminations requested by clients are not evictions
+	_, err = store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+	a.NoError(store.Terminate("1"))
+
+	_, err = store.NewUpload(tusd.FileInfo{Size: 60})
+	a.NoError(err)
+	a.Equal([]string{"1", "0"}, dataStore.terminatedUploads)
+
+	select {
+	case evicted := <-received:
+		a.Equal("0", evicted.Upload.ID)
+		a.EqualValues(10, evicted.Upload.Offset)
+		a.Equal(ReasonSpace, evicted.Reason)
+	case <-time.After(time.Second):
+		t.Fatal("Expected eviction to be sent")
+	}
+	a.Equal(2, attempts)
+}
@@ -0,0 +1,65 @@
+package limitedstore
+
+import (
+	"sync"
+
+	"github.com/tus/tusd"
+)
+
+// QuotaManager coordinates a storage budget shared by multiple limited stores.
+// Implementations must be safe for concurrent use. MemoryQuota can be used if
+// all stores live in a single process, while stores distributed over multiple
+// processes require an implementation backed by a shared service.
+type QuotaManager interface {
+	// Reserve claims the specified number of bytes from the budget. If not
+	// enough space is left, tusd.InsufficientStorageError should be returned
+	// in order to allow the store to terminate some of its uploads and retry.
+	Reserve(size int64) error
+	// Release returns the specified number of bytes to the budget.
+	Release(size int64)
+}
+
+// MemoryQuota is an in-memory QuotaManager for stores within the same process.
+type MemoryQuota struct {
+	total int64
+	used  int64
+	mutex sync.Mutex
+}
+
+// NewMemoryQuota creates a new quota manager with the given total size.
+func NewMemoryQuota(total int64) *MemoryQuota {
+	return &MemoryQuota{
+		total: total,
+	}
+}
+
+func (quota *MemoryQuota) Reserve(size int64) error {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+
+	if (quota.used + size) > quota.total {
+		return tusd.InsufficientStorageError{
+			Used:      quota.used,
+			Total:     quota.total,
+			Requested: size,
+		}
+	}
+
+	quota.used += size
+	return nil
+}
+
+func (quota *MemoryQuota) Release(size int64) {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+
+	quota.used -= size
+}
+
+// Used returns the number of bytes which are currently reserved.
+func (quota *MemoryQuota) Used() int64 {
+	quota.mutex.Lock()
+	defer quota.mutex.Unlock()
+
+	return quota.used
+}
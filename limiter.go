@@ -0,0 +1,86 @@
+package tusd
+
+// ConcurrencyLimiter bounds the number of PATCH requests which are processed
+// at the same time. Since the limit may be enforced using a counter shared by
+// multiple instances, it can protect a common storage backend from the
+// aggregated load of a whole cluster. See Config.PatchLimiter.
+type ConcurrencyLimiter interface {
+	// Acquire reserves a slot for a request. If the limit has been reached,
+	// false is returned and the request is rejected.
+	Acquire() (bool, error)
+	// Release frees a slot which has been reserved using Acquire.
+	Release() error
+}
+
+// SharedCounter is an integer counter which can be shared between multiple
+// instances, for example using the INCRBY command of a Redis server.
+type SharedCounter interface {
+	// Add atomically adds delta, which may be negative, to the counter and
+	// returns the new value.
+	Add(delta int64) (int64, error)
+}
+
+// ResettableCounter is a SharedCounter whose value can be overwritten, for
+// example using the SET command of a Redis server. See CounterLimiter.Reset.
+type ResettableCounter interface {
+	SharedCounter
+
+	// Set atomically replaces the counter's value.
+	Set(value int64) error
+}
+
+// CounterLimiter is a ConcurrencyLimiter allowing up to Limit requests at the
+// same time across all instances using the same counter.
+//
+// The counter only tracks how many slots are taken, not by whom. If an
+// instance exits without releasing its slots, e.g. because it crashed, they
+// are never freed and the limit available to the cluster shrinks until the
+// counter is reset. Operators can do so using Reset, or by modifying the
+// counter in its backend directly, once the instances have been restarted.
+type CounterLimiter struct {
+	Counter SharedCounter
+	Limit   int64
+}
+
+// NewCounterLimiter creates a new limiter using the provided counter.
+func NewCounterLimiter(counter SharedCounter, limit int64) *CounterLimiter {
+	return &CounterLimiter{
+		Counter: counter,
+		Limit:   limit,
+	}
+}
+
+func (limiter *CounterLimiter) Acquire() (bool, error) {
+	value, err := limiter.Counter.Add(1)
+	if err != nil {
+		return false, err
+	}
+
+	if value > limiter.Limit {
+		// Undo the increment since the slot has not been taken
+		_, err := limiter.Counter.Add(-1)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (limiter *CounterLimiter) Release() error {
+	_, err := limiter.Counter.Add(-1)
+	return err
+}
+
+// Reset frees all slots, including those leaked by instances which have
+// exited without releasing them. Slots which are still held are freed, too,
+// so the limit may be exceeded until they are released. Therefore it should
+// only be used while no requests are processed, e.g. after restarting the
+// cluster. If the counter does not implement ResettableCounter,
+// ErrNotImplemented is returned.
+func (limiter *CounterLimiter) Reset() error {
+	counter, ok := limiter.Counter.(ResettableCounter)
+	if !ok {
+		return ErrNotImplemented
+	}
+
+	return counter.Set(0)
+}
@@ -0,0 +1,151 @@
+package tusd_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+// memoryCounter is a fake shared counter
+type memoryCounter struct {
+	mutex sync.Mutex
+	value int64
+}
+
+func (counter *memoryCounter) Add(delta int64) (int64, error) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	counter.value += delta
+	return counter.value, nil
+}
+
+func (counter *memoryCounter) Set(value int64) error {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	counter.value = value
+	return nil
+}
+
+type slowStore struct {
+	zeroStore
+	started chan struct{}
+}
+
+func (s slowStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:   id,
+		Size: 10,
+	}, nil
+}
+
+func (s slowStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	if s.started != nil {
+		close(s.started)
+	}
+	return io.Copy(ioutil.Discard, src)
+}
+
+func TestPatchLimiter(t *testing.T) {
+	a := assert.New(t)
+
+	// Two instances of a cluster sharing a limit of one request
+	counter := &memoryCounter{}
+	started := make(chan struct{})
+	handlerA, _ := NewHandler(Config{
+		DataStore:    slowStore{started: started},
+		PatchLimiter: NewCounterLimiter(counter, 1),
+	})
+	handlerB, _ := NewHandler(Config{
+		DataStore:    slowStore{},
+		PatchLimiter: NewCounterLimiter(counter, 1),
+	})
+
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		(&httpTest{
+			Name:   "Request on first instance",
+			Method: "PATCH",
+			URL:    "foo",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: reader,
+			Code:    http.StatusNoContent,
+		}).Run(handlerA, t)
+	}()
+
+	<-started
+
+	(&httpTest{
+		Name:   "Limit reached on second instance",
+		Method: "PATCH",
+		URL:    "bar",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusServiceUnavailable,
+	}).Run(handlerB, t)
+
+	writer.Write([]byte("hello"))
+	writer.Close()
+	<-done
+
+	(&httpTest{
+		Name:   "Slot released",
+		Method: "PATCH",
+		URL:    "bar",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handlerB, t)
+
+	a.EqualValues(0, counter.value)
+}
+
+type addOnlyCounter struct {
+	SharedCounter
+}
+
+func TestCounterLimiterReset(t *testing.T) {
+	a := assert.New(t)
+
+	// The slot of an instance which has crashed is never released
+	counter := &memoryCounter{}
+	crashed := NewCounterLimiter(counter, 1)
+	ok, err := crashed.Acquire()
+	a.NoError(err)
+	a.True(ok)
+
+	limiter := NewCounterLimiter(counter, 1)
+	ok, err = limiter.Acquire()
+	a.NoError(err)
+	a.False(ok)
+
+	a.NoError(limiter.Reset())
+	ok, err = limiter.Acquire()
+	a.NoError(err)
+	a.True(ok)
+
+	a.Equal(ErrNotImplemented, NewCounterLimiter(addOnlyCounter{counter}, 1).Reset())
+}
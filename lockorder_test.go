@@ -0,0 +1,120 @@
+package tusd_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+// waitingLockStore provides a lock which blocks until it can be acquired,
+// similar to distributed lockers, and verifies that the info is only read
+// while the lock is held.
+type waitingLockStore struct {
+	t      *assert.Assertions
+	lock   chan struct{}
+	mutex  *sync.Mutex
+	locked *bool
+	offset *int64
+}
+
+func (s waitingLockStore) NewUpload(info FileInfo) (string, error) {
+	return "", nil
+}
+
+func (s waitingLockStore) LockUpload(id string) error {
+	s.lock <- struct{}{}
+
+	s.mutex.Lock()
+	*s.locked = true
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s waitingLockStore) UnlockUpload(id string) error {
+	s.mutex.Lock()
+	*s.locked = false
+	s.mutex.Unlock()
+
+	<-s.lock
+	return nil
+}
+
+func (s waitingLockStore) GetInfo(id string) (FileInfo, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.t.True(*s.locked, "info must only be read after acquiring the lock")
+
+	return FileInfo{
+		ID:     id,
+		Offset: *s.offset,
+		Size:   10,
+	}, nil
+}
+
+func (s waitingLockStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	n, err := io.Copy(ioutil.Discard, src)
+
+	s.mutex.Lock()
+	*s.offset += n
+	s.mutex.Unlock()
+
+	return n, err
+}
+
+func TestPatchReadsOffsetAfterLocking(t *testing.T) {
+	a := assert.New(t)
+
+	locked := false
+	offset := int64(0)
+	handler, _ := NewHandler(Config{
+		DataStore: waitingLockStore{
+			t:      a,
+			lock:   make(chan struct{}, 1),
+			mutex:  &sync.Mutex{},
+			locked: &locked,
+			offset: &offset,
+		},
+	})
+
+	// Both requests are sent using the same offset. Whichever acquires the
+	// lock second must see the offset written by the first one and fail.
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, _ := http.NewRequest("PATCH", "foo", strings.NewReader("hello"))
+			req.Header.Set("Tus-Resumable", "1.0.0")
+			req.Header.Set("Content-Type", "application/offset+octet-stream")
+			req.Header.Set("Upload-Offset", "0")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	results := make(map[int]int)
+	for code := range codes {
+		results[code] += 1
+	}
+
+	a.Equal(map[int]int{
+		http.StatusNoContent: 1,
+		http.StatusConflict:  1,
+	}, results)
+	a.EqualValues(5, offset)
+}
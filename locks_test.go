@@ -0,0 +1,69 @@
+package tusd_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/tus/tusd"
+)
+
+type lockInspectorStore struct {
+	zeroStore
+}
+
+func (s lockInspectorStore) LockUpload(id string) error {
+	return nil
+}
+
+func (s lockInspectorStore) UnlockUpload(id string) error {
+	return nil
+}
+
+func (s lockInspectorStore) ActiveLocks() []LockInfo {
+	return []LockInfo{
+		{
+			ID:     "foo",
+			Holder: "42",
+			Since:  time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+	}
+}
+
+func TestListLocks(t *testing.T) {
+	handler, _ := NewUnroutedHandler(Config{
+		DataStore: lockInspectorStore{},
+	})
+
+	(&httpTest{
+		Name:   "Successful request",
+		Method: "GET",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code:    http.StatusOK,
+		ResBody: `[{"ID":"foo","Holder":"42","Since":"2016-01-02T03:04:05Z"}]`,
+		ResHeader: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}).Run(http.HandlerFunc(handler.ListLocks), t)
+
+	handler, _ = NewUnroutedHandler(Config{
+		DataStore: zeroStore{},
+	})
+
+	(&httpTest{
+		Name:   "LockInspector not implemented",
+		Method: "GET",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNotImplemented,
+	}).Run(http.HandlerFunc(handler.ListLocks), t)
+
+	(&httpTest{
+		Name:   "Missing Tus-Resumable header",
+		Method: "GET",
+		Code:   http.StatusPreconditionFailed,
+	}).Run(http.HandlerFunc(handler.ListLocks), t)
+}
@@ -0,0 +1,81 @@
+package tusd_test
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type brokenInfoStore struct {
+	zeroStore
+}
+
+func (s brokenInfoStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{}, errors.New("database unreachable")
+}
+
+func TestLogger(t *testing.T) {
+	a := assert.New(t)
+
+	var buf bytes.Buffer
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+		BasePath: "/files/",
+		Logger:   log.New(&buf, "", 0),
+	})
+
+	(&httpTest{
+		Name:   "Create upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Finish upload",
+		Method: "PATCH",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	output := buf.String()
+	a.Contains(output, "POST new: upload created with size 5\n")
+	a.Contains(output, "PATCH new: chunk of 5 bytes written, offset is 5\n")
+	a.Contains(output, "PATCH new: upload completed\n")
+
+	buf.Reset()
+	handler, _ = NewHandler(Config{
+		DataStore: brokenInfoStore{},
+		Logger:    log.New(&buf, "", 0),
+	})
+
+	(&httpTest{
+		Name:   "Internal error",
+		Method: "HEAD",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	a.Contains(buf.String(), "HEAD foo: error: database unreachable\n")
+}
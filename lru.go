@@ -0,0 +1,61 @@
+package tusd
+
+import (
+	"container/list"
+)
+
+// lruCache maps keys to values like a map but discards the least recently
+// used entries once it holds more than its capacity. It is not safe for
+// concurrent use.
+type lruCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns the value stored for the key and marks it as recently used.
+func (cache *lruCache) get(key string) (interface{}, bool) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+// set stores the value for the key, discarding the least recently used entry
+// if the capacity is exceeded.
+func (cache *lruCache) set(key string, value interface{}) {
+	if element, ok := cache.entries[key]; ok {
+		element.Value.(*lruEntry).value = value
+		cache.order.MoveToFront(element)
+		return
+	}
+
+	cache.entries[key] = cache.order.PushFront(&lruEntry{key, value})
+	if cache.order.Len() > cache.capacity {
+		cache.remove(cache.order.Back().Value.(*lruEntry).key)
+	}
+}
+
+// remove deletes the entry for the key, if any.
+func (cache *lruCache) remove(key string) {
+	if element, ok := cache.entries[key]; ok {
+		cache.order.Remove(element)
+		delete(cache.entries, key)
+	}
+}
@@ -0,0 +1,86 @@
+package tusd_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type manifestStore struct {
+	zeroStore
+}
+
+func (s manifestStore) GetInfo(id string) (FileInfo, error) {
+	switch id {
+	case "final":
+		return FileInfo{
+			IsFinal:        true,
+			PartialUploads: []string{"a", "b"},
+			Size:           15,
+			Offset:         15,
+		}, nil
+	case "a":
+		return FileInfo{IsPartial: true, Size: 5, Offset: 5}, nil
+	case "b":
+		return FileInfo{IsPartial: true, Size: 10, Offset: 7}, nil
+	}
+
+	return FileInfo{}, ErrNotFound
+}
+
+func TestManifest(t *testing.T) {
+	a := assert.New(t)
+
+	handler, _ := NewHandler(Config{
+		BasePath:  "files",
+		DataStore: manifestStore{},
+	})
+
+	w := (&httpTest{
+		Name:   "Final upload",
+		Method: "GET",
+		URL:    "final/manifest",
+		Code:   http.StatusOK,
+		ResHeader: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}).Run(handler, t)
+
+	var manifest Manifest
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &manifest))
+	a.Equal(Manifest{
+		ID:      "final",
+		Size:    15,
+		Offset:  15,
+		IsFinal: true,
+		Parts: []ManifestPart{
+			{ID: "a", Start: 0, Size: 5, Offset: 5, Complete: true},
+			{ID: "b", Start: 5, Size: 10, Offset: 7, Complete: false},
+		},
+	}, manifest)
+
+	w = (&httpTest{
+		Name:   "Partial upload",
+		Method: "GET",
+		URL:    "b/manifest",
+		Code:   http.StatusOK,
+	}).Run(handler, t)
+
+	manifest = Manifest{}
+	a.NoError(json.Unmarshal(w.Body.Bytes(), &manifest))
+	a.Equal("b", manifest.ID)
+	a.True(manifest.IsPartial)
+	a.EqualValues(7, manifest.Offset)
+	a.Len(manifest.Parts, 0)
+
+	(&httpTest{
+		Name:   "Unknown upload",
+		Method: "GET",
+		URL:    "unknown/manifest",
+		Code:   http.StatusNotFound,
+	}).Run(handler, t)
+}
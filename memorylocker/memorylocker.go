@@ -7,10 +7,17 @@
 //
 // MemoryLocker persists locks using memory and therefore allowing a simple and
 // cheap mechansim. Locks will only exist as long as this object is kept in
-// reference and will be erased if the program exits.
+// reference and will be erased if the program exits. Optionally, locks expire
+// after a TTL, so uploads do not remain locked forever if a request hangs.
+// Expired locks are removed by MemoryLocker.Sweep and the number of locks may
+// be capped using MemoryLocker.MaxLocks, so the locker does not grow without
+// bounds if locks are never released, e.g. because clients crashed.
 package memorylocker
 
 import (
+	"sync"
+	"time"
+
 	"github.com/tus/tusd"
 )
 
@@ -19,35 +26,171 @@ import (
 // reference and will be erased if the program exits.
 type MemoryLocker struct {
 	tusd.DataStore
-	locks map[string]bool
+	// MaxLocks is the maximum number of locks held at the same time. If it is
+	// reached, expired locks are removed before acquiring a new lock. If all
+	// locks are still active, no lock is released and tusd.ErrFileLocked is
+	// returned instead, so the client retries later. If zero, the number of
+	// locks is not limited.
+	MaxLocks int
+
+	// locks maps the IDs of the locked uploads to their locks.
+	locks map[string]*lock
+	// pending counts the releases which are still expected for uploads whose
+	// expired locks have been removed by Sweep, so these late releases do not
+	// release a lock acquired afterwards.
+	pending map[string]int
+	// ttl is the duration after which a lock may be acquired again although
+	// it has not been released. If zero, locks do not expire.
+	ttl time.Duration
+	// mutex guards locks since uploads may be locked and unlocked from
+	// multiple goroutines concurrently.
+	mutex sync.Mutex
+}
+
+// lock describes a held lock.
+type lock struct {
+	// since is the time at which the lock has been acquired or taken over.
+	since time.Time
+	// holders is the number of requests which have acquired the lock and not
+	// released it yet. It only exceeds one if the lock has been taken over
+	// after expiring, since the previous holders still release it later.
+	holders int
 }
 
 // New creates a new lock memory wrapper around the provided storage.
 func NewMemoryLocker(store tusd.DataStore) *MemoryLocker {
 	return &MemoryLocker{
 		DataStore: store,
-		locks:     make(map[string]bool),
+		locks:     make(map[string]*lock),
+		pending:   make(map[string]int),
 	}
 }
 
+// NewMemoryLockerWithTTL creates a new lock memory wrapper around the provided
+// storage whose locks expire. A lock which has been held for longer than the
+// TTL is taken over by the next attempt to acquire it, so the TTL must exceed
+// the longest duration a request may legitimately hold a lock. Since the
+// locker cannot tell the holders apart, a lock which has been taken over is
+// only released once the original holder has released it as well, so a late
+// release does not release the lock of the new holder.
+func NewMemoryLockerWithTTL(store tusd.DataStore, ttl time.Duration) *MemoryLocker {
+	locker := NewMemoryLocker(store)
+	locker.ttl = ttl
+	return locker
+}
+
 // LockUpload tries to obtain the exclusive lock.
 func (locker *MemoryLocker) LockUpload(id string) error {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	// Ensure file is not locked, unless the lock has expired
+	if held, ok := locker.locks[id]; ok {
+		if !locker.expired(held.since) {
+			return tusd.ErrFileLocked
+		}
 
-	// Ensure file is not locked
-	if _, ok := locker.locks[id]; ok {
-		return tusd.ErrFileLocked
+		held.since = time.Now()
+		held.holders++
+		return nil
 	}
 
-	locker.locks[id] = true
+	if locker.MaxLocks > 0 && len(locker.locks) >= locker.MaxLocks {
+		locker.sweep()
+		if len(locker.locks) >= locker.MaxLocks {
+			return tusd.ErrFileLocked
+		}
+	}
+
+	// Holders of a removed lock have not released it yet
+	locker.locks[id] = &lock{
+		since:   time.Now(),
+		holders: 1 + locker.pending[id],
+	}
+	delete(locker.pending, id)
 
 	return nil
 }
 
 // UnlockUpload releases a lock. If no such lock exists, no error will be returned.
+// A lock which has been taken over after expiring is only released once all of
+// its holders have released it.
 func (locker *MemoryLocker) UnlockUpload(id string) error {
-	// Deleting a non-existing key does not end in unexpected errors or panic
-	// since this operation results in a no-op
-	delete(locker.locks, id)
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	held, ok := locker.locks[id]
+	if !ok {
+		// The lock may have expired and been removed by Sweep
+		if locker.pending[id] > 1 {
+			locker.pending[id]--
+		} else {
+			delete(locker.pending, id)
+		}
+		return nil
+	}
+
+	held.holders--
+	if held.holders <= 0 {
+		delete(locker.locks, id)
+	}
 
 	return nil
 }
+
+// LockTTL returns the duration after which locks expire, see
+// NewMemoryLockerWithTTL. Zero means that locks do not expire.
+func (locker *MemoryLocker) LockTTL() time.Duration {
+	return locker.ttl
+}
+
+// ActiveLocks returns the currently held locks including the time at which
+// they have been acquired. Expired locks are omitted.
+func (locker *MemoryLocker) ActiveLocks() []tusd.LockInfo {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	locks := make([]tusd.LockInfo, 0, len(locker.locks))
+	for id, held := range locker.locks {
+		if locker.expired(held.since) {
+			continue
+		}
+
+		locks = append(locks, tusd.LockInfo{
+			ID:    id,
+			Since: held.since,
+		})
+	}
+
+	return locks
+}
+
+// expired returns whether a lock acquired at the given time has expired.
+func (locker *MemoryLocker) expired(since time.Time) bool {
+	return locker.ttl > 0 && time.Since(since) >= locker.ttl
+}
+
+// Sweep removes all expired locks and returns their number. Since expired locks
+// are only replaced when the same upload is locked again, Sweep may be invoked
+// periodically in order to release the memory held by abandoned locks. Only a
+// counter is kept for each removed lock until its holder releases it. If no
+// TTL is configured, no lock expires and nothing happens.
+func (locker *MemoryLocker) Sweep() int {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	return locker.sweep()
+}
+
+func (locker *MemoryLocker) sweep() int {
+	removed := 0
+	for id, held := range locker.locks {
+		if locker.expired(held.since) {
+			locker.pending[id] += held.holders
+			delete(locker.locks, id)
+			removed++
+		}
+	}
+
+	return removed
+}
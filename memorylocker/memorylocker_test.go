@@ -2,7 +2,10 @@ package memorylocker
 
 import (
 	"io"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -37,3 +40,153 @@ func TestMemoryLocker(t *testing.T) {
 	a.NoError(locker.UnlockUpload("one"))
 	a.NoError(locker.UnlockUpload("one"))
 }
+
+func TestActiveLocks(t *testing.T) {
+	a := assert.New(t)
+
+	locker := NewMemoryLocker(&zeroStore{})
+	a.Len(locker.ActiveLocks(), 0)
+
+	before := time.Now()
+	a.NoError(locker.LockUpload("one"))
+
+	locks := locker.ActiveLocks()
+	a.Len(locks, 1)
+	a.Equal("one", locks[0].ID)
+	a.False(locks[0].Since.Before(before))
+
+	a.NoError(locker.UnlockUpload("one"))
+	a.Len(locker.ActiveLocks(), 0)
+}
+
+func TestConcurrentLocks(t *testing.T) {
+	tests := []struct {
+		name string
+		ids  int
+	}{
+		{"same upload", 1},
+		{"different uploads", 50},
+	}
+
+	for _, test := range tests {
+		locker := NewMemoryLocker(&zeroStore{})
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		holders := make(map[string]int)
+
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+
+				for j := 0; j < 100; j++ {
+					if err := locker.LockUpload(id); err != nil {
+						continue
+					}
+
+					// No other goroutine may hold the lock at the same time
+					mutex.Lock()
+					holders[id]++
+					if holders[id] != 1 {
+						t.Errorf("%s: lock for %s is held %d times", test.name, id, holders[id])
+					}
+					holders[id]--
+					mutex.Unlock()
+
+					locker.ActiveLocks()
+					locker.UnlockUpload(id)
+				}
+			}(strconv.Itoa(i % test.ids))
+		}
+
+		wg.Wait()
+
+		if locks := locker.ActiveLocks(); len(locks) != 0 {
+			t.Errorf("%s: expected no locks to be left but got %v", test.name, locks)
+		}
+	}
+}
+
+func TestLockTTL(t *testing.T) {
+	a := assert.New(t)
+
+	locker := NewMemoryLockerWithTTL(&zeroStore{}, time.Minute)
+	a.NoError(locker.LockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+
+	// Pretend the lock has been held for longer than the TTL
+	locker.locks["one"].since = time.Now().Add(-2 * time.Minute)
+	a.Len(locker.ActiveLocks(), 0)
+	a.NoError(locker.LockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+	a.Len(locker.ActiveLocks(), 1)
+
+	// The release by the original holder does not release the lock which has
+	// been taken over
+	a.NoError(locker.UnlockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+	a.NoError(locker.UnlockUpload("one"))
+	a.NoError(locker.LockUpload("one"))
+	a.NoError(locker.UnlockUpload("one"))
+
+	// Neither does it if the expired lock has been removed in the meantime
+	a.NoError(locker.LockUpload("one"))
+	locker.locks["one"].since = time.Now().Add(-2 * time.Minute)
+	a.Equal(1, locker.Sweep())
+	a.NoError(locker.LockUpload("one"))
+	a.NoError(locker.UnlockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+	a.NoError(locker.UnlockUpload("one"))
+	a.Len(locker.locks, 0)
+	a.Len(locker.pending, 0)
+
+	// Locks without a TTL never expire
+	locker = NewMemoryLocker(&zeroStore{})
+	a.NoError(locker.LockUpload("one"))
+	locker.locks["one"].since = time.Now().Add(-24 * time.Hour)
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+}
+
+func TestSweep(t *testing.T) {
+	a := assert.New(t)
+
+	locker := NewMemoryLockerWithTTL(&zeroStore{}, time.Minute)
+	a.NoError(locker.LockUpload("one"))
+	a.NoError(locker.LockUpload("two"))
+	locker.locks["one"].since = time.Now().Add(-2 * time.Minute)
+
+	a.Equal(1, locker.Sweep())
+	a.NotContains(locker.locks, "one")
+	a.Contains(locker.locks, "two")
+	a.Equal(0, locker.Sweep())
+}
+
+func TestMaxLocks(t *testing.T) {
+	a := assert.New(t)
+
+	locker := NewMemoryLockerWithTTL(&zeroStore{}, time.Minute)
+	locker.MaxLocks = 2
+	a.NoError(locker.LockUpload("one"))
+	a.NoError(locker.LockUpload("two"))
+	locker.locks["one"].since = time.Now().Add(-30 * time.Second)
+	locker.locks["two"].since = time.Now().Add(-40 * time.Second)
+
+	// Active locks are never released in order to meet the limit
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("three"))
+	a.Len(locker.locks, 2)
+	a.Contains(locker.locks, "one")
+	a.Contains(locker.locks, "two")
+
+	// Expired locks are removed to make room for new ones
+	locker.locks["two"].since = time.Now().Add(-2 * time.Minute)
+	a.NoError(locker.LockUpload("three"))
+	a.Len(locker.locks, 2)
+	a.Contains(locker.locks, "one")
+	a.Contains(locker.locks, "three")
+
+	// Released locks make room as well
+	a.NoError(locker.UnlockUpload("one"))
+	a.NoError(locker.LockUpload("four"))
+	a.Len(locker.locks, 2)
+}
@@ -0,0 +1,121 @@
+package tusd_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+	"github.com/tus/tusd/memorylocker"
+)
+
+func TestPatchMemoryLocker(t *testing.T) {
+	a := assert.New(t)
+
+	locker := memorylocker.NewMemoryLocker(patchStore{
+		t: a,
+	})
+	handler, _ := NewHandler(Config{
+		DataStore: locker,
+	})
+
+	a.NoError(locker.LockUpload("yes"))
+
+	(&httpTest{
+		Name:   "Locked upload",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    423,
+	}).Run(handler, t)
+
+	a.NoError(locker.UnlockUpload("yes"))
+
+	(&httpTest{
+		Name:   "Unlocked upload",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	// The lock has been released after the request
+	a.Len(locker.ActiveLocks(), 0)
+}
+
+func TestLockedRetryAfter(t *testing.T) {
+	a := assert.New(t)
+
+	lockedPatch := func(config Config, locker *memorylocker.MemoryLocker) string {
+		config.DataStore = locker
+		handler, _ := NewHandler(config)
+
+		a.NoError(locker.LockUpload("yes"))
+		defer locker.UnlockUpload("yes")
+
+		w := (&httpTest{
+			Name:   "Locked upload",
+			Method: "PATCH",
+			URL:    "yes",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "5",
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusLocked,
+		}).Run(handler, t)
+		return w.HeaderMap.Get("Retry-After")
+	}
+
+	inRange := func(header string, min, max int) {
+		seconds, err := strconv.Atoi(header)
+		a.NoError(err)
+		a.True(seconds >= min && seconds <= max, "%d not in [%d, %d]", seconds, min, max)
+	}
+
+	// The delay is derived from the locks' TTL
+	withTTL := memorylocker.NewMemoryLockerWithTTL(patchStore{t: a}, 10*time.Second)
+	for i := 0; i < 10; i++ {
+		inRange(lockedPatch(Config{}, withTTL), 10, 20)
+	}
+
+	// The configured delay takes precedence
+	for i := 0; i < 10; i++ {
+		inRange(lockedPatch(Config{LockedRetryAfter: 2 * time.Second}, withTTL), 2, 4)
+	}
+
+	// Without any known delay, no header is sent
+	a.Empty(lockedPatch(Config{}, memorylocker.NewMemoryLocker(patchStore{t: a})))
+}
+
+func BenchmarkPatchMemoryLocker(b *testing.B) {
+	handler, _ := NewHandler(Config{
+		DataStore: memorylocker.NewMemoryLocker(notifyStore{}),
+	})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("PATCH", "foo", strings.NewReader("hello"))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", "0")
+
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
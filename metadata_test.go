@@ -0,0 +1,109 @@
+package tusd_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+type metaStore struct {
+	zeroStore
+	info *FileInfo
+}
+
+func (s metaStore) NewUpload(info FileInfo) (string, error) {
+	*s.info = info
+	return "foo", nil
+}
+
+func (s metaStore) GetInfo(id string) (FileInfo, error) {
+	return *s.info, nil
+}
+
+func TestDefaultMetaData(t *testing.T) {
+	info := FileInfo{}
+	handler, _ := NewHandler(Config{
+		BasePath:  "files",
+		DataStore: metaStore{info: &info},
+		DefaultMetaData: map[string]string{
+			"source": "web",
+			"bucket": "default",
+		},
+	})
+
+	(&httpTest{
+		Name:   "Create upload with partial metadata",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+			// bucket: custom
+			"Upload-Metadata": "bucket Y3VzdG9t, name aGVsbG8=",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	expected := MetaData{
+		"source": "web",
+		"bucket": "custom",
+		"name":   "hello",
+	}
+	if !reflect.DeepEqual(info.MetaData, expected) {
+		t.Errorf("Expected metadata %v but got %v", expected, info.MetaData)
+	}
+
+	w := (&httpTest{
+		Name:   "Merged metadata in HEAD response",
+		Method: "HEAD",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	meta := MetaData{}
+	for _, element := range strings.Split(w.HeaderMap.Get("Upload-Metadata"), ",") {
+		parts := strings.Split(element, " ")
+		value, _ := base64.StdEncoding.DecodeString(parts[1])
+		meta[parts[0]] = string(value)
+	}
+	if !reflect.DeepEqual(meta, expected) {
+		t.Errorf("Expected metadata %v in HEAD response but got %v", expected, meta)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+		MaxHeaderBytes: 1024,
+	})
+
+	(&httpTest{
+		Name:   "Headers within limit",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "5",
+			"Upload-Metadata": "foo " + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 500))),
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Oversized headers",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "5",
+			"Upload-Metadata": "foo " + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 1000))),
+		},
+		Code: http.StatusRequestHeaderFieldsTooLarge,
+	}).Run(handler, t)
+}
@@ -0,0 +1,114 @@
+package tusd
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics counts the events of one or more handlers, see Config.Metrics. The
+// counters only increase and may be read at any time, e.g. by the
+// prometheuscollector package. It is safe for concurrent use.
+type Metrics struct {
+	// The counters are accessed atomically and therefore placed at the
+	// beginning for 64-bit alignment.
+	uploadsCreated    uint64
+	bytesReceived     uint64
+	uploadsFinished   uint64
+	uploadsTerminated uint64
+
+	errorsTotal map[int]uint64
+	errorsMutex sync.Mutex
+}
+
+// NewMetrics creates a new set of counters starting at zero.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		errorsTotal: make(map[int]uint64),
+	}
+}
+
+// UploadsCreated returns the number of uploads which have been created.
+func (m *Metrics) UploadsCreated() uint64 {
+	return atomic.LoadUint64(&m.uploadsCreated)
+}
+
+// BytesReceived returns the number of bytes which have been written to uploads.
+// It is updated while the data is received, so it includes the bytes of
+// requests which are still running.
+func (m *Metrics) BytesReceived() uint64 {
+	return atomic.LoadUint64(&m.bytesReceived)
+}
+
+// UploadsFinished returns the number of uploads which have been finished.
+func (m *Metrics) UploadsFinished() uint64 {
+	return atomic.LoadUint64(&m.uploadsFinished)
+}
+
+// UploadsTerminated returns the number of uploads which have been terminated.
+func (m *Metrics) UploadsTerminated() uint64 {
+	return atomic.LoadUint64(&m.uploadsTerminated)
+}
+
+// ErrorsTotal returns a copy of the number of error responses which have been
+// sent, grouped by their status code.
+func (m *Metrics) ErrorsTotal() map[int]uint64 {
+	m.errorsMutex.Lock()
+	defer m.errorsMutex.Unlock()
+
+	counts := make(map[int]uint64, len(m.errorsTotal))
+	for status, count := range m.errorsTotal {
+		counts[status] = count
+	}
+	return counts
+}
+
+// The following methods are invoked by the handler. They do nothing if no
+// metrics are configured, i.e. if the receiver is nil.
+
+func (m *Metrics) incUploadsCreated() {
+	if m != nil {
+		atomic.AddUint64(&m.uploadsCreated, 1)
+	}
+}
+
+func (m *Metrics) incBytesReceived(delta uint64) {
+	if m != nil {
+		atomic.AddUint64(&m.bytesReceived, delta)
+	}
+}
+
+func (m *Metrics) incUploadsFinished() {
+	if m != nil {
+		atomic.AddUint64(&m.uploadsFinished, 1)
+	}
+}
+
+func (m *Metrics) incUploadsTerminated() {
+	if m != nil {
+		atomic.AddUint64(&m.uploadsTerminated, 1)
+	}
+}
+
+func (m *Metrics) incErrorsTotal(status int) {
+	if m != nil {
+		m.errorsMutex.Lock()
+		m.errorsTotal[status]++
+		m.errorsMutex.Unlock()
+	}
+}
+
+// bytesCounter adds the number of bytes read from the underlying reader to
+// the metrics as soon as they have been read.
+type bytesCounter struct {
+	reader  io.Reader
+	metrics *Metrics
+}
+
+func (c bytesCounter) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if n > 0 {
+		c.metrics.incBytesReceived(uint64(n))
+	}
+	return n, err
+}
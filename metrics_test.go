@@ -0,0 +1,90 @@
+package tusd_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+func TestMetrics(t *testing.T) {
+	a := assert.New(t)
+
+	metrics := NewMetrics()
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+		BasePath: "/files/",
+		Metrics:  metrics,
+	})
+
+	(&httpTest{
+		Name:   "Create upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "10",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+	a.EqualValues(1, metrics.UploadsCreated())
+
+	// The received bytes are counted while the request is running
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		(&httpTest{
+			Name:   "Upload in chunks",
+			Method: "PATCH",
+			URL:    "new",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: reader,
+			Code:    http.StatusNoContent,
+		}).Run(handler, t)
+	}()
+
+	writer.Write([]byte("hello"))
+	deadline := time.Now().Add(time.Second)
+	for metrics.BytesReceived() != 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	a.EqualValues(5, metrics.BytesReceived())
+	a.EqualValues(0, metrics.UploadsFinished())
+
+	writer.Write([]byte("world"))
+	writer.Close()
+	<-done
+	a.EqualValues(10, metrics.BytesReceived())
+	a.EqualValues(1, metrics.UploadsFinished())
+
+	(&httpTest{
+		Name:   "Terminate upload",
+		Method: "DELETE",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+	a.EqualValues(1, metrics.UploadsTerminated())
+
+	(&httpTest{
+		Name:   "Invalid version",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "0.0.1",
+		},
+		Code: http.StatusPreconditionFailed,
+	}).Run(handler, t)
+	a.Equal(map[int]uint64{http.StatusPreconditionFailed: 1}, metrics.ErrorsTotal())
+}
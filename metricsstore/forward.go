@@ -0,0 +1,41 @@
+package metricsstore
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/tus/tusd"
+)
+
+// The following types replace the optional interfaces of the underlying data
+// store in the composer returned by MetricsStore.Composer if their methods are
+// measured. All other optional interfaces are forwarded without wrapping them.
+
+type terminater struct {
+	*MetricsStore
+	terminater tusd.TerminaterDataStore
+}
+
+func (store terminater) Terminate(id string) error {
+	defer store.observe("Terminate", time.Now())
+	return store.terminater.Terminate(id)
+}
+
+type contexter struct {
+	*MetricsStore
+	contexter tusd.ContextDataStore
+}
+
+func (store contexter) NewUploadWithContext(ctx context.Context, info tusd.FileInfo) (string, error) {
+	defer store.observe("NewUpload", time.Now())
+	return store.contexter.NewUploadWithContext(ctx, info)
+}
+
+func (store contexter) WriteChunkWithContext(ctx context.Context, id string, offset int64, src io.Reader) (int64, error) {
+	reader := &timedReader{reader: src}
+	start := time.Now()
+	n, err := store.contexter.WriteChunkWithContext(ctx, id, offset, reader)
+	store.record("WriteChunk", time.Since(start)-reader.waited)
+	return n, err
+}
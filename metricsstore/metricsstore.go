@@ -0,0 +1,254 @@
+// Package metricsstore provides a wrapper around existing data stores which
+// measures the latency of their methods.
+//
+// MetricsStore records the duration of every call to NewUpload, WriteChunk,
+// GetInfo, FinishUpload and Terminate in a histogram per method. Since it only
+// decorates the tusd.DataStore interface, it works with any backend and allows
+// to pinpoint which storage operation is the bottleneck under load. The
+// histograms can be inspected using MetricsStore.Latency, while the Observe
+// hook may be used for forwarding the measurements to an external metrics
+// system.
+//
+// The latency of WriteChunk only covers the time spent by the data store. The
+// time spent waiting for the client to send the data is excluded, so slow
+// clients do not distort the measurements.
+//
+// While MetricsStore implements the FinishUpload, LockUpload and UnlockUpload
+// methods, it does not contain proper definitions for them. When invoked, the
+// call will be passed to the underlying data store as long as it provides
+// these methods. If not, nothing happens. Since the handler enables features
+// depending on the interfaces implemented by the data store, the composer
+// returned by MetricsStore.Composer should be passed to it using
+// tusd.Config.StoreComposer. It contains every optional interface of the
+// underlying data store. Calls to methods which are not listed in Methods,
+// e.g. GetReader, are forwarded without being measured.
+package metricsstore
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tus/tusd"
+)
+
+// Methods lists the names of the data store methods whose latency is measured.
+var Methods = []string{"NewUpload", "WriteChunk", "GetInfo", "FinishUpload", "Terminate"}
+
+// DefaultBuckets are the upper bounds of the histograms' buckets used if none
+// are provided to New.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram counts the observed durations in buckets. It is safe for
+// concurrent use.
+type Histogram struct {
+	buckets []time.Duration
+	counts  []uint64
+	count   uint64
+	sum     time.Duration
+	mutex   sync.Mutex
+}
+
+// HistogramSnapshot is a copy of a histogram's state at a given time.
+type HistogramSnapshot struct {
+	// Buckets contains the upper bounds of the buckets in ascending order.
+	Buckets []time.Duration
+	// Counts contains the number of observations less than or equal to the
+	// bucket's bound for each bucket, i.e. the counts are cumulative.
+	Counts []uint64
+	// Count is the total number of observations, including those exceeding
+	// the biggest bucket.
+	Count uint64
+	// Sum is the total of all observed durations.
+	Sum time.Duration
+}
+
+func newHistogram(buckets []time.Duration) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe records a single duration.
+func (h *Histogram) Observe(d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	i := sort.Search(len(h.buckets), func(i int) bool {
+		return d <= h.buckets[i]
+	})
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += d
+}
+
+// Snapshot returns a copy of the histogram's current state.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	snapshot := HistogramSnapshot{
+		Buckets: append([]time.Duration(nil), h.buckets...),
+		Counts:  make([]uint64, len(h.counts)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+
+	var total uint64
+	for i, count := range h.counts {
+		total += count
+		snapshot.Counts[i] = total
+	}
+
+	return snapshot
+}
+
+// See the tusd.DataStore interface for documentation about the different
+// methods.
+type MetricsStore struct {
+	tusd.DataStore
+
+	// Observe is optionally invoked after every measured call with the name of
+	// the method and its duration, e.g. for forwarding the measurements to an
+	// external metrics system. It must be safe for concurrent use.
+	Observe func(method string, duration time.Duration)
+
+	latencies map[string]*Histogram
+}
+
+// New creates a new metrics store wrapping the provided data store. The
+// histograms use the given upper bounds for their buckets, which must be
+// sorted in ascending order. If none are provided, DefaultBuckets is used.
+func New(dataStore tusd.DataStore, buckets []time.Duration) *MetricsStore {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	latencies := make(map[string]*Histogram, len(Methods))
+	for _, method := range Methods {
+		latencies[method] = newHistogram(buckets)
+	}
+
+	return &MetricsStore{
+		DataStore: dataStore,
+		latencies: latencies,
+	}
+}
+
+// Composer returns the composer which should be used for
+// tusd.Config.StoreComposer. It contains the optional interfaces of the
+// underlying data store, whose measured methods are wrapped.
+func (store *MetricsStore) Composer() *tusd.StoreComposer {
+	composer := tusd.NewStoreComposer(store.DataStore)
+	composer.Core = store
+
+	if composer.Terminater != nil {
+		composer.Terminater = terminater{store, composer.Terminater}
+	}
+	if composer.Context != nil {
+		composer.Context = contexter{store, composer.Context}
+	}
+	if composer.Finisher != nil {
+		composer.Finisher = store
+	}
+
+	return composer
+}
+
+// Latency returns the histogram for the specified method, see Methods. If the
+// method is not measured, nil is returned.
+func (store *MetricsStore) Latency(method string) *Histogram {
+	return store.latencies[method]
+}
+
+// observe records the time which has passed since start for the method.
+func (store *MetricsStore) observe(method string, start time.Time) {
+	store.record(method, time.Since(start))
+}
+
+// record records the duration for the method.
+func (store *MetricsStore) record(method string, duration time.Duration) {
+	store.latencies[method].Observe(duration)
+
+	if store.Observe != nil {
+		store.Observe(method, duration)
+	}
+}
+
+func (store *MetricsStore) NewUpload(info tusd.FileInfo) (string, error) {
+	defer store.observe("NewUpload", time.Now())
+	return store.DataStore.NewUpload(info)
+}
+
+func (store *MetricsStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	reader := &timedReader{reader: src}
+	start := time.Now()
+	n, err := store.DataStore.WriteChunk(id, offset, reader)
+	store.record("WriteChunk", time.Since(start)-reader.waited)
+	return n, err
+}
+
+func (store *MetricsStore) GetInfo(id string) (tusd.FileInfo, error) {
+	defer store.observe("GetInfo", time.Now())
+	return store.DataStore.GetInfo(id)
+}
+
+// FinishUpload will pass the call to the underlying data store if it implements
+// the tusd.FinisherDataStore interface. Else this function simply returns nil
+// without recording a measurement.
+func (store *MetricsStore) FinishUpload(id string) error {
+	if s, ok := store.DataStore.(tusd.FinisherDataStore); ok {
+		defer store.observe("FinishUpload", time.Now())
+		return s.FinishUpload(id)
+	}
+
+	return nil
+}
+
+// LockUpload will pass the call to the underlying data store if it implements
+// the tusd.LockerDataStore interface. Else this function simply returns nil.
+func (store *MetricsStore) LockUpload(id string) error {
+	if s, ok := store.DataStore.(tusd.LockerDataStore); ok {
+		return s.LockUpload(id)
+	}
+
+	return nil
+}
+
+// UnlockUpload will pass the call to the underlying data store if it implements
+// the tusd.LockerDataStore interface. Else this function simply returns nil.
+func (store *MetricsStore) UnlockUpload(id string) error {
+	if s, ok := store.DataStore.(tusd.LockerDataStore); ok {
+		return s.UnlockUpload(id)
+	}
+
+	return nil
+}
+
+// timedReader measures the time spent waiting for the underlying reader, i.e.
+// for the client to send the data, so it can be excluded from the latency.
+type timedReader struct {
+	reader io.Reader
+	waited time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.reader.Read(p)
+	r.waited += time.Since(start)
+	return n, err
+}
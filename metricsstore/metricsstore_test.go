@@ -0,0 +1,198 @@
+package metricsstore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+var _ tusd.DataStore = &MetricsStore{}
+var _ tusd.FinisherDataStore = &MetricsStore{}
+var _ tusd.LockerDataStore = &MetricsStore{}
+
+type slowStore struct {
+	delay time.Duration
+}
+
+func (store slowStore) NewUpload(info tusd.FileInfo) (string, error) {
+	time.Sleep(store.delay)
+	return "foo", nil
+}
+
+func (store slowStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	time.Sleep(store.delay)
+	return 0, nil
+}
+
+func (store slowStore) GetInfo(id string) (tusd.FileInfo, error) {
+	time.Sleep(store.delay)
+	return tusd.FileInfo{}, nil
+}
+
+func (store slowStore) FinishUpload(id string) error {
+	time.Sleep(store.delay)
+	return nil
+}
+
+func (store slowStore) Terminate(id string) error {
+	time.Sleep(store.delay)
+	return nil
+}
+
+func TestMetricsStore(t *testing.T) {
+	a := assert.New(t)
+
+	var mutex sync.Mutex
+	observed := make(map[string]int)
+
+	store := New(slowStore{delay: 5 * time.Millisecond}, []time.Duration{time.Millisecond, time.Second})
+	store.Observe = func(method string, duration time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		observed[method]++
+	}
+
+	_, err := store.NewUpload(tusd.FileInfo{Size: 5})
+	a.NoError(err)
+	_, err = store.WriteChunk("foo", 0, strings.NewReader("hello"))
+	a.NoError(err)
+	_, err = store.GetInfo("foo")
+	a.NoError(err)
+	a.NoError(store.FinishUpload("foo"))
+	a.NoError(store.Composer().Terminater.Terminate("foo"))
+
+	for _, method := range Methods {
+		snapshot := store.Latency(method).Snapshot()
+		a.Equal(uint64(1), snapshot.Count, method)
+		a.True(snapshot.Sum >= 5*time.Millisecond, method)
+		a.Equal([]uint64{0, 1}, snapshot.Counts, method)
+		a.Equal(1, observed[method], method)
+	}
+
+	a.Nil(store.Latency("GetReader"))
+}
+
+func TestMetricsStoreUnsupported(t *testing.T) {
+	a := assert.New(t)
+
+	type minimalStore struct {
+		tusd.DataStore
+	}
+	store := New(minimalStore{}, nil)
+
+	a.NoError(store.FinishUpload("foo"))
+
+	// Optional interfaces which are not implemented by the underlying store
+	// are hidden from the handler
+	composer := store.Composer()
+	a.Equal(store, composer.Core)
+	a.Nil(composer.Terminater)
+	a.Nil(composer.GetReader)
+
+	a.Equal(uint64(0), store.Latency("FinishUpload").Snapshot().Count)
+	a.Equal(uint64(0), store.Latency("Terminate").Snapshot().Count)
+	a.Equal(DefaultBuckets, store.Latency("Terminate").Snapshot().Buckets)
+}
+
+type forwardedStore struct {
+	slowStore
+}
+
+func (store forwardedStore) DeclareLength(id string, length int64) error {
+	return nil
+}
+
+func (store forwardedStore) GetReader(id string) (io.Reader, error) {
+	return strings.NewReader("hello"), nil
+}
+
+func (store forwardedStore) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	return strings.NewReader("hello"), 5, nil
+}
+
+func (store forwardedStore) GetWrittenOffset(id string) (int64, error) {
+	return 5, nil
+}
+
+func (store forwardedStore) NewUploadWithContext(ctx context.Context, info tusd.FileInfo) (string, error) {
+	return "foo", nil
+}
+
+func (store forwardedStore) WriteChunkWithContext(ctx context.Context, id string, offset int64, src io.Reader) (int64, error) {
+	return 0, nil
+}
+
+func TestMetricsStoreForwarding(t *testing.T) {
+	a := assert.New(t)
+
+	store := New(forwardedStore{}, nil)
+	composer := store.Composer()
+
+	a.NotNil(composer.Terminater)
+	a.Nil(composer.Concater)
+	a.NoError(composer.LengthDeferrer.DeclareLength("foo", 5))
+
+	src, err := composer.GetReader.GetReader("foo")
+	a.NoError(err)
+	a.NotNil(src)
+
+	_, size, err := composer.ReaderAt.GetReaderAt("foo")
+	a.NoError(err)
+	a.Equal(int64(5), size)
+
+	offset, err := composer.Buffered.GetWrittenOffset("foo")
+	a.NoError(err)
+	a.Equal(int64(5), offset)
+
+	// Calls passing a context are measured, too
+	_, err = composer.Context.NewUploadWithContext(context.Background(), tusd.FileInfo{})
+	a.NoError(err)
+	_, err = composer.Context.WriteChunkWithContext(context.Background(), "foo", 0, strings.NewReader("hello"))
+	a.NoError(err)
+	a.Equal(uint64(1), store.Latency("NewUpload").Snapshot().Count)
+	a.Equal(uint64(1), store.Latency("WriteChunk").Snapshot().Count)
+}
+
+type slowReader struct {
+	delay time.Duration
+	data  io.Reader
+}
+
+func (r slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.data.Read(p)
+}
+
+type readingStore struct {
+	slowStore
+}
+
+func (store readingStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return io.Copy(ioutil.Discard, src)
+}
+
+func TestMetricsStoreClientTime(t *testing.T) {
+	a := assert.New(t)
+
+	store := New(readingStore{}, []time.Duration{10 * time.Millisecond})
+
+	// Waiting for the client is not attributed to the store
+	n, err := store.WriteChunk("foo", 0, slowReader{
+		delay: 20 * time.Millisecond,
+		data:  strings.NewReader("hello"),
+	})
+	a.NoError(err)
+	a.EqualValues(5, n)
+
+	snapshot := store.Latency("WriteChunk").Snapshot()
+	a.Equal(uint64(1), snapshot.Count)
+	a.Equal([]uint64{1}, snapshot.Counts)
+}
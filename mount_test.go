@@ -0,0 +1,193 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+func TestMountBasePath(t *testing.T) {
+	for _, basePath := range []string{"/api/upload/", "http://tus.io/api/upload/"} {
+		store := &expirationStore{
+			uploads: make(map[string]FileInfo),
+		}
+		handler, _ := NewHandler(Config{
+			DataStore: store,
+			BasePath:  basePath,
+		})
+
+		for _, url := range []string{"/api/upload/", "/api/upload"} {
+			(&httpTest{
+				Name:   "Create upload on nested path",
+				Method: "POST",
+				URL:    url,
+				ReqHeader: map[string]string{
+					"Tus-Resumable": "1.0.0",
+					"Upload-Length": "10",
+				},
+				Code: http.StatusCreated,
+				ResHeader: map[string]string{
+					"Location": "http://tus.io/api/upload/new",
+				},
+			}).Run(handler, t)
+		}
+
+		(&httpTest{
+			Name:   "Write to nested path",
+			Method: "PATCH",
+			URL:    "/api/upload/new",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Upload-Offset": "5",
+			},
+		}).Run(handler, t)
+
+		(&httpTest{
+			Name:   "Inspect nested path",
+			Method: "HEAD",
+			URL:    "/api/upload/new",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+			},
+			Code: http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Upload-Offset": "5",
+				"Upload-Length": "10",
+			},
+		}).Run(handler, t)
+
+		// Paths which have been stripped already are still accepted
+		(&httpTest{
+			Name:   "Inspect stripped path",
+			Method: "HEAD",
+			URL:    "new",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+			},
+			Code: http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Upload-Offset": "5",
+			},
+		}).Run(handler, t)
+	}
+}
+
+func TestTrailingSlash(t *testing.T) {
+	store := &expirationStore{
+		uploads: map[string]FileInfo{
+			"new": {ID: "new", Size: 10},
+		},
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+	})
+
+	for _, url := range []string{"/files/new/", "new/"} {
+		(&httpTest{
+			Name:   "Write with trailing slash",
+			Method: "PATCH",
+			URL:    url,
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": strconv.FormatInt(store.uploads["new"].Offset, 10),
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+		}).Run(handler, t)
+	}
+
+	for _, url := range []string{"/files/new", "/files/new/"} {
+		(&httpTest{
+			Name:   "Inspect with and without trailing slash",
+			Method: "HEAD",
+			URL:    url,
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+			},
+			Code: http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Upload-Offset": "10",
+			},
+		}).Run(handler, t)
+	}
+}
+
+func TestMountBehindProxy(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		basePath string
+		header   map[string]string
+		location string
+	}{
+		{
+			name:     "Bare mount",
+			basePath: "/",
+			location: "http://tus.io/new",
+		},
+		{
+			// The proxy serves the handler at /files/ but removes the prefix
+			// before forwarding the requests
+			name:     "Prefixed mount behind proxy",
+			basePath: "/files/",
+			header: map[string]string{
+				"X-Forwarded-Host":  "example.com",
+				"X-Forwarded-Proto": "https",
+			},
+			location: "https://example.com/files/new",
+		},
+	} {
+		handler, _ := NewHandler(Config{
+			DataStore: &expirationStore{
+				uploads: make(map[string]FileInfo),
+			},
+			BasePath:                test.basePath,
+			RespectForwardedHeaders: true,
+		})
+
+		header := map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "5",
+		}
+		for key, value := range test.header {
+			header[key] = value
+		}
+
+		(&httpTest{
+			Name:      test.name + ": create upload",
+			Method:    "POST",
+			URL:       "/",
+			ReqHeader: header,
+			Code:      http.StatusCreated,
+			ResHeader: map[string]string{
+				"Location": test.location,
+			},
+		}).Run(handler, t)
+
+		(&httpTest{
+			Name:   test.name + ": resume upload",
+			Method: "PATCH",
+			URL:    "/new",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+			ResHeader: map[string]string{
+				"Upload-Offset": "5",
+			},
+		}).Run(handler, t)
+	}
+}
@@ -0,0 +1,226 @@
+package tusd_test
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type notifyStore struct {
+	zeroStore
+}
+
+func (s notifyStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:     id,
+		Offset: 0,
+		Size:   5,
+	}, nil
+}
+
+func (s notifyStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return 5, nil
+}
+
+func TestCompleteUploadsCallback(t *testing.T) {
+	a := assert.New(t)
+
+	var mutex sync.Mutex
+	var running, maxRunning int
+	var wg sync.WaitGroup
+	ids := make(map[string]bool)
+
+	handler, _ := NewHandler(Config{
+		DataStore:              notifyStore{},
+		CompleteUploadsWorkers: 2,
+		CompleteUploadsCallback: func(info FileInfo) {
+			mutex.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			ids[info.ID] = true
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+			wg.Done()
+		},
+	})
+
+	numUploads := 10
+	wg.Add(numUploads)
+
+	var requests sync.WaitGroup
+	requests.Add(numUploads)
+	for i := 0; i < numUploads; i++ {
+		go func(id string) {
+			defer requests.Done()
+
+			(&httpTest{
+				Name:   "Finishing upload",
+				Method: "PATCH",
+				URL:    id,
+				ReqHeader: map[string]string{
+					"Tus-Resumable": "1.0.0",
+					"Content-Type":  "application/offset+octet-stream",
+					"Upload-Offset": "0",
+				},
+				ReqBody: strings.NewReader("hello"),
+				Code:    http.StatusNoContent,
+			}).Run(handler, t)
+		}(string('a' + rune(i)))
+	}
+
+	requests.Wait()
+	wg.Wait()
+
+	a.Len(ids, numUploads)
+	a.True(maxRunning <= 2, "at most two callbacks may run concurrently")
+}
+
+func TestCompleteUploadsClose(t *testing.T) {
+	a := assert.New(t)
+
+	var mutex sync.Mutex
+	var ids []string
+	handler, _ := NewHandler(Config{
+		DataStore: notifyStore{},
+		Logger:    log.New(ioutil.Discard, "", 0),
+		CompleteUploadsCallback: func(info FileInfo) {
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			ids = append(ids, info.ID)
+		},
+	})
+
+	finish := func(id string) {
+		(&httpTest{
+			Name:   "Finishing upload",
+			Method: "PATCH",
+			URL:    id,
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: strings.NewReader("hello"),
+			Code:    http.StatusNoContent,
+		}).Run(handler, t)
+	}
+
+	// Close waits for the callbacks of uploads which have already finished
+	finish("a")
+	handler.Unrouted.Close()
+	a.Equal([]string{"a"}, ids)
+
+	// Afterwards, the callback is not invoked anymore
+	finish("b")
+	handler.Unrouted.Close()
+	a.Equal([]string{"a"}, ids)
+}
+
+func TestCompleteUploadsComputedInfo(t *testing.T) {
+	a := assert.New(t)
+
+	offset := int64(0)
+	completed := make(chan FileInfo, 1)
+	handler, _ := NewHandler(Config{
+		DataStore: treeHashStore{
+			offset: &offset,
+			size:   5,
+		},
+		BasePath:        "/files/",
+		ComputeTreeHash: true,
+		CompleteUploadsCallback: func(info FileInfo) {
+			completed <- info
+		},
+	})
+
+	before := time.Now()
+	(&httpTest{
+		Name:   "Finishing upload",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	select {
+	case info := <-completed:
+		sum, ok := handler.Unrouted.TreeHash("foo")
+		a.True(ok)
+		a.Equal(sum, info.Hash)
+		a.Equal("http://tus.io/files/foo", info.URL)
+		if a.NotNil(info.CompletedAt) {
+			a.False(info.CompletedAt.Before(before))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected callback to be invoked")
+	}
+}
+
+func TestCreatedUploads(t *testing.T) {
+	a := assert.New(t)
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+		BasePath:             "/files/",
+		NotifyCreatedUploads: true,
+	})
+
+	(&httpTest{
+		Name:   "Create upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "5",
+			"Upload-Metadata": "foo aGVsbG8=",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	select {
+	case info := <-handler.CreatedUploads:
+		a.Equal("new", info.ID)
+		a.Equal("http://tus.io/files/new", info.URL)
+		a.Equal(int64(5), info.Size)
+		a.Equal("hello", info.MetaData["foo"])
+	default:
+		t.Fatal("Expected created upload to be sent")
+	}
+
+	// Creating uploads does not block if nobody receives from the channel
+	for i := 0; i < cap(handler.CreatedUploads)+1; i++ {
+		(&httpTest{
+			Name:   "Create upload without consumer",
+			Method: "POST",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Upload-Length": "5",
+			},
+			Code: http.StatusCreated,
+		}).Run(handler, t)
+	}
+	a.Len(handler.CreatedUploads, cap(handler.CreatedUploads))
+}
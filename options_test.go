@@ -17,7 +17,7 @@ func TestOptions(t *testing.T) {
 		Method: "OPTIONS",
 		Code:   http.StatusNoContent,
 		ResHeader: map[string]string{
-			"Tus-Extension": "creation",
+			"Tus-Extension": "creation,creation-with-upload,checksum",
 			"Tus-Version":   "1.0.0",
 			"Tus-Resumable": "1.0.0",
 			"Tus-Max-Size":  "400",
@@ -33,3 +33,40 @@ func TestOptions(t *testing.T) {
 		Code: http.StatusPreconditionFailed,
 	}).Run(handler, t)
 }
+
+type describeStore struct {
+	zeroStore
+}
+
+func (s describeStore) Describe() string {
+	return "teststore/1"
+}
+
+func TestOptionsDescribe(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: describeStore{},
+	})
+
+	(&httpTest{
+		Name:   "Describing store",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"X-Tusd-Store": "teststore/1",
+		},
+	}).Run(handler, t)
+
+	handler, _ = NewHandler(Config{
+		DataStore: zeroStore{},
+	})
+
+	w := (&httpTest{
+		Name:   "Non-describing store",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+	}).Run(handler, t)
+
+	if header := w.HeaderMap.Get("X-Tusd-Store"); header != "" {
+		t.Errorf("Expected no X-Tusd-Store header but got '%s'", header)
+	}
+}
@@ -0,0 +1,186 @@
+package tusd_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type panicStore struct {
+	zeroStore
+	locked *bool
+}
+
+func (s panicStore) NewUpload(info FileInfo) (string, error) {
+	panic("unable to create upload")
+}
+
+func (s panicStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:   id,
+		Size: 10,
+	}, nil
+}
+
+func (s panicStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	panic("unable to write chunk")
+}
+
+func (s panicStore) LockUpload(id string) error {
+	*s.locked = true
+	return nil
+}
+
+func (s panicStore) UnlockUpload(id string) error {
+	*s.locked = false
+	return nil
+}
+
+func TestRecoverPanics(t *testing.T) {
+	a := assert.New(t)
+
+	locked := false
+	logs := new(bytes.Buffer)
+	handler, _ := NewHandler(Config{
+		DataStore: panicStore{
+			locked: &locked,
+		},
+		RecoverPanics: true,
+		Logger:        log.New(logs, "", 0),
+	})
+
+	(&httpTest{
+		Name:   "Panic during PATCH request",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	a.False(locked, "lock must be released")
+	a.Contains(logs.String(), "upload foo: unable to write chunk")
+
+	(&httpTest{
+		Name:   "Panic during POST request",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "10",
+		},
+		Code: http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	a.Contains(logs.String(), "creating upload: unable to create upload")
+}
+
+type partialPanicStore struct {
+	zeroStore
+	data      *[]byte
+	truncated *int64
+}
+
+func (s partialPanicStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:     id,
+		Offset: int64(len(*s.data)),
+		Size:   10,
+	}, nil
+}
+
+func (s partialPanicStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	buf := make([]byte, 3)
+	n, _ := io.ReadFull(src, buf)
+	*s.data = append(*s.data, buf[:n]...)
+	panic("disk disconnected")
+}
+
+func (s partialPanicStore) Truncate(id string, offset int64) error {
+	*s.truncated = offset
+	*s.data = (*s.data)[:offset]
+	return nil
+}
+
+type failingPanicStore struct {
+	panicStore
+	reason *string
+}
+
+func (s failingPanicStore) FailUpload(id string, reason string) error {
+	*s.reason = reason
+	return nil
+}
+
+func TestRecoverPanicsRollback(t *testing.T) {
+	a := assert.New(t)
+
+	data := []byte("hello")
+	truncated := int64(-1)
+	handler, _ := NewHandler(Config{
+		DataStore: partialPanicStore{
+			data:      &data,
+			truncated: &truncated,
+		},
+		RecoverPanics: true,
+		Logger:        log.New(ioutil.Discard, "", 0),
+	})
+
+	(&httpTest{
+		Name:   "Panic after writing part of the chunk",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader("world"),
+		Code:    http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	// The partially written chunk has been discarded
+	a.EqualValues(5, truncated)
+	a.Equal("hello", string(data))
+
+	// Stores which cannot truncate the upload mark it as failed instead
+	locked := false
+	reason := ""
+	handler, _ = NewHandler(Config{
+		DataStore: failingPanicStore{
+			panicStore: panicStore{
+				locked: &locked,
+			},
+			reason: &reason,
+		},
+		RecoverPanics: true,
+		Logger:        log.New(ioutil.Discard, "", 0),
+	})
+
+	(&httpTest{
+		Name:   "Panic in store without truncation",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	a.NotEmpty(reason)
+	a.False(locked)
+}
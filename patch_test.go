@@ -1,6 +1,8 @@
 package tusd_test
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -105,6 +107,241 @@ func TestPatch(t *testing.T) {
 	}).Run(handler, t)
 }
 
+type completePatchStore struct {
+	zeroStore
+}
+
+func (s completePatchStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		Offset: 20,
+		Size:   20,
+	}, nil
+}
+
+func (s completePatchStore) FinishUpload(id string) error {
+	panic("upload must not be finished again")
+}
+
+func TestPatchEmpty(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: patchStore{
+			t: assert.New(t),
+		},
+	})
+
+	// WriteChunk fails the test if it is called without data
+	(&httpTest{
+		Name:   "Empty request",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader(""),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Empty request with mismatching offset",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "4",
+		},
+		ReqBody: strings.NewReader(""),
+		Code:    http.StatusConflict,
+	}).Run(handler, t)
+
+	handler, _ = NewHandler(Config{
+		DataStore: completePatchStore{},
+	})
+
+	(&httpTest{
+		Name:   "Empty request to completed upload",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "20",
+		},
+		ReqBody: strings.NewReader(""),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "20",
+		},
+	}).Run(handler, t)
+}
+
+type failingPatchStore struct {
+	zeroStore
+	data *[]byte
+	fail *bool
+}
+
+func (s failingPatchStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		Offset: int64(len(*s.data)),
+		Size:   10,
+	}, nil
+}
+
+func (s failingPatchStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	if offset != int64(len(*s.data)) {
+		panic("chunk must be written at the stored offset")
+	}
+
+	buf, err := ioutil.ReadAll(src)
+	if err != nil {
+		return 0, err
+	}
+
+	// Only store the first three bytes before failing
+	if *s.fail {
+		*s.fail = false
+		buf = buf[:3]
+		*s.data = append(*s.data, buf...)
+		return 3, errors.New("disk unavailable")
+	}
+
+	*s.data = append(*s.data, buf...)
+	return int64(len(buf)), nil
+}
+
+func TestPatchFailedRetry(t *testing.T) {
+	a := assert.New(t)
+
+	data := []byte{}
+	fail := true
+	handler, _ := NewHandler(Config{
+		DataStore: failingPatchStore{
+			data: &data,
+			fail: &fail,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Failing request",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusInternalServerError,
+		ResHeader: map[string]string{
+			"Upload-Offset": "3",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Offset after failure",
+		Method: "HEAD",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "3",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Retried request",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "3",
+		},
+		ReqBody: strings.NewReader("loworld"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	a.Equal("helloworld", string(data))
+}
+
+func TestPatchOffsetSkew(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: patchStore{
+			t: assert.New(t),
+		},
+		OffsetSkewTolerance: 3,
+	})
+
+	(&httpTest{
+		Name:   "Client behind within tolerance",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "3",
+		},
+		ReqBody: strings.NewReader("XXhello"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Client behind at tolerance",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "2",
+		},
+		ReqBody: strings.NewReader("XXXhello"),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Client behind beyond tolerance",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "1",
+		},
+		ReqBody: strings.NewReader("XXXXhello"),
+		Code:    http.StatusConflict,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Client ahead",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "6",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusConflict,
+	}).Run(handler, t)
+}
+
 type overflowPatchStore struct {
 	zeroStore
 	t      *assert.Assertions
@@ -187,8 +424,119 @@ func TestPatchOverflow(t *testing.T) {
 			"Content-Length": "3",
 		},
 		ReqBody: body,
+		Code:    http.StatusRequestEntityTooLarge,
+		ResHeader: map[string]string{
+			"Upload-Offset": "20",
+		},
+	}).Run(handler, t)
+}
+
+type declaredLengthStore struct {
+	zeroStore
+	data *bytes.Buffer
+}
+
+func (s declaredLengthStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:     id,
+		Offset: int64(s.data.Len()),
+		Size:   10,
+	}, nil
+}
+
+func (s declaredLengthStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return io.Copy(s.data, src)
+}
+
+func TestPatchExceedingDeclaredLength(t *testing.T) {
+	a := assert.New(t)
+
+	store := declaredLengthStore{
+		data: new(bytes.Buffer),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+	})
+
+	// The body is sent without a Content-Length, so its size is only known
+	// after it has been read.
+	body := &noEOFReader{}
+	body.Write([]byte("01234567890123456789"))
+	body.Close()
+
+	(&httpTest{
+		Name:   "Body exceeding declared length",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: body,
+		Code:    http.StatusRequestEntityTooLarge,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	a.Equal("0123456789", store.data.String())
+
+	store.data.Reset()
+	body = &noEOFReader{}
+	body.Write([]byte("0123456789"))
+	body.Close()
+
+	(&httpTest{
+		Name:   "Body matching declared length",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: body,
 		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+}
+
+func TestPatchStaleOffset(t *testing.T) {
+	a := assert.New(t)
+
+	store := declaredLengthStore{
+		data: bytes.NewBufferString("hello"),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+	})
+
+	body := &noEOFReader{}
+	body.Write([]byte("stale"))
+	body.Close()
+
+	(&httpTest{
+		Name:   "Stale offset",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: body,
+		Code:    http.StatusConflict,
+		ResHeader: map[string]string{
+			"Upload-Offset": "5",
+		},
 	}).Run(handler, t)
+
+	// Neither has the body been read nor has any data been written
+	a.Equal("stale", string(body.buffer))
+	a.Equal("hello", store.data.String())
 }
 
 const (
@@ -274,3 +622,63 @@ func TestLockingPatch(t *testing.T) {
 		t.Error("expected no more calls to happen")
 	}
 }
+
+type bufferedStore struct {
+	patchStore
+}
+
+func (s bufferedStore) GetWrittenOffset(id string) (int64, error) {
+	return 10, nil
+}
+
+func (s bufferedStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	s.t.Equal(int64(10), offset)
+	return 0, nil
+}
+
+func TestPatchBuffered(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: bufferedStore{
+			patchStore{t: assert.New(t)},
+		},
+	})
+
+	(&httpTest{
+		Name:   "Continuing after unflushed bytes",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "10",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Offset": "10",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Offset beyond written bytes",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "11",
+		},
+		Code: http.StatusConflict,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Offset before flushed bytes",
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "4",
+		},
+		Code: http.StatusConflict,
+	}).Run(handler, t)
+}
@@ -1,7 +1,9 @@
 package tusd_test
 
 import (
+	"errors"
 	"net/http"
+	"regexp"
 	"testing"
 
 	. "github.com/tus/tusd"
@@ -64,7 +66,11 @@ func TestPost(t *testing.T) {
 			"Upload-Length":   "500",
 			"Upload-Metadata": "foo aGVsbG8=, bar d29ybGQ=",
 		},
-		Code: http.StatusRequestEntityTooLarge,
+		Code:    http.StatusRequestEntityTooLarge,
+		ResBody: "maximum size exceeded, see Tus-Max-Size header for the limit\n",
+		ResHeader: map[string]string{
+			"Tus-Max-Size": "400",
+		},
 	}).Run(handler, t)
 
 	(&httpTest{
@@ -141,3 +147,440 @@ func TestPost(t *testing.T) {
 		},
 	}).Run(handler, t)
 }
+
+func TestPostInvalidMetaData(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: zeroStore{},
+	})
+
+	(&httpTest{
+		Name:   "Newline in value",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "foo aGVsbG8Kd29ybGQ=",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Null byte in value",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "foo YQBi",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Invalid base64 in value",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "name aGVsbG8=, foo !!!",
+		},
+		Code:    http.StatusBadRequest,
+		ResBody: "invalid Upload-Metadata header: value of key \"foo\" is not valid base64\n",
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Too many parts in element",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "foo aGVsbG8= d29ybGQ=",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Unsafe character in key",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "fo:o aGVsbG8=",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+
+	handler, _ = NewHandler(Config{
+		DataStore:            zeroStore{},
+		MetaDataKeyPattern:   regexp.MustCompile(`^[a-z:]+$`),
+		MetaDataValuePattern: regexp.MustCompile(`^[a-z\n]+$`),
+	})
+
+	(&httpTest{
+		Name:   "Custom patterns allowing value",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "fo:o aGVsbG8Kd29ybGQ=",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Custom patterns rejecting value",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "foo SGVsbG8=",
+		},
+		Code: http.StatusBadRequest,
+	}).Run(handler, t)
+}
+
+func TestPostDryRun(t *testing.T) {
+	store := &expirationStore{
+		uploads: make(map[string]FileInfo),
+	}
+	handler, _ := NewHandler(Config{
+		MaxSize:   400,
+		DataStore: store,
+	})
+
+	(&httpTest{
+		Name:   "Accepted dry run",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Tus-Dry-Run":     "1",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "foo aGVsbG8=",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Rejected dry run",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Tus-Dry-Run":   "1",
+			"Upload-Length": "500",
+		},
+		Code: http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+
+	if len(store.uploads) != 0 {
+		t.Errorf("Expected no upload to be created but got %v", store.uploads)
+	}
+
+	// The data store's own limits are checked as well
+	handler, _ = NewHandler(Config{
+		DataStore: capacityStore{
+			expirationStore: store,
+			free:            100,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Dry run exceeding the store's capacity",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Tus-Dry-Run":   "1",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+}
+
+type capacityStore struct {
+	*expirationStore
+	free int64
+}
+
+func (s capacityStore) CheckCapacity(info FileInfo) error {
+	if info.Size > s.free {
+		return ErrNotEnoughSpace
+	}
+	return nil
+}
+
+func TestPreUploadCreateCallback(t *testing.T) {
+	store := &expirationStore{
+		uploads: make(map[string]FileInfo),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		PreUploadCreateCallback: func(info FileInfo) error {
+			if info.MetaData["filename"] == "" {
+				return errors.New("filename is required")
+			}
+			info.MetaData["owner"] = "alice"
+			return nil
+		},
+	})
+
+	(&httpTest{
+		Name:   "Rejected upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code:    http.StatusBadRequest,
+		ResBody: "filename is required\n",
+	}).Run(handler, t)
+
+	if len(store.uploads) != 0 {
+		t.Errorf("Expected no upload to be created but got %v", store.uploads)
+	}
+
+	(&httpTest{
+		Name:   "Accepted upload",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	if owner := store.uploads["new"].MetaData["owner"]; owner != "alice" {
+		t.Errorf("Expected injected metadata but got '%s'", owner)
+	}
+}
+
+type collisionStore struct {
+	zeroStore
+	collisions int
+	attempts   int
+}
+
+func (s *collisionStore) NewUpload(info FileInfo) (string, error) {
+	s.attempts++
+	if s.attempts <= s.collisions {
+		return "", ErrUploadIDCollision
+	}
+	return "new", nil
+}
+
+func TestPostIDCollision(t *testing.T) {
+	store := &collisionStore{collisions: 1}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+	})
+
+	(&httpTest{
+		Name:   "Retry after collision",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusCreated,
+		ResHeader: map[string]string{
+			"Location": "http://tus.io/files/new",
+		},
+	}).Run(handler, t)
+
+	if store.attempts != 2 {
+		t.Errorf("Expected two attempts but got %d", store.attempts)
+	}
+
+	store = &collisionStore{collisions: 10}
+	handler, _ = NewHandler(Config{
+		DataStore:          store,
+		BasePath:           "/files/",
+		IDCollisionRetries: 2,
+	})
+
+	(&httpTest{
+		Name:   "Give up after retries",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	if store.attempts != 3 {
+		t.Errorf("Expected three attempts but got %d", store.attempts)
+	}
+}
+
+type idStore struct {
+	zeroStore
+	ids []string
+}
+
+func (s *idStore) NewUpload(info FileInfo) (string, error) {
+	s.ids = append(s.ids, info.ID)
+	return info.ID, nil
+}
+
+func TestPostIDGenerator(t *testing.T) {
+	store := &idStore{}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+		IDGenerator: func(info FileInfo) (string, error) {
+			switch info.MetaData["tenant"] {
+			case "":
+				return "", errors.New("tenant is required")
+			case "acme":
+				return "acme-123", nil
+			case "dotted":
+				return "acme-123.info", nil
+			default:
+				return info.MetaData["tenant"] + "/123", nil
+			}
+		},
+	})
+
+	(&httpTest{
+		Name:   "Generated ID",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "tenant YWNtZQ==",
+		},
+		Code: http.StatusCreated,
+		ResHeader: map[string]string{
+			"Location": "http://tus.io/files/acme-123",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "ID with reserved characters",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "tenant ZXZpbA==",
+		},
+		Code:    http.StatusInternalServerError,
+		ResBody: "generated upload ID is empty or contains reserved characters\n",
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "ID containing a dot",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "tenant ZG90dGVk",
+		},
+		Code: http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Failing generator",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusInternalServerError,
+	}).Run(handler, t)
+
+	if len(store.ids) != 1 || store.ids[0] != "acme-123" {
+		t.Errorf("Expected only acme-123 to be created but got %v", store.ids)
+	}
+}
+
+func TestPostMaxSizeFunc(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: make(map[string]FileInfo),
+		},
+		BasePath: "/files/",
+		MaxSize:  5000,
+		MaxSizeFunc: func(info FileInfo) int64 {
+			if info.MetaData["plan"] == "paid" {
+				return 5000
+			}
+			return 100
+		},
+	})
+
+	(&httpTest{
+		Name:   "Exceeding free limit",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusRequestEntityTooLarge,
+		ResHeader: map[string]string{
+			"Tus-Max-Size": "100",
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Within paid limit",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "plan cGFpZA==",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Global limit in OPTIONS",
+		Method: "OPTIONS",
+		Code:   http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Tus-Max-Size": "5000",
+		},
+	}).Run(handler, t)
+}
+
+func TestPostMetaDataRoundTrip(t *testing.T) {
+	store := &expirationStore{
+		uploads: make(map[string]FileInfo),
+	}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+	})
+
+	(&httpTest{
+		Name:   "Key without value",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable":   "1.0.0",
+			"Upload-Length":   "300",
+			"Upload-Metadata": "public, name aGVsbG8=,",
+		},
+		Code: http.StatusCreated,
+	}).Run(handler, t)
+
+	meta := store.uploads["new"].MetaData
+	if len(meta) != 2 || meta["name"] != "hello" {
+		t.Errorf("Expected parsed metadata but got %v", meta)
+	}
+	if value, ok := meta["public"]; !ok || value != "" {
+		t.Errorf("Expected key without value but got %v", meta)
+	}
+
+	w := (&httpTest{
+		Name:   "Metadata in HEAD response",
+		Method: "HEAD",
+		URL:    "new",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	if v := w.HeaderMap.Get("Upload-Metadata"); v != "public,name aGVsbG8=" && v != "name aGVsbG8=,public" {
+		t.Errorf("Expected re-encoded metadata (got '%s')", v)
+	}
+}
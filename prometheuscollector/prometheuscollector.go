@@ -0,0 +1,93 @@
+// Package prometheuscollector exposes the metrics of tusd handlers to
+// Prometheus (https://prometheus.io).
+//
+// The counters are taken from a tusd.Metrics which has to be passed to the
+// handlers using Config.Metrics. Collector implements http.Handler, so it can
+// be mounted as the endpoint scraped by Prometheus, e.g.:
+//
+//	metrics := tusd.NewMetrics()
+//	handler, err := tusd.NewHandler(tusd.Config{
+//		DataStore: store,
+//		Metrics:   metrics,
+//	})
+//	http.Handle("/metrics", prometheuscollector.New(metrics))
+//
+// The metrics are written in Prometheus' text-based exposition format, so no
+// client library is required. All of them are counters:
+//
+//	tusd_uploads_created_total
+//	tusd_bytes_received_total
+//	tusd_uploads_finished_total
+//	tusd_uploads_terminated_total
+//	tusd_errors_total{status="..."}
+package prometheuscollector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/tus/tusd"
+)
+
+// ContentType is the media type of the text-based exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Collector serves the metrics of the handlers sharing Metrics.
+type Collector struct {
+	Metrics *tusd.Metrics
+}
+
+// New creates a new collector exposing the provided metrics.
+func New(metrics *tusd.Metrics) *Collector {
+	return &Collector{
+		Metrics: metrics,
+	}
+}
+
+// WriteTo writes the current values of the metrics to w in the text-based
+// exposition format.
+func (collector *Collector) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	metrics := collector.Metrics
+
+	writeCounter(&buf, "tusd_uploads_created_total", "Number of created uploads.", metrics.UploadsCreated())
+	writeCounter(&buf, "tusd_bytes_received_total", "Number of bytes received for uploads.", metrics.BytesReceived())
+	writeCounter(&buf, "tusd_uploads_finished_total", "Number of finished uploads.", metrics.UploadsFinished())
+	writeCounter(&buf, "tusd_uploads_terminated_total", "Number of terminated uploads.", metrics.UploadsTerminated())
+
+	errors := metrics.ErrorsTotal()
+	statuses := make([]int, 0, len(errors))
+	for status := range errors {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	fmt.Fprintf(&buf, "# HELP tusd_errors_total Number of error responses by status code.\n")
+	fmt.Fprintf(&buf, "# TYPE tusd_errors_total counter\n")
+	for _, status := range statuses {
+		fmt.Fprintf(&buf, "tusd_errors_total{status=\"%d\"} %d\n", status, errors[status])
+	}
+
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP responds to scrapes with the current values of the metrics.
+func (collector *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	collector.WriteTo(&buf)
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+func writeCounter(w io.Writer, name string, help string, value uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
@@ -0,0 +1,67 @@
+package prometheuscollector
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+var _ http.Handler = &Collector{}
+
+type zeroStore struct{}
+
+func (store zeroStore) NewUpload(info tusd.FileInfo) (string, error) {
+	return "foo", nil
+}
+func (store zeroStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return 0, nil
+}
+
+func (store zeroStore) GetInfo(id string) (tusd.FileInfo, error) {
+	return tusd.FileInfo{}, tusd.ErrNotFound
+}
+
+func TestCollector(t *testing.T) {
+	a := assert.New(t)
+
+	metrics := tusd.NewMetrics()
+	handler, err := tusd.NewHandler(tusd.Config{
+		DataStore: zeroStore{},
+		Metrics:   metrics,
+	})
+	a.NoError(err)
+
+	for _, request := range []struct{ method, url string }{{"POST", ""}, {"HEAD", "foo"}, {"HEAD", "foo"}} {
+		req, _ := http.NewRequest(request.method, request.url, nil)
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Upload-Length", "5")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	New(metrics).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	a.Equal(http.StatusOK, w.Code)
+	a.Equal(ContentType, w.Header().Get("Content-Type"))
+	a.Equal(`# HELP tusd_uploads_created_total Number of created uploads.
+# TYPE tusd_uploads_created_total counter
+tusd_uploads_created_total 1
+# HELP tusd_bytes_received_total Number of bytes received for uploads.
+# TYPE tusd_bytes_received_total counter
+tusd_bytes_received_total 0
+# HELP tusd_uploads_finished_total Number of finished uploads.
+# TYPE tusd_uploads_finished_total counter
+tusd_uploads_finished_total 0
+# HELP tusd_uploads_terminated_total Number of terminated uploads.
+# TYPE tusd_uploads_terminated_total counter
+tusd_uploads_terminated_total 0
+# HELP tusd_errors_total Number of error responses by status code.
+# TYPE tusd_errors_total counter
+tusd_errors_total{status="404"} 2
+`, w.Body.String())
+}
@@ -0,0 +1,84 @@
+package tusd_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+type fullStore struct {
+	zeroStore
+}
+
+func (s fullStore) NewUpload(info FileInfo) (string, error) {
+	return "", InsufficientStorageError{
+		Used:      80,
+		Total:     100,
+		Requested: info.Size,
+	}
+}
+
+func TestInsufficientStorage(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: fullStore{},
+	})
+
+	w := (&httpTest{
+		Name:   "Store is full",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusInsufficientStorage,
+		ResHeader: map[string]string{
+			"Content-Type":       "application/json",
+			"Upload-Quota-Used":  "80",
+			"Upload-Quota-Total": "100",
+		},
+	}).Run(handler, t)
+
+	var body struct {
+		Error     string
+		Used      int64
+		Total     int64
+		Requested int64
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON body but got error: %s", err)
+	}
+
+	if body.Used != 80 || body.Total != 100 || body.Requested != 300 {
+		t.Errorf("Unexpected quota details in body: %+v", body)
+	}
+
+	if body.Error == "" {
+		t.Error("Expected error message in body")
+	}
+}
+
+type noSpaceStore struct {
+	zeroStore
+}
+
+func (s noSpaceStore) NewUpload(info FileInfo) (string, error) {
+	return "", ErrNotEnoughSpace
+}
+
+func TestNotEnoughSpace(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: noSpaceStore{},
+	})
+
+	(&httpTest{
+		Name:   "Space cannot be freed",
+		Method: "POST",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Upload-Length": "300",
+		},
+		Code: http.StatusRequestEntityTooLarge,
+	}).Run(handler, t)
+}
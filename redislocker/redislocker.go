@@ -0,0 +1,221 @@
+// Package redislocker provides a locking mechanism using a Redis server.
+//
+// In contrast to memorylocker, the locks are shared by all processes which are
+// connected to the same Redis server, so multiple tusd instances behind a load
+// balancer can serve the same uploads. A lock is acquired by setting a key
+// using SET with the NX and PX options. The key's value is a random token
+// which is only known to the holder, so a lock is only released by the process
+// which has acquired it. Every lock expires after a TTL, so uploads do not
+// remain locked forever if a process crashes while holding a lock. While a lock
+// is held, its TTL is renewed periodically, so requests taking longer than the
+// TTL keep their lock. ActiveLocks only reports the locks held by the calling
+// process since the keys do not reveal when the locks have been acquired.
+//
+// The package does not depend on a specific Redis client. Instead, any client
+// implementing the Client interface can be used, e.g. redigo's redis.Conn.
+package redislocker
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tus/tusd"
+	"github.com/tus/tusd/uid"
+)
+
+// DefaultTTL is the duration after which locks expire if NewRedisLocker is
+// used.
+const DefaultTTL = 30 * time.Second
+
+// DefaultKeyPrefix is prepended to the upload IDs in order to obtain the keys
+// of the locks if NewRedisLocker is used.
+const DefaultKeyPrefix = "tusd-lock:"
+
+// unlockScript deletes the key only if it still contains the token, i.e. if
+// the lock has neither expired nor been acquired by another process since.
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+else
+	return 0
+end`
+
+// refreshScript renews the key's TTL only if it still contains the token.
+const refreshScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+else
+	return 0
+end`
+
+var ErrLockExpired = errors.New("redislocker: lock has expired before it has been released")
+
+// Client executes a single Redis command and returns its reply. A reply of nil
+// must be returned for Redis' null reply. It must be safe for concurrent use,
+// e.g. by taking a connection from a pool for every command.
+type Client interface {
+	Do(command string, args ...interface{}) (reply interface{}, err error)
+}
+
+type RedisLocker struct {
+	tusd.DataStore
+	// Client used to connect to the Redis server.
+	Client Client
+	// KeyPrefix is prepended to the upload IDs in order to obtain the keys
+	// of the locks. If multiple services share a Redis server, distinct
+	// prefixes prevent their locks from colliding.
+	KeyPrefix string
+	// TTL is the duration after which a lock expires if it has not been
+	// released. It must be at least one millisecond. While the lock is held,
+	// the TTL is renewed after a third of it has passed, so it only expires if
+	// the holding process stops, e.g. due to a crash, or cannot reach Redis.
+	TTL time.Duration
+
+	// locks contains the uploads locked by this locker, indexed by their IDs.
+	locks map[string]*heldLock
+	mutex sync.Mutex
+}
+
+// heldLock describes a lock acquired by this locker.
+type heldLock struct {
+	// token is the value of the lock's key, required for renewing and
+	// releasing the lock.
+	token string
+	// since is the time at which the lock has been acquired.
+	since time.Time
+	// stop is closed in order to end the renewal, which closes done once it
+	// has returned.
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRedisLocker creates a new lock wrapper around the provided storage using
+// the client for acquiring the locks.
+func NewRedisLocker(store tusd.DataStore, client Client) *RedisLocker {
+	return &RedisLocker{
+		DataStore: store,
+		Client:    client,
+		KeyPrefix: DefaultKeyPrefix,
+		TTL:       DefaultTTL,
+		locks:     make(map[string]*heldLock),
+	}
+}
+
+// LockUpload tries to obtain the exclusive lock. If the upload is locked by
+// this or another process, tusd.ErrFileLocked is returned.
+func (locker *RedisLocker) LockUpload(id string) error {
+	token := uid.Uid()
+	ttl := strconv.FormatInt(int64(locker.TTL/time.Millisecond), 10)
+
+	reply, err := locker.Client.Do("SET", locker.KeyPrefix+id, token, "NX", "PX", ttl)
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return tusd.ErrFileLocked
+	}
+
+	lock := &heldLock{
+		token: token,
+		since: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go locker.refresh(id, lock, ttl)
+
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	locker.locks[id] = lock
+
+	return nil
+}
+
+// refresh renews the lock's TTL until it is released. The renewal stops if the
+// lock has been lost in the meantime, which UnlockUpload reports using
+// ErrLockExpired.
+func (locker *RedisLocker) refresh(id string, lock *heldLock, ttl string) {
+	defer close(lock.done)
+
+	interval := locker.TTL / 3
+	if interval <= 0 {
+		interval = time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			reply, err := locker.Client.Do("EVAL", refreshScript, 1, locker.KeyPrefix+id, lock.token, ttl)
+			if err != nil {
+				// Try again on the next tick, the lock may still be valid
+				continue
+			}
+			if renewed, ok := reply.(int64); !ok || renewed == 0 {
+				return
+			}
+		}
+	}
+}
+
+// LockTTL returns the duration after which locks expire.
+func (locker *RedisLocker) LockTTL() time.Duration {
+	return locker.TTL
+}
+
+// ActiveLocks returns the locks held by this locker including the time at
+// which they have been acquired. Locks which have been lost since their TTL
+// could not be renewed are omitted.
+func (locker *RedisLocker) ActiveLocks() []tusd.LockInfo {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+
+	locks := make([]tusd.LockInfo, 0, len(locker.locks))
+	for id, lock := range locker.locks {
+		// The renewal only ends on its own once the lock has been lost
+		select {
+		case <-lock.done:
+			continue
+		default:
+		}
+
+		locks = append(locks, tusd.LockInfo{
+			ID:    id,
+			Since: lock.since,
+		})
+	}
+
+	return locks
+}
+
+// UnlockUpload releases a lock. If the lock has not been acquired by this
+// locker, no error will be returned. If it has expired in the meantime,
+// ErrLockExpired is returned and the key is left untouched, since it may
+// belong to another process by now.
+func (locker *RedisLocker) UnlockUpload(id string) error {
+	locker.mutex.Lock()
+	lock, ok := locker.locks[id]
+	delete(locker.locks, id)
+	locker.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	// Stop renewing the TTL before the key is deleted
+	close(lock.stop)
+	<-lock.done
+
+	reply, err := locker.Client.Do("EVAL", unlockScript, 1, locker.KeyPrefix+id, lock.token)
+	if err != nil {
+		return err
+	}
+	if deleted, ok := reply.(int64); !ok || deleted == 0 {
+		return ErrLockExpired
+	}
+
+	return nil
+}
@@ -0,0 +1,203 @@
+package redislocker
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+var _ tusd.LockerDataStore = &RedisLocker{}
+var _ tusd.ExpiringLocker = &RedisLocker{}
+var _ tusd.LockInspector = &RedisLocker{}
+
+type zeroStore struct{}
+
+func (store zeroStore) NewUpload(info tusd.FileInfo) (string, error) {
+	return "", nil
+}
+func (store zeroStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	return 0, nil
+}
+
+func (store zeroStore) GetInfo(id string) (tusd.FileInfo, error) {
+	return tusd.FileInfo{}, nil
+}
+
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+// fakeRedis implements the SET and EVAL commands used by RedisLocker in memory.
+type fakeRedis struct {
+	keys  map[string]entry
+	mutex sync.Mutex
+}
+
+func (r *fakeRedis) get(key string) (string, bool) {
+	e, ok := r.keys[key]
+	if !ok || time.Now().After(e.expires) {
+		return "", false
+	}
+	return e.value, true
+}
+
+func (r *fakeRedis) Do(command string, args ...interface{}) (interface{}, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	switch command {
+	case "SET":
+		key := args[0].(string)
+		if args[2] != "NX" || args[3] != "PX" {
+			return nil, errors.New("unsupported options")
+		}
+		ttl, err := strconv.ParseInt(args[4].(string), 10, 64)
+		if err != nil || ttl <= 0 {
+			return nil, errors.New("ERR invalid expire time")
+		}
+		if _, ok := r.get(key); ok {
+			return nil, nil
+		}
+		r.keys[key] = entry{
+			value:   args[1].(string),
+			expires: time.Now().Add(time.Duration(ttl) * time.Millisecond),
+		}
+		return "OK", nil
+	case "EVAL":
+		if args[1] != 1 {
+			return nil, errors.New("unexpected number of keys")
+		}
+		key := args[2].(string)
+		value, ok := r.get(key)
+		if !ok || value != args[3] {
+			return int64(0), nil
+		}
+
+		switch args[0] {
+		case unlockScript:
+			delete(r.keys, key)
+		case refreshScript:
+			ttl, err := strconv.ParseInt(args[4].(string), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			r.keys[key] = entry{
+				value:   value,
+				expires: time.Now().Add(time.Duration(ttl) * time.Millisecond),
+			}
+		default:
+			return nil, errors.New("unexpected script")
+		}
+		return int64(1), nil
+	}
+
+	return nil, errors.New("unknown command")
+}
+
+func TestRedisLocker(t *testing.T) {
+	a := assert.New(t)
+
+	redis := &fakeRedis{keys: make(map[string]entry)}
+	locker := NewRedisLocker(zeroStore{}, redis)
+	other := NewRedisLocker(zeroStore{}, redis)
+
+	a.NoError(locker.LockUpload("one"))
+	a.Contains(redis.keys, "tusd-lock:one")
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, other.LockUpload("one"))
+
+	// Only the holder releases the lock
+	a.NoError(other.UnlockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, other.LockUpload("one"))
+
+	a.NoError(locker.UnlockUpload("one"))
+	a.NoError(other.LockUpload("one"))
+	a.NoError(other.UnlockUpload("one"))
+	a.Empty(redis.keys)
+}
+
+func TestRedisLockerRefresh(t *testing.T) {
+	a := assert.New(t)
+
+	redis := &fakeRedis{keys: make(map[string]entry)}
+	locker := NewRedisLocker(zeroStore{}, redis)
+	locker.TTL = 30 * time.Millisecond
+	other := NewRedisLocker(zeroStore{}, redis)
+
+	// The lock is held for several TTLs without expiring
+	a.NoError(locker.LockUpload("one"))
+	time.Sleep(100 * time.Millisecond)
+	a.Equal(tusd.ErrFileLocked, other.LockUpload("one"))
+
+	a.NoError(locker.UnlockUpload("one"))
+	a.NoError(other.LockUpload("one"))
+	a.NoError(other.UnlockUpload("one"))
+}
+
+func TestRedisLockerTTL(t *testing.T) {
+	a := assert.New(t)
+
+	redis := &fakeRedis{keys: make(map[string]entry)}
+	locker := NewRedisLocker(zeroStore{}, redis)
+	locker.TTL = 10 * time.Millisecond
+	other := NewRedisLocker(zeroStore{}, redis)
+
+	a.NoError(locker.LockUpload("one"))
+
+	// Simulate a process which has stopped renewing its lock, e.g. since it
+	// cannot reach Redis anymore
+	redis.mutex.Lock()
+	delete(redis.keys, "tusd-lock:one")
+	redis.mutex.Unlock()
+
+	// The expired lock is taken over and not released by the original holder
+	a.NoError(other.LockUpload("one"))
+	a.Equal(ErrLockExpired, locker.UnlockUpload("one"))
+	a.Equal(tusd.ErrFileLocked, locker.LockUpload("one"))
+
+	a.NoError(other.UnlockUpload("one"))
+	a.NoError(locker.LockUpload("one"))
+}
+
+func TestRedisLockerActiveLocks(t *testing.T) {
+	a := assert.New(t)
+
+	redis := &fakeRedis{keys: make(map[string]entry)}
+	locker := NewRedisLocker(zeroStore{}, redis)
+	locker.TTL = 10 * time.Millisecond
+	other := NewRedisLocker(zeroStore{}, redis)
+
+	a.Len(locker.ActiveLocks(), 0)
+
+	start := time.Now()
+	a.NoError(locker.LockUpload("one"))
+
+	locks := locker.ActiveLocks()
+	a.Len(locks, 1)
+	a.Equal("one", locks[0].ID)
+	a.False(locks[0].Since.Before(start))
+
+	// Only the locks of the calling process are reported
+	a.Len(other.ActiveLocks(), 0)
+
+	// A lost lock is omitted once its renewal has failed
+	redis.mutex.Lock()
+	delete(redis.keys, "tusd-lock:one")
+	redis.mutex.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	a.Len(locker.ActiveLocks(), 0)
+
+	a.Equal(ErrLockExpired, locker.UnlockUpload("one"))
+	a.NoError(locker.LockUpload("two"))
+	a.Len(locker.ActiveLocks(), 1)
+	a.NoError(locker.UnlockUpload("two"))
+	a.Len(locker.ActiveLocks(), 0)
+}
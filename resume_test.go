@@ -0,0 +1,233 @@
+package tusd_test
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type resumeStore struct {
+	cancelStore
+	locks *sync.Map
+}
+
+func newResumeStore() resumeStore {
+	offset := int64(0)
+	return resumeStore{
+		cancelStore: cancelStore{
+			mutex:   &sync.Mutex{},
+			offset:  &offset,
+			started: make(chan struct{}),
+		},
+		locks: &sync.Map{},
+	}
+}
+
+func (s resumeStore) LockUpload(id string) error {
+	if _, locked := s.locks.LoadOrStore(id, true); locked {
+		return ErrFileLocked
+	}
+	return nil
+}
+
+func (s resumeStore) UnlockUpload(id string) error {
+	s.locks.Delete(id)
+	return nil
+}
+
+// stallReader returns no data without blocking until done is closed, after
+// which io.EOF is returned.
+type stallReader struct {
+	done chan struct{}
+}
+
+func (r stallReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.done:
+		return 0, io.EOF
+	case <-time.After(time.Millisecond):
+		return 0, nil
+	}
+}
+
+// startStalledPatch begins a PATCH request which writes five bytes and then
+// stalls until done is closed. The returned channel is closed once the request
+// has been answered with the expected status code.
+func startStalledPatch(t *testing.T, handler *Handler, store resumeStore, done chan struct{}, code int) chan struct{} {
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+
+		(&httpTest{
+			Name:   "Stalled PATCH request",
+			Method: "PATCH",
+			URL:    "yes",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: io.MultiReader(strings.NewReader("hello"), stallReader{done}),
+			Code:    code,
+		}).Run(handler, t)
+	}()
+
+	<-store.started
+	return finished
+}
+
+func resumeTest(name string, code int) *httpTest {
+	test := &httpTest{
+		Name:   name,
+		Method: "PATCH",
+		URL:    "yes",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader("world"),
+		Code:    code,
+	}
+	if code == http.StatusNoContent {
+		test.ResHeader = map[string]string{
+			"Upload-Offset": "10",
+		}
+	}
+	return test
+}
+
+func TestResumeReject(t *testing.T) {
+	store := newResumeStore()
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+	})
+
+	done := make(chan struct{})
+	finished := startStalledPatch(t, handler, store, done, http.StatusNoContent)
+
+	resumeTest("Rejected while locked", http.StatusLocked).Run(handler, t)
+
+	close(done)
+	<-finished
+
+	resumeTest("Resumed after unlock", http.StatusNoContent).Run(handler, t)
+}
+
+func TestResumeQueue(t *testing.T) {
+	store := newResumeStore()
+	handler, _ := NewHandler(Config{
+		DataStore:     store,
+		ResumePolicy:  ResumeQueue,
+		ResumeTimeout: 50 * time.Millisecond,
+	})
+
+	done := make(chan struct{})
+	finished := startStalledPatch(t, handler, store, done, http.StatusNoContent)
+
+	resumeTest("Timed out while queued", http.StatusLocked).Run(handler, t)
+
+	// Release the lock shortly after the next request started waiting
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+	}()
+
+	handler, _ = NewHandler(Config{
+		DataStore:    store,
+		ResumePolicy: ResumeQueue,
+	})
+	resumeTest("Resumed after waiting", http.StatusNoContent).Run(handler, t)
+	<-finished
+}
+
+func TestResumeTakeOver(t *testing.T) {
+	store := newResumeStore()
+	handler, _ := NewHandler(Config{
+		DataStore:    store,
+		ResumePolicy: ResumeTakeOver,
+	})
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// The stalled request is interrupted by the newer one
+	finished := startStalledPatch(t, handler, store, done, http.StatusBadRequest)
+
+	resumeTest("Taking over upload", http.StatusNoContent).Run(handler, t)
+	<-finished
+}
+
+func TestResumptions(t *testing.T) {
+	a := assert.New(t)
+
+	completed := make(chan FileInfo, 1)
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: map[string]FileInfo{
+				"foo": {ID: "foo", Size: 15},
+			},
+		},
+		ResumptionGap: 50 * time.Millisecond,
+		CompleteUploadsCallback: func(info FileInfo) {
+			completed <- info
+		},
+	})
+
+	patch := func(name string, remoteAddr string, offset string) {
+		(&httpTest{
+			Name:   name,
+			Method: "PATCH",
+			URL:    "foo",
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": offset,
+			},
+			ReqBody: strings.NewReader("abc"),
+			Code:    http.StatusNoContent,
+		}).Run(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.RemoteAddr = remoteAddr
+			handler.ServeHTTP(w, r)
+		}), t)
+	}
+
+	patch("First chunk", "192.0.2.1:1000", "0")
+	patch("Same connection", "192.0.2.1:1000", "3")
+	resumptions, ok := handler.Unrouted.Resumptions("foo")
+	a.True(ok)
+	a.Equal(0, resumptions)
+
+	patch("New connection", "192.0.2.1:2000", "6")
+	time.Sleep(100 * time.Millisecond)
+	patch("After gap", "192.0.2.1:2000", "9")
+
+	(&httpTest{
+		Name:   "Resumptions in HEAD response",
+		Method: "HEAD",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Resumptions": "2",
+		},
+	}).Run(handler, t)
+
+	patch("Last chunk on new connection", "192.0.2.1:3000", "12")
+
+	select {
+	case info := <-completed:
+		a.Equal(3, info.Resumptions)
+	case <-time.After(time.Second):
+		t.Fatal("Expected callback to be invoked")
+	}
+}
@@ -0,0 +1,71 @@
+package s3store
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// ClientOptions tunes the HTTP client used for communicating with the S3
+// backend. The defaults of Go's HTTP client allow only two idle connections
+// per host, which can throttle the throughput under load since new
+// connections have to be established for most requests. Zero values keep the
+// defaults of http.DefaultTransport.
+//
+// The client is not used by S3Store directly but must be passed to the AWS
+// SDK when constructing the service, e.g.:
+//
+//	config := aws.NewConfig().WithHTTPClient(options.HTTPClient())
+//	store := s3store.New(bucket, s3.New(session.New(), config))
+type ClientOptions struct {
+	// MaxIdleConns limits the number of idle connections kept open in total.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits the number of idle connections kept open per
+	// host. Since all requests are sent to the same endpoint, this is usually
+	// the relevant limit.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is the time after which idle connections are closed.
+	IdleConnTimeout time.Duration
+	// ResponseHeaderTimeout is the time to wait for the response headers after
+	// the request has been sent entirely.
+	ResponseHeaderTimeout time.Duration
+	// Timeout limits the entire duration of a request, including reading the
+	// response body. Since uploading a part may take a long time, it should
+	// be chosen generously.
+	Timeout time.Duration
+}
+
+// HTTPClient returns a new HTTP client configured according to the options.
+func (options ClientOptions) HTTPClient() *http.Client {
+	// The transport is built from scratch, using the same settings as
+	// http.DefaultTransport, since cloning it requires a recent Go version.
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if options.MaxIdleConns > 0 {
+		transport.MaxIdleConns = options.MaxIdleConns
+	}
+	if options.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = options.MaxIdleConnsPerHost
+	}
+	if options.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = options.IdleConnTimeout
+	}
+	if options.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = options.ResponseHeaderTimeout
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   options.Timeout,
+	}
+}
@@ -0,0 +1,38 @@
+package s3store_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd/s3store"
+)
+
+func TestClientOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	client := s3store.ClientOptions{
+		MaxIdleConns:          200,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       time.Minute,
+		ResponseHeaderTimeout: 10 * time.Second,
+		Timeout:               time.Hour,
+	}.HTTPClient()
+
+	assert.Equal(time.Hour, client.Timeout)
+
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(200, transport.MaxIdleConns)
+	assert.Equal(100, transport.MaxIdleConnsPerHost)
+	assert.Equal(time.Minute, transport.IdleConnTimeout)
+	assert.Equal(10*time.Second, transport.ResponseHeaderTimeout)
+
+	// Unset options keep the defaults and the default transport is not modified
+	defaults := http.DefaultTransport.(*http.Transport)
+	transport = s3store.ClientOptions{}.HTTPClient().Transport.(*http.Transport)
+	assert.Equal(defaults.MaxIdleConns, transport.MaxIdleConns)
+	assert.Equal(defaults.IdleConnTimeout, transport.IdleConnTimeout)
+	assert.NotEqual(200, defaults.MaxIdleConns)
+}
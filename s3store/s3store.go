@@ -59,6 +59,15 @@
 // even passed to the storage server. If your server supports a different
 // limit, you can adjust this value using S3Store.MinPartSize.
 //
+// Furthermore, a multipart upload may consist of at most 10,000 parts. Once
+// the remaining parts would not suffice for the remaining data using parts of
+// S3Store.MaxPartSize, the store uploads bigger parts which are sized so the
+// upload can still be completed. Chunks which are smaller than this size are
+// rejected with tusd.ErrChunkTooSmall, unless they complete the upload. The
+// limit can be adjusted using S3Store.MaxMultipartParts. Since S3 does not
+// accept parts bigger than 5GB, uploads which would not fit into the parts
+// even then are rejected with tusd.ErrMaxSizeExceeded.
+//
 // When receiving a PATCH request, its body will be temporarily stored on disk.
 // This requirement has been made to ensure the minimum size of a single part
 // and to allow the calculating of a checksum. Once the part has been uploaded
@@ -113,25 +122,41 @@ type S3Store struct {
 	//
 	// If this value is too low, a lot of requests to S3 may be made, depending
 	// on how fast data is coming in. This may result in an eventual overhead.
+	//
+	// Values above S3's limit of 5GB are lowered to it.
 	MaxPartSize int64
 	// MinPartSize specifies the minimum size of a single part uploaded to S3
 	// in bytes. This number needs to match with the underlying S3 backend or else
 	// uploaded parts will be reject. AWS S3, for example, uses 5MB for this value.
 	MinPartSize int64
+	// MaxMultipartParts specifies the maximum number of parts a multipart
+	// upload may consist of. AWS S3, for example, allows 10,000 parts. If
+	// zero, the number of parts is not limited.
+	MaxMultipartParts int64
 }
 
+// maxS3PartSize is the size of the biggest part S3 accepts.
+const maxS3PartSize = 5 * 1024 * 1024 * 1024
+
 // New constructs a new storage using the supplied bucket and service object.
-// The MaxPartSize and MinPartSize properties are set to 6 and 5MB.
+// The MaxPartSize and MinPartSize properties are set to 6 and 5MB while
+// MaxMultipartParts is set to 10,000.
 func New(bucket string, service s3iface.S3API) S3Store {
 	return S3Store{
-		Bucket:      bucket,
-		Service:     service,
-		MaxPartSize: 6 * 1024 * 1024,
-		MinPartSize: 5 * 1024 * 1024,
+		Bucket:            bucket,
+		Service:           service,
+		MaxPartSize:       6 * 1024 * 1024,
+		MinPartSize:       5 * 1024 * 1024,
+		MaxMultipartParts: 10000,
 	}
 }
 
 func (store S3Store) NewUpload(info tusd.FileInfo) (id string, err error) {
+	// Reject uploads which cannot be stored even using the biggest parts
+	if store.MaxMultipartParts > 0 && info.Size > store.MaxMultipartParts*maxS3PartSize {
+		return "", tusd.ErrMaxSizeExceeded
+	}
+
 	var uploadId string
 	if info.ID == "" {
 		uploadId = uid.Uid()
@@ -205,6 +230,30 @@ func (store S3Store) WriteChunk(id string, offset int64, src io.Reader) (int64,
 	numParts := len(list.Parts)
 	nextPartNum := int64(numParts + 1)
 
+	// Ensure the remaining data fits into the remaining parts by raising the
+	// size of the parts if necessary
+	minPartSize, maxPartSize := store.MinPartSize, store.MaxPartSize
+	if store.MaxMultipartParts > 0 {
+		remainingParts := store.MaxMultipartParts - int64(numParts)
+		if remainingParts <= 0 {
+			return 0, tusd.ErrChunkTooSmall
+		}
+
+		requiredPartSize := (size - offset + remainingParts - 1) / remainingParts
+		if requiredPartSize > maxS3PartSize {
+			return 0, tusd.ErrMaxSizeExceeded
+		}
+		if requiredPartSize > minPartSize {
+			minPartSize = requiredPartSize
+		}
+		if requiredPartSize > maxPartSize {
+			maxPartSize = requiredPartSize
+		}
+	}
+	if maxPartSize > maxS3PartSize {
+		maxPartSize = maxS3PartSize
+	}
+
 	for {
 		// Create a temporary file to store the part in it
 		file, err := ioutil.TempFile("", "tusd-s3-tmp-")
@@ -214,17 +263,23 @@ func (store S3Store) WriteChunk(id string, offset int64, src io.Reader) (int64,
 		defer os.Remove(file.Name())
 		defer file.Close()
 
-		limitedReader := io.LimitReader(src, store.MaxPartSize)
+		limitedReader := io.LimitReader(src, maxPartSize)
 		n, err := io.Copy(file, limitedReader)
 		if err != nil && err != io.EOF {
 			return bytesUploaded, err
 		}
 
-		if (size - offset) <= store.MinPartSize {
-			if (size - offset) != n {
-				return bytesUploaded, nil
+		// Only the last part may be smaller than the minimum
+		tooSmall := n < minPartSize
+		if (size - offset) <= minPartSize {
+			tooSmall = (size - offset) != n
+		}
+		if tooSmall {
+			if minPartSize > store.MinPartSize && bytesUploaded == 0 {
+				// The part limit requires bigger chunks than the client sends,
+				// so dropping it silently would only make the client resend it
+				return 0, tusd.ErrChunkTooSmall
 			}
-		} else if n < store.MinPartSize {
 			return bytesUploaded, nil
 		}
 
@@ -341,6 +396,42 @@ func (store S3Store) GetReader(id string) (io.Reader, error) {
 	return nil, err
 }
 
+func (store S3Store) Describe() string {
+	return "s3store/1"
+}
+
+// GetReaderAt returns a reader which fetches the requested bytes of a finished
+// upload using ranged GET requests. The content of non-finished uploads cannot
+// be read since the multipart upload has not been completed yet.
+func (store S3Store) GetReaderAt(id string) (io.ReaderAt, int64, error) {
+	uploadId, _ := splitIds(id)
+
+	res, err := store.Service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(uploadId),
+	})
+	if err != nil {
+		if !isAwsError(err, "NotFound") {
+			return nil, 0, err
+		}
+
+		// Find out whether the upload never existed or just has not been
+		// finished yet
+		if _, err := store.GetInfo(id); err != nil {
+			return nil, 0, err
+		}
+
+		return nil, 0, errors.New("cannot stream non-finished upload")
+	}
+
+	size := *res.ContentLength
+	return &s3ReaderAt{
+		store: store,
+		key:   uploadId,
+		size:  size,
+	}, size, nil
+}
+
 func (store S3Store) Terminate(id string) error {
 	uploadId, multipartId := splitIds(id)
 	var wg sync.WaitGroup
@@ -478,6 +569,67 @@ func (store S3Store) ConcatUploads(dest string, partialUploads []string) error {
 	return store.FinishUpload(dest)
 }
 
+// s3ReaderAt implements the io.ReaderAt interface by issuing a ranged GET
+// request for every read. In addition, it implements tusd.RangeReader, so an
+// entire range can be streamed using a single request.
+type s3ReaderAt struct {
+	store S3Store
+	key   string
+	size  int64
+}
+
+func (reader *s3ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= reader.size {
+		return 0, io.EOF
+	}
+
+	end := off + int64(len(p))
+	if end > reader.size {
+		end = reader.size
+	}
+
+	if end == off {
+		return 0, nil
+	}
+
+	res, err := reader.store.Service.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(reader.store.Bucket),
+		Key:    aws.String(reader.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", off, end-1)),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	n, err := io.ReadFull(res.Body, p[:end-off])
+	if err == nil && end-off < int64(len(p)) {
+		// Less bytes than requested were available
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// ReadRange issues a single ranged GET request and returns the response body
+// without buffering it.
+func (reader *s3ReaderAt) ReadRange(offset, length int64) (io.ReadCloser, error) {
+	if length <= 0 {
+		return ioutil.NopCloser(bytes.NewReader(nil)), nil
+	}
+
+	res, err := reader.store.Service.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(reader.store.Bucket),
+		Key:    aws.String(reader.key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
 func splitIds(id string) (uploadId, multipartId string) {
 	index := strings.Index(id, "+")
 	if index == -1 {
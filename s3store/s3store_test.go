@@ -2,6 +2,7 @@ package s3store_test
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"testing"
 
@@ -23,6 +24,7 @@ var _ tusd.GetReaderDataStore = s3store.S3Store{}
 var _ tusd.TerminaterDataStore = s3store.S3Store{}
 var _ tusd.FinisherDataStore = s3store.S3Store{}
 var _ tusd.ConcaterDataStore = s3store.S3Store{}
+var _ tusd.DescriberDataStore = s3store.S3Store{}
 
 func TestNewUpload(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
@@ -42,8 +44,8 @@ func TestNewUpload(t *testing.T) {
 		s3obj.EXPECT().PutObject(&s3.PutObjectInput{
 			Bucket:        aws.String("bucket"),
 			Key:           aws.String("uploadId.info"),
-			Body:          bytes.NewReader([]byte(`{"ID":"uploadId","Size":500,"Offset":0,"MetaData":{"bar":"world","foo":"hello"},"IsPartial":false,"IsFinal":false,"PartialUploads":null}`)),
-			ContentLength: aws.Int64(int64(136)),
+			Body:          bytes.NewReader([]byte(`{"ID":"uploadId","Size":500,"Offset":0,"MetaData":{"bar":"world","foo":"hello"},"IsPartial":false,"IsFinal":false,"PartialUploads":null,"IsComplete":false}`)),
+			ContentLength: aws.Int64(int64(155)),
 		}),
 		s3obj.EXPECT().CreateMultipartUpload(&s3.CreateMultipartUploadInput{
 			Bucket: aws.String("bucket"),
@@ -227,6 +229,132 @@ func TestGetReaderNotFinished(t *testing.T) {
 	assert.Equal(err.Error(), "cannot stream non-finished upload")
 }
 
+func TestGetReaderAt(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	assert := assert.New(t)
+
+	s3obj := NewMockS3API(mockCtrl)
+	store := s3store.New("bucket", s3obj)
+
+	gomock.InOrder(
+		s3obj.EXPECT().HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+		}).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(11),
+		}, nil),
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+			Range:  aws.String("bytes=6-10"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`world`))),
+		}, nil),
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+			Range:  aws.String("bytes=0-4"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`hello`))),
+		}, nil),
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+			Range:  aws.String("bytes=9-10"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`ld`))),
+		}, nil),
+	)
+
+	reader, size, err := store.GetReaderAt("uploadId+multipartId")
+	assert.Nil(err)
+	assert.Equal(int64(11), size)
+
+	p := make([]byte, 5)
+	n, err := reader.ReadAt(p, 6)
+	assert.Nil(err)
+	assert.Equal(5, n)
+	assert.Equal("world", string(p))
+
+	n, err = reader.ReadAt(p, 0)
+	assert.Nil(err)
+	assert.Equal(5, n)
+	assert.Equal("hello", string(p))
+
+	n, err = reader.ReadAt(p, 9)
+	assert.Equal(io.EOF, err)
+	assert.Equal(2, n)
+	assert.Equal("ld", string(p[:n]))
+
+	n, err = reader.ReadAt(p, 11)
+	assert.Equal(io.EOF, err)
+	assert.Equal(0, n)
+}
+
+func TestGetReaderAtRange(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	assert := assert.New(t)
+
+	s3obj := NewMockS3API(mockCtrl)
+	store := s3store.New("bucket", s3obj)
+
+	gomock.InOrder(
+		s3obj.EXPECT().HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+		}).Return(&s3.HeadObjectOutput{
+			ContentLength: aws.Int64(11),
+		}, nil),
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+			Range:  aws.String("bytes=2-8"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`llo wor`))),
+		}, nil),
+	)
+
+	reader, _, err := store.GetReaderAt("uploadId+multipartId")
+	assert.Nil(err)
+
+	rangeReader, ok := reader.(tusd.RangeReader)
+	assert.True(ok)
+
+	body, err := rangeReader.ReadRange(2, 7)
+	assert.Nil(err)
+
+	content, err := ioutil.ReadAll(body)
+	assert.Nil(err)
+	assert.Equal("llo wor", string(content))
+	assert.Nil(body.Close())
+}
+
+func TestGetReaderAtNotFound(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	assert := assert.New(t)
+
+	s3obj := NewMockS3API(mockCtrl)
+	store := s3store.New("bucket", s3obj)
+
+	gomock.InOrder(
+		s3obj.EXPECT().HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId"),
+		}).Return(nil, awserr.New("NotFound", "Not Found", nil)),
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId.info"),
+		}).Return(nil, awserr.New("NoSuchKey", "The specified key does not exist.", nil)),
+	)
+
+	reader, _, err := store.GetReaderAt("uploadId+multipartId")
+	assert.Nil(reader)
+	assert.Equal(tusd.ErrNotFound, err)
+}
+
 func TestFinishUpload(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
@@ -461,6 +589,130 @@ func TestWriteChunkAllowTooSmallLast(t *testing.T) {
 	assert.Equal(int64(10), bytesRead)
 }
 
+func TestWriteChunkPartLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	assert := assert.New(t)
+
+	s3obj := NewMockS3API(mockCtrl)
+	store := s3store.New("bucket", s3obj)
+	store.MaxPartSize = 4
+	store.MinPartSize = 2
+	store.MaxMultipartParts = 4
+
+	// expectInfo expects the upload's info and parts to be fetched by
+	// WriteChunk. 300 of the 500 bytes have been uploaded in two parts, so the
+	// remaining 200 bytes must be stored in at most two parts of 100 bytes.
+	expectInfo := func() {
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId.info"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"ID":"uploadId","Size":500,"Offset":0,"MetaData":null,"IsPartial":false,"IsFinal":false,"PartialUploads":null}`))),
+		}, nil)
+		s3obj.EXPECT().ListParts(&s3.ListPartsInput{
+			Bucket:   aws.String("bucket"),
+			Key:      aws.String("uploadId"),
+			UploadId: aws.String("multipartId"),
+		}).Return(&s3.ListPartsOutput{
+			Parts: []*s3.Part{
+				{
+					Size: aws.Int64(100),
+				},
+				{
+					Size: aws.Int64(200),
+				},
+			},
+		}, nil).Times(2)
+	}
+
+	// Chunks smaller than the required part size are rejected
+	expectInfo()
+	bytesRead, err := store.WriteChunk("uploadId+multipartId", 300, bytes.NewReader([]byte("1234567890")))
+	assert.Equal(tusd.ErrChunkTooSmall, err)
+	assert.Equal(int64(0), bytesRead)
+
+	// Bigger chunks are split into parts of the required size
+	chunk := bytes.Repeat([]byte("a"), 200)
+	expectInfo()
+	gomock.InOrder(
+		s3obj.EXPECT().UploadPart(NewUploadPartInputMatcher(&s3.UploadPartInput{
+			Bucket:     aws.String("bucket"),
+			Key:        aws.String("uploadId"),
+			UploadId:   aws.String("multipartId"),
+			PartNumber: aws.Int64(3),
+			Body:       bytes.NewReader(chunk[:100]),
+		})).Return(nil, nil),
+		s3obj.EXPECT().UploadPart(NewUploadPartInputMatcher(&s3.UploadPartInput{
+			Bucket:     aws.String("bucket"),
+			Key:        aws.String("uploadId"),
+			UploadId:   aws.String("multipartId"),
+			PartNumber: aws.Int64(4),
+			Body:       bytes.NewReader(chunk[100:]),
+		})).Return(nil, nil),
+	)
+
+	bytesRead, err = store.WriteChunk("uploadId+multipartId", 300, bytes.NewReader(chunk))
+	assert.Nil(err)
+	assert.Equal(int64(200), bytesRead)
+
+	// No further chunk is accepted once all parts have been used
+	store.MaxMultipartParts = 2
+	expectInfo()
+	bytesRead, err = store.WriteChunk("uploadId+multipartId", 300, bytes.NewReader(chunk))
+	assert.Equal(tusd.ErrChunkTooSmall, err)
+	assert.Equal(int64(0), bytesRead)
+}
+
+func TestNewUploadTooLarge(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	assert := assert.New(t)
+
+	s3obj := NewMockS3API(mockCtrl)
+	store := s3store.New("bucket", s3obj)
+	store.MaxMultipartParts = 2
+
+	// Two parts of at most 5GB cannot hold the upload
+	id, err := store.NewUpload(tusd.FileInfo{
+		ID:   "uploadId",
+		Size: 10*1024*1024*1024 + 1,
+	})
+	assert.Equal(tusd.ErrMaxSizeExceeded, err)
+	assert.Equal("", id)
+}
+
+func TestWriteChunkPartSizeLimit(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	assert := assert.New(t)
+
+	s3obj := NewMockS3API(mockCtrl)
+	store := s3store.New("bucket", s3obj)
+	store.MaxMultipartParts = 3
+
+	// The remaining 20GB would require parts bigger than 5GB
+	gomock.InOrder(
+		s3obj.EXPECT().GetObject(&s3.GetObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String("uploadId.info"),
+		}).Return(&s3.GetObjectOutput{
+			Body: ioutil.NopCloser(bytes.NewReader([]byte(`{"ID":"uploadId","Size":21474836480,"Offset":0,"MetaData":null,"IsPartial":false,"IsFinal":false,"PartialUploads":null}`))),
+		}, nil),
+		s3obj.EXPECT().ListParts(&s3.ListPartsInput{
+			Bucket:   aws.String("bucket"),
+			Key:      aws.String("uploadId"),
+			UploadId: aws.String("multipartId"),
+		}).Return(&s3.ListPartsOutput{
+			Parts: []*s3.Part{},
+		}, nil).Times(2),
+	)
+
+	bytesRead, err := store.WriteChunk("uploadId+multipartId", 0, bytes.NewReader([]byte("hello")))
+	assert.Equal(tusd.ErrMaxSizeExceeded, err)
+	assert.Equal(int64(0), bytesRead)
+}
+
 func TestTerminate(t *testing.T) {
 	mockCtrl := gomock.NewController(t)
 	defer mockCtrl.Finish()
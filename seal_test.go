@@ -0,0 +1,122 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+type sealStore struct {
+	*expirationStore
+}
+
+func (s sealStore) Seal(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := s.uploads[id]
+	info.Sealed = true
+	s.uploads[id] = info
+	return nil
+}
+
+func TestSeal(t *testing.T) {
+	a := assert.New(t)
+	store := sealStore{&expirationStore{
+		uploads: map[string]FileInfo{
+			"finished":   {ID: "finished", Size: 5, Offset: 5},
+			"unfinished": {ID: "unfinished", Size: 5, Offset: 2},
+		},
+	}}
+	handler, _ := NewHandler(Config{
+		DataStore: store,
+		BasePath:  "/files/",
+	})
+	seal := http.HandlerFunc(handler.Unrouted.SealFile)
+
+	(&httpTest{
+		Name:   "Missing Tus-Resumable header",
+		Method: "POST",
+		URL:    "finished/seal",
+		Code:   http.StatusPreconditionFailed,
+	}).Run(seal, t)
+	a.False(store.uploads["finished"].Sealed)
+
+	(&httpTest{
+		Name:   "Sealing unfinished upload",
+		Method: "POST",
+		URL:    "unfinished/seal",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: 425,
+	}).Run(seal, t)
+	a.False(store.uploads["unfinished"].Sealed)
+
+	(&httpTest{
+		Name:   "Sealing finished upload",
+		Method: "POST",
+		URL:    "finished/seal",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(seal, t)
+	a.True(store.uploads["finished"].Sealed)
+
+	(&httpTest{
+		Name:   "Modifying sealed upload",
+		Method: "PATCH",
+		URL:    "finished",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusForbidden,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Terminating sealed upload",
+		Method: "DELETE",
+		URL:    "finished",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusForbidden,
+	}).Run(handler, t)
+	a.Contains(store.uploads, "finished")
+
+	(&httpTest{
+		Name:   "Terminating unsealed upload",
+		Method: "DELETE",
+		URL:    "unfinished",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	// Termination may be allowed explicitly
+	handler, _ = NewHandler(Config{
+		DataStore:              store,
+		BasePath:               "/files/",
+		TerminateSealedUploads: true,
+	})
+
+	(&httpTest{
+		Name:   "Terminating sealed upload if allowed",
+		Method: "DELETE",
+		URL:    "finished",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+	a.Equal([]string{"unfinished", "finished"}, store.terminated)
+}
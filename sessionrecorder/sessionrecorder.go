@@ -0,0 +1,306 @@
+// Package sessionrecorder captures the protocol operations of upload sessions
+// and replays them for reproducing client bugs.
+//
+// Recorder is a middleware which is wrapped around a tusd handler. For every
+// request, it emits an Event containing the method, the protocol headers of
+// the request and the response, the response's status and the number of bytes
+// read from the body. The bodies themselves are never stored, so the
+// recordings do not contain any of the uploaded data:
+//
+//	handler, err := tusd.NewHandler(config)
+//	sink := sessionrecorder.NewJSONSink(file)
+//	http.Handle("/files/", http.StripPrefix("/files/", sessionrecorder.New(handler, sink)))
+//
+// A recording, e.g. the events of a single upload obtained using ReadEvents
+// and Filter, can be replayed against a test server using Replay. The bodies
+// are substituted by zeros of the recorded size, so the replayed session
+// exercises the same offsets and sizes as the original one. Checksums are
+// recorded for diagnosis but not replayed.
+package sessionrecorder
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaders lists the names of the request and response headers which
+// are recorded if New is used. Other headers, e.g. cookies and credentials,
+// are omitted.
+var DefaultHeaders = []string{
+	"Tus-Resumable",
+	"Upload-Offset",
+	"Upload-Length",
+	"Upload-Defer-Length",
+	"Upload-Metadata",
+	"Upload-Concat",
+	"Upload-Checksum",
+	"Upload-Expires",
+	"Content-Type",
+	"Location",
+	"Range",
+	"X-HTTP-Method-Override",
+}
+
+// Event describes a single request and its response.
+type Event struct {
+	Time time.Time `json:"time"`
+	// UploadID is the ID of the upload the request is addressing. For POST
+	// requests, it is taken from the Location header of the response.
+	UploadID string `json:"upload_id,omitempty"`
+	Method   string `json:"method"`
+	// ContentLength is the length of the request's body as announced by the
+	// client or -1 if it is unknown.
+	ContentLength int64 `json:"content_length"`
+	// BodySize is the number of bytes which have actually been read from the
+	// request's body. It is less than ContentLength if the client has been
+	// interrupted or the body has been rejected.
+	BodySize       int64             `json:"body_size"`
+	RequestHeader  map[string]string `json:"request_header,omitempty"`
+	Status         int               `json:"status"`
+	ResponseHeader map[string]string `json:"response_header,omitempty"`
+}
+
+// Sink receives the recorded events. It must be safe for concurrent use.
+type Sink interface {
+	Record(event Event)
+}
+
+// SinkFunc is an adapter allowing to use an ordinary function as Sink.
+type SinkFunc func(event Event)
+
+// Record calls f(event).
+func (f SinkFunc) Record(event Event) {
+	f(event)
+}
+
+// JSONSink writes the events to a writer, one JSON object per line. The
+// events can be read again using ReadEvents.
+type JSONSink struct {
+	encoder *json.Encoder
+	err     error
+	mutex   sync.Mutex
+}
+
+// NewJSONSink creates a new sink writing to w.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Record writes the event. After the first error, no more events are written.
+func (sink *JSONSink) Record(event Event) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if sink.err == nil {
+		sink.err = sink.encoder.Encode(event)
+	}
+}
+
+// Err returns the first error which occurred while writing the events.
+func (sink *JSONSink) Err() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	return sink.err
+}
+
+// ReadEvents reads the events written by a JSONSink.
+func ReadEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	decoder := json.NewDecoder(r)
+	for {
+		var event Event
+		if err := decoder.Decode(&event); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
+
+// Filter returns the events addressing the upload, in their original order.
+func Filter(events []Event, id string) []Event {
+	var filtered []Event
+	for _, event := range events {
+		if event.UploadID == id {
+			filtered = append(filtered, event)
+		}
+	}
+	return filtered
+}
+
+// Recorder is a http.Handler recording the requests passed to the wrapped
+// handler.
+type Recorder struct {
+	Handler http.Handler
+	Sink    Sink
+	// Headers lists the names of the request and response headers which are
+	// recorded.
+	Headers []string
+}
+
+// New creates a new recorder around the handler, sending the events to sink.
+func New(handler http.Handler, sink Sink) *Recorder {
+	return &Recorder{
+		Handler: handler,
+		Sink:    sink,
+		Headers: DefaultHeaders,
+	}
+}
+
+// ServeHTTP passes the request to the wrapped handler and records it once the
+// handler has returned.
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := Event{
+		Time:          time.Now(),
+		Method:        r.Method,
+		ContentLength: r.ContentLength,
+		RequestHeader: rec.selectHeaders(r.Header),
+	}
+
+	body := &countingReader{reader: r.Body}
+	if r.Body != nil {
+		r.Body = body
+	}
+	writer := &statusWriter{ResponseWriter: w}
+
+	rec.Handler.ServeHTTP(writer, r)
+
+	event.BodySize = body.n
+	event.Status = writer.status
+	if event.Status == 0 {
+		event.Status = http.StatusOK
+	}
+	event.ResponseHeader = rec.selectHeaders(w.Header())
+
+	switch r.Method {
+	case "POST":
+		if location := w.Header().Get("Location"); location != "" {
+			event.UploadID = path.Base(location)
+		}
+	case "OPTIONS":
+	default:
+		event.UploadID = path.Base(r.URL.Path)
+	}
+
+	rec.Sink.Record(event)
+}
+
+func (rec *Recorder) selectHeaders(header http.Header) map[string]string {
+	selected := make(map[string]string)
+	for _, name := range rec.Headers {
+		if value := header.Get(name); value != "" {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// Replay sends the requests of the events to the handler, one after another,
+// and returns the events recorded for the replayed requests. Their URLs are
+// constructed by appending the upload IDs to basePath, which must therefore
+// end with a slash. The bodies consist of BodySize zeros. Therefore the
+// recorded Upload-Checksum headers are not sent since they would not match.
+//
+// Since the handler assigns new IDs to the created uploads, the IDs of
+// uploads created by replayed POST requests are substituted in the URLs and
+// headers of the subsequent requests. The returned events contain the new IDs.
+func Replay(handler http.Handler, basePath string, events []Event) []Event {
+	var replayed []Event
+	rec := New(handler, SinkFunc(func(event Event) {
+		replayed = append(replayed, event)
+	}))
+
+	ids := make(map[string]string)
+	replaceIDs := func(s string) string {
+		for recordedID, replayedID := range ids {
+			s = strings.Replace(s, recordedID, replayedID, -1)
+		}
+		return s
+	}
+
+	for _, event := range events {
+		url := basePath
+		if event.Method != "POST" && event.Method != "OPTIONS" {
+			url += replaceIDs(event.UploadID)
+		}
+
+		req, err := http.NewRequest(event.Method, url, io.LimitReader(zeroReader{}, event.BodySize))
+		if err != nil {
+			continue
+		}
+		req.ContentLength = event.ContentLength
+		for name, value := range event.RequestHeader {
+			req.Header.Set(name, replaceIDs(value))
+		}
+		req.Header.Del("Upload-Checksum")
+		if event.ContentLength >= 0 {
+			req.Header.Set("Content-Length", strconv.FormatInt(event.ContentLength, 10))
+		}
+
+		rec.ServeHTTP(httptest.NewRecorder(), req)
+
+		if event.Method == "POST" && event.UploadID != "" {
+			if replayedID := replayed[len(replayed)-1].UploadID; replayedID != "" {
+				ids[event.UploadID] = replayedID
+			}
+		}
+	}
+
+	return replayed
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	reader io.ReadCloser
+	n      int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *countingReader) Close() error {
+	return r.reader.Close()
+}
+
+// statusWriter remembers the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
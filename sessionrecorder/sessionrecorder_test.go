@@ -0,0 +1,130 @@
+package sessionrecorder
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+	"github.com/tus/tusd/filestore"
+)
+
+var _ Sink = &JSONSink{}
+
+func newHandler(t *testing.T) (http.Handler, func()) {
+	dir, err := ioutil.TempDir("", "tusd-sessionrecorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler, err := tusd.NewHandler(tusd.Config{
+		BasePath:  "/files/",
+		DataStore: filestore.New(dir),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return http.StripPrefix("/files/", handler), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	a := assert.New(t)
+
+	handler, cleanup := newHandler(t)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	sink := NewJSONSink(&buf)
+	recorder := New(handler, sink)
+
+	send := func(method string, url string, body string, header map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		req.Host = "tus.io"
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		req.Header.Set("Authorization", "secret")
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		recorder.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("POST", "/files/", "", map[string]string{
+		"Upload-Length": "10",
+	})
+	a.Equal(http.StatusCreated, w.Code)
+	url := "/files/" + w.HeaderMap.Get("Location")[len("http://tus.io/files/"):]
+
+	patch := func(offset string, body string, checksum string) {
+		header := map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": offset,
+		}
+		if checksum != "" {
+			header["Upload-Checksum"] = checksum
+		}
+		send("PATCH", url, body, header)
+	}
+	patch("0", "hello", "")
+	patch("3", "lo", "")
+	send("HEAD", url, "", nil)
+	patch("5", "world", "sha1 fCEUM/AgcVl3Qeb/Wo6jR4mrv0M=")
+
+	a.NoError(sink.Err())
+	events, err := ReadEvents(&buf)
+	a.NoError(err)
+	a.Len(events, 5)
+
+	id := events[0].UploadID
+	a.NotEmpty(id)
+	a.Len(Filter(events, id), 5)
+	a.Empty(Filter(events, "other"))
+
+	statuses := []int{
+		http.StatusCreated,
+		http.StatusNoContent,
+		http.StatusConflict,
+		http.StatusNoContent,
+		http.StatusNoContent,
+	}
+	for i, event := range events {
+		a.Equal(statuses[i], event.Status)
+		a.NotContains(event.RequestHeader, "Authorization")
+	}
+	a.Equal("10", events[0].RequestHeader["Upload-Length"])
+	a.Equal(int64(5), events[1].BodySize)
+	a.Equal(int64(2), events[2].ContentLength)
+	a.Equal(int64(0), events[2].BodySize)
+	a.Equal("5", events[3].ResponseHeader["Upload-Offset"])
+	a.Equal("10", events[4].ResponseHeader["Upload-Offset"])
+	a.Equal("sha1 fCEUM/AgcVl3Qeb/Wo6jR4mrv0M=", events[4].RequestHeader["Upload-Checksum"])
+
+	// Replaying the session against another server yields the same responses
+	// for a new upload
+	replayHandler, replayCleanup := newHandler(t)
+	defer replayCleanup()
+
+	for i := 0; i < 2; i++ {
+		replayed := Replay(replayHandler, "/files/", events)
+		a.Len(replayed, 5)
+
+		a.NotEqual(id, replayed[0].UploadID)
+		for j, event := range replayed {
+			a.Equal(replayed[0].UploadID, event.UploadID)
+			a.Equal(events[j].Method, event.Method)
+			a.Equal(events[j].Status, event.Status)
+			a.Equal(events[j].BodySize, event.BodySize)
+			a.Equal(events[j].ResponseHeader["Upload-Offset"], event.ResponseHeader["Upload-Offset"])
+		}
+	}
+}
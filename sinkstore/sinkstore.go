@@ -0,0 +1,173 @@
+// Package sinkstore provides a storage backend which streams the uploaded data
+// into writers instead of storing it.
+//
+// SinkStore is intended for pipelines which process the data immediately and
+// do not need it to be persisted, for example by passing it straight to a
+// transcoder. For every new upload, a writer is obtained from a user-provided
+// factory and the received chunks are written to it in order. Once the upload
+// is finished, the writer is closed. This allows tusd to be used as a
+// resumable front-end for streaming consumers.
+//
+// Since the data is not retained, the information about the uploads, such as
+// their offsets, is only kept in memory. Uploads can be resumed as long as the
+// process is alive, but are lost once it exits. In addition, the data cannot
+// be read again, so the GET route is not supported.
+//
+// No locking mechanism is provided, so it is recommended to wrap the store
+// using the memorylocker package.
+package sinkstore
+
+import (
+	"io"
+	"sync"
+
+	"github.com/tus/tusd"
+	"github.com/tus/tusd/uid"
+)
+
+// Factory returns the writer into which the data of the new upload described
+// by the info is streamed.
+type Factory func(info tusd.FileInfo) (io.WriteCloser, error)
+
+// sink holds the information and writer of a single upload. The writer is nil
+// once the upload has been finished or terminated.
+type sink struct {
+	info   tusd.FileInfo
+	writer io.WriteCloser
+}
+
+// See the tusd.DataStore interface for documentation about the different
+// methods.
+type SinkStore struct {
+	// Factory is invoked for obtaining the writer of each new upload.
+	Factory Factory
+
+	sinks map[string]*sink
+	mutex *sync.Mutex
+}
+
+// New creates a new sink store which obtains the writers for new uploads from
+// the provided factory.
+func New(factory Factory) *SinkStore {
+	return &SinkStore{
+		Factory: factory,
+		sinks:   make(map[string]*sink),
+		mutex:   new(sync.Mutex),
+	}
+}
+
+func (store *SinkStore) NewUpload(info tusd.FileInfo) (string, error) {
+	if info.ID == "" {
+		info.ID = uid.Uid()
+	}
+
+	// Reserve the ID before obtaining the writer, so an existing upload with
+	// the same ID is never replaced
+	store.mutex.Lock()
+	if _, ok := store.sinks[info.ID]; ok {
+		store.mutex.Unlock()
+		return "", tusd.ErrUploadIDCollision
+	}
+	s := &sink{
+		info: info,
+	}
+	store.sinks[info.ID] = s
+	store.mutex.Unlock()
+
+	writer, err := store.Factory(info)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if err != nil {
+		delete(store.sinks, info.ID)
+		return "", err
+	}
+	s.writer = writer
+
+	return info.ID, nil
+}
+
+func (store *SinkStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	s, err := store.get(id)
+	if err != nil {
+		return 0, err
+	}
+
+	store.mutex.Lock()
+	writer := s.writer
+	current := s.info.Offset
+	store.mutex.Unlock()
+
+	// The data cannot be rewritten once it has been passed to the writer
+	if writer == nil || offset != current {
+		return 0, tusd.ErrMismatchOffset
+	}
+
+	n, err := io.Copy(writer, src)
+
+	store.mutex.Lock()
+	s.info.Offset += n
+	store.mutex.Unlock()
+
+	return n, err
+}
+
+func (store *SinkStore) GetInfo(id string) (tusd.FileInfo, error) {
+	s, err := store.get(id)
+	if err != nil {
+		return tusd.FileInfo{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return s.info, nil
+}
+
+// FinishUpload closes the upload's writer.
+func (store *SinkStore) FinishUpload(id string) error {
+	return store.close(id, false)
+}
+
+// Terminate closes the upload's writer and forgets the upload.
+func (store *SinkStore) Terminate(id string) error {
+	return store.close(id, true)
+}
+
+// get returns the sink of an upload or tusd.ErrNotFound if it does not exist.
+func (store *SinkStore) get(id string) (*sink, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	s, ok := store.sinks[id]
+	if !ok {
+		return nil, tusd.ErrNotFound
+	}
+
+	return s, nil
+}
+
+// close closes the writer of the upload, if it has not been closed yet, and
+// optionally removes the upload.
+func (store *SinkStore) close(id string, remove bool) error {
+	store.mutex.Lock()
+	s, ok := store.sinks[id]
+	if !ok {
+		store.mutex.Unlock()
+		return tusd.ErrNotFound
+	}
+
+	writer := s.writer
+	s.writer = nil
+	if remove {
+		delete(store.sinks, id)
+	}
+	store.mutex.Unlock()
+
+	if writer == nil {
+		return nil
+	}
+
+	return writer.Close()
+}
@@ -0,0 +1,151 @@
+package sinkstore
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+var _ tusd.DataStore = &SinkStore{}
+var _ tusd.FinisherDataStore = &SinkStore{}
+var _ tusd.TerminaterDataStore = &SinkStore{}
+
+// bufferSink is a buffer-backed writer which records whether it was closed.
+type bufferSink struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (sink *bufferSink) Close() error {
+	sink.closed = true
+	return nil
+}
+
+func TestSinkStore(t *testing.T) {
+	a := assert.New(t)
+
+	var sinks []*bufferSink
+	store := New(func(info tusd.FileInfo) (io.WriteCloser, error) {
+		a.EqualValues(11, info.Size)
+		a.Equal("world", info.MetaData["hello"])
+
+		sink := &bufferSink{}
+		sinks = append(sinks, sink)
+		return sink, nil
+	})
+
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size: 11,
+		MetaData: map[string]string{
+			"hello": "world",
+		},
+	})
+	a.NoError(err)
+	a.NotEqual("", id)
+	a.Len(sinks, 1)
+
+	n, err := store.WriteChunk(id, 0, strings.NewReader("hello "))
+	a.NoError(err)
+	a.EqualValues(6, n)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.Equal(id, info.ID)
+	a.EqualValues(6, info.Offset)
+
+	// Data which has been streamed already cannot be written again
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello "))
+	a.Equal(tusd.ErrMismatchOffset, err)
+
+	n, err = store.WriteChunk(id, 6, strings.NewReader("world"))
+	a.NoError(err)
+	a.EqualValues(5, n)
+
+	a.False(sinks[0].closed)
+	a.NoError(store.FinishUpload(id))
+	a.True(sinks[0].closed)
+	a.Equal("hello world", sinks[0].String())
+
+	info, err = store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(11, info.Offset)
+
+	a.NoError(store.Terminate(id))
+	_, err = store.GetInfo(id)
+	a.Equal(tusd.ErrNotFound, err)
+}
+
+func TestSinkStoreTerminate(t *testing.T) {
+	a := assert.New(t)
+
+	sink := &bufferSink{}
+	store := New(func(info tusd.FileInfo) (io.WriteCloser, error) {
+		return sink, nil
+	})
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hello"))
+	a.NoError(err)
+
+	a.NoError(store.Terminate(id))
+	a.True(sink.closed)
+
+	_, err = store.WriteChunk(id, 5, strings.NewReader("world"))
+	a.Equal(tusd.ErrNotFound, err)
+	a.Equal(tusd.ErrNotFound, store.Terminate(id))
+}
+
+func TestSinkStoreFactoryError(t *testing.T) {
+	a := assert.New(t)
+
+	store := New(func(info tusd.FileInfo) (io.WriteCloser, error) {
+		return nil, errors.New("transcoder unavailable")
+	})
+
+	_, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.EqualError(err, "transcoder unavailable")
+}
+
+func TestSinkStoreCollision(t *testing.T) {
+	a := assert.New(t)
+
+	var sinks []*bufferSink
+	store := New(func(info tusd.FileInfo) (io.WriteCloser, error) {
+		sink := &bufferSink{}
+		sinks = append(sinks, sink)
+		return sink, nil
+	})
+
+	id, err := store.NewUpload(tusd.FileInfo{ID: "taken", Size: 5})
+	a.NoError(err)
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hel"))
+	a.NoError(err)
+
+	// A second upload with the same ID must neither replace the first one nor
+	// obtain a writer
+	_, err = store.NewUpload(tusd.FileInfo{ID: "taken", Size: 100})
+	a.Equal(tusd.ErrUploadIDCollision, err)
+	a.Len(sinks, 1)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(5, info.Size)
+	a.EqualValues(3, info.Offset)
+
+	// The ID is not reserved if the factory fails
+	store.Factory = func(info tusd.FileInfo) (io.WriteCloser, error) {
+		return nil, errors.New("transcoder unavailable")
+	}
+	_, err = store.NewUpload(tusd.FileInfo{ID: "failed", Size: 5})
+	a.Error(err)
+	_, err = store.GetInfo("failed")
+	a.Equal(tusd.ErrNotFound, err)
+}
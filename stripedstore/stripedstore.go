@@ -0,0 +1,355 @@
+// Package stripedstore provides a storage backend which distributes the data
+// of a single upload over multiple directories.
+//
+// StripedStore works similar to the filestore package but splits the uploaded
+// data into stripes of a fixed size which are assigned to the directories in
+// a round-robin fashion, comparable to RAID 0. If these directories are
+// located on different disks, the I/O load of a single upload is spread over
+// them without the need for a volume manager.
+//
+// The `[id].info` files are stored in the first directory and contain the
+// fileinfo in addition to the stripe layout (the directories and the stripe
+// size) used when the upload was created. Therefore, changing the layout of
+// the store does not affect existing uploads as long as the first directory
+// stays the same. Each directory contains a `[id].bin` file holding the
+// stripes assigned to it.
+//
+// No cleanup is performed so you may want to run a cronjob to ensure your disks
+// are not filled up with old and finished uploads. In addition, no locking
+// mechanism is provided, so it is recommended to wrap the store using the
+// memorylocker package.
+package stripedstore
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/tus/tusd"
+	"github.com/tus/tusd/uid"
+)
+
+var defaultFilePerm = os.FileMode(0775)
+
+// See the tusd.DataStore interface for documentation about the different
+// methods.
+type StripedStore struct {
+	// Relative or absolute paths of the directories to distribute the data
+	// over. The info files are stored in the first one. StripedStore does not
+	// check whether the paths exist, use os.MkdirAll in this case on your own.
+	Paths []string
+	// StripeSize defines how many consecutive bytes of an upload are stored in
+	// the same directory before continuing with the next one.
+	StripeSize int64
+	// FileMode defines the permission bits of the files created for uploads.
+	// It is applied explicitly after creating a file, so the umask of the
+	// process does not affect it. If zero, the files are created using 0775
+	// restricted by the umask.
+	FileMode os.FileMode
+}
+
+// New creates a new striped storage backend using the provided directories
+// and stripe size. This method does not check whether the paths exist, use
+// os.MkdirAll to ensure.
+func New(paths []string, stripeSize int64) StripedStore {
+	return StripedStore{
+		Paths:      paths,
+		StripeSize: stripeSize,
+	}
+}
+
+// stripedInfo is the content of the .info files. In addition to the fileinfo,
+// it contains the stripe layout used for storing the upload's data.
+type stripedInfo struct {
+	Info       tusd.FileInfo
+	Paths      []string
+	StripeSize int64
+}
+
+func (store StripedStore) NewUpload(info tusd.FileInfo) (id string, err error) {
+	if len(store.Paths) == 0 || store.StripeSize <= 0 {
+		return "", errors.New("stripedstore: at least one path and a positive stripe size are required")
+	}
+
+	if id = info.ID; id == "" {
+		id = uid.Uid()
+	}
+	info.ID = id
+
+	layout := stripedInfo{
+		Info:       info,
+		Paths:      store.Paths,
+		StripeSize: store.StripeSize,
+	}
+
+	// Create .bin files with no content. They are created exclusively, so an
+	// existing upload with the same ID is never overwritten.
+	for i, path := range layout.Paths {
+		file, err := os.OpenFile(binPath(path, id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+		if err == nil {
+			err = store.chmod(file.Name())
+			file.Close()
+		}
+		if err != nil {
+			created := layout.Paths[:i]
+			if !os.IsExist(err) {
+				created = layout.Paths[:i+1]
+			}
+			for _, path := range created {
+				os.Remove(binPath(path, id))
+			}
+			if os.IsExist(err) {
+				return "", tusd.ErrUploadIDCollision
+			}
+			return "", err
+		}
+	}
+
+	err = store.writeInfo(id, layout)
+	return
+}
+
+func (store StripedStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return 0, err
+	}
+
+	numPaths := int64(len(layout.Paths))
+	bytesWritten := int64(0)
+
+	for {
+		stripe := offset / layout.StripeSize
+		stripeOffset := offset % layout.StripeSize
+		path := layout.Paths[stripe%numPaths]
+
+		file, err := os.OpenFile(binPath(path, id), os.O_WRONLY, defaultFilePerm)
+		if err != nil {
+			return bytesWritten, err
+		}
+
+		// Position inside the .bin file of this directory, which contains every
+		// numPaths-th stripe
+		position := (stripe/numPaths)*layout.StripeSize + stripeOffset
+		if _, err := file.Seek(position, 0); err != nil {
+			file.Close()
+			return bytesWritten, err
+		}
+
+		remaining := layout.StripeSize - stripeOffset
+		n, err := io.Copy(file, io.LimitReader(src, remaining))
+		file.Close()
+
+		bytesWritten += n
+		offset += n
+
+		if err != nil || n < remaining {
+			return bytesWritten, err
+		}
+	}
+}
+
+func (store StripedStore) GetInfo(id string) (tusd.FileInfo, error) {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return tusd.FileInfo{}, err
+	}
+
+	info := layout.Info
+	info.Offset, err = offset(id, layout)
+	return info, err
+}
+
+func (store StripedStore) Describe() string {
+	return "stripedstore/1"
+}
+
+func (store StripedStore) GetReader(id string) (io.Reader, error) {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := offset(id, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &stripeReader{
+		stripeSize: layout.StripeSize,
+		size:       size,
+		files:      make([]*os.File, 0, len(layout.Paths)),
+	}
+
+	for _, path := range layout.Paths {
+		file, err := os.Open(binPath(path, id))
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+
+		reader.files = append(reader.files, file)
+	}
+
+	return reader, nil
+}
+
+func (store StripedStore) Terminate(id string) error {
+	layout, err := store.readInfo(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(store.infoPath(id)); err != nil {
+		return err
+	}
+
+	for _, path := range layout.Paths {
+		if err := os.Remove(binPath(path, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// offset returns the number of bytes stored for the upload. Since the stripes
+// are written in order, it is the sum of the sizes of all .bin files.
+func offset(id string, layout stripedInfo) (int64, error) {
+	offset := int64(0)
+	for _, path := range layout.Paths {
+		stat, err := os.Stat(binPath(path, id))
+		if err != nil {
+			return 0, err
+		}
+
+		offset += stat.Size()
+	}
+
+	return offset, nil
+}
+
+// binPath returns the path to the .bin file storing the stripes which are
+// assigned to the given directory.
+func binPath(path string, id string) string {
+	return path + "/" + id + ".bin"
+}
+
+// infoPath returns the path to the .info file storing the file's info.
+func (store StripedStore) infoPath(id string) string {
+	return store.Paths[0] + "/" + id + ".info"
+}
+
+// writeInfo updates the entire information. Everything will be overwritten.
+// The info is written to a temporary file which is renamed afterwards, so a
+// crash while writing never leaves a truncated info file behind.
+func (store StripedStore) writeInfo(id string, layout stripedInfo) error {
+	data, err := json.Marshal(layout)
+	if err != nil {
+		return err
+	}
+
+	// The temporary file is created exclusively using a random name, so
+	// concurrent writers do not interfere
+	file, err := os.OpenFile(store.infoPath(id)+".tmp"+uid.Uid(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, store.perm())
+	if err != nil {
+		return err
+	}
+
+	err = store.chmod(file.Name())
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), store.infoPath(id))
+	}
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// perm returns the mode used for creating files, see FileMode.
+func (store StripedStore) perm() os.FileMode {
+	if store.FileMode == 0 {
+		return defaultFilePerm
+	}
+	return store.FileMode
+}
+
+// chmod applies the configured FileMode to a newly created file, if set.
+func (store StripedStore) chmod(path string) error {
+	if store.FileMode == 0 {
+		return nil
+	}
+	return os.Chmod(path, store.FileMode)
+}
+
+// readInfo reads the fileinfo and stripe layout from the .info file.
+func (store StripedStore) readInfo(id string) (stripedInfo, error) {
+	layout := stripedInfo{}
+	data, err := ioutil.ReadFile(store.infoPath(id))
+	if err != nil {
+		return layout, err
+	}
+
+	err = json.Unmarshal(data, &layout)
+	return layout, err
+}
+
+// stripeReader reassembles the stripes stored in multiple files into the
+// contiguous content of an upload.
+type stripeReader struct {
+	files      []*os.File
+	stripeSize int64
+	size       int64
+	offset     int64
+}
+
+func (reader *stripeReader) Read(p []byte) (int, error) {
+	if reader.offset >= reader.size {
+		return 0, io.EOF
+	}
+
+	numFiles := int64(len(reader.files))
+	stripe := reader.offset / reader.stripeSize
+	stripeOffset := reader.offset % reader.stripeSize
+
+	// Do not read beyond the end of the current stripe or the upload
+	length := int64(len(p))
+	if remaining := reader.stripeSize - stripeOffset; length > remaining {
+		length = remaining
+	}
+	if remaining := reader.size - reader.offset; length > remaining {
+		length = remaining
+	}
+
+	position := (stripe/numFiles)*reader.stripeSize + stripeOffset
+	n, err := reader.files[stripe%numFiles].ReadAt(p[:length], position)
+	reader.offset += int64(n)
+
+	// Reaching the end of a single file does not mean that the end of the
+	// upload has been reached.
+	if err == io.EOF && int64(n) == length {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (reader *stripeReader) Close() error {
+	var err error
+	for _, file := range reader.files {
+		if closeErr := file.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,155 @@
+package stripedstore
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/tus/tusd"
+)
+
+// Test interface implementation of StripedStore
+var _ tusd.DataStore = StripedStore{}
+var _ tusd.GetReaderDataStore = StripedStore{}
+var _ tusd.TerminaterDataStore = StripedStore{}
+var _ tusd.DescriberDataStore = StripedStore{}
+
+func TestStripedStore(t *testing.T) {
+	a := assert.New(t)
+
+	paths := make([]string, 3)
+	for i := range paths {
+		tmp, err := ioutil.TempDir("", "tusd-stripedstore-")
+		a.NoError(err)
+		paths[i] = tmp
+	}
+
+	store := New(paths, 4)
+
+	content := "abcdefghijklmnopqrstuvwxyz"
+
+	id, err := store.NewUpload(tusd.FileInfo{
+		Size: int64(len(content)),
+		MetaData: map[string]string{
+			"hello": "world",
+		},
+	})
+	a.NoError(err)
+	a.NotEqual("", id)
+
+	// Write chunks which are not aligned to the stripe size
+	offset := int64(0)
+	for _, chunk := range []string{"abcde", "fghijklmnopqr", "s", "tuvwxyz"} {
+		n, err := store.WriteChunk(id, offset, strings.NewReader(chunk))
+		a.NoError(err)
+		a.EqualValues(len(chunk), n)
+		offset += n
+	}
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(26, info.Size)
+	a.EqualValues(26, info.Offset)
+	a.Equal(tusd.MetaData{"hello": "world"}, info.MetaData)
+
+	// Every directory holds every third stripe
+	expected := []string{"abcdmnopyz", "efghqrst", "ijkluvwx"}
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path + "/" + id + ".bin")
+		a.NoError(err)
+		a.Equal(expected[i], string(data))
+	}
+
+	// The stripes are reassembled when reading
+	reader, err := store.GetReader(id)
+	a.NoError(err)
+
+	data, err := ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal(content, string(data))
+	a.NoError(reader.(io.Closer).Close())
+
+	// Changing the layout does not affect existing uploads
+	store.StripeSize = 7
+	reader, err = store.GetReader(id)
+	a.NoError(err)
+
+	data, err = ioutil.ReadAll(reader)
+	a.NoError(err)
+	a.Equal(content, string(data))
+	a.NoError(reader.(io.Closer).Close())
+
+	// Terminate upload
+	a.NoError(store.Terminate(id))
+
+	_, err = store.GetInfo(id)
+	a.True(os.IsNotExist(err))
+
+	for _, path := range paths {
+		_, err := os.Stat(path + "/" + id + ".bin")
+		a.True(os.IsNotExist(err))
+	}
+
+	// No temporary info files are left behind
+	for _, path := range paths {
+		files, err := ioutil.ReadDir(path)
+		a.NoError(err)
+		a.Empty(files)
+	}
+}
+
+func TestNewUploadCollision(t *testing.T) {
+	a := assert.New(t)
+
+	paths := make([]string, 2)
+	for i := range paths {
+		tmp, err := ioutil.TempDir("", "tusd-stripedstore-collision-")
+		a.NoError(err)
+		paths[i] = tmp
+	}
+
+	store := New(paths, 4)
+
+	id, err := store.NewUpload(tusd.FileInfo{ID: "taken", Size: 5})
+	a.NoError(err)
+	_, err = store.WriteChunk(id, 0, strings.NewReader("hel"))
+	a.NoError(err)
+
+	// A second upload with the same ID must not overwrite the first one
+	_, err = store.NewUpload(tusd.FileInfo{ID: "taken", Size: 100})
+	a.Equal(tusd.ErrUploadIDCollision, err)
+
+	info, err := store.GetInfo(id)
+	a.NoError(err)
+	a.EqualValues(5, info.Size)
+	a.EqualValues(3, info.Offset)
+}
+
+func TestFileMode(t *testing.T) {
+	a := assert.New(t)
+
+	paths := make([]string, 2)
+	for i := range paths {
+		tmp, err := ioutil.TempDir("", "tusd-stripedstore-mode-")
+		a.NoError(err)
+		paths[i] = tmp
+	}
+
+	// The mode is writable by everyone, so it is only kept if the umask is
+	// not applied
+	store := New(paths, 4)
+	store.FileMode = 0666
+
+	id, err := store.NewUpload(tusd.FileInfo{Size: 10})
+	a.NoError(err)
+
+	for _, path := range []string{binPath(paths[0], id), binPath(paths[1], id), store.infoPath(id)} {
+		stat, err := os.Stat(path)
+		a.NoError(err)
+		a.Equal(os.FileMode(0666), stat.Mode().Perm(), path)
+	}
+}
@@ -1,10 +1,16 @@
 package tusd_test
 
 import (
+	"errors"
 	"net/http"
+	"os"
+	"strconv"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+
 	. "github.com/tus/tusd"
+	"github.com/tus/tusd/limitedstore"
 )
 
 type terminateStore struct {
@@ -31,7 +37,7 @@ func TestTerminate(t *testing.T) {
 		Method: "OPTIONS",
 		URL:    "",
 		ResHeader: map[string]string{
-			"Tus-Extension": "creation,termination",
+			"Tus-Extension": "creation,creation-with-upload,termination,checksum",
 		},
 		Code: http.StatusNoContent,
 	}).Run(handler, t)
@@ -62,3 +68,194 @@ func TestTerminateNotImplemented(t *testing.T) {
 		Code: http.StatusMethodNotAllowed,
 	}).Run(handler, t)
 }
+
+type slowTerminateStore struct {
+	zeroStore
+	release chan struct{}
+}
+
+func (s slowTerminateStore) NewUpload(info FileInfo) (string, error) {
+	return "foo", nil
+}
+
+func (s slowTerminateStore) GetInfo(id string) (FileInfo, error) {
+	if id != "foo" {
+		return FileInfo{}, os.ErrNotExist
+	}
+	return FileInfo{ID: id, Size: 40}, nil
+}
+
+func (s slowTerminateStore) Terminate(id string) error {
+	<-s.release
+	return nil
+}
+
+func TestTerminateAsync(t *testing.T) {
+	release := make(chan struct{})
+	store := limitedstore.New(100, slowTerminateStore{
+		release: release,
+	})
+	handler, _ := NewHandler(Config{
+		DataStore:        store,
+		AsyncTermination: true,
+	})
+
+	if _, err := store.NewUpload(FileInfo{Size: 40}); err != nil {
+		t.Fatal(err)
+	}
+
+	(&httpTest{
+		Name:   "Accepted termination",
+		Method: "DELETE",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusAccepted,
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "Unknown upload",
+		Method: "DELETE",
+		URL:    "bar",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNotFound,
+	}).Run(handler, t)
+
+	// The space is only released once the upload has been terminated
+	if used := store.Used(); used != 40 {
+		t.Errorf("Expected 40 bytes to be used before termination but got %d", used)
+	}
+
+	close(release)
+	handler.Unrouted.WaitForTerminations()
+
+	if used := store.Used(); used != 0 {
+		t.Errorf("Expected no bytes to be used after termination but got %d", used)
+	}
+}
+
+type partsStore struct {
+	zeroStore
+	uploads map[string]FileInfo
+	failing string
+}
+
+func (s *partsStore) NewUpload(info FileInfo) (string, error) {
+	info.ID = strconv.Itoa(len(s.uploads))
+	s.uploads[info.ID] = info
+	return info.ID, nil
+}
+
+func (s *partsStore) GetInfo(id string) (FileInfo, error) {
+	info, ok := s.uploads[id]
+	if !ok {
+		return info, os.ErrNotExist
+	}
+	return info, nil
+}
+
+func (s *partsStore) Terminate(id string) error {
+	if id == s.failing {
+		return errors.New("disk on fire")
+	}
+	if _, ok := s.uploads[id]; !ok {
+		return os.ErrNotExist
+	}
+	delete(s.uploads, id)
+	return nil
+}
+
+func TestTerminateWithParts(t *testing.T) {
+	a := assert.New(t)
+	inner := &partsStore{
+		uploads: make(map[string]FileInfo),
+	}
+	store := limitedstore.New(100, inner)
+	handler, _ := NewHandler(Config{
+		DataStore:               store,
+		TerminatePartialUploads: true,
+	})
+
+	createFinal := func() string {
+		var parts []string
+		for i := 0; i < 2; i++ {
+			id, err := store.NewUpload(FileInfo{Size: 10, IsPartial: true})
+			a.NoError(err)
+			parts = append(parts, id)
+		}
+		id, err := store.NewUpload(FileInfo{Size: 20, IsFinal: true, PartialUploads: parts})
+		a.NoError(err)
+		return id
+	}
+
+	final := createFinal()
+	a.Equal(int64(40), store.Used())
+
+	(&httpTest{
+		Name:   "Terminate final upload with its parts",
+		Method: "DELETE",
+		URL:    final,
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	a.Empty(inner.uploads)
+	a.Equal(int64(0), store.Used())
+
+	// Parts which have already been removed are ignored while the others are
+	// reported
+	final = createFinal()
+	info := inner.uploads[final]
+	a.NoError(store.Terminate(info.PartialUploads[0]))
+	inner.failing = info.PartialUploads[1]
+
+	err := handler.Unrouted.TerminateWithParts(final)
+	if a.IsType(PartialTerminationError{}, err) {
+		a.Len(err.(PartialTerminationError).Errors, 1)
+		a.Contains(err.Error(), info.PartialUploads[1]+": disk on fire")
+	}
+	a.Len(inner.uploads, 1)
+	a.Equal(int64(10), store.Used())
+}
+
+func TestTerminatedUploads(t *testing.T) {
+	a := assert.New(t)
+	handler, _ := NewHandler(Config{
+		DataStore: &expirationStore{
+			uploads: map[string]FileInfo{
+				"foo": {
+					ID:       "foo",
+					Size:     10,
+					Offset:   5,
+					MetaData: map[string]string{"filename": "cat.jpg"},
+				},
+			},
+		},
+		NotifyTerminatedUploads: true,
+	})
+
+	(&httpTest{
+		Name:   "Terminate upload",
+		Method: "DELETE",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+	}).Run(handler, t)
+
+	select {
+	case info := <-handler.TerminatedUploads:
+		a.Equal("foo", info.ID)
+		a.Equal(int64(10), info.Size)
+		a.Equal(int64(5), info.Offset)
+		a.Equal("cat.jpg", info.MetaData["filename"])
+	default:
+		t.Fatal("Expected terminated upload to be sent")
+	}
+}
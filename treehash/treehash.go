@@ -0,0 +1,120 @@
+// Package treehash implements the SHA-256 tree hash used by Amazon Glacier for
+// verifying the integrity of archives.
+//
+// The data is divided into blocks of 1 MiB, whereas the last one may be
+// shorter, and the SHA-256 digest of each block is computed. Afterwards,
+// adjacent digests are concatenated in pairs and hashed again until a single
+// digest, the tree hash, is left. A digest without a partner is carried over
+// to the next level unchanged.
+//
+// See: http://docs.aws.amazon.com/amazonglacier/latest/dev/checksum-calculations.html
+package treehash
+
+import (
+	"crypto/sha256"
+	"hash"
+)
+
+// BlockSize is the size of the blocks whose digests form the tree's leaves.
+const BlockSize = 1024 * 1024
+
+// Hash computes the tree hash incrementally. Data may be written in pieces of
+// arbitrary sizes which do not need to be aligned to the block size. It
+// implements the hash.Hash interface.
+type Hash struct {
+	// leaves contains the digests of all completed blocks
+	leaves [][]byte
+	// block is the digest of the block which is currently being written
+	block hash.Hash
+	// blockLen is the number of bytes written to the current block
+	blockLen int
+	// length is the total number of bytes written
+	length int64
+}
+
+// New returns a new Hash computing the tree hash.
+func New() *Hash {
+	return &Hash{
+		block: sha256.New(),
+	}
+}
+
+// Write adds more data to the running hash. It never returns an error.
+func (h *Hash) Write(p []byte) (int, error) {
+	n := len(p)
+	h.length += int64(n)
+
+	for len(p) > 0 {
+		remaining := BlockSize - h.blockLen
+		if remaining > len(p) {
+			remaining = len(p)
+		}
+
+		h.block.Write(p[:remaining])
+		h.blockLen += remaining
+		p = p[remaining:]
+
+		if h.blockLen == BlockSize {
+			h.leaves = append(h.leaves, h.block.Sum(nil))
+			h.block.Reset()
+			h.blockLen = 0
+		}
+	}
+
+	return n, nil
+}
+
+// Sum appends the tree hash of the data written so far to b and returns the
+// resulting slice. It does not change the underlying hash state.
+func (h *Hash) Sum(b []byte) []byte {
+	level := make([][]byte, len(h.leaves), len(h.leaves)+1)
+	copy(level, h.leaves)
+
+	// The current block is only a leaf if it contains data or if nothing has
+	// been written at all, in which case the tree hash is the digest of the
+	// empty input.
+	if h.blockLen > 0 || len(level) == 0 {
+		level = append(level, h.block.Sum(nil))
+	}
+
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			pair := sha256.New()
+			pair.Write(level[i])
+			pair.Write(level[i+1])
+			next = append(next, pair.Sum(nil))
+		}
+		level = next
+	}
+
+	return append(b, level[0]...)
+}
+
+// Reset resets the Hash to its initial state.
+func (h *Hash) Reset() {
+	h.leaves = nil
+	h.block.Reset()
+	h.blockLen = 0
+	h.length = 0
+}
+
+// Size returns the number of bytes Sum will return.
+func (h *Hash) Size() int {
+	return sha256.Size
+}
+
+// BlockSize returns the hash's underlying block size.
+func (h *Hash) BlockSize() int {
+	return sha256.BlockSize
+}
+
+// Len returns the total number of bytes which have been written.
+func (h *Hash) Len() int64 {
+	return h.length
+}
@@ -0,0 +1,73 @@
+package treehash
+
+import (
+	"encoding/hex"
+	"hash"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var _ hash.Hash = New()
+
+// data returns a deterministic, non-repeating byte sequence of length n.
+func data(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte((i*7 + 3) % 251)
+	}
+	return p
+}
+
+// Vectors computed using the algorithm described in the Glacier documentation
+var vectors = []struct {
+	length int
+	hash   string
+}{
+	{0, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	{5, "c0a7188b4e87d64b5ff6dbedc69629b41ded38b08f0f79b85c5b63ed4a6b4646"},
+	{BlockSize, "1ac437f476c488acba4000af7ae89ef53f7ffbeef2e937850985f5ceb8b5ae6f"},
+	{2 * BlockSize, "c0f66951c231d05e177d8e69073fe588745ab5645ad279c93b4e85abee79ae5a"},
+	{3*BlockSize + BlockSize/2, "a615ba50edba04e140904767e069647dbb68881835122d8c2b2830170cdd81f1"},
+	{5 * BlockSize, "25cbfd0b6adcaeb034ddf2b9f63f377a7f67b403fae212cade0c422140cf288b"},
+}
+
+func TestVectors(t *testing.T) {
+	a := assert.New(t)
+
+	for _, vector := range vectors {
+		h := New()
+		h.Write(data(vector.length))
+		a.Equal(vector.hash, hex.EncodeToString(h.Sum(nil)), "length %d", vector.length)
+		a.EqualValues(vector.length, h.Len())
+	}
+}
+
+func TestUnalignedWrites(t *testing.T) {
+	a := assert.New(t)
+
+	for _, vector := range vectors {
+		input := data(vector.length)
+		h := New()
+
+		// Write pieces not aligned to the block size and check that computing
+		// the sum in between does not alter the state
+		for len(input) > 0 {
+			n := 300001
+			if n > len(input) {
+				n = len(input)
+			}
+			h.Write(input[:n])
+			input = input[n:]
+			h.Sum(nil)
+		}
+
+		a.Equal(vector.hash, hex.EncodeToString(h.Sum(nil)), "length %d", vector.length)
+	}
+
+	h := New()
+	h.Write(data(BlockSize + 1))
+	h.Reset()
+	a.Equal(vectors[0].hash, hex.EncodeToString(h.Sum(nil)))
+	a.EqualValues(0, h.Len())
+}
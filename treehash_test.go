@@ -0,0 +1,168 @@
+package tusd_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+	"github.com/tus/tusd/treehash"
+)
+
+type treeHashStore struct {
+	zeroStore
+	offset *int64
+	size   int64
+}
+
+func (s treeHashStore) GetInfo(id string) (FileInfo, error) {
+	return FileInfo{
+		ID:     id,
+		Offset: *s.offset,
+		Size:   s.size,
+	}, nil
+}
+
+func (s treeHashStore) WriteChunk(id string, offset int64, src io.Reader) (int64, error) {
+	n, err := io.Copy(ioutil.Discard, src)
+	*s.offset += n
+	return n, err
+}
+
+func TestTreeHash(t *testing.T) {
+	a := assert.New(t)
+
+	data := bytes.Repeat([]byte("tusd"), treehash.BlockSize/2)
+	expected := treehash.New()
+	expected.Write(data)
+	sum := hex.EncodeToString(expected.Sum(nil))
+
+	offset := int64(0)
+	handler, _ := NewHandler(Config{
+		DataStore: treeHashStore{
+			offset: &offset,
+			size:   int64(len(data)),
+		},
+		ComputeTreeHash: true,
+	})
+
+	// Split the data at a position which is not aligned to the block size
+	split := treehash.BlockSize + 12345
+
+	w := (&httpTest{
+		Name:   "First chunk",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: bytes.NewReader(data[:split]),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+	a.Equal("", w.HeaderMap.Get("Upload-Tree-Hash"))
+
+	(&httpTest{
+		Name:   "Final chunk",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(split),
+		},
+		ReqBody: bytes.NewReader(data[split:]),
+		Code:    http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Tree-Hash": sum,
+		},
+	}).Run(handler, t)
+
+	(&httpTest{
+		Name:   "HEAD request",
+		Method: "HEAD",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+		},
+		Code: http.StatusNoContent,
+		ResHeader: map[string]string{
+			"Upload-Tree-Hash": sum,
+		},
+	}).Run(handler, t)
+
+	hash, ok := handler.Unrouted.TreeHash("foo")
+	a.True(ok)
+	a.Equal(sum, hash)
+
+	// No hash is available if the upload has not been received from the
+	// beginning by this handler
+	offset = 5
+	handler, _ = NewHandler(Config{
+		DataStore: treeHashStore{
+			offset: &offset,
+			size:   10,
+		},
+		ComputeTreeHash: true,
+	})
+
+	w = (&httpTest{
+		Name:   "Resumed upload",
+		Method: "PATCH",
+		URL:    "bar",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "5",
+		},
+		ReqBody: bytes.NewReader([]byte("hello")),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+	a.Equal("", w.HeaderMap.Get("Upload-Tree-Hash"))
+
+	_, ok = handler.Unrouted.TreeHash("bar")
+	a.False(ok)
+}
+
+func TestTreeHashLimit(t *testing.T) {
+	a := assert.New(t)
+
+	offset := int64(0)
+	handler, _ := NewHandler(Config{
+		DataStore: treeHashStore{
+			offset: &offset,
+			size:   5,
+		},
+		ComputeTreeHash: true,
+		MaxTreeHashes:   1,
+	})
+
+	for _, id := range []string{"foo", "bar"} {
+		offset = 0
+		(&httpTest{
+			Name:   "Complete upload",
+			Method: "PATCH",
+			URL:    id,
+			ReqHeader: map[string]string{
+				"Tus-Resumable": "1.0.0",
+				"Content-Type":  "application/offset+octet-stream",
+				"Upload-Offset": "0",
+			},
+			ReqBody: bytes.NewReader([]byte("hello")),
+			Code:    http.StatusNoContent,
+		}).Run(handler, t)
+	}
+
+	// Only the hash of the most recently finished upload is kept
+	_, ok := handler.Unrouted.TreeHash("foo")
+	a.False(ok)
+	_, ok = handler.Unrouted.TreeHash("bar")
+	a.True(ok)
+}
@@ -1,95 +1,544 @@
 package tusd
 
 import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/tus/tusd/treehash"
 )
 
 var (
 	reExtractFileID  = regexp.MustCompile(`([^/]+)\/?$`)
 	reForwardedHost  = regexp.MustCompile(`host=([^,]+)`)
 	reForwardedProto = regexp.MustCompile(`proto=(https?)`)
+	reMetaDataKey    = regexp.MustCompile(`^[A-Za-z0-9_.\-]+$`)
+	reUploadID       = regexp.MustCompile(`^[A-Za-z0-9_~\-]+$`)
 )
 
 var (
-	ErrUnsupportedVersion  = errors.New("unsupported version")
-	ErrMaxSizeExceeded     = errors.New("maximum size exceeded")
-	ErrInvalidContentType  = errors.New("missing or invalid Content-Type header")
-	ErrInvalidUploadLength = errors.New("missing or invalid Upload-Length header")
-	ErrInvalidOffset       = errors.New("missing or invalid Upload-Offset header")
-	ErrNotFound            = errors.New("upload not found")
-	ErrFileLocked          = errors.New("file currently locked")
-	ErrMismatchOffset      = errors.New("mismatched offset")
-	ErrSizeExceeded        = errors.New("resource's size exceeded")
-	ErrNotImplemented      = errors.New("feature not implemented")
-	ErrUploadNotFinished   = errors.New("one of the partial uploads is not finished")
-	ErrInvalidConcat       = errors.New("invalid Upload-Concat header")
-	ErrModifyFinal         = errors.New("modifying a final upload is not allowed")
+	ErrUnsupportedVersion       = errors.New("unsupported version")
+	ErrMaxSizeExceeded          = errors.New("maximum size exceeded, see Tus-Max-Size header for the limit")
+	ErrInvalidContentType       = errors.New("missing or invalid Content-Type header")
+	ErrInvalidUploadLength      = errors.New("missing or invalid Upload-Length header")
+	ErrInvalidUploadDeferLength = errors.New("invalid Upload-Defer-Length header")
+	ErrUploadLengthAlreadySet   = errors.New("upload length has already been set")
+	ErrInvalidOffset            = errors.New("missing or invalid Upload-Offset header")
+	ErrNotFound                 = errors.New("upload not found")
+	ErrFileLocked               = errors.New("file currently locked")
+	ErrMismatchOffset           = errors.New("mismatched offset")
+	ErrSizeExceeded             = errors.New("resource's size exceeded")
+	ErrNotImplemented           = errors.New("feature not implemented")
+	ErrExtensionDisabled        = errors.New("extension not enabled")
+	ErrUploadNotFinished        = errors.New("one of the partial uploads is not finished")
+	ErrInvalidConcat            = errors.New("invalid Upload-Concat header")
+	ErrModifyFinal              = errors.New("modifying a final upload is not allowed")
+	ErrFinalWithData            = errors.New("final upload may not contain data")
+	ErrInvalidRange             = errors.New("requested range not satisfiable")
+	ErrInvalidMetaData          = errors.New("invalid Upload-Metadata header")
+	ErrUploadIncomplete         = errors.New("upload has not been finished yet")
+	ErrMetaDataMismatch         = errors.New("partial uploads have mismatching metadata")
+	ErrUploadInterrupted        = errors.New("write has been canceled by another request")
+	ErrNoActiveWrite            = errors.New("upload is not being written to")
+	ErrUploadFailed             = errors.New("upload has failed permanently")
+	ErrUploadsNotAccepted       = errors.New("new uploads are currently not accepted")
+	ErrInternal                 = errors.New("internal server error")
+	ErrConcurrencyLimit         = errors.New("too many concurrent uploads, retry later")
+	ErrStoreUnavailable         = errors.New("storage temporarily unavailable, retry later")
+	ErrNotEnoughSpace           = errors.New("not enough space since the remaining uploads are in use")
+	ErrChunkTooSmall            = errors.New("chunk too small, the remaining data must be sent in bigger chunks")
+	ErrHeaderTooLarge           = errors.New("request headers too large")
+	ErrUploadIDCollision        = errors.New("upload ID already exists")
+	ErrInvalidUploadID          = errors.New("generated upload ID is empty or contains reserved characters")
+	ErrUploadSealed             = errors.New("upload has been sealed and cannot be modified")
+	ErrDuplicateConcatPart      = errors.New("partial upload is referenced multiple times")
+	ErrInvalidManifest          = errors.New("invalid Upload-Chunk-Manifest header")
+	ErrManifestMismatch         = errors.New("chunk does not match the manifest")
+	ErrInvalidChecksum          = errors.New("invalid Upload-Checksum header")
+	ErrUnsupportedChecksum      = errors.New("unsupported checksum algorithm")
+	ErrChecksumMismatch         = errors.New("checksum mismatch")
+	ErrChecksumScope            = errors.New("checksum of the last chunk sent for a chunk which does not complete the upload")
+	ErrVerifiedChunkTooLarge    = errors.New("chunk is too large to be verified")
 )
 
 // HTTP status codes sent in the response when the specific error is returned.
 var ErrStatusCodes = map[error]int{
-	ErrUnsupportedVersion:  http.StatusPreconditionFailed,
-	ErrMaxSizeExceeded:     http.StatusRequestEntityTooLarge,
-	ErrInvalidContentType:  http.StatusBadRequest,
-	ErrInvalidUploadLength: http.StatusBadRequest,
-	ErrInvalidOffset:       http.StatusBadRequest,
-	ErrNotFound:            http.StatusNotFound,
-	ErrFileLocked:          423, // Locked (WebDAV) (RFC 4918)
-	ErrMismatchOffset:      http.StatusConflict,
-	ErrSizeExceeded:        http.StatusRequestEntityTooLarge,
-	ErrNotImplemented:      http.StatusNotImplemented,
-	ErrUploadNotFinished:   http.StatusBadRequest,
-	ErrInvalidConcat:       http.StatusBadRequest,
-	ErrModifyFinal:         http.StatusForbidden,
+	ErrUnsupportedVersion:       http.StatusPreconditionFailed,
+	ErrMaxSizeExceeded:          http.StatusRequestEntityTooLarge,
+	ErrInvalidContentType:       http.StatusBadRequest,
+	ErrInvalidUploadLength:      http.StatusBadRequest,
+	ErrInvalidUploadDeferLength: http.StatusBadRequest,
+	ErrUploadLengthAlreadySet:   http.StatusBadRequest,
+	ErrInvalidOffset:            http.StatusBadRequest,
+	ErrNotFound:                 http.StatusNotFound,
+	ErrFileLocked:               423, // Locked (WebDAV) (RFC 4918)
+	ErrMismatchOffset:           http.StatusConflict,
+	ErrSizeExceeded:             http.StatusRequestEntityTooLarge,
+	ErrNotImplemented:           http.StatusNotImplemented,
+	ErrExtensionDisabled:        http.StatusPreconditionFailed,
+	ErrUploadNotFinished:        http.StatusBadRequest,
+	ErrInvalidConcat:            http.StatusBadRequest,
+	ErrModifyFinal:              http.StatusForbidden,
+	ErrFinalWithData:            http.StatusBadRequest,
+	ErrInvalidRange:             http.StatusRequestedRangeNotSatisfiable,
+	ErrInvalidMetaData:          http.StatusBadRequest,
+	ErrUploadIncomplete:         425, // Too Early (RFC 8470)
+	ErrMetaDataMismatch:         http.StatusBadRequest,
+	ErrUploadInterrupted:        http.StatusBadRequest,
+	ErrNoActiveWrite:            http.StatusConflict,
+	ErrUploadFailed:             http.StatusGone,
+	ErrUploadsNotAccepted:       http.StatusServiceUnavailable,
+	ErrInternal:                 http.StatusInternalServerError,
+	ErrConcurrencyLimit:         http.StatusServiceUnavailable,
+	ErrStoreUnavailable:         http.StatusServiceUnavailable,
+	ErrNotEnoughSpace:           http.StatusRequestEntityTooLarge,
+	ErrChunkTooSmall:            http.StatusBadRequest,
+	ErrHeaderTooLarge:           http.StatusRequestHeaderFieldsTooLarge,
+	ErrUploadIDCollision:        http.StatusInternalServerError,
+	ErrInvalidUploadID:          http.StatusInternalServerError,
+	ErrUploadSealed:             http.StatusForbidden,
+	ErrDuplicateConcatPart:      http.StatusBadRequest,
+	ErrInvalidManifest:          http.StatusBadRequest,
+	ErrManifestMismatch:         460, // Checksum Mismatch (tus checksum extension)
+	ErrInvalidChecksum:          http.StatusBadRequest,
+	ErrUnsupportedChecksum:      http.StatusBadRequest,
+	ErrChecksumMismatch:         460, // Checksum Mismatch (tus checksum extension)
+	ErrChecksumScope:            http.StatusBadRequest,
+	ErrVerifiedChunkTooLarge:    http.StatusRequestEntityTooLarge,
 }
 
+// IncompleteDownloadBehavior defines how GET requests for uploads which have
+// not been finished yet are answered.
+type IncompleteDownloadBehavior int
+
+const (
+	// IncompleteDownloadPartialAllowed serves the bytes which have been
+	// received so far.
+	IncompleteDownloadPartialAllowed IncompleteDownloadBehavior = iota
+	// IncompleteDownloadNotFound responds with 404 Not Found as if the upload
+	// did not exist.
+	IncompleteDownloadNotFound
+	// IncompleteDownloadTooEarly responds with 425 Too Early.
+	IncompleteDownloadTooEarly
+)
+
 // Config provides a way to configure the Handler depending on your needs.
 type Config struct {
 	// DataStore implementation used to store and retrieve the single uploads.
-	// Must no be nil.
+	// Must no be nil unless StoreComposer is set.
 	DataStore DataStore
+	// StoreComposer optionally defines which optional interfaces are used,
+	// e.g. for wrapping another data store, see metricsstore. If set,
+	// DataStore is ignored and the composer's core is used instead. Otherwise
+	// the composer is created from DataStore using NewStoreComposer.
+	StoreComposer *StoreComposer
 	// MaxSize defines how many bytes may be stored in one single upload. If its
 	// value is is 0 or smaller no limit will be enforced.
 	MaxSize int64
+	// MaxSizeFunc optionally returns the maximum size of a single upload,
+	// overriding MaxSize, e.g. for granting paid users bigger uploads based on
+	// the metadata. It is invoked with the info of the upload to be created
+	// and again when the length of a deferred upload is declared. If it
+	// returns 0 or less, no limit will be enforced. The Tus-Max-Size header in
+	// responses to OPTIONS requests still reports MaxSize.
+	MaxSizeFunc func(info FileInfo) int64
+	// MaxHeaderBytes limits the total size of a request's headers, including
+	// their names, in bytes. Requests exceeding it are rejected with 431
+	// Request Header Fields Too Large before any header is processed. This is
+	// independent of the http.Server's MaxHeaderBytes, which may be set
+	// higher for other handlers. If zero, no limit is enforced.
+	MaxHeaderBytes int
 	// BasePath defines the URL path used for handling uploads, e.g. "/files/".
 	// If no trailing slash is presented it will be added. You may specify an
-	// absolute URL containing a scheme, e.g. "http://tus.io"
+	// absolute URL containing a scheme, e.g. "http://tus.io/files/".
+	// The Handler returned by NewHandler strips this path from the requests'
+	// URLs before routing them, so it can be mounted at any path, e.g. using
+	// http.Handle("/api/upload/", handler), without http.StripPrefix.
 	BasePath string
 	// Initiate the CompleteUploads channel in the Handler struct in order to
 	// be notified about complete uploads
 	NotifyCompleteUploads bool
-	// Logger the logger to use internally
+	// Initiate the CreatedUploads channel in the Handler struct in order to
+	// be notified about new uploads, e.g. for starting to scan them while they
+	// are being uploaded. Unlike CompleteUploads, the channel is buffered and
+	// infos which do not fit into its buffer are dropped, so a slow consumer
+	// does not block the creation of uploads.
+	NotifyCreatedUploads bool
+	// Initiate the TerminatedUploads channel in the Handler struct in order to
+	// be notified about uploads which have been terminated by the handler,
+	// e.g. using a DELETE request or CleanupExpiredUploads. As for
+	// CreatedUploads, the channel is buffered and infos which do not fit into
+	// its buffer are dropped. Uploads which are removed by the data store on
+	// its own, e.g. by a LimitedStore freeing space, are not included.
+	NotifyTerminatedUploads bool
+	// CompleteUploadsCallback is invoked for each finished upload. Unlike the
+	// CompleteUploads channel, the calls are made from a pool of worker
+	// goroutines, so a slow callback does not block the HTTP handlers until
+	// all workers are busy. The workers are stopped by UnroutedHandler.Close.
+	CompleteUploadsCallback func(FileInfo)
+	// CompleteUploadsWorkers defines the number of workers which invoke the
+	// CompleteUploadsCallback and therefore limits how many callbacks may be
+	// running at the same time. If its value is 0 or smaller, one worker is
+	// used.
+	CompleteUploadsWorkers int
+	// CompletionWebhook, if set, is used for sending the info of each finished
+	// upload to an external service. The notifications are delivered in the
+	// background and failures are logged.
+	CompletionWebhook *Webhook
+	// FirstChunkCallback is invoked once the first bytes of an upload have been
+	// written, i.e. its offset has advanced from zero. Comparing these calls to
+	// the created uploads tells which of them have actually been started
+	// instead of being abandoned right away. Since an upload's offset never
+	// returns to zero, it is invoked only once per upload, even if the upload
+	// is resumed afterwards. The calls are made in separate goroutines.
+	FirstChunkCallback func(FileInfo)
+	// Logger is used for reporting the handler's events, such as created,
+	// written and completed uploads, and internal errors. Each line contains
+	// the request's method and the upload's ID. It may be pointed at any
+	// writer in order to route the output into another logging pipeline.
+	// Defaults to a logger writing to os.Stderr.
 	Logger *log.Logger
 	// Respect the X-Forwarded-Host, X-Forwarded-Proto and Forwarded headers
 	// potentially set by proxies when generating an absolute URL in the
 	// reponse to POST requests.
 	RespectForwardedHeaders bool
+	// MetaDataKeyPattern defines which keys are allowed in the Upload-Metadata
+	// header. If nil, keys may only consist of ASCII letters, digits, dashes,
+	// underscores and dots.
+	MetaDataKeyPattern *regexp.Regexp
+	// MetaDataValuePattern defines which decoded values are allowed in the
+	// Upload-Metadata header. If nil, every value is accepted as long as it
+	// does not contain control characters, such as newlines or null bytes,
+	// which could be used for injecting headers or forging log entries.
+	MetaDataValuePattern *regexp.Regexp
+	// DefaultMetaData is merged into the metadata of every new upload, e.g.
+	// {"source": "web"}, allowing server-side defaults without changes to
+	// the clients. Keys provided by the client in the Upload-Metadata header
+	// take precedence over the defaults.
+	DefaultMetaData map[string]string
+	// PreUploadCreateCallback is invoked for every POST request after its
+	// headers have been validated but before the upload is created. Returning
+	// an error rejects the request with 400 Bad Request and the error's message
+	// as the body, e.g. if required metadata is missing. Since the info's
+	// MetaData map is passed to the data store afterwards, the callback may
+	// add server-side metadata to it. It is invoked for dry runs, too.
+	PreUploadCreateCallback func(info FileInfo) error
+	// ConcatMetaDataKeys lists the metadata keys whose values must be equal
+	// for all partial uploads which are concatenated into a final one, e.g.
+	// "filetype". This prevents accidentally combining parts of different
+	// files. If empty, the metadata of the partial uploads is not compared.
+	ConcatMetaDataKeys []string
+	// AllowDuplicateConcatParts allows a final upload to reference the same
+	// partial upload multiple times, in which case its data is included
+	// repeatedly in the given order. By default, such requests are rejected
+	// since they are usually caused by a mistake of the client.
+	AllowDuplicateConcatParts bool
+	// IncompleteDownloadBehavior controls the response to GET requests for
+	// uploads which have not been finished yet. By default, the bytes which
+	// have been received so far are served.
+	IncompleteDownloadBehavior IncompleteDownloadBehavior
+	// FinishUploadRetries defines how often the FinisherDataStore's
+	// FinishUpload method is retried if it fails, e.g. due to throttling by a
+	// remote storage. In this case, the upload is marked as pending and the
+	// PATCH request succeeds since all data has been received. The retries
+	// are performed in the background, so the upload is not locked while
+	// waiting for them. Pending uploads are reported using the
+	// Upload-Finish-Pending header in HEAD responses and, if all retries
+	// fail, can be finished later using RetryPendingFinishes. If its value is
+	// 0 or smaller, the upload is not retried and the error is returned to the
+	// client.
+	FinishUploadRetries int
+	// FinishUploadBackoff is the time to wait before the first retry of
+	// FinishUpload. It is doubled for every subsequent retry.
+	FinishUploadBackoff time.Duration
+	// OffsetSkewTolerance defines by how many bytes the Upload-Offset sent by
+	// a client in a PATCH request may be behind the stored offset. Within this
+	// tolerance, the bytes which have already been received are skipped and
+	// only the remaining ones are written. If the skew is bigger or the client
+	// is ahead of the stored offset, the request is rejected with 409 Conflict.
+	// By default, the offsets must match exactly.
+	OffsetSkewTolerance int64
+	// ComputeTreeHash enables computing the SHA-256 tree hash, as used by
+	// Amazon Glacier, while the data is received. Once an upload is finished,
+	// the hex-encoded hash is sent in the Upload-Tree-Hash header of the PATCH
+	// and subsequent HEAD responses and can be retrieved using
+	// UnroutedHandler.TreeHash. The intermediate state is kept in memory, so no
+	// hash is available for uploads which have been resumed after a restart.
+	ComputeTreeHash bool
+	// MaxTreeHashes is the number of tree hashes kept in memory, separately
+	// for finished and unfinished uploads. Once it is exceeded, the hashes of
+	// the least recently used uploads are discarded. Defaults to 10000.
+	MaxTreeHashes int
+	// ResumptionGap enables counting how often an upload has been resumed,
+	// which indicates the quality of the clients' network connections. A PATCH
+	// request counts as a resumption if it is received over another connection
+	// than the previous request writing to the upload or more than
+	// ResumptionGap after that request has ended. The number is sent in the
+	// Upload-Resumptions header of HEAD responses and in the Resumptions
+	// property of the finished upload's info. Like the tree hash, it is kept
+	// in memory, so it only covers the requests received by this handler
+	// since it has been started. If zero, resumptions are not counted.
+	ResumptionGap time.Duration
+	// Metrics, if set, counts the created, finished and terminated uploads,
+	// the received bytes and the sent error responses. The same Metrics may
+	// be shared by multiple handlers. See the prometheuscollector package for
+	// exposing them.
+	Metrics *Metrics
+	// AcceptancePolicy is consulted before creating a new upload and may
+	// refuse it by returning false for the current time, e.g. during a
+	// maintenance window. In this case, it returns the time at which uploads
+	// are accepted again, e.g. the window's end, or the zero time if unknown.
+	// Refused requests are answered with 503 Service Unavailable and, unless
+	// the time is unknown, a Retry-After header pointing to it. Existing
+	// uploads are not affected.
+	AcceptancePolicy func(now time.Time) (accept bool, reopens time.Time)
+	// RecoverPanics enables recovering from panics, e.g. raised by the data
+	// store, while creating or writing to an upload. The panic is logged and
+	// the request is answered with 500 Internal Server Error instead of
+	// aborting the connection. The upload's lock is released in either case.
+	// Since the bytes written before the panic may be incomplete, the upload
+	// is truncated to the offset at which the interrupted chunk started if the
+	// store implements TruncaterDataStore. Otherwise, the upload is marked as
+	// failed if the store implements FailerDataStore. If neither is
+	// implemented, the bytes are kept and reported in the offset, unless the
+	// store only reports flushed data (see BufferedDataStore).
+	RecoverPanics bool
+	// PatchLimiter, if set, bounds the number of PATCH requests processed at
+	// the same time. Requests exceeding the limit are rejected with 503 Service
+	// Unavailable. Using a CounterLimiter with a shared counter, the limit
+	// applies to all instances of a cluster. See CounterLimiter for how slots
+	// held by crashed instances are freed.
+	PatchLimiter ConcurrencyLimiter
+	// MaxManifestChunkSize enables clients to register the sizes and SHA-256
+	// digests of the chunks they are going to upload using the
+	// Upload-Chunk-Manifest header when creating an upload, e.g.
+	// "Upload-Chunk-Manifest: 5 2cf24d...,6 486ea4...". Afterwards, every PATCH
+	// request must contain exactly the next chunk and is rejected with 460 if
+	// it does not match. Since a chunk is verified before it is written, it is
+	// buffered in memory and therefore its size may not exceed this value nor
+	// MaxVerifiedChunkSize. If zero, the header is ignored.
+	MaxManifestChunkSize int64
+	// MaxVerifiedChunkSize is the maximum size of a chunk which is verified
+	// before it is written, i.e. if the PATCH request contains an
+	// Upload-Checksum header or a chunk manifest has been registered. These
+	// chunks are buffered in memory, so larger ones are rejected with 413
+	// Request Entity Too Large. Defaults to 16 MiB.
+	MaxVerifiedChunkSize int64
+	// VerifyDownloads enables checking the data of uploads created with an
+	// Upload-Chunk-Manifest against the recorded digests while it is served
+	// using GET, detecting data which has been corrupted at rest. Since the
+	// status is sent before the data has been read, the result is reported
+	// using the Upload-Integrity trailer, which is either "verified" or
+	// "corrupted". A corrupted chunk is not sent and the transfer ends early.
+	// Verified downloads do not support Range requests.
+	VerifyDownloads bool
+	// TerminateSealedUploads allows uploads to be terminated using DELETE
+	// requests after they have been sealed, see UnroutedHandler.SealFile. By
+	// default, such requests are rejected with 403 Forbidden.
+	TerminateSealedUploads bool
+	// ResumePolicy defines how a PATCH request is handled if the upload is
+	// locked by another request, e.g. if a second client resumes the upload
+	// while the first one is still writing. Defaults to ResumeReject.
+	ResumePolicy ResumePolicy
+	// ResumeTimeout is the maximum time a PATCH request waits for the lock if
+	// ResumePolicy is set to ResumeQueue or ResumeTakeOver. Once it is
+	// exceeded, the request is rejected with 423 Locked. Defaults to 10
+	// seconds.
+	ResumeTimeout time.Duration
+	// LockedRetryAfter enables sending a Retry-After header in 423 Locked
+	// responses, so clients wait before retrying instead of hammering a
+	// popular upload. The delay is chosen randomly between LockedRetryAfter
+	// and twice its value, spreading out the retries of multiple clients. If
+	// zero, the TTL of the data store's locks is used instead if it
+	// implements ExpiringLocker. Otherwise no header is sent.
+	LockedRetryAfter time.Duration
+	// AsyncTermination enables answering DELETE requests with 202 Accepted as
+	// soon as the upload's lock has been acquired, while the data store's
+	// Terminate method is invoked in the background. This keeps responses fast
+	// for stores which need a long time to reclaim the space of large uploads.
+	// The lock is held until the upload has been terminated, so other requests
+	// to it are rejected in the meantime. Use WaitForTerminations to wait for
+	// pending terminations, e.g. before shutting down.
+	AsyncTermination bool
+	// TerminatePartialUploads enables removing the partial uploads a final
+	// upload has been concatenated from when the final upload is terminated
+	// using a DELETE request, see UnroutedHandler.TerminateWithParts.
+	TerminatePartialUploads bool
+	// Extensions lists the names of the tus extensions which are enabled, e.g.
+	// "creation", "termination" or "concatenation". Only the enabled ones are
+	// advertised in the Tus-Extension header and requests using a disabled
+	// extension are rejected. Extensions which are not supported by the data
+	// store or not implemented by tusd are never enabled. If nil, all
+	// supported extensions are enabled.
+	Extensions []string
+	// StoreBreaker, if set, protects the data store during outages. Once it
+	// has opened after repeated failures of creating uploads or writing
+	// chunks, these requests are rejected with 503 Service Unavailable
+	// instead of failing randomly, while HEAD and GET requests are still
+	// served. Its state can be queried for health checks.
+	StoreBreaker *CircuitBreaker
+	// IDGenerator is invoked for every new upload to obtain its ID, e.g. for
+	// embedding a tenant prefix. If nil, the data store generates a random
+	// ID. Since the ID is used in the upload's URL and by the data store, it
+	// may only contain the characters A-Z, a-z, 0-9, "-", "_" and "~".
+	// Otherwise the request fails with ErrInvalidUploadID. Dots are not
+	// allowed since the stores derive the names of further files and objects,
+	// e.g. "[id].info", from the ID. It is invoked again if the data store
+	// reports a collision.
+	IDGenerator func(info FileInfo) (string, error)
+	// IDCollisionRetries defines how often creating an upload is attempted
+	// again if the data store reports that the generated ID is already taken
+	// using ErrUploadIDCollision. Defaults to 3.
+	IDCollisionRetries int
+	// Cors configures the CORS headers allowing browsers to access the server
+	// from other origins. By default, requests from any origin are allowed.
+	Cors CorsConfig
+	// UploadExpiration enables the expiration extension if the data store
+	// implements ExpirerDataStore. Unfinished uploads expire once they have
+	// not received any data for this duration, which is announced to clients
+	// in the Upload-Expires header. Expired uploads are only removed when
+	// CleanupExpiredUploads is invoked. If zero, uploads do not expire.
+	UploadExpiration time.Duration
+	// MaxUploadDuration limits the time an upload may remain unfinished after
+	// its creation, regardless of whether it is still receiving data. Like
+	// UploadExpiration, it enables the expiration extension if the data store
+	// implements ExpirerDataStore, and uploads exceeding the limit are removed
+	// by CleanupExpiredUploads. Only uploads created while the limit is set
+	// are affected, since their creation time is recorded in
+	// FileInfo.CreatedAt. If zero, the duration is not limited.
+	MaxUploadDuration time.Duration
 }
 
+// ResumePolicy defines how PATCH requests to an upload which is locked by
+// another request are handled, see Config.ResumePolicy.
+type ResumePolicy int
+
+const (
+	// ResumeReject rejects the request immediately with 423 Locked.
+	ResumeReject ResumePolicy = iota
+	// ResumeTakeOver cancels the active write of this handler to the upload,
+	// as if a DELETE request with the Upload-Cancel-Write header had been
+	// received, and waits for the lock. The older request is answered with
+	// 400 Bad Request, so the newest client wins, which is useful when an
+	// upload is handed off between devices. Writes on other instances of a
+	// cluster cannot be canceled, so the request may still time out.
+	ResumeTakeOver
+	// ResumeQueue waits for the active request to release the lock.
+	ResumeQueue
+)
+
+// resumePollInterval is the interval in which the lock is attempted to be
+// acquired again while waiting according to the ResumePolicy.
+const resumePollInterval = 10 * time.Millisecond
+
+// defaultIDCollisionRetries is the default of Config.IDCollisionRetries.
+const defaultIDCollisionRetries = 3
+
+// defaultMaxTreeHashes is the default of Config.MaxTreeHashes.
+const defaultMaxTreeHashes = 10000
+
+// defaultMaxVerifiedChunkSize is the default of Config.MaxVerifiedChunkSize.
+const defaultMaxVerifiedChunkSize = 16 << 20
+
+// notificationsBuffer is the capacity of the CreatedUploads and
+// TerminatedUploads channels.
+const notificationsBuffer = 100
+
 // UnroutedHandler exposes methods to handle requests as part of the tus protocol,
 // such as PostFile, HeadFile, PatchFile and DelFile. In addition the GetFile method
 // is provided which is, however, not part of the specification.
 type UnroutedHandler struct {
 	config        Config
 	dataStore     DataStore
+	composer      *StoreComposer
 	isBasePathAbs bool
 	basePath      string
 	logger        *log.Logger
 	extensions    string
+	// enabledExtensions contains the names of the enabled extensions which
+	// are advertised in extensions.
+	enabledExtensions map[string]bool
+
+	// completions passes the infos of finished uploads to the workers invoking
+	// the CompleteUploadsCallback, which are tracked by workers and stop once
+	// closed has been closed by Close.
+	completions chan FileInfo
+	workers     sync.WaitGroup
+	closed      chan struct{}
+	closeOnce   sync.Once
+
+	// pendingFinishes contains the info objects of the uploads which have been
+	// received entirely but could not be finished, indexed by their IDs.
+	pendingFinishes map[string]FileInfo
+	pendingMutex    sync.Mutex
+
+	// writes contains a channel for each upload which is currently being
+	// written to by a PATCH request. Closing it cancels the write.
+	writes      map[string]chan struct{}
+	writesMutex sync.Mutex
+
+	// terminations tracks the terminations running in the background, see
+	// Config.AsyncTermination.
+	terminations sync.WaitGroup
+
+	// locker is the composer's LockerDataStore, if any.
+	locker LockerDataStore
+
+	// treeHashes contains the running tree hashes, as *treehash.Hash, of
+	// unfinished uploads while treeHashSums contains the hex-encoded hashes of
+	// finished ones. Both are bounded by Config.MaxTreeHashes.
+	treeHashes    *lruCache
+	treeHashSums  *lruCache
+	treeHashMutex sync.Mutex
+
+	// sessions tracks the requests writing to the uploads, see
+	// Config.ResumptionGap.
+	sessions      map[string]*uploadSession
+	sessionsMutex sync.Mutex
+
+	// corsAllowedHeaders and corsExposedHeaders are the values of the CORS
+	// headers, see Config.Cors.
+	corsAllowedHeaders string
+	corsExposedHeaders string
 
 	// For each finished upload the corresponding info object will be sent using
 	// this unbuffered channel. The NotifyCompleteUploads property in the Config
 	// struct must be set to true in order to work.
 	CompleteUploads chan FileInfo
+
+	// For each created upload the corresponding info object will be sent using
+	// this buffered channel. The NotifyCreatedUploads property in the Config
+	// struct must be set to true in order to work.
+	CreatedUploads chan FileInfo
+
+	// For each terminated upload the info object, as it has been before the
+	// termination, will be sent using this buffered channel. The
+	// NotifyTerminatedUploads property in the Config struct must be set to
+	// true in order to work.
+	TerminatedUploads chan FileInfo
 }
 
 // NewUnroutedHandler creates a new handler without routing using the given
@@ -99,7 +548,7 @@ type UnroutedHandler struct {
 func NewUnroutedHandler(config Config) (*UnroutedHandler, error) {
 	logger := config.Logger
 	if logger == nil {
-		logger = log.New(os.Stdout, "[tusd] ", 0)
+		logger = log.New(os.Stderr, "[tusd] ", 0)
 	}
 	base := config.BasePath
 	uri, err := url.Parse(base)
@@ -117,23 +566,82 @@ func NewUnroutedHandler(config Config) (*UnroutedHandler, error) {
 		base = "/" + base
 	}
 
+	composer := config.StoreComposer
+	if composer == nil {
+		composer = NewStoreComposer(config.DataStore)
+	}
+	config.DataStore = composer.Core
+
 	// Only promote extesions using the Tus-Extension header which are implemented
-	extensions := "creation"
-	if _, ok := config.DataStore.(TerminaterDataStore); ok {
-		extensions += ",termination"
+	supported := []string{"creation"}
+	if composer.LengthDeferrer != nil {
+		supported = append(supported, "creation-defer-length")
+	}
+	supported = append(supported, "creation-with-upload")
+	if composer.Terminater != nil {
+		supported = append(supported, "termination")
 	}
-	if _, ok := config.DataStore.(ConcaterDataStore); ok {
-		extensions += ",concatenation"
+	if composer.Concater != nil {
+		supported = append(supported, "concatenation")
+	}
+	if composer.Expirer != nil && (config.UploadExpiration > 0 || config.MaxUploadDuration > 0) {
+		supported = append(supported, "expiration")
+	}
+	supported = append(supported, "checksum")
+
+	// Of these, only use the ones enabled in the configuration
+	enabledExtensions := make(map[string]bool)
+	var enabled []string
+	for _, name := range supported {
+		if config.Extensions == nil || containsString(config.Extensions, name) {
+			enabledExtensions[name] = true
+			enabled = append(enabled, name)
+		}
+	}
+	extensions := strings.Join(enabled, ",")
+
+	if config.ResumeTimeout <= 0 {
+		config.ResumeTimeout = 10 * time.Second
+	}
+
+	if config.MaxTreeHashes <= 0 {
+		config.MaxTreeHashes = defaultMaxTreeHashes
 	}
 
 	handler := &UnroutedHandler{
-		config:          config,
-		dataStore:       config.DataStore,
-		basePath:        base,
-		isBasePathAbs:   uri.IsAbs(),
-		CompleteUploads: make(chan FileInfo),
-		logger:          logger,
-		extensions:      extensions,
+		config:             config,
+		dataStore:          composer.Core,
+		composer:           composer,
+		basePath:           base,
+		isBasePathAbs:      uri.IsAbs(),
+		CompleteUploads:    make(chan FileInfo),
+		CreatedUploads:     make(chan FileInfo, notificationsBuffer),
+		TerminatedUploads:  make(chan FileInfo, notificationsBuffer),
+		logger:             logger,
+		extensions:         extensions,
+		enabledExtensions:  enabledExtensions,
+		pendingFinishes:    make(map[string]FileInfo),
+		writes:             make(map[string]chan struct{}),
+		locker:             composer.Locker,
+		treeHashes:         newLRUCache(config.MaxTreeHashes),
+		treeHashSums:       newLRUCache(config.MaxTreeHashes),
+		sessions:           make(map[string]*uploadSession),
+		corsAllowedHeaders: config.Cors.allowedHeaders(),
+		corsExposedHeaders: config.Cors.exposedHeaders(),
+		closed:             make(chan struct{}),
+	}
+
+	if config.CompleteUploadsCallback != nil {
+		workers := config.CompleteUploadsWorkers
+		if workers <= 0 {
+			workers = 1
+		}
+
+		handler.completions = make(chan FileInfo, workers)
+		handler.workers.Add(workers)
+		for i := 0; i < workers; i++ {
+			go handler.completeUploadsWorker()
+		}
 	}
 
 	return handler, nil
@@ -153,22 +661,31 @@ func (handler *UnroutedHandler) Middleware(h http.Handler) http.Handler {
 			r.Method = newMethod
 		}
 
-		go handler.logger.Println(r.Method, r.URL.Path)
+		handler.logger.Println(r.Method, r.URL.Path)
 
 		header := w.Header()
 
 		if origin := r.Header.Get("Origin"); origin != "" {
-			header.Set("Access-Control-Allow-Origin", origin)
-
-			if r.Method == "OPTIONS" {
-				// Preflight request
-				header.Set("Access-Control-Allow-Methods", "POST, GET, HEAD, PATCH, DELETE, OPTIONS")
-				header.Set("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Upload-Length, Upload-Offset, Tus-Resumable, Upload-Metadata")
-				header.Set("Access-Control-Max-Age", "86400")
-
-			} else {
-				// Actual request
-				header.Set("Access-Control-Expose-Headers", "Upload-Offset, Location, Upload-Length, Tus-Version, Tus-Resumable, Tus-Max-Size, Tus-Extension, Upload-Metadata")
+			// Whether CORS headers are sent depends on the origin, which is
+			// echoed if allowed
+			header.Add("Vary", "Origin")
+
+			if handler.config.Cors.allowsOrigin(origin) {
+				header.Set("Access-Control-Allow-Origin", origin)
+				if handler.config.Cors.allowsCredentials(origin) {
+					header.Set("Access-Control-Allow-Credentials", "true")
+				}
+
+				if r.Method == "OPTIONS" {
+					// Preflight request
+					header.Set("Access-Control-Allow-Methods", "POST, GET, HEAD, PATCH, DELETE, OPTIONS")
+					header.Set("Access-Control-Allow-Headers", handler.corsAllowedHeaders)
+					header.Set("Access-Control-Max-Age", "86400")
+
+				} else {
+					// Actual request
+					header.Set("Access-Control-Expose-Headers", handler.corsExposedHeaders)
+				}
 			}
 		}
 
@@ -178,6 +695,13 @@ func (handler *UnroutedHandler) Middleware(h http.Handler) http.Handler {
 		// Add nosniff to all responses https://golang.org/src/net/http/server.go#L1429
 		header.Set("X-Content-Type-Options", "nosniff")
 
+		// Reject oversized headers before any of them, e.g. Upload-Metadata, is
+		// parsed
+		if max := handler.config.MaxHeaderBytes; max > 0 && headerSize(r.Header) > max {
+			handler.sendError(w, r, ErrHeaderTooLarge)
+			return
+		}
+
 		// Set appropriated headers in case of OPTIONS method allowing protocol
 		// discovery and end with an 204 No Content
 		if r.Method == "OPTIONS" {
@@ -186,17 +710,21 @@ func (handler *UnroutedHandler) Middleware(h http.Handler) http.Handler {
 			}
 
 			header.Set("Tus-Version", "1.0.0")
-			header.Set("Tus-Extension", handler.extensions)
+			if handler.extensions != "" {
+				header.Set("Tus-Extension", handler.extensions)
+			}
+			if handler.hasExtension("checksum") {
+				header.Set("Tus-Checksum-Algorithm", strings.Join(checksumAlgorithmNames, ","))
+				header.Set("Tus-Checksum-Scope", strings.Join(checksumScopes, ","))
+			}
 
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
+			if describer := handler.composer.Describer; describer != nil {
+				if description := describer.Describe(); description != "" {
+					header.Set("X-Tusd-Store", description)
+				}
+			}
 
-		// Test if the version sent by the client is supported
-		// GET methods are not checked since a browser may visit this URL and does
-		// not include this header. This request is not part of the specification.
-		if r.Method != "GET" && r.Header.Get("Tus-Resumable") != "1.0.0" {
-			handler.sendError(w, r, ErrUnsupportedVersion)
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
@@ -208,12 +736,45 @@ func (handler *UnroutedHandler) Middleware(h http.Handler) http.Handler {
 // PostFile creates a new file upload using the datastore after validating the
 // length and parsing the metadata.
 func (handler *UnroutedHandler) PostFile(w http.ResponseWriter, r *http.Request) {
-	// Only use the proper Upload-Concat header if the concatenation extension
-	// is even supported by the data store.
-	var concatHeader string
-	concatStore, ok := handler.dataStore.(ConcaterDataStore)
-	if ok {
-		concatHeader = r.Header.Get("Upload-Concat")
+	if handler.config.RecoverPanics {
+		defer handler.recoverPanic(w, r, "")
+	}
+
+	if err := checkResumableVersion(r); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	if !handler.hasExtension("creation") {
+		handler.sendError(w, r, ErrExtensionDisabled)
+		return
+	}
+
+	if policy := handler.config.AcceptancePolicy; policy != nil {
+		now := time.Now()
+		if accept, reopens := policy(now); !accept {
+			if !reopens.IsZero() {
+				w.Header().Set("Retry-After", strconv.FormatInt(retryAfter(now, reopens), 10))
+			}
+			handler.sendError(w, r, ErrUploadsNotAccepted)
+			return
+		}
+	}
+
+	// Reject the Upload-Concat header if the concatenation extension is not
+	// supported by the data store or has been disabled.
+	concatHeader := r.Header.Get("Upload-Concat")
+	concatStore := handler.composer.Concater
+	if concatHeader != "" && !handler.hasExtension("concatenation") {
+		handler.sendError(w, r, ErrExtensionDisabled)
+		return
+	}
+
+	// The first chunk may only be included if creation-with-upload is enabled
+	withUpload := r.Header.Get("Content-Type") == "application/offset+octet-stream"
+	if withUpload && !handler.hasExtension("creation-with-upload") {
+		handler.sendError(w, r, ErrExtensionDisabled)
+		return
 	}
 
 	// Parse Upload-Concat header
@@ -223,16 +784,40 @@ func (handler *UnroutedHandler) PostFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// A final upload consists of its partial uploads only, so it is rejected
+	// before being created if the request contains any data
+	if isFinal && (withUpload || r.ContentLength > 0) {
+		handler.sendError(w, r, ErrFinalWithData)
+		return
+	}
+
 	// If the upload is a final upload created by concatenation multiple partial
 	// uploads the size is sum of all sizes of these files (no need for
 	// Upload-Length header)
 	var size int64
+	var sizeIsDeferred bool
 	if isFinal {
+		if !handler.config.AllowDuplicateConcatParts && hasDuplicates(partialUploads) {
+			handler.sendError(w, r, ErrDuplicateConcatPart)
+			return
+		}
+
 		size, err = handler.sizeOfUploads(partialUploads)
 		if err != nil {
 			handler.sendError(w, r, err)
 			return
 		}
+	} else if deferHeader := r.Header.Get("Upload-Defer-Length"); deferHeader != "" {
+		// The length will be declared in a later PATCH request
+		if deferHeader != "1" || r.Header.Get("Upload-Length") != "" {
+			handler.sendError(w, r, ErrInvalidUploadDeferLength)
+			return
+		}
+		if !handler.hasExtension("creation-defer-length") {
+			handler.sendError(w, r, ErrExtensionDisabled)
+			return
+		}
+		sizeIsDeferred = true
 	} else {
 		size, err = strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
 		if err != nil || size < 0 {
@@ -241,48 +826,203 @@ func (handler *UnroutedHandler) PostFile(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// Test whether the size is still allowed
-	if handler.config.MaxSize > 0 && size > handler.config.MaxSize {
+	// Parse metadata
+	meta, err := parseMeta(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		handler.sendError(w, r, rejectedError{err})
+		return
+	}
+	if err := handler.validateMeta(meta); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	for key, value := range handler.config.DefaultMetaData {
+		if _, ok := meta[key]; !ok {
+			meta[key] = value
+		}
+	}
+
+	// Test whether the size is still allowed. The limit may depend on the
+	// metadata, so it is checked afterwards.
+	maxSize := handler.maxSize(FileInfo{
+		Size:           size,
+		SizeIsDeferred: sizeIsDeferred,
+		MetaData:       meta,
+		IsPartial:      isPartial,
+		IsFinal:        isFinal,
+		PartialUploads: partialUploads,
+	})
+	if maxSize > 0 && size > maxSize {
+		// Tell the client about the limit, as in responses to OPTIONS requests
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
 		handler.sendError(w, r, ErrMaxSizeExceeded)
 		return
 	}
 
-	// Parse metadata
-	meta := parseMeta(r.Header.Get("Upload-Metadata"))
+	// Reject a first chunk which cannot be stored before creating the upload
+	if withUpload && r.ContentLength > 0 {
+		limit := size
+		if sizeIsDeferred {
+			limit = maxSize
+		}
+		if (!sizeIsDeferred || limit > 0) && r.ContentLength > limit {
+			handler.sendError(w, r, ErrSizeExceeded)
+			return
+		}
+	}
+
+	var manifest []ChunkHash
+	if handler.config.MaxManifestChunkSize > 0 && r.Header.Get("Upload-Chunk-Manifest") != "" {
+		maxChunkSize := handler.config.MaxManifestChunkSize
+		if maxChunkSize > handler.maxVerifiedChunkSize() {
+			maxChunkSize = handler.maxVerifiedChunkSize()
+		}
+		manifest, err = parseChunkManifest(r.Header.Get("Upload-Chunk-Manifest"), size, maxChunkSize)
+		if err != nil || isFinal || sizeIsDeferred {
+			handler.sendError(w, r, ErrInvalidManifest)
+			return
+		}
+	}
 
 	info := FileInfo{
 		Size:           size,
+		SizeIsDeferred: sizeIsDeferred,
 		MetaData:       meta,
 		IsPartial:      isPartial,
 		IsFinal:        isFinal,
 		PartialUploads: partialUploads,
+		ChunkManifest:  manifest,
+	}
+
+	now := time.Now()
+	if handler.config.MaxUploadDuration > 0 {
+		info.CreatedAt = &now
+	}
+
+	// Final uploads are finished once they have been created, so they do not
+	// expire.
+	if handler.hasExtension("expiration") && !isFinal {
+		info.Expires = handler.uploadExpiration(info, now)
+	}
+
+	if callback := handler.config.PreUploadCreateCallback; callback != nil {
+		if err := callback(info); err != nil {
+			handler.sendError(w, r, rejectedError{err})
+			return
+		}
+	}
+
+	// A dry run only validates the request, allowing clients to check whether
+	// the upload would be accepted without creating it. Limits enforced by the
+	// data store, e.g. a LimitedStore's size, are checked if it implements
+	// CapacityCheckerDataStore.
+	if r.Header.Get("Tus-Dry-Run") == "1" {
+		if checker := handler.composer.CapacityChecker; checker != nil {
+			if err := checker.CheckCapacity(info); err != nil {
+				handler.sendError(w, r, err)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// A new ID is generated for each attempt, so creating the upload is
+	// retried if the ID is already taken
+	retries := handler.config.IDCollisionRetries
+	if retries <= 0 {
+		retries = defaultIDCollisionRetries
 	}
 
-	id, err := handler.dataStore.NewUpload(info)
+	var id string
+	err = handler.guardStore(r, nil, func() (err error) {
+		for attempt := 0; ; attempt++ {
+			if generator := handler.config.IDGenerator; generator != nil {
+				if info.ID, err = generator(info); err != nil {
+					return err
+				}
+				if !validUploadID(info.ID) {
+					return ErrInvalidUploadID
+				}
+			}
+
+			if cstore := handler.composer.Context; cstore != nil {
+				id, err = cstore.NewUploadWithContext(r.Context(), info)
+			} else {
+				id, err = handler.dataStore.NewUpload(info)
+			}
+			if err != ErrUploadIDCollision || attempt >= retries {
+				return err
+			}
+			handler.logger.Printf("Generated upload ID is already taken, retrying")
+		}
+	})
 	if err != nil {
 		handler.sendError(w, r, err)
 		return
 	}
 
+	handler.config.Metrics.incUploadsCreated()
+
+	if sizeIsDeferred {
+		handler.logEvent(r, id, "upload created with deferred length")
+	} else {
+		handler.logEvent(r, id, "upload created with size %d", size)
+	}
+
+	if handler.config.NotifyCreatedUploads {
+		created := info
+		created.ID = id
+		created.URL = handler.absFileURL(r, id)
+		handler.notify(handler.CreatedUploads, created, "created")
+	}
+
 	if isFinal {
 		if err := concatStore.ConcatUploads(id, partialUploads); err != nil {
 			handler.sendError(w, r, err)
 			return
 		}
 
-		if handler.config.NotifyCompleteUploads {
-			info.ID = id
-			handler.CompleteUploads <- info
-		}
+		info.ID = id
+		info.URL = handler.absFileURL(r, id)
+		handler.logEvent(r, id, "upload completed")
+		handler.notifyComplete(info)
+	}
+
+	if info.Expires != nil {
+		w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
 	}
 
 	url := handler.absFileURL(r, id)
 	w.Header().Set("Location", url)
+
+	// The request may contain the upload's first chunk, which is written as
+	// if it had been sent in a subsequent PATCH request. The Location header
+	// is already set, so the client can resume if writing it fails.
+	if withUpload {
+		if err := handler.lockUpload(id); err != nil {
+			handler.sendError(w, r, err)
+			return
+		}
+		defer handler.unlockUpload(id)
+
+		info.ID = id
+		if err := handler.writeChunk(w, r, id, info, 0, 0); err != nil {
+			handler.sendError(w, r, err)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusCreated)
 }
 
 // HeadFile returns the length and offset for the HEAD request
 func (handler *UnroutedHandler) HeadFile(w http.ResponseWriter, r *http.Request) {
+	if err := checkResumableVersion(r); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
 
 	id, err := extractIDFromPath(r.URL.Path)
 	if err != nil {
@@ -290,14 +1030,11 @@ func (handler *UnroutedHandler) HeadFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if locker, ok := handler.dataStore.(LockerDataStore); ok {
-		if err := locker.LockUpload(id); err != nil {
-			handler.sendError(w, r, err)
-			return
-		}
-
-		defer locker.UnlockUpload(id)
+	if err := handler.lockUpload(id); err != nil {
+		handler.sendError(w, r, err)
+		return
 	}
+	defer handler.unlockUpload(id)
 
 	info, err := handler.dataStore.GetInfo(id)
 	if err != nil {
@@ -305,6 +1042,13 @@ func (handler *UnroutedHandler) HeadFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Failed uploads cannot be resumed, so clients must start over
+	if info.Error != "" {
+		w.Header().Set("Upload-Error", sanitizeHeader(info.Error))
+		handler.sendError(w, r, ErrUploadFailed)
+		return
+	}
+
 	// Add Upload-Concat header if possible
 	if info.IsPartial {
 		w.Header().Set("Upload-Concat", "partial")
@@ -322,14 +1066,44 @@ func (handler *UnroutedHandler) HeadFile(w http.ResponseWriter, r *http.Request)
 		w.Header().Set("Upload-Metadata", serializeMeta(info.MetaData))
 	}
 
+	if handler.isFinishPending(id) {
+		w.Header().Set("Upload-Finish-Pending", "true")
+	}
+
+	if sum, ok := handler.TreeHash(id); ok {
+		w.Header().Set("Upload-Tree-Hash", sum)
+	}
+
+	if resumptions, ok := handler.Resumptions(id); ok {
+		w.Header().Set("Upload-Resumptions", strconv.Itoa(resumptions))
+	}
+
+	if info.Expires != nil && (info.SizeIsDeferred || info.Offset < info.Size) {
+		w.Header().Set("Upload-Expires", info.Expires.UTC().Format(http.TimeFormat))
+	}
+
+	if info.SizeIsDeferred {
+		w.Header().Set("Upload-Defer-Length", "1")
+	} else {
+		w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
+	}
+
 	w.Header().Set("Cache-Control", "no-store")
-	w.Header().Set("Upload-Length", strconv.FormatInt(info.Size, 10))
 	w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
 	w.WriteHeader(http.StatusNoContent)
 }
 
 // PatchFile adds a chunk to an upload. Only allowed enough space is left.
+// The body is not read before the upload has been locked and the offset and
+// size have been validated. Since net/http only answers `Expect: 100-continue`
+// once the body is read, clients using it receive the 100 Continue response
+// only if the chunk is accepted, while rejected requests are answered before
+// any data is sent.
 func (handler *UnroutedHandler) PatchFile(w http.ResponseWriter, r *http.Request) {
+	if err := checkResumableVersion(r); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
 
 	// Check for presence of application/offset+octet-stream
 	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
@@ -350,15 +1124,38 @@ func (handler *UnroutedHandler) PatchFile(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if locker, ok := handler.dataStore.(LockerDataStore); ok {
-		if err := locker.LockUpload(id); err != nil {
+	// Registered before the lock is acquired, so the lock is released before
+	// the panic is recovered
+	if handler.config.RecoverPanics {
+		defer handler.recoverPanic(w, r, id)
+	}
+
+	if limiter := handler.config.PatchLimiter; limiter != nil {
+		ok, err := limiter.Acquire()
+		if err != nil {
 			handler.sendError(w, r, err)
 			return
 		}
+		if !ok {
+			handler.sendError(w, r, ErrConcurrencyLimit)
+			return
+		}
 
-		defer locker.UnlockUpload(id)
+		defer func() {
+			if err := limiter.Release(); err != nil {
+				handler.logger.Printf("Unable to release concurrency limit for upload %s: %s", id, err)
+			}
+		}()
+	}
+
+	if err := handler.lockUploadForWrite(id); err != nil {
+		handler.sendError(w, r, err)
+		return
 	}
+	defer handler.unlockUpload(id)
 
+	// The info must be read after acquiring the lock since another request may
+	// have written to the upload while waiting for it, changing the offset.
 	info, err := handler.dataStore.GetInfo(id)
 	if err != nil {
 		handler.sendError(w, r, err)
@@ -371,141 +1168,1599 @@ func (handler *UnroutedHandler) PatchFile(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	if offset != info.Offset {
-		handler.sendError(w, r, ErrMismatchOffset)
+	if info.Error != "" {
+		handler.sendError(w, r, ErrUploadFailed)
 		return
 	}
 
-	// Get Content-Length if possible
-	length := r.ContentLength
-
-	// Test if this upload fits into the file's size
-	if offset+length > info.Size {
-		handler.sendError(w, r, ErrSizeExceeded)
+	if info.Sealed {
+		handler.sendError(w, r, ErrUploadSealed)
 		return
 	}
 
-	maxSize := info.Size - offset
-	if length > 0 {
-		maxSize = length
+	// Tolerate clients which are slightly behind the stored offset by skipping
+	// the bytes which have already been received.
+	skew := int64(0)
+	if offset < info.Offset && info.Offset-offset <= handler.config.OffsetSkewTolerance {
+		skew = info.Offset - offset
+		offset = info.Offset
 	}
 
-	// Limit the
-	reader := io.LimitReader(r.Body, maxSize)
+	// Buffering stores also accept writes continuing after bytes which have not
+	// been flushed yet
+	maxOffset := info.Offset
+	if store := handler.composer.Buffered; store != nil {
+		maxOffset, err = store.GetWrittenOffset(id)
+		if err != nil {
+			handler.sendError(w, r, err)
+			return
+		}
+	}
 
-	bytesWritten, err := handler.dataStore.WriteChunk(id, offset, reader)
-	if err != nil {
-		handler.sendError(w, r, err)
+	// The body is not read, so the connection can be reused right away. The
+	// stored offset is included for letting the client resync without an
+	// additional HEAD request.
+	if offset < info.Offset || offset > maxOffset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(info.Offset, 10))
+		handler.sendError(w, r, ErrMismatchOffset)
 		return
 	}
 
-	// Send new offset to client
-	newOffset := offset + bytesWritten
-	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
-
-	// If the upload is completed, ...
-	if newOffset == info.Size {
-		// ... allow custom mechanism to finish and cleanup the upload
-		if store, ok := handler.dataStore.(FinisherDataStore); ok {
-			if err := store.FinishUpload(id); err != nil {
-				handler.sendError(w, r, err)
-				return
-			}
+	// The length of an upload created with Upload-Defer-Length can be declared
+	// once. It may not be smaller than the data which has already been received.
+	lengthDeclared := false
+	if lengthHeader := r.Header.Get("Upload-Length"); lengthHeader != "" {
+		if !info.SizeIsDeferred {
+			handler.sendError(w, r, ErrUploadLengthAlreadySet)
+			return
 		}
 
-		// ... send the info out to the channel
-		if handler.config.NotifyCompleteUploads {
-			info.Offset = newOffset
-			handler.CompleteUploads <- info
+		size, err := strconv.ParseInt(lengthHeader, 10, 64)
+		if err != nil || size < maxOffset {
+			handler.sendError(w, r, ErrInvalidUploadLength)
+			return
+		}
+		if maxSize := handler.maxSize(info); maxSize > 0 && size > maxSize {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxSize, 10))
+			handler.sendError(w, r, ErrMaxSizeExceeded)
+			return
 		}
-	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// GetFile handles requests to download a file using a GET request. This is not
-// part of the specification.
-func (handler *UnroutedHandler) GetFile(w http.ResponseWriter, r *http.Request) {
-	dataStore, ok := handler.dataStore.(GetReaderDataStore)
-	if !ok {
-		handler.sendError(w, r, ErrNotImplemented)
-		return
-	}
-
-	id, err := extractIDFromPath(r.URL.Path)
-	if err != nil {
-		handler.sendError(w, r, err)
-		return
-	}
 
-	if locker, ok := handler.dataStore.(LockerDataStore); ok {
-		if err := locker.LockUpload(id); err != nil {
+		if err := handler.composer.LengthDeferrer.DeclareLength(id, size); err != nil {
 			handler.sendError(w, r, err)
 			return
 		}
-
-		defer locker.UnlockUpload(id)
+		info.Size = size
+		info.SizeIsDeferred = false
+		lengthDeclared = true
 	}
 
-	info, err := handler.dataStore.GetInfo(id)
-	if err != nil {
-		handler.sendError(w, r, err)
-		return
-	}
-
-	// Do not do anything if no data is stored yet.
-	if info.Offset == 0 {
+	// Clients may send an empty PATCH request for probing the offset, which is
+	// answered without touching the data store. Empty uploads are excluded
+	// since they are only finished once such a request is received, as are
+	// uploads whose declared length has just been reached. A zero
+	// Content-Length with a body other than http.NoBody means that the length
+	// is unknown.
+	completesUpload := lengthDeclared && offset == info.Size
+	if r.ContentLength == 0 && (r.Body == nil || r.Body == http.NoBody) && (info.SizeIsDeferred || info.Size > 0) && !completesUpload {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	// Get reader
-	src, err := dataStore.GetReader(id)
-	if err != nil {
+	if err := handler.writeChunk(w, r, id, info, offset, skew); err != nil {
 		handler.sendError(w, r, err)
 		return
 	}
 
-	w.Header().Set("Content-Length", strconv.FormatInt(info.Offset, 10))
-	w.WriteHeader(http.StatusOK)
-	io.Copy(w, src)
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	// Try to close the reader if the io.Closer interface is implemented
-	if closer, ok := src.(io.Closer); ok {
-		closer.Close()
+// maxSize returns the maximum size of the upload described by info, using
+// Config.MaxSizeFunc if set. A value of 0 or less means that there is no limit.
+func (handler *UnroutedHandler) maxSize(info FileInfo) int64 {
+	if handler.config.MaxSizeFunc != nil {
+		return handler.config.MaxSizeFunc(info)
 	}
+
+	return handler.config.MaxSize
 }
 
-// DelFile terminates an upload permanently.
-func (handler *UnroutedHandler) DelFile(w http.ResponseWriter, r *http.Request) {
-	// Abort the request handling if the required interface is not implemented
-	tstore, ok := handler.config.DataStore.(TerminaterDataStore)
-	if !ok {
-		handler.sendError(w, r, ErrNotImplemented)
-		return
+// maxVerifiedChunkSize returns the maximum size of a chunk which is buffered
+// for verification, see Config.MaxVerifiedChunkSize.
+func (handler *UnroutedHandler) maxVerifiedChunkSize() int64 {
+	if handler.config.MaxVerifiedChunkSize > 0 {
+		return handler.config.MaxVerifiedChunkSize
 	}
 
-	id, err := extractIDFromPath(r.URL.Path)
-	if err != nil {
-		handler.sendError(w, r, err)
-		return
-	}
+	return defaultMaxVerifiedChunkSize
+}
 
-	if locker, ok := handler.dataStore.(LockerDataStore); ok {
-		if err := locker.LockUpload(id); err != nil {
-			handler.sendError(w, r, err)
-			return
+// writeChunk writes the request's body to the upload starting at the offset,
+// after skipping the first skew bytes, and finishes the upload if it has been
+// completed. The new offset is set in the Upload-Offset header, while the
+// caller is responsible for sending the response's status. The upload must be
+// locked.
+func (handler *UnroutedHandler) writeChunk(w http.ResponseWriter, r *http.Request, id string, info FileInfo, offset int64, skew int64) error {
+	// Get Content-Length if possible
+	length := r.ContentLength
+	if length > 0 && skew > 0 {
+		length -= skew
+		if length < 0 {
+			length = 0
 		}
+	}
+
+	// Test if this upload fits into the file's size. If the size has been
+	// deferred, only the maximum size is enforced.
+	sizeLimit := info.Size
+	if info.SizeIsDeferred {
+		sizeLimit = math.MaxInt64
+		if maxSize := handler.maxSize(info); maxSize > 0 {
+			sizeLimit = maxSize
+		}
+	}
+	if offset+length > sizeLimit {
+		return ErrSizeExceeded
+	}
+
+	maxSize := sizeLimit - offset
+	if length > 0 {
+		maxSize = length
+	}
+
+	// Chunks which are verified before they are written are buffered, so their
+	// size is limited
+	verified := r.Header.Get("Upload-Checksum") != "" || len(info.ChunkManifest) > 0
+	if verified && length > handler.maxVerifiedChunkSize() {
+		return ErrVerifiedChunkTooLarge
+	}
+
+	// The body is read from here on, which makes net/http send 100 Continue to
+	// clients waiting for it, so all validation must happen before.
+
+	// The client's checksum and the manifest cover the entire body, so the
+	// bytes which have already been received are only skipped after verifying
+	// it. Otherwise, they are skipped right away.
+	start := offset - skew
+	var reader io.Reader
+	if verified {
+		reader = io.LimitReader(r.Body, skew+maxSize)
+	} else {
+		if skew > 0 {
+			if _, err := io.CopyN(ioutil.Discard, r.Body, skew); err != nil && err != io.EOF {
+				return err
+			}
+		}
+		reader = io.LimitReader(r.Body, maxSize)
+	}
+
+	var data []byte
+
+	// Verify the chunk before writing it if a manifest has been registered
+	if len(info.ChunkManifest) > 0 {
+		var err error
+		data, err = verifyChunk(info.ChunkManifest, start, r.Body, handler.maxVerifiedChunkSize())
+		if err != nil {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	// Verify the chunk's checksum before writing it. Since the bytes must not
+	// be committed if it does not match, the chunk is buffered in memory, up
+	// to Config.MaxVerifiedChunkSize.
+	if checksum := r.Header.Get("Upload-Checksum"); checksum != "" {
+		if !handler.hasExtension("checksum") {
+			return ErrExtensionDisabled
+		}
+
+		var lastChunk bool
+		var err error
+		data, lastChunk, err = verifyChecksum(checksum, reader, handler.maxVerifiedChunkSize())
+		if err == nil && lastChunk && (info.SizeIsDeferred || start+int64(len(data)) != info.Size) {
+			err = ErrChecksumScope
+		}
+		if err != nil {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+			return err
+		}
+	}
+
+	if verified {
+		if skew > int64(len(data)) {
+			skew = int64(len(data))
+		}
+		reader = bytes.NewReader(data[skew:])
+	}
+
+	handler.startSession(r, id)
+	defer handler.endSession(id)
+
+	// Allow the write to be canceled using a DELETE request
+	cancel := handler.registerWrite(id)
+	defer handler.unregisterWrite(id, cancel)
+	reader = &cancelableReader{
+		reader: reader,
+		cancel: cancel,
+	}
+
+	// Count the bytes while they are received, so long requests are visible
+	if handler.config.Metrics != nil {
+		reader = bytesCounter{
+			reader:  reader,
+			metrics: handler.config.Metrics,
+		}
+	}
+
+	var hash *treehash.Hash
+	if handler.config.ComputeTreeHash {
+		hash = handler.runningTreeHash(id, offset)
+		if hash != nil {
+			reader = io.TeeReader(reader, hash)
+		}
+	}
+
+	// Roll back the chunk if writing it panics, before the panic is recovered
+	if handler.config.RecoverPanics {
+		defer func() {
+			if err := recover(); err != nil {
+				handler.rollBackChunk(id, offset)
+				panic(err)
+			}
+		}()
+	}
+
+	body := &readErrorRecorder{reader: reader}
+	var bytesWritten int64
+	err := handler.guardStore(r, body, func() (err error) {
+		if cstore := handler.composer.Context; cstore != nil {
+			bytesWritten, err = cstore.WriteChunkWithContext(r.Context(), id, offset, body)
+		} else {
+			bytesWritten, err = handler.dataStore.WriteChunk(id, offset, body)
+		}
+		return err
+	})
+	if err == ErrStoreUnavailable {
+		return err
+	}
+	if hash != nil {
+		handler.updateTreeHash(id, hash, offset+bytesWritten, sizeLimit)
+	}
+	if err != nil {
+		// Report the offset the client has to resume from. The store is asked
+		// since it may not have persisted all bytes which have been written.
+		durableOffset := offset + bytesWritten
+		if info, infoErr := handler.dataStore.GetInfo(id); infoErr == nil {
+			durableOffset = info.Offset
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(durableOffset, 10))
+		handler.notifyFirstChunk(info, offset, durableOffset)
+
+		return handler.checkUnrecoverable(id, err)
+	}
+
+	// If the body's length is unknown, e.g. due to chunked encoding, the
+	// LimitReader silently stops at the upload's size. Any data remaining
+	// after it has been received is rejected, while the received bytes are
+	// kept, so the upload can still be completed.
+	var exceededErr error
+	if length <= 0 && bytesWritten == maxSize && r.Body != nil {
+		if n, _ := r.Body.Read(make([]byte, 1)); n > 0 {
+			exceededErr = ErrSizeExceeded
+		}
+	}
+
+	// Send new offset to client
+	newOffset := offset + bytesWritten
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	handler.logEvent(r, id, "chunk of %d bytes written, offset is %d", bytesWritten, newOffset)
+	handler.notifyFirstChunk(info, offset, newOffset)
+
+	// Postpone the expiration of the unfinished upload since it is in use
+	if newOffset < sizeLimit && handler.hasExtension("expiration") && handler.config.UploadExpiration > 0 {
+		expires := *handler.uploadExpiration(info, time.Now())
+		if err := handler.composer.Expirer.SetExpiration(id, expires); err != nil {
+			handler.logger.Printf("Unable to update expiration of upload %s: %s", id, err)
+		} else {
+			w.Header().Set("Upload-Expires", expires.UTC().Format(http.TimeFormat))
+		}
+	}
+
+	// If the upload is completed, ...
+	if !info.SizeIsDeferred && newOffset == info.Size {
+		info.ID = id
+		info.Offset = newOffset
+		info.URL = handler.absFileURL(r, id)
+
+		if sum, ok := handler.TreeHash(id); ok {
+			w.Header().Set("Upload-Tree-Hash", sum)
+		}
+
+		// ... allow custom mechanism to finish and cleanup the upload
+		if err := handler.finishUpload(id); err != nil {
+			if _, ok := err.(UnrecoverableError); ok || handler.config.FinishUploadRetries <= 0 {
+				return handler.checkUnrecoverable(id, err)
+			}
+
+			// All data has been received, so the upload is only marked as pending
+			// instead of failing the request. The retries must not wait while
+			// holding the lock, so they are performed once it has been released.
+			handler.logger.Printf("Unable to finish upload %s, marking as pending: %s", id, err)
+			handler.pendingMutex.Lock()
+			handler.pendingFinishes[id] = info
+			handler.pendingMutex.Unlock()
+			go handler.retryFinish(id)
+
+			w.Header().Set("Upload-Finish-Pending", "true")
+			return exceededErr
+		}
+
+		// ... send the info out to the channel and callback
+		handler.logEvent(r, id, "upload completed")
+		handler.notifyComplete(info)
+	}
+
+	return exceededErr
+}
+
+// GetFile handles requests to download a file using a GET request. This is not
+// part of the specification.
+func (handler *UnroutedHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	dataStore := handler.composer.GetReader
+	if dataStore == nil {
+		handler.sendError(w, r, ErrNotImplemented)
+		return
+	}
+
+	id, err := extractIDFromPath(r.URL.Path)
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	if err := handler.lockUpload(id); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+	defer handler.unlockUpload(id)
+
+	info, err := handler.dataStore.GetInfo(id)
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	if info.SizeIsDeferred || info.Offset != info.Size {
+		switch handler.config.IncompleteDownloadBehavior {
+		case IncompleteDownloadNotFound:
+			handler.sendError(w, r, ErrNotFound)
+			return
+		case IncompleteDownloadTooEarly:
+			handler.sendError(w, r, ErrUploadIncomplete)
+			return
+		}
+	}
+
+	// Allow caches to revalidate their copy of the content
+	tag := etag(id, info)
+	w.Header().Set("ETag", tag)
+	if etagMatches(r.Header.Get("If-None-Match"), tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Do not do anything if no data is stored yet.
+	if info.Offset == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Only finished uploads can be verified since the digests cover entire
+	// chunks
+	verify := handler.config.VerifyDownloads && len(info.ChunkManifest) > 0 && info.Offset == info.Size
+
+	// Serve only the requested range if the data store supports random access.
+	// Malformed Range headers are ignored and the entire upload is sent.
+	if readerAtStore := handler.composer.ReaderAt; readerAtStore != nil && !verify {
+		w.Header().Set("Accept-Ranges", "bytes")
+
+		start, end, ok, err := parseRange(r.Header.Get("Range"), info.Offset)
+		if err != nil {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(info.Offset, 10))
+			handler.sendError(w, r, err)
+			return
+		}
+
+		var src io.ReaderAt
+		if ok {
+			src, _, err = readerAtStore.GetReaderAt(id)
+			if err == ErrNotImplemented {
+				// Wrapping stores, such as limitedstore, only support random
+				// access if the store they wrap does, so the entire upload is
+				// sent instead.
+				w.Header().Del("Accept-Ranges")
+				ok = false
+			} else if err != nil {
+				handler.sendError(w, r, err)
+				return
+			}
+		}
+
+		if ok {
+
+			// Stream the range in a single pass if the reader supports it
+			var body io.Reader = io.NewSectionReader(src, start, end-start)
+			if rangeReader, ok := src.(RangeReader); ok {
+				rangeBody, err := rangeReader.ReadRange(start, end-start)
+				if err != nil {
+					if closer, ok := src.(io.Closer); ok {
+						closer.Close()
+					}
+					handler.sendError(w, r, err)
+					return
+				}
+				defer rangeBody.Close()
+				body = rangeBody
+			}
+
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, info.Offset))
+			w.Header().Set("Content-Length", strconv.FormatInt(end-start, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			io.Copy(w, body)
+
+			// Try to close the reader if the io.Closer interface is implemented
+			if closer, ok := src.(io.Closer); ok {
+				closer.Close()
+			}
+			return
+		}
+	}
+
+	// Get reader
+	var src io.Reader
+	if cstore := handler.composer.ContextGetReader; cstore != nil {
+		src, err = cstore.GetReaderWithContext(r.Context(), id)
+	} else {
+		src, err = dataStore.GetReader(id)
+	}
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	if verify {
+		handler.sendVerified(w, r, id, info.ChunkManifest, src)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Offset, 10))
+		w.WriteHeader(http.StatusOK)
+		io.Copy(w, src)
+	}
+
+	// Try to close the reader if the io.Closer interface is implemented
+	if closer, ok := src.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// sendVerified copies the upload's data to the response chunk by chunk. Each
+// chunk is compared to its digest from the manifest before it is sent. The
+// result is reported using the Upload-Integrity trailer, which requires the
+// response to be sent without a Content-Length.
+func (handler *UnroutedHandler) sendVerified(w http.ResponseWriter, r *http.Request, id string, manifest []ChunkHash, src io.Reader) {
+	w.Header().Set("Trailer", "Upload-Integrity")
+	w.WriteHeader(http.StatusOK)
+
+	var offset int64
+	for _, chunk := range manifest {
+		data := make([]byte, chunk.Size)
+		if _, err := io.ReadFull(src, data); err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			// Reading failed for other reasons than missing data, so the
+			// integrity is unknown
+			handler.logEvent(r, id, "verified download failed: %s", err)
+			return
+		} else if digest := sha256.Sum256(data); err != nil || hex.EncodeToString(digest[:]) != chunk.SHA256 {
+			w.Header().Set("Upload-Integrity", "corrupted")
+			handler.logEvent(r, id, "chunk at offset %d does not match the manifest", offset)
+			return
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+		offset += chunk.Size
+	}
+
+	w.Header().Set("Upload-Integrity", "verified")
+}
+
+// etag returns the entity tag for the upload's content. It is derived from the
+// upload's ID, size and offset, since the content only changes when data is
+// appended. State held in memory, such as the tree hash, is not used, so all
+// instances of a cluster and restarted processes send the same tag.
+func etag(id string, info FileInfo) string {
+	hash := sha256.Sum256([]byte(id + ":" + strconv.FormatInt(info.Size, 10) + ":" + strconv.FormatInt(info.Offset, 10)))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// etagMatches returns whether the If-None-Match header contains the entity
+// tag. Weak tags are compared using their opaque value only.
+func etagMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Manifest describes an upload and, if it is a final upload, the partial
+// uploads it has been concatenated from. It is returned by GetManifest.
+type Manifest struct {
+	ID        string
+	Size      int64
+	Offset    int64
+	IsPartial bool
+	IsFinal   bool
+	// Parts lists the partial uploads of a final upload in their order of
+	// concatenation. It is empty for all other uploads.
+	Parts []ManifestPart
+}
+
+// ManifestPart describes a single partial upload of a final upload.
+type ManifestPart struct {
+	ID string
+	// Start is the position of the part's first byte inside the final upload.
+	Start int64
+	// Size is the total length of the part.
+	Size int64
+	// Offset is the number of bytes which have been received for the part.
+	Offset int64
+	// Complete indicates whether the part has been received entirely.
+	Complete bool
+}
+
+// GetManifest responds to GET requests for the path of an upload followed by
+// /manifest with a JSON-encoded Manifest, allowing clients to track the parts
+// of a concatenated upload. This is not part of the specification.
+func (handler *UnroutedHandler) GetManifest(w http.ResponseWriter, r *http.Request) {
+	id, err := extractIDFromPath(strings.TrimSuffix(r.URL.Path, "/manifest"))
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	if err := handler.lockUpload(id); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+	defer handler.unlockUpload(id)
+
+	info, err := handler.dataStore.GetInfo(id)
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	manifest := Manifest{
+		ID:        id,
+		Size:      info.Size,
+		Offset:    info.Offset,
+		IsPartial: info.IsPartial,
+		IsFinal:   info.IsFinal,
+		Parts:     []ManifestPart{},
+	}
+
+	start := int64(0)
+	for _, partID := range info.PartialUploads {
+		part, err := handler.dataStore.GetInfo(partID)
+		if err != nil {
+			handler.sendError(w, r, err)
+			return
+		}
+
+		manifest.Parts = append(manifest.Parts, ManifestPart{
+			ID:       partID,
+			Start:    start,
+			Size:     part.Size,
+			Offset:   part.Offset,
+			Complete: part.Offset == part.Size,
+		})
+		start += part.Size
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// DelFile terminates an upload permanently. If the Upload-Cancel-Write header
+// is set to true, only the PATCH request which is currently writing to the
+// upload is canceled while the upload itself remains. If no such request is
+// handled by this handler, 409 Conflict is sent instead, or 404 Not Found if
+// the upload does not exist.
+func (handler *UnroutedHandler) DelFile(w http.ResponseWriter, r *http.Request) {
+	if err := checkResumableVersion(r); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	// Abort the request handling if the required interface is not implemented
+	tstore := handler.composer.Terminater
+	if tstore == nil || !handler.hasExtension("termination") {
+		handler.sendError(w, r, ErrNotImplemented)
+		return
+	}
+
+	id, err := extractIDFromPath(r.URL.Path)
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	// Only cancel the active write instead of terminating the upload if the
+	// client requests it. The lock must not be acquired in this case since it
+	// is held by the PATCH request which is about to be canceled.
+	if r.Header.Get("Upload-Cancel-Write") == "true" {
+		if !handler.cancelWrite(id) {
+			if _, err := tstore.GetInfo(id); err != nil {
+				handler.sendError(w, r, err)
+			} else {
+				handler.sendError(w, r, ErrNoActiveWrite)
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := handler.lockUpload(id); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	// Only stores which are able to seal uploads have to be asked. In
+	// addition, the upload must be known before an asynchronous termination
+	// is accepted since errors are not reported to the client afterwards.
+	checkSealed := handler.composer.Sealer != nil && !handler.config.TerminateSealedUploads
+	if checkSealed || handler.config.AsyncTermination {
+		info, err := tstore.GetInfo(id)
+		if err == nil && checkSealed && info.Sealed {
+			err = ErrUploadSealed
+		}
+		if err != nil {
+			handler.unlockUpload(id)
+			handler.sendError(w, r, err)
+			return
+		}
+	}
+
+	terminate := handler.terminate
+	if handler.config.TerminatePartialUploads {
+		terminate = handler.terminateWithParts
+	}
+
+	if handler.config.AsyncTermination {
+		handler.terminations.Add(1)
+		go func() {
+			defer handler.terminations.Done()
+			defer handler.unlockUpload(id)
+
+			if err := terminate(tstore, id); err != nil {
+				handler.logger.Printf("Unable to terminate upload %s: %s", id, err)
+			}
+		}()
+
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	defer handler.unlockUpload(id)
+
+	if err := terminate(tstore, id); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate removes the upload from the data store and discards the state the
+// handler holds for it. The upload's lock must be held by the caller.
+func (handler *UnroutedHandler) terminate(tstore TerminaterDataStore, id string) error {
+	// The info must be fetched beforehand since it is gone afterwards
+	var info FileInfo
+	if handler.config.NotifyTerminatedUploads {
+		info, _ = tstore.GetInfo(id)
+		info.ID = id
+	}
+
+	if err := tstore.Terminate(id); err != nil {
+		return err
+	}
+
+	handler.config.Metrics.incUploadsTerminated()
+
+	if handler.config.NotifyTerminatedUploads {
+		handler.notify(handler.TerminatedUploads, info, "terminated")
+	}
+
+	handler.discardTreeHash(id)
+
+	handler.sessionsMutex.Lock()
+	delete(handler.sessions, id)
+	handler.sessionsMutex.Unlock()
+
+	return nil
+}
+
+// PartialTerminationError is returned if a final upload has been terminated
+// but some of the partial uploads it consists of could not be removed.
+type PartialTerminationError struct {
+	// Errors maps the IDs of the remaining partial uploads to the reason why
+	// they could not be terminated.
+	Errors map[string]error
+}
+
+func (err PartialTerminationError) Error() string {
+	ids := make([]string, 0, len(err.Errors))
+	for id := range err.Errors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	reasons := make([]string, len(ids))
+	for i, id := range ids {
+		reasons[i] = fmt.Sprintf("%s: %s", id, err.Errors[id])
+	}
+	return "unable to terminate partial uploads: " + strings.Join(reasons, "; ")
+}
+
+// TerminateWithParts terminates the upload and, if it is a final upload, all
+// partial uploads it has been concatenated from. The final upload is removed
+// first and the operation is aborted if this fails. Afterwards each part is
+// locked and terminated on its own, while parts which no longer exist are
+// ignored. If some of them cannot be removed, a PartialTerminationError listing
+// them is returned.
+func (handler *UnroutedHandler) TerminateWithParts(id string) error {
+	tstore := handler.composer.Terminater
+	if tstore == nil {
+		return ErrNotImplemented
+	}
+
+	if err := handler.lockUpload(id); err != nil {
+		return err
+	}
+	defer handler.unlockUpload(id)
+
+	return handler.terminateWithParts(tstore, id)
+}
+
+// terminateWithParts implements TerminateWithParts. The final upload's lock
+// must be held by the caller.
+func (handler *UnroutedHandler) terminateWithParts(tstore TerminaterDataStore, id string) error {
+	info, err := tstore.GetInfo(id)
+	if err != nil {
+		return err
+	}
+
+	if err := handler.terminate(tstore, id); err != nil {
+		return err
+	}
+
+	if !info.IsFinal {
+		return nil
+	}
+
+	failed := make(map[string]error)
+	terminated := make(map[string]bool)
+	for _, partID := range info.PartialUploads {
+		// The same part may be referenced multiple times, see
+		// Config.AllowDuplicateConcatParts.
+		if terminated[partID] {
+			continue
+		}
+		terminated[partID] = true
+
+		if err := handler.lockUpload(partID); err != nil {
+			failed[partID] = err
+			continue
+		}
+
+		err := handler.terminate(tstore, partID)
+		handler.unlockUpload(partID)
+		if err != nil && !os.IsNotExist(err) && err != ErrNotFound {
+			failed[partID] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return PartialTerminationError{Errors: failed}
+	}
+	return nil
+}
+
+// WaitForTerminations blocks until all terminations which are running in the
+// background have finished, see Config.AsyncTermination.
+func (handler *UnroutedHandler) WaitForTerminations() {
+	handler.terminations.Wait()
+}
+
+// CleanupExpiredUploads terminates all unfinished uploads whose expiration time
+// has passed or which have exceeded Config.MaxUploadDuration. Uploads which are
+// currently locked are skipped since they are in use. It may be invoked
+// periodically, e.g. from a background goroutine, while ErrNotImplemented is
+// returned if the expiration extension is not enabled.
+func (handler *UnroutedHandler) CleanupExpiredUploads() error {
+	store := handler.composer.Expirer
+	if store == nil || !handler.hasExtension("expiration") {
+		return ErrNotImplemented
+	}
+
+	uploads, err := store.ListUploads()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, upload := range uploads {
+		id := upload.ID
+		if err := handler.lockUpload(id); err != nil {
+			continue
+		}
+
+		info, err := store.GetInfo(id)
+		unfinished := info.SizeIsDeferred || info.Offset < info.Size
+		if err == nil && unfinished && handler.isExpired(info, now) {
+			err = handler.terminate(store, id)
+		}
+		handler.unlockUpload(id)
+
+		// The upload may have been terminated in the meantime
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadExpiration returns the time at which the unfinished upload expires if
+// it is used now, i.e. after Config.UploadExpiration but no later than
+// Config.MaxUploadDuration after its creation. It is nil if the upload does
+// not expire.
+func (handler *UnroutedHandler) uploadExpiration(info FileInfo, now time.Time) *time.Time {
+	var expires *time.Time
+	if handler.config.UploadExpiration > 0 {
+		idle := now.Add(handler.config.UploadExpiration)
+		expires = &idle
+	}
+	if handler.config.MaxUploadDuration > 0 && info.CreatedAt != nil {
+		deadline := info.CreatedAt.Add(handler.config.MaxUploadDuration)
+		if expires == nil || deadline.Before(*expires) {
+			expires = &deadline
+		}
+	}
+	return expires
+}
+
+// isExpired reports whether the upload's expiration time has passed or it has
+// been created more than Config.MaxUploadDuration ago. The creation time is
+// checked on its own, so the limit also applies to uploads whose expiration
+// has been set before it has been configured.
+func (handler *UnroutedHandler) isExpired(info FileInfo, now time.Time) bool {
+	if info.Expires != nil && now.After(*info.Expires) {
+		return true
+	}
+	maxDuration := handler.config.MaxUploadDuration
+	return maxDuration > 0 && info.CreatedAt != nil && now.After(info.CreatedAt.Add(maxDuration))
+}
+
+// hasExtension reports whether the extension is supported and enabled, see
+// Config.Extensions.
+func (handler *UnroutedHandler) hasExtension(name string) bool {
+	return handler.enabledExtensions[name]
+}
+
+// uploadSession describes the last request which has written to an upload.
+type uploadSession struct {
+	remoteAddr  string
+	ended       time.Time
+	active      bool
+	resumptions int
+}
+
+// Resumptions returns how often the upload has been resumed if it is tracked,
+// see Config.ResumptionGap.
+func (handler *UnroutedHandler) Resumptions(id string) (int, bool) {
+	handler.sessionsMutex.Lock()
+	defer handler.sessionsMutex.Unlock()
+
+	session, ok := handler.sessions[id]
+	if !ok {
+		return 0, false
+	}
+	return session.resumptions, true
+}
+
+// startSession records that the request is about to write to the upload and
+// counts it as a resumption if it continues the upload on a new connection or
+// after a gap. The first request for an upload only starts tracking it.
+func (handler *UnroutedHandler) startSession(r *http.Request, id string) {
+	if handler.config.ResumptionGap <= 0 {
+		return
+	}
+
+	handler.sessionsMutex.Lock()
+	defer handler.sessionsMutex.Unlock()
+
+	session, ok := handler.sessions[id]
+	if !ok {
+		session = &uploadSession{}
+		handler.sessions[id] = session
+	} else if session.remoteAddr != r.RemoteAddr || (!session.active && time.Since(session.ended) > handler.config.ResumptionGap) {
+		session.resumptions++
+	}
+
+	session.remoteAddr = r.RemoteAddr
+	session.active = true
+}
+
+// endSession records that the request writing to the upload has ended, so the
+// gap to the next one can be measured.
+func (handler *UnroutedHandler) endSession(id string) {
+	if handler.config.ResumptionGap <= 0 {
+		return
+	}
+
+	handler.sessionsMutex.Lock()
+	defer handler.sessionsMutex.Unlock()
+
+	if session, ok := handler.sessions[id]; ok {
+		session.ended = time.Now()
+		session.active = false
+	}
+}
+
+// TreeHash returns the hex-encoded tree hash of a finished upload if it has
+// been computed, see Config.ComputeTreeHash.
+func (handler *UnroutedHandler) TreeHash(id string) (string, bool) {
+	handler.treeHashMutex.Lock()
+	defer handler.treeHashMutex.Unlock()
+
+	sum, ok := handler.treeHashSums.get(id)
+	if !ok {
+		return "", false
+	}
+	return sum.(string), true
+}
+
+// discardTreeHash removes the running and the final tree hash of the upload,
+// e.g. since it has been terminated.
+func (handler *UnroutedHandler) discardTreeHash(id string) {
+	handler.treeHashMutex.Lock()
+	defer handler.treeHashMutex.Unlock()
+
+	handler.treeHashes.remove(id)
+	handler.treeHashSums.remove(id)
+}
+
+// runningTreeHash returns the tree hash for an upload to which data will be
+// written at the given offset. If the hash does not cover exactly the bytes
+// before the offset, e.g. because the upload was started before a restart,
+// nil is returned since it cannot be computed anymore.
+func (handler *UnroutedHandler) runningTreeHash(id string, offset int64) *treehash.Hash {
+	handler.treeHashMutex.Lock()
+	defer handler.treeHashMutex.Unlock()
+
+	var hash *treehash.Hash
+	if value, ok := handler.treeHashes.get(id); ok {
+		hash = value.(*treehash.Hash)
+	} else if offset == 0 {
+		hash = treehash.New()
+		handler.treeHashes.set(id, hash)
+	}
+
+	if hash == nil || hash.Len() != offset {
+		handler.treeHashes.remove(id)
+		return nil
+	}
+
+	return hash
+}
+
+// updateTreeHash checks that the hash covers exactly the bytes which have been
+// written. Otherwise, it is discarded. Once the upload is finished, the final
+// hash is stored.
+func (handler *UnroutedHandler) updateTreeHash(id string, hash *treehash.Hash, offset int64, size int64) {
+	handler.treeHashMutex.Lock()
+	defer handler.treeHashMutex.Unlock()
+
+	if hash.Len() != offset {
+		handler.treeHashes.remove(id)
+		return
+	}
+
+	if offset == size {
+		handler.treeHashes.remove(id)
+		handler.treeHashSums.set(id, hex.EncodeToString(hash.Sum(nil)))
+	}
+}
+
+// lockUpload acquires the lock for the upload if the data store implements the
+// LockerDataStore interface.
+func (handler *UnroutedHandler) lockUpload(id string) error {
+	if handler.locker == nil {
+		return nil
+	}
+
+	return handler.locker.LockUpload(id)
+}
+
+// lockUploadForWrite acquires the lock for writing to the upload. If the upload
+// is locked by another request, it is handled according to the configured
+// ResumePolicy.
+func (handler *UnroutedHandler) lockUploadForWrite(id string) error {
+	err := handler.lockUpload(id)
+	if err != ErrFileLocked || handler.config.ResumePolicy == ResumeReject {
+		return err
+	}
+
+	if handler.config.ResumePolicy == ResumeTakeOver {
+		handler.cancelWrite(id)
+	}
+
+	deadline := time.Now().Add(handler.config.ResumeTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(resumePollInterval)
+
+		err = handler.lockUpload(id)
+		if err != ErrFileLocked {
+			return err
+		}
+	}
+
+	return err
+}
+
+// lockedRetryAfter returns the number of seconds a client should wait before
+// retrying a request for a locked upload, see Config.LockedRetryAfter. Zero
+// means that no delay is known.
+func (handler *UnroutedHandler) lockedRetryAfter() int {
+	base := handler.config.LockedRetryAfter
+	if base <= 0 {
+		if locker, ok := handler.locker.(ExpiringLocker); ok {
+			base = locker.LockTTL()
+		}
+	}
+	if base <= 0 {
+		return 0
+	}
+
+	delay := base + time.Duration(rand.Int63n(int64(base)))
+	return int(math.Ceil(delay.Seconds()))
+}
+
+// unlockUpload releases the lock acquired using lockUpload.
+func (handler *UnroutedHandler) unlockUpload(id string) {
+	if handler.locker != nil {
+		handler.locker.UnlockUpload(id)
+	}
+}
+
+// registerWrite records an active write for the upload and returns the channel
+// which will be closed once the write should be canceled.
+func (handler *UnroutedHandler) registerWrite(id string) chan struct{} {
+	handler.writesMutex.Lock()
+	defer handler.writesMutex.Unlock()
+
+	cancel := make(chan struct{})
+	handler.writes[id] = cancel
+	return cancel
+}
+
+// unregisterWrite removes the record of the active write for the upload
+// unless it has already been replaced by another write.
+func (handler *UnroutedHandler) unregisterWrite(id string, cancel chan struct{}) {
+	handler.writesMutex.Lock()
+	defer handler.writesMutex.Unlock()
+
+	if handler.writes[id] == cancel {
+		delete(handler.writes, id)
+	}
+}
+
+// isWriting returns whether a request is currently writing to the upload.
+func (handler *UnroutedHandler) isWriting(id string) bool {
+	handler.writesMutex.Lock()
+	defer handler.writesMutex.Unlock()
+
+	_, ok := handler.writes[id]
+	return ok
+}
+
+// cancelWrite cancels the active write for the upload, if there is one, and
+// returns whether it has been found. The PATCH request will stop reading its
+// body and respond with an error, allowing the client to resume the upload
+// from the last stored offset.
+func (handler *UnroutedHandler) cancelWrite(id string) bool {
+	handler.writesMutex.Lock()
+	defer handler.writesMutex.Unlock()
+
+	cancel, ok := handler.writes[id]
+	if ok {
+		close(cancel)
+		delete(handler.writes, id)
+	}
+	return ok
+}
+
+// cancelableReader passes reads to the underlying reader until the cancel
+// channel is closed, after which ErrUploadInterrupted is returned. Since a
+// blocking read cannot be interrupted, the cancellation takes effect once the
+// current read returns.
+type cancelableReader struct {
+	reader io.Reader
+	cancel chan struct{}
+}
+
+func (r *cancelableReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.cancel:
+		return 0, ErrUploadInterrupted
+	default:
+	}
+
+	n, err := r.reader.Read(p)
+
+	select {
+	case <-r.cancel:
+		return n, ErrUploadInterrupted
+	default:
+		return n, err
+	}
+}
+
+// errStorePanicked is reported to the StoreBreaker if an operation of the data
+// store panics.
+var errStorePanicked = errors.New("data store panicked")
+
+// guardStore performs a write operation of the data store, which is rejected
+// with ErrStoreUnavailable while the StoreBreaker is open. Its outcome is
+// reported to the breaker even if the operation panics, so a half-open breaker
+// does not wait for its trial operation forever. Failures caused by the
+// request, i.e. if the client has disconnected or reading the body, which may
+// be passed for this purpose, has failed, are not reported.
+func (handler *UnroutedHandler) guardStore(r *http.Request, body *readErrorRecorder, operation func() error) error {
+	breaker := handler.config.StoreBreaker
+	if breaker == nil {
+		return operation()
+	}
+	if !breaker.Allow() {
+		return ErrStoreUnavailable
+	}
+
+	err := errStorePanicked
+	defer func() {
+		if r.Context().Err() != nil || (body != nil && body.err != nil) {
+			breaker.release()
+		} else {
+			breaker.Report(err)
+		}
+	}()
+
+	err = operation()
+	return err
+}
+
+// readErrorRecorder remembers the first error, except io.EOF, returned by the
+// underlying reader, allowing to tell failures of the request body apart from
+// failures of the data store.
+type readErrorRecorder struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *readErrorRecorder) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// finishUpload invokes the FinishUpload method if the data store implements
+// the FinisherDataStore interface.
+func (handler *UnroutedHandler) finishUpload(id string) error {
+	store := handler.composer.Finisher
+	if store == nil {
+		return nil
+	}
+
+	return store.FinishUpload(id)
+}
+
+// retryFinish retries finishing the pending upload using an exponential
+// backoff as configured by FinishUploadRetries and FinishUploadBackoff. The
+// upload is only locked during the attempts, not while waiting for them. If
+// all attempts fail, the upload remains pending for RetryPendingFinishes.
+func (handler *UnroutedHandler) retryFinish(id string) {
+	backoff := handler.config.FinishUploadBackoff
+	for retries := 0; retries < handler.config.FinishUploadRetries; retries++ {
+		time.Sleep(backoff)
+		backoff *= 2
+
+		if handler.finishPending(id) {
+			return
+		}
+	}
+}
+
+// finishPending attempts to finish the pending upload while holding its lock
+// and sends the completion notifications if this succeeds. It returns whether
+// the upload is not pending anymore, e.g. because it has been finished by
+// another attempt in the meantime or failed permanently.
+func (handler *UnroutedHandler) finishPending(id string) bool {
+	if err := handler.lockUpload(id); err != nil {
+		return false
+	}
+	defer handler.unlockUpload(id)
+
+	handler.pendingMutex.Lock()
+	info, ok := handler.pendingFinishes[id]
+	handler.pendingMutex.Unlock()
+	if !ok {
+		return true
+	}
+
+	err := handler.finishUpload(id)
+	if err != nil && handler.checkUnrecoverable(id, err) != ErrUploadFailed {
+		handler.logger.Printf("Unable to finish pending upload %s: %s", id, err)
+		return false
+	}
+
+	// The upload is either finished or will never be, so it is not pending
+	// anymore
+	handler.pendingMutex.Lock()
+	delete(handler.pendingFinishes, id)
+	handler.pendingMutex.Unlock()
+
+	if err == nil {
+		handler.notifyComplete(info)
+	}
+
+	return true
+}
+
+// rollBackChunk discards the bytes written to the upload after the offset,
+// since the chunk's write has been interrupted by a panic and they may be
+// incomplete. If the data store cannot truncate the upload, it is marked as
+// failed instead. The upload must be locked.
+func (handler *UnroutedHandler) rollBackChunk(id string, offset int64) {
+	if store := handler.composer.Truncater; store != nil {
+		err := store.Truncate(id, offset)
+		if err == nil {
+			return
+		}
+		handler.logger.Printf("Unable to truncate upload %s to offset %d: %s", id, offset, err)
+	}
+
+	if handler.composer.Failer != nil {
+		handler.checkUnrecoverable(id, UnrecoverableError{
+			Reason: "writing a chunk has been interrupted by a panic",
+		})
+	}
+}
 
-		defer locker.UnlockUpload(id)
+// recoverPanic recovers from a panic while handling a request for the upload,
+// which is empty if it has not been created yet, and responds with
+// ErrInternal. It must be invoked using defer.
+func (handler *UnroutedHandler) recoverPanic(w http.ResponseWriter, r *http.Request, id string) {
+	err := recover()
+	if err == nil {
+		return
+	}
+
+	if id == "" {
+		handler.logger.Printf("Recovered from panic while creating upload: %v", err)
+	} else {
+		handler.logger.Printf("Recovered from panic while writing to upload %s: %v", id, err)
+	}
+
+	handler.sendError(w, r, ErrInternal)
+}
+
+// retryAfter returns the number of seconds until the specified time, rounded
+// up, for use in the Retry-After header. Since clients may retry immediately
+// if it is zero, at least one second is returned.
+func retryAfter(now time.Time, until time.Time) int64 {
+	seconds := int64((until.Sub(now) + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// checkUnrecoverable marks the upload as failed if the error returned by the
+// data store is an UnrecoverableError, in which case ErrUploadFailed is
+// returned. Other errors are returned unchanged.
+func (handler *UnroutedHandler) checkUnrecoverable(id string, err error) error {
+	unrecoverable, ok := err.(UnrecoverableError)
+	if !ok {
+		return err
+	}
+
+	handler.logger.Printf("Upload %s failed permanently: %s", id, unrecoverable.Reason)
+
+	// The upload will never be finished
+	handler.discardTreeHash(id)
+
+	if store := handler.composer.Failer; store != nil {
+		if err := store.FailUpload(id, unrecoverable.Reason); err != nil {
+			handler.logger.Printf("Unable to mark upload %s as failed: %s", id, err)
+		}
+	}
+
+	return ErrUploadFailed
+}
+
+// containsString reports whether the slice contains the value.
+func containsString(slice []string, value string) bool {
+	for _, element := range slice {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
+
+// sanitizeHeader replaces control characters, such as newlines, in order to
+// allow the value to be used in a header.
+func sanitizeHeader(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, value)
+}
+
+// isFinishPending returns whether the upload has been received entirely but
+// could not be finished yet.
+func (handler *UnroutedHandler) isFinishPending(id string) bool {
+	handler.pendingMutex.Lock()
+	defer handler.pendingMutex.Unlock()
+
+	_, ok := handler.pendingFinishes[id]
+	return ok
+}
+
+// RetryPendingFinishes attempts to finish all uploads which have been marked
+// as pending because FinishUpload failed even after all retries (see
+// Config.FinishUploadRetries). Once an upload could be finished, the
+// completion notifications are sent. This method is intended to be invoked
+// periodically by a background worker. Please note that pending uploads are
+// only tracked in memory and are lost once the process exits.
+func (handler *UnroutedHandler) RetryPendingFinishes() {
+	if handler.composer.Finisher == nil {
+		return
+	}
+
+	handler.pendingMutex.Lock()
+	pending := make([]string, 0, len(handler.pendingFinishes))
+	for id := range handler.pendingFinishes {
+		pending = append(pending, id)
+	}
+	handler.pendingMutex.Unlock()
+
+	for _, id := range pending {
+		handler.finishPending(id)
+	}
+}
+
+// notifyComplete sends the info of a finished upload to the CompleteUploads
+// channel, hands it to the workers invoking the CompleteUploadsCallback and
+// delivers it to the CompletionWebhook, if these mechanisms are enabled. The
+// tree hash and the completion time are added to the info beforehand.
+func (handler *UnroutedHandler) notifyComplete(info FileInfo) {
+	if sum, ok := handler.TreeHash(info.ID); ok {
+		info.Hash = sum
+	}
+	if resumptions, ok := handler.Resumptions(info.ID); ok {
+		info.Resumptions = resumptions
+	}
+	completedAt := time.Now()
+	info.CompletedAt = &completedAt
+
+	handler.config.Metrics.incUploadsFinished()
+
+	if handler.config.NotifyCompleteUploads {
+		handler.CompleteUploads <- info
+	}
+
+	if handler.completions != nil {
+		if handler.isClosed() {
+			handler.logger.Printf("Unable to invoke callback for upload %s: handler has been closed", info.ID)
+		} else {
+			select {
+			case handler.completions <- info:
+			case <-handler.closed:
+			}
+		}
+	}
+
+	if hook := handler.config.CompletionWebhook; hook != nil {
+		go func() {
+			if err := hook.Send(info); err != nil {
+				handler.logger.Printf("Unable to deliver webhook for upload %s: %s", info.ID, err)
+			}
+		}()
+	}
+}
+
+// notify sends the info to the channel without blocking. If its buffer is
+// full, the info is dropped.
+func (handler *UnroutedHandler) notify(channel chan FileInfo, info FileInfo, event string) {
+	select {
+	case channel <- info:
+	default:
+		handler.logger.Printf("Unable to notify about %s upload %s: channel is full", event, info.ID)
+	}
+}
+
+// notifyFirstChunk invokes the FirstChunkCallback if a write has advanced the
+// upload's offset from zero.
+func (handler *UnroutedHandler) notifyFirstChunk(info FileInfo, offset int64, newOffset int64) {
+	if handler.config.FirstChunkCallback == nil || offset != 0 || newOffset == 0 {
+		return
+	}
+
+	info.Offset = newOffset
+	go handler.config.FirstChunkCallback(info)
+}
+
+// completeUploadsWorker invokes the CompleteUploadsCallback for every info
+// object received from the completions channel. Once the handler has been
+// closed, the remaining infos in the channel's buffer are handled before the
+// worker returns.
+func (handler *UnroutedHandler) completeUploadsWorker() {
+	defer handler.workers.Done()
+
+	for {
+		select {
+		case info := <-handler.completions:
+			handler.config.CompleteUploadsCallback(info)
+		case <-handler.closed:
+			for {
+				select {
+				case info := <-handler.completions:
+					handler.config.CompleteUploadsCallback(info)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// isClosed returns whether Close has been called.
+func (handler *UnroutedHandler) isClosed() bool {
+	select {
+	case <-handler.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the workers invoking the CompleteUploadsCallback and waits until
+// they have handled the uploads which have already been finished. Uploads
+// finished afterwards are not passed to the callback anymore. Calling Close
+// multiple times is safe.
+func (handler *UnroutedHandler) Close() {
+	handler.closeOnce.Do(func() {
+		close(handler.closed)
+	})
+	handler.workers.Wait()
+}
+
+// ListLocks responds with a JSON-encoded list of the locks which are currently
+// held, as reported by the data store's ActiveLocks method. This is not part
+// of the specification and is not attached by NewHandler since it exposes
+// information about all uploads. If you want to use it, for debugging stuck
+// uploads for example, mount it on your own behind some form of
+// authentication.
+func (handler *UnroutedHandler) ListLocks(w http.ResponseWriter, r *http.Request) {
+	if err := checkResumableVersion(r); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	inspector := handler.composer.LockInspector
+	if inspector == nil {
+		handler.sendError(w, r, ErrNotImplemented)
+		return
+	}
+
+	locks := inspector.ActiveLocks()
+	if locks == nil {
+		locks = []LockInfo{}
+	}
+
+	data, err := json.Marshal(locks)
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// SealFile makes the finished upload, whose ID is taken from the request's
+// path, immutable using the data store's Seal method. Afterwards, PATCH and
+// DELETE requests for it are rejected with 403 Forbidden, see
+// Config.TerminateSealedUploads. Unfinished uploads cannot be sealed. This is
+// not part of the specification and is not attached by NewHandler. If you want
+// to use it, mount it on your own behind some form of authentication, e.g. for
+// POST requests to "[id]/seal".
+func (handler *UnroutedHandler) SealFile(w http.ResponseWriter, r *http.Request) {
+	if err := checkResumableVersion(r); err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	store := handler.composer.Sealer
+	if store == nil {
+		handler.sendError(w, r, ErrNotImplemented)
+		return
+	}
+
+	id, err := extractIDFromPath(strings.TrimSuffix(r.URL.Path, "/seal"))
+	if err != nil {
+		handler.sendError(w, r, err)
+		return
+	}
+
+	if err := handler.lockUpload(id); err != nil {
+		handler.sendError(w, r, err)
+		return
 	}
+	defer handler.unlockUpload(id)
 
-	err = tstore.Terminate(id)
+	info, err := store.GetInfo(id)
 	if err != nil {
 		handler.sendError(w, r, err)
 		return
 	}
 
+	if info.SizeIsDeferred || info.Offset != info.Size {
+		handler.sendError(w, r, ErrUploadIncomplete)
+		return
+	}
+
+	if !info.Sealed {
+		if err := store.Seal(id); err != nil {
+			handler.sendError(w, r, err)
+			return
+		}
+		handler.logEvent(r, id, "upload sealed")
+	}
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -517,11 +2772,36 @@ func (handler *UnroutedHandler) sendError(w http.ResponseWriter, r *http.Request
 		err = ErrNotFound
 	}
 
-	status, ok := ErrStatusCodes[err]
-	if !ok {
-		status = 500
+	if storageErr, ok := err.(InsufficientStorageError); ok {
+		handler.config.Metrics.incErrorsTotal(http.StatusInsufficientStorage)
+		handler.sendInsufficientStorage(w, r, storageErr)
+		return
+	}
+
+	// Inform the client about the versions supported by the server
+	if err == ErrUnsupportedVersion {
+		w.Header().Set("Tus-Version", "1.0.0")
+	}
+
+	if err == ErrFileLocked {
+		if retryAfter := handler.lockedRetryAfter(); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		}
+	}
+
+	status := http.StatusBadRequest
+	if _, rejected := err.(rejectedError); !rejected {
+		var ok bool
+		if status, ok = ErrStatusCodes[err]; !ok {
+			status = 500
+		}
 	}
 
+	if status >= 500 {
+		handler.logEvent(r, requestUploadID(w, r), "error: %s", err)
+	}
+	handler.config.Metrics.incErrorsTotal(status)
+
 	reason := err.Error() + "\n"
 	if r.Method == "HEAD" {
 		reason = ""
@@ -533,6 +2813,69 @@ func (handler *UnroutedHandler) sendError(w http.ResponseWriter, r *http.Request
 	w.Write([]byte(reason))
 }
 
+// headerSize returns the number of bytes the headers occupy in the request,
+// including the separators and line breaks.
+func headerSize(header http.Header) int {
+	size := 0
+	for key, values := range header {
+		for _, value := range values {
+			size += len(key) + len(": ") + len(value) + len("\r\n")
+		}
+	}
+	return size
+}
+
+// rejectedError wraps errors describing why a request is invalid, such as the
+// ones returned by the PreUploadCreateCallback, so they are answered with 400
+// Bad Request and their message.
+type rejectedError struct {
+	error
+}
+
+// logEvent reports an event concerning the upload with the specified ID to the
+// logger, prefixed with the request's method and the ID.
+func (handler *UnroutedHandler) logEvent(r *http.Request, id string, format string, args ...interface{}) {
+	handler.logger.Printf("%s %s: %s", r.Method, id, fmt.Sprintf(format, args...))
+}
+
+// requestUploadID returns the ID of the upload the request refers to. For POST
+// requests, it is taken from the Location header, so it is empty if the upload
+// has not been created yet.
+func requestUploadID(w http.ResponseWriter, r *http.Request) string {
+	path := r.URL.Path
+	if r.Method == "POST" {
+		path = w.Header().Get("Location")
+		if path == "" {
+			return ""
+		}
+	}
+
+	id, _ := extractIDFromPath(path)
+	return id
+}
+
+// sendInsufficientStorage responds with 507 Insufficient Storage including the
+// details about the store's usage in headers and a JSON-encoded body.
+func (handler *UnroutedHandler) sendInsufficientStorage(w http.ResponseWriter, r *http.Request, err InsufficientStorageError) {
+	w.Header().Set("Upload-Quota-Used", strconv.FormatInt(err.Used, 10))
+	w.Header().Set("Upload-Quota-Total", strconv.FormatInt(err.Total, 10))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"error":     err.Error(),
+		"used":      err.Used,
+		"total":     err.Total,
+		"requested": err.Requested,
+	})
+	if r.Method == "HEAD" {
+		body = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(http.StatusInsufficientStorage)
+	w.Write(body)
+}
+
 // Make an absolute URLs to the given upload id. If the base path is absolute
 // it will be prepended else the host and protocol from the request is used.
 func (handler *UnroutedHandler) absFileURL(r *http.Request, id string) string {
@@ -590,57 +2933,277 @@ func getHostAndProtocol(r *http.Request, allowForwarded bool) (host, proto strin
 // all of these uploads are finished yet. This is used to calculate the size
 // of a final resource.
 func (handler *UnroutedHandler) sizeOfUploads(ids []string) (size int64, err error) {
-	for _, id := range ids {
+	// Metadata of the first partial upload which all others are compared to
+	var first MetaData
+
+	for i, id := range ids {
 		info, err := handler.dataStore.GetInfo(id)
 		if err != nil {
 			return size, err
 		}
 
-		if info.Offset != info.Size {
+		// A part which is still being written to or whose finishing is pending
+		// may not be complete yet, even if all data has been received
+		if info.SizeIsDeferred || info.Offset != info.Size || handler.isWriting(id) || handler.isFinishPending(id) {
 			err = ErrUploadNotFinished
 			return size, err
 		}
 
+		if i == 0 {
+			first = info.MetaData
+		} else {
+			for _, key := range handler.config.ConcatMetaDataKeys {
+				if info.MetaData[key] != first[key] {
+					err = ErrMetaDataMismatch
+					return size, err
+				}
+			}
+		}
+
 		size += info.Size
 	}
 
 	return
 }
 
-// Parse the Upload-Metadata header as defined in the File Creation extension.
-// e.g. Upload-Metadata: name bHVucmpzLnBuZw==,type aW1hZ2UvcG5n
-func parseMeta(header string) map[string]string {
-	meta := make(map[string]string)
+// parseChunkManifest parses the Upload-Chunk-Manifest header which contains a
+// comma-separated list of chunks, each consisting of its size and hex-encoded
+// SHA-256 digest separated by a space. The sizes must add up to the upload's
+// size and may not exceed the maximum chunk size.
+func parseChunkManifest(header string, size int64, maxChunkSize int64) ([]ChunkHash, error) {
+	var manifest []ChunkHash
+	total := int64(0)
 
 	for _, element := range strings.Split(header, ",") {
-		element := strings.TrimSpace(element)
+		parts := strings.Fields(element)
+		if len(parts) != 2 {
+			return nil, ErrInvalidManifest
+		}
 
-		parts := strings.Split(element, " ")
+		chunkSize, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || chunkSize <= 0 || chunkSize > maxChunkSize {
+			return nil, ErrInvalidManifest
+		}
 
-		// Do not continue with this element if no key and value or presented
-		if len(parts) != 2 {
+		digest, err := hex.DecodeString(parts[1])
+		if err != nil || len(digest) != sha256.Size {
+			return nil, ErrInvalidManifest
+		}
+
+		manifest = append(manifest, ChunkHash{
+			Size:   chunkSize,
+			SHA256: strings.ToLower(parts[1]),
+		})
+		total += chunkSize
+	}
+
+	if total != size {
+		return nil, ErrInvalidManifest
+	}
+
+	return manifest, nil
+}
+
+// verifyChunk reads the chunk starting at the offset and compares it to the
+// corresponding manifest entry. If the offset is not the start of a chunk or
+// the data does not match, ErrManifestMismatch is returned. Chunks exceeding
+// maxSize, which may have been registered before the limit was lowered, are
+// rejected with ErrVerifiedChunkTooLarge without being read.
+func verifyChunk(manifest []ChunkHash, offset int64, src io.Reader, maxSize int64) ([]byte, error) {
+	start := int64(0)
+	for _, chunk := range manifest {
+		if start < offset {
+			start += chunk.Size
 			continue
 		}
+		if start > offset {
+			break
+		}
+
+		if chunk.Size > maxSize {
+			return nil, ErrVerifiedChunkTooLarge
+		}
 
-		// Ignore corrent element if the value is no valid base64
-		key := parts[0]
-		value, err := base64.StdEncoding.DecodeString(parts[1])
+		// Read one byte more than expected to detect oversized chunks
+		data, err := ioutil.ReadAll(io.LimitReader(src, chunk.Size+1))
 		if err != nil {
+			return nil, err
+		}
+
+		digest := sha256.Sum256(data)
+		if int64(len(data)) != chunk.Size || hex.EncodeToString(digest[:]) != chunk.SHA256 {
+			return nil, ErrManifestMismatch
+		}
+
+		return data, nil
+	}
+
+	return nil, ErrManifestMismatch
+}
+
+// checkResumableVersion tests if the version sent by the client in the
+// Tus-Resumable header is supported. It is invoked by every handler of the
+// protocol except GetFile, since a browser may visit this URL and does not
+// include this header. That request is not part of the specification.
+func checkResumableVersion(r *http.Request) error {
+	if r.Header.Get("Tus-Resumable") != "1.0.0" {
+		return ErrUnsupportedVersion
+	}
+
+	return nil
+}
+
+// checksumAlgorithmNames lists the algorithms supported by the checksum
+// extension in the order in which they are advertised.
+var checksumAlgorithmNames = []string{"sha1", "md5", "crc32"}
+
+// checksumAlgorithms contains the hash functions of the algorithms supported
+// by the checksum extension, indexed by their names.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"sha1":  sha1.New,
+	"md5":   md5.New,
+	"crc32": func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// checksumScopes lists the scopes which may follow the digest in the
+// Upload-Checksum header. By default, a checksum covers the chunk of the
+// request. Clients which only want to detect truncated uploads may instead
+// send a single checksum marked with "last-chunk", which must be sent with the
+// chunk completing the upload, e.g. "sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=
+// last-chunk".
+var checksumScopes = []string{"chunk", "last-chunk"}
+
+// verifyChecksum reads the chunk and compares its digest to the one from the
+// Upload-Checksum header, e.g. "sha1 Kq5sNclPz7QV2+lfQIuc6R7oRu0=". If they
+// do not match, ErrChecksumMismatch is returned, and ErrVerifiedChunkTooLarge
+// if the chunk exceeds maxSize. In addition, it returns whether the checksum's
+// scope is the last chunk of the upload.
+func verifyChecksum(header string, src io.Reader, maxSize int64) ([]byte, bool, error) {
+	parts := strings.Split(header, " ")
+	if len(parts) != 2 && len(parts) != 3 {
+		return nil, false, ErrInvalidChecksum
+	}
+
+	lastChunk := false
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "chunk":
+		case "last-chunk":
+			lastChunk = true
+		default:
+			return nil, false, ErrInvalidChecksum
+		}
+	}
+
+	newHash, ok := checksumAlgorithms[parts[0]]
+	if !ok {
+		return nil, false, ErrUnsupportedChecksum
+	}
+
+	expected, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, false, ErrInvalidChecksum
+	}
+
+	// Read one byte more than allowed to detect oversized chunks whose length
+	// has not been declared
+	data, err := ioutil.ReadAll(io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, false, ErrVerifiedChunkTooLarge
+	}
+
+	hash := newHash()
+	hash.Write(data)
+	if !bytes.Equal(hash.Sum(nil), expected) {
+		return nil, false, ErrChecksumMismatch
+	}
+
+	return data, lastChunk, nil
+}
+
+// hasDuplicates returns whether an ID is contained multiple times.
+func hasDuplicates(ids []string) bool {
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return true
+		}
+		seen[id] = true
+	}
+
+	return false
+}
+
+// Parse the Upload-Metadata header as defined in the File Creation extension.
+// Keys may be sent without a value, in which case it is empty. If a value is
+// not valid base64, an error describing the offending key is returned.
+// e.g. Upload-Metadata: name bHVucmpzLnBuZw==,type aW1hZ2UvcG5n,public
+func parseMeta(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+
+	for _, element := range strings.Split(header, ",") {
+		parts := strings.Fields(element)
+
+		switch len(parts) {
+		case 0:
+			// Tolerate empty elements, e.g. caused by a trailing comma
 			continue
+		case 1:
+			// Keys may be sent without a value
+			meta[parts[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata header: value of key %q is not valid base64", parts[0])
+			}
+			meta[parts[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid Upload-Metadata header: element %q does not consist of a key and an optional value", strings.TrimSpace(element))
+		}
+	}
+
+	return meta, nil
+}
+
+// validateMeta ensures that the keys and values of the parsed metadata match
+// the patterns from the configuration or, if none are set, do not contain
+// unsafe characters.
+func (handler *UnroutedHandler) validateMeta(meta map[string]string) error {
+	keyPattern := handler.config.MetaDataKeyPattern
+	if keyPattern == nil {
+		keyPattern = reMetaDataKey
+	}
+
+	for key, value := range meta {
+		if !keyPattern.MatchString(key) {
+			return ErrInvalidMetaData
 		}
 
-		meta[key] = string(value)
+		if handler.config.MetaDataValuePattern != nil {
+			if !handler.config.MetaDataValuePattern.MatchString(value) {
+				return ErrInvalidMetaData
+			}
+		} else if strings.IndexFunc(value, unicode.IsControl) != -1 {
+			return ErrInvalidMetaData
+		}
 	}
 
-	return meta
+	return nil
 }
 
 // Serialize a map of strings into the Upload-Metadata header format used in the
-// response for HEAD requests.
-// e.g. Upload-Metadata: name bHVucmpzLnBuZw==,type aW1hZ2UvcG5n
+// response for HEAD requests. Keys with an empty value are sent without one.
+// e.g. Upload-Metadata: name bHVucmpzLnBuZw==,type aW1hZ2UvcG5n,public
 func serializeMeta(meta map[string]string) string {
 	header := ""
 	for key, value := range meta {
+		if value == "" {
+			header += key + ","
+			continue
+		}
 		valueBase64 := base64.StdEncoding.EncodeToString([]byte(value))
 		header += key + " " + valueBase64 + ","
 	}
@@ -653,6 +3216,71 @@ func serializeMeta(meta map[string]string) string {
 	return header
 }
 
+// Parse a single byte range from the Range header for an upload containing
+// size bytes, e.g.
+// Range: bytes=0-499
+// Range: bytes=500-
+// Range: bytes=-500
+// The returned end is exclusive. If the header is empty, malformed or contains
+// multiple ranges, ok will be false and the header should be ignored.
+func parseRange(header string, size int64) (start int64, end int64, ok bool, err error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return
+	}
+
+	spec := strings.TrimSpace(header[len("bytes="):])
+	index := strings.Index(spec, "-")
+	if index == -1 || strings.Contains(spec, ",") {
+		return
+	}
+
+	first, last := spec[:index], spec[index+1:]
+
+	// A suffix range specifies the number of bytes at the end of the upload
+	if first == "" {
+		length, parseErr := strconv.ParseInt(last, 10, 64)
+		if parseErr != nil || length < 0 {
+			return
+		}
+
+		ok = true
+		if length == 0 || size == 0 {
+			err = ErrInvalidRange
+			return
+		}
+
+		if length > size {
+			length = size
+		}
+
+		return size - length, size, true, nil
+	}
+
+	start, parseErr := strconv.ParseInt(first, 10, 64)
+	if parseErr != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+
+	end = size
+	if last != "" {
+		lastByte, parseErr := strconv.ParseInt(last, 10, 64)
+		if parseErr != nil || lastByte < start {
+			return 0, 0, false, nil
+		}
+
+		if lastByte+1 < size {
+			end = lastByte + 1
+		}
+	}
+
+	ok = true
+	if start >= size {
+		err = ErrInvalidRange
+	}
+
+	return
+}
+
 // Parse the Upload-Concat header, e.g.
 // Upload-Concat: partial
 // Upload-Concat: final; http://tus.io/files/a /files/b/
@@ -696,6 +3324,12 @@ func parseConcat(header string) (isPartial bool, isFinal bool, partialUploads []
 	return
 }
 
+// validUploadID reports whether id can be used in an upload's URL and as a
+// file name without escaping.
+func validUploadID(id string) bool {
+	return reUploadID.MatchString(id)
+}
+
 // extractIDFromPath pulls the last segment from the url provided
 func extractIDFromPath(url string) (string, error) {
 	result := reExtractFileID.FindStringSubmatch(url)
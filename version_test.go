@@ -0,0 +1,41 @@
+package tusd_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	. "github.com/tus/tusd"
+)
+
+func TestUnsupportedVersion(t *testing.T) {
+	handler, _ := NewHandler(Config{
+		DataStore: extensionStore{},
+	})
+
+	for _, method := range []string{"POST", "HEAD", "PATCH", "DELETE"} {
+		for _, version := range []string{"", "0.2.2"} {
+			url := "yes"
+			if method == "POST" {
+				url = ""
+			}
+
+			(&httpTest{
+				Name:   method + " with unsupported version",
+				Method: method,
+				URL:    url,
+				ReqHeader: map[string]string{
+					"Tus-Resumable": version,
+					"Upload-Length": "5",
+					"Content-Type":  "application/offset+octet-stream",
+					"Upload-Offset": "0",
+				},
+				ReqBody: strings.NewReader("hello"),
+				Code:    http.StatusPreconditionFailed,
+				ResHeader: map[string]string{
+					"Tus-Version": "1.0.0",
+				},
+			}).Run(handler, t)
+		}
+	}
+}
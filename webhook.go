@@ -0,0 +1,108 @@
+package tusd
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Webhook delivers JSON-encoded notifications to an HTTP endpoint using POST
+// requests. It can be used for informing external services about finished
+// uploads, see Config.CompletionWebhook.
+type Webhook struct {
+	// URL of the endpoint receiving the notifications
+	URL string
+	// Secret is used for signing the request body using HMAC-SHA256 if it is
+	// not empty. The hex-encoded signature is sent in the X-Tusd-Signature
+	// header, prefixed by "sha256=", allowing the receiver to verify that the
+	// notification originates from tusd.
+	Secret string
+	// Retries defines how often a failed delivery is retried. A delivery is
+	// considered as failed if the request could not be sent or the endpoint
+	// did not respond using a 2xx status code.
+	Retries int
+	// Backoff is the time to wait before the first retry. It is doubled for
+	// every subsequent retry.
+	Backoff time.Duration
+	// Client is used for sending the requests. If nil, http.DefaultClient is
+	// used.
+	Client *http.Client
+	// DeadLetter is invoked with the request body and the last error if the
+	// notification could not be delivered even after all retries, allowing it
+	// to be stored somewhere else for later processing.
+	DeadLetter func(body []byte, err error)
+}
+
+// Send encodes the value as JSON and delivers it to the endpoint, retrying
+// failed attempts as configured. If all attempts fail, the notification is
+// passed to DeadLetter and the last error is returned.
+func (hook *Webhook) Send(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	backoff := hook.Backoff
+	for retries := 0; ; retries++ {
+		err = hook.post(body)
+		if err == nil {
+			return nil
+		}
+
+		if retries >= hook.Retries {
+			break
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	if hook.DeadLetter != nil {
+		hook.DeadLetter(body, err)
+	}
+
+	return err
+}
+
+// post sends a single, signed request containing the body.
+func (hook *Webhook) post(body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if hook.Secret != "" {
+		req.Header.Set("X-Tusd-Signature", "sha256="+hook.sign(body))
+	}
+
+	client := hook.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook: unexpected response status %s", res.Status)
+	}
+
+	return nil
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of the body using the secret, as
+// sent in the X-Tusd-Signature header.
+func (hook *Webhook) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(hook.Secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package tusd_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	. "github.com/tus/tusd"
+)
+
+// signature returns the expected value of the X-Tusd-Signature header without
+// its prefix.
+func signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestCompletionWebhook(t *testing.T) {
+	a := assert.New(t)
+
+	attempts := 0
+	received := make(chan FileInfo, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts += 1
+
+		body, err := ioutil.ReadAll(r.Body)
+		a.NoError(err)
+		a.Equal("POST", r.Method)
+		a.Equal("application/json", r.Header.Get("Content-Type"))
+		a.Equal("sha256="+signature("secret", body), r.Header.Get("X-Tusd-Signature"))
+
+		// Fail the first attempt to trigger a retry
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var info FileInfo
+		a.NoError(json.Unmarshal(body, &info))
+		received <- info
+	}))
+	defer server.Close()
+
+	handler, _ := NewHandler(Config{
+		DataStore: notifyStore{},
+		CompletionWebhook: &Webhook{
+			URL:     server.URL,
+			Secret:  "secret",
+			Retries: 2,
+			Backoff: time.Millisecond,
+		},
+	})
+
+	(&httpTest{
+		Name:   "Finishing upload",
+		Method: "PATCH",
+		URL:    "foo",
+		ReqHeader: map[string]string{
+			"Tus-Resumable": "1.0.0",
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": "0",
+		},
+		ReqBody: strings.NewReader("hello"),
+		Code:    http.StatusNoContent,
+	}).Run(handler, t)
+
+	select {
+	case info := <-received:
+		a.Equal("foo", info.ID)
+		a.EqualValues(5, info.Size)
+		a.EqualValues(5, info.Offset)
+	case <-time.After(time.Second):
+		t.Fatal("webhook not received")
+	}
+
+	a.Equal(2, attempts)
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	a := assert.New(t)
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts += 1
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var deadBody []byte
+	var deadErr error
+	hook := &Webhook{
+		URL:     server.URL,
+		Retries: 2,
+		DeadLetter: func(body []byte, err error) {
+			deadBody = body
+			deadErr = err
+		},
+	}
+
+	err := hook.Send(FileInfo{ID: "foo"})
+	a.Error(err)
+	a.Equal(3, attempts)
+	a.Equal(err, deadErr)
+	a.Contains(string(deadBody), `"ID":"foo"`)
+
+	// Unsigned requests do not contain the signature header
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.Equal("", r.Header.Get("X-Tusd-Signature"))
+	}))
+	defer server.Close()
+
+	hook = &Webhook{
+		URL: server.URL,
+		DeadLetter: func(body []byte, err error) {
+			a.NoError(errors.New("unexpected dead letter"))
+		},
+	}
+	a.NoError(hook.Send(FileInfo{ID: "foo"}))
+}